@@ -0,0 +1,18 @@
+package ipxedust
+
+import "inet.af/netaddr"
+
+// SetAllowedClients restricts the clients served, over both protocols, to ips, for example the nodes of a static
+// fleet. Other clients are rejected, with a 403 over HTTP and an error over TFTP. The addresses are indexed, so that
+// checking a request takes the same time for a fleet of tens of thousands of nodes as for a handful.
+// A nil ips allows every client again, which is the default, an empty one rejects every client.
+// It is safe to call concurrently with serving, to follow a dynamic fleet: the new set applies to new requests,
+// and transfers in flight complete. HTTP requests over a Unix domain socket have no client IP and are rejected
+// while the allowlist is set. ips is not retained.
+func (c *Server) SetAllowedClients(ips []netaddr.IP) {
+	c.allowed.Replace(ips)
+	if c.Log.GetSink() != nil {
+		n, on := c.allowed.Len()
+		c.log().Info("allowed clients changed", "allowlist", on, "clients", n)
+	}
+}
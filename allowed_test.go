@@ -0,0 +1,82 @@
+package ipxedust
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/binary"
+	"inet.af/netaddr"
+)
+
+func TestSetAllowedClients(t *testing.T) {
+	ready := make(chan net.Addr, 2)
+	c := &Server{
+		Log:     logr.Discard(),
+		OnReady: func(_ Protocol, addr net.Addr) { ready <- addr },
+	}
+	conn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpAddr, tftpAddr := conn.Addr().String(), uconn.LocalAddr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Serve(ctx, conn, uconn) }()
+	defer func() {
+		cancel()
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}()
+	<-ready
+	<-ready
+
+	status := func() int {
+		t.Helper()
+		resp, err := http.Get(fmt.Sprintf("http://%v/snp.efi", httpAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	tests := []struct {
+		name    string
+		ips     []netaddr.IP
+		allowed bool
+	}{
+		{name: "other clients", ips: []netaddr.IP{netaddr.IPv4(127, 0, 0, 2), netaddr.IPv4(192, 168, 2, 10)}},
+		{name: "client allowed", ips: []netaddr.IP{netaddr.IPv4(192, 168, 2, 10), netaddr.IPv4(127, 0, 0, 1)}, allowed: true},
+		{name: "none", ips: []netaddr.IP{}},
+		{name: "disabled", allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.SetAllowedClients(tt.ips)
+			if !tt.allowed {
+				if got := status(); got != http.StatusForbidden {
+					t.Fatalf("expected status %v, got: %v", http.StatusForbidden, got)
+				}
+				if _, err := tftpReceive(tftpAddr, "snp.efi"); err == nil {
+					t.Fatal("expected the TFTP request to be rejected")
+				}
+				return
+			}
+			if got := status(); got != http.StatusOK {
+				t.Fatalf("expected status %v, got: %v", http.StatusOK, got)
+			}
+			if !bytes.Equal(tftpGet(t, tftpAddr, "snp.efi"), binary.SNP) {
+				t.Fatal("expected the TFTP request to be served")
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+// Package allowlist restricts the clients iPXE binaries are served to, to a set of known IP addresses.
+package allowlist
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"inet.af/netaddr"
+)
+
+// ErrNotAllowed is returned when a request is rejected because its client is not in the allowlist.
+var ErrNotAllowed = errors.New("client not allowed")
+
+// Set is an allowlist of client IP addresses, indexed for lookups in constant time, so that it scales to fleets
+// of tens of thousands of nodes. It is safe for concurrent use: Replace swaps the whole set at once, while lookups
+// are in flight. The zero value, like a nil *Set, allows every client, until Replace is called.
+type Set struct {
+	// ips holds a map[netaddr.IP]struct{}, nil when every client is allowed.
+	ips atomic.Value
+}
+
+// New returns a Set allowing the clients ips, see Replace.
+func New(ips []netaddr.IP) *Set {
+	s := &Set{}
+	s.Replace(ips)
+	return s
+}
+
+// Replace replaces the allowed clients with ips. A nil ips allows every client, an empty one allows none.
+// IPv4-mapped IPv6 addresses, like ::ffff:192.168.2.10, match their IPv4 address.
+func (s *Set) Replace(ips []netaddr.IP) {
+	if ips == nil {
+		s.ips.Store(map[netaddr.IP]struct{}(nil))
+		return
+	}
+	m := make(map[netaddr.IP]struct{}, len(ips))
+	for _, ip := range ips {
+		m[ip.Unmap().WithZone("")] = struct{}{}
+	}
+	s.ips.Store(m)
+}
+
+// Allow reports whether a request from ip may proceed.
+func (s *Set) Allow(ip netaddr.IP) bool {
+	m := s.load()
+	if m == nil {
+		return true
+	}
+	_, ok := m[ip.Unmap().WithZone("")]
+	return ok
+}
+
+// Len returns the number of allowed clients, and false when every client is allowed.
+func (s *Set) Len() (int, bool) {
+	m := s.load()
+	return len(m), m != nil
+}
+
+// load returns the allowed clients, nil when every client is allowed.
+func (s *Set) load() map[netaddr.IP]struct{} {
+	if s == nil {
+		return nil
+	}
+	m, _ := s.ips.Load().(map[netaddr.IP]struct{})
+	return m
+}
@@ -0,0 +1,100 @@
+package allowlist
+
+import (
+	"sync"
+	"testing"
+
+	"inet.af/netaddr"
+)
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     *Set
+		ip      netaddr.IP
+		allowed bool
+	}{
+		{name: "nil set", ip: netaddr.IPv4(192, 168, 2, 10), allowed: true},
+		{name: "zero set", set: &Set{}, ip: netaddr.IPv4(192, 168, 2, 10), allowed: true},
+		{name: "nil ips", set: New(nil), ip: netaddr.IPv4(192, 168, 2, 10), allowed: true},
+		{name: "empty", set: New([]netaddr.IP{}), ip: netaddr.IPv4(192, 168, 2, 10)},
+		{name: "allowed", set: New([]netaddr.IP{netaddr.IPv4(192, 168, 2, 10)}), ip: netaddr.IPv4(192, 168, 2, 10), allowed: true},
+		{name: "not allowed", set: New([]netaddr.IP{netaddr.IPv4(192, 168, 2, 10)}), ip: netaddr.IPv4(192, 168, 2, 11)},
+		{name: "mapped client", set: New([]netaddr.IP{netaddr.IPv4(192, 168, 2, 10)}), ip: netaddr.MustParseIP("::ffff:192.168.2.10"), allowed: true},
+		{name: "mapped entry", set: New([]netaddr.IP{netaddr.MustParseIP("::ffff:192.168.2.10")}), ip: netaddr.IPv4(192, 168, 2, 10), allowed: true},
+		{name: "ipv6", set: New([]netaddr.IP{netaddr.MustParseIP("fd00::10")}), ip: netaddr.MustParseIP("fd00::10"), allowed: true},
+		{name: "zone", set: New([]netaddr.IP{netaddr.MustParseIP("fe80::10")}), ip: netaddr.MustParseIP("fe80::10%eth0"), allowed: true},
+		{name: "unknown client", set: New([]netaddr.IP{netaddr.IPv4(192, 168, 2, 10)}), ip: netaddr.IP{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.set.Allow(tt.ip); got != tt.allowed {
+				t.Fatalf("expected allowed: %v, got: %v", tt.allowed, got)
+			}
+		})
+	}
+}
+
+func TestSetReplace(t *testing.T) {
+	a, b := netaddr.IPv4(192, 168, 2, 10), netaddr.IPv4(192, 168, 2, 11)
+	s := New([]netaddr.IP{a})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				s.Allow(a)
+			}
+		}()
+	}
+	s.Replace([]netaddr.IP{b})
+	wg.Wait()
+	if s.Allow(a) || !s.Allow(b) {
+		t.Fatal("expected the replaced set to be consulted")
+	}
+	if n, ok := s.Len(); n != 1 || !ok {
+		t.Fatalf("expected 1 allowed client, got: %v, %v", n, ok)
+	}
+	s.Replace(nil)
+	if !s.Allow(a) {
+		t.Fatal("expected every client to be allowed")
+	}
+	if _, ok := s.Len(); ok {
+		t.Fatal("expected no allowlist")
+	}
+}
+
+// BenchmarkAllow compares the lookup of the last client of a fleet of 50000 in a Set to a scan of the fleet.
+func BenchmarkAllow(b *testing.B) {
+	ips := make([]netaddr.IP, 50000)
+	for i := range ips {
+		ips[i] = netaddr.IPv4(10, byte(i>>16), byte(i>>8), byte(i))
+	}
+	last := ips[len(ips)-1]
+	b.Run("set", func(b *testing.B) {
+		s := New(ips)
+		for i := 0; i < b.N; i++ {
+			if !s.Allow(last) {
+				b.Fatal("expected the client to be allowed")
+			}
+		}
+	})
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if !scan(ips, last) {
+				b.Fatal("expected the client to be allowed")
+			}
+		}
+	})
+}
+
+// scan reports whether ip is in ips, looking at each in turn.
+func scan(ips []netaddr.IP, ip netaddr.IP) bool {
+	for _, a := range ips {
+		if a == ip {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,15 @@
+package ipxedust
+
+import "net"
+
+// setListenBacklog sets the accept queue length of l to c.HTTP.ListenBacklog, when l exposes its socket,
+// like a *net.TCPListener does, and logs the length the OS actually applied, which may be capped.
+func (c *Server) setListenBacklog(l net.Listener) {
+	size := c.HTTP.ListenBacklog
+	actual, err := listenBacklog(l, size)
+	if err != nil {
+		c.protocolLog(ProtocolHTTP).Info("warning: setting the HTTP listen backlog failed, keeping the OS default", "requested", size, "error", err.Error())
+		return
+	}
+	c.protocolLog(ProtocolHTTP).Info("set HTTP listen backlog", "requested", size, "actual", actual)
+}
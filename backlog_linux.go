@@ -0,0 +1,62 @@
+package ipxedust
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenBacklog sets the accept queue length of the listening socket of l to size and returns the length applied.
+// Linux allows listening again on a listening socket to change the length, and caps it to net.core.somaxconn.
+func listenBacklog(l net.Listener, size int) (int, error) {
+	sc, ok := l.(syscall.Conn)
+	if !ok {
+		return 0, errors.New("listener does not expose its socket")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = unix.Listen(int(fd), size)
+	}); err != nil {
+		return 0, err
+	}
+	if serr != nil {
+		return 0, serr
+	}
+	if max, err := somaxconn(); err == nil && size > max {
+		return max, nil
+	}
+	return size, nil
+}
+
+// somaxconn returns net.core.somaxconn, the maximum accept queue length.
+func somaxconn() (int, error) {
+	b, err := os.ReadFile("/proc/sys/net/core/somaxconn")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+// listenReusePort listens on address like net.Listen, with SO_REUSEPORT set on the socket before it is bound.
+func listenReusePort(network, address string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: func(_, _ string, rc syscall.RawConn) error {
+		var serr error
+		if err := rc.Control(func(fd uintptr) {
+			serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		}); err != nil {
+			return err
+		}
+		return serr
+	}}
+	return lc.Listen(context.Background(), network, address)
+}
@@ -0,0 +1,94 @@
+package ipxedust
+
+import (
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+	"golang.org/x/sys/unix"
+	"inet.af/netaddr"
+)
+
+func TestListenReusePort(t *testing.T) {
+	c := &Server{HTTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), 0), ReusePort: true}}
+	l, err := c.bindHTTP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	rc, err := l.(syscall.Conn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reuse int
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		reuse, serr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if serr != nil || reuse != 1 {
+		t.Fatalf("expected SO_REUSEPORT to be set, got: %v, %v", reuse, serr)
+	}
+	// a second listener binds the same address.
+	c.HTTP.Addr = netaddr.IPPortFrom(c.HTTP.Addr.IP(), uint16(l.Addr().(*net.TCPAddr).Port))
+	l2, err := c.bindHTTP()
+	if err != nil {
+		t.Fatalf("expected a second listener on %v, got: %v", c.HTTP.Addr, err)
+	}
+	l2.Close()
+	c.HTTP.ReusePort = false
+	if l3, err := c.bindHTTP(); err == nil {
+		l3.Close()
+		t.Fatal("expected binding the address without SO_REUSEPORT to fail")
+	}
+}
+
+func TestListenBacklog(t *testing.T) {
+	const backlog = 2
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var logged []string
+	c := &Server{
+		Log:  funcr.New(func(_, args string) { logged = append(logged, args) }, funcr.Options{}),
+		HTTP: ServerSpec{ListenBacklog: backlog},
+	}
+	c.setListenBacklog(l)
+	if want := `"msg"="set HTTP listen backlog" "requested"=2 "actual"=2`; len(logged) != 1 || !strings.Contains(logged[0], want) {
+		t.Fatalf("expected %v logged, got: %v", want, logged)
+	}
+	// nothing accepts the connections: Linux queues one more than the backlog and drops the SYNs that follow.
+	established := 0
+	for i := 0; i < backlog+3; i++ {
+		conn, err := net.DialTimeout("tcp", l.Addr().String(), 200*time.Millisecond)
+		if err != nil {
+			continue
+		}
+		defer conn.Close()
+		established++
+	}
+	if established != backlog+1 {
+		t.Fatalf("expected %v connections established, got: %v", backlog+1, established)
+	}
+}
+
+func TestListenBacklogUnsupported(t *testing.T) {
+	var logged []string
+	c := &Server{
+		Log:  funcr.New(func(_, args string) { logged = append(logged, args) }, funcr.Options{}),
+		HTTP: ServerSpec{ListenBacklog: 2},
+	}
+	c.setListenBacklog(fakeListener{})
+	if want := `"msg"="warning: setting the HTTP listen backlog failed, keeping the OS default"`; len(logged) != 1 || !strings.Contains(logged[0], want) {
+		t.Fatalf("expected %v logged, got: %v", want, logged)
+	}
+}
+
+// fakeListener is a net.Listener that doesn't expose its socket.
+type fakeListener struct{ net.Listener }
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package ipxedust
+
+import "net"
+
+// listenBacklog is only supported on Linux.
+func listenBacklog(net.Listener, int) (int, error) {
+	return 0, errNotLinux
+}
+
+// listenReusePort is only supported on Linux.
+func listenReusePort(string, string) (net.Listener, error) {
+	return nil, errNotLinux
+}
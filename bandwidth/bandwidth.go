@@ -0,0 +1,109 @@
+// Package bandwidth throttles the rate transfers are sent at, so that a large download doesn't saturate a shared link.
+package bandwidth
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/tinkerbell/ipxedust/clock"
+	"golang.org/x/time/rate"
+)
+
+// maxChunk bounds the bytes read, and so sent, at once by a throttled transfer, which keeps the rate smooth at high rates.
+const maxChunk = 32 * 1024
+
+// Config holds the bandwidth limits. The zero value means unlimited.
+type Config struct {
+	// PerTransfer is the rate, in bytes per second, a single transfer is sent at. Zero means unlimited.
+	PerTransfer int64
+	// Total is the rate, in bytes per second, all transfers together are sent at. Zero means unlimited.
+	Total int64
+	// Clock tells the time and sleeps to throttle. Defaults to the real clock.
+	Clock clock.Clock
+}
+
+// Limiter throttles transfers. A nil *Limiter doesn't throttle.
+type Limiter struct {
+	perTransfer int64
+	total       *rate.Limiter
+	clock       clock.Clock
+}
+
+// New returns a Limiter for c. It returns nil, which doesn't throttle, when no limit is configured.
+func New(c Config) *Limiter {
+	if c.PerTransfer <= 0 && c.Total <= 0 {
+		return nil
+	}
+	l := &Limiter{perTransfer: c.PerTransfer, clock: clock.Or(c.Clock)}
+	if c.Total > 0 {
+		l.total = newLimiter(c.Total, l.clock.Now())
+	}
+	return l
+}
+
+// Reader returns an io.Reader that reads from r no faster than the limits of l, for a single transfer.
+// It stops with the error of ctx once ctx is done. When l is nil, r is returned as is.
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	tr := &reader{ctx: ctx, r: r, total: l.total, clock: l.clock}
+	if l.perTransfer > 0 {
+		tr.perTransfer = newLimiter(l.perTransfer, l.clock.Now())
+	}
+	return tr
+}
+
+// newLimiter returns a token bucket of bytesPerSecond that starts empty, so that a transfer doesn't start with a burst.
+func newLimiter(bytesPerSecond int64, now time.Time) *rate.Limiter {
+	burst := bytesPerSecond
+	if burst > maxChunk {
+		burst = maxChunk
+	}
+	lim := rate.NewLimiter(rate.Limit(bytesPerSecond), int(burst))
+	lim.AllowN(now, int(burst))
+	return lim
+}
+
+// reader is an io.Reader that waits for the bytes it reads to fit the limits.
+type reader struct {
+	ctx         context.Context
+	r           io.Reader
+	perTransfer *rate.Limiter
+	total       *rate.Limiter
+	clock       clock.Clock
+}
+
+func (t *reader) Read(p []byte) (int, error) {
+	if err := t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	for _, lim := range []*rate.Limiter{t.perTransfer, t.total} {
+		if lim != nil && len(p) > lim.Burst() {
+			p = p[:lim.Burst()]
+		}
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.wait(n)
+	}
+	return n, err
+}
+
+// wait sleeps until n bytes fit the limits.
+func (t *reader) wait(n int) {
+	now := t.clock.Now()
+	var delay time.Duration
+	for _, lim := range []*rate.Limiter{t.perTransfer, t.total} {
+		if lim == nil {
+			continue
+		}
+		if d := lim.ReserveN(now, n).DelayFrom(now); d > delay {
+			delay = d
+		}
+	}
+	if delay > 0 {
+		t.clock.Sleep(delay)
+	}
+}
@@ -0,0 +1,78 @@
+package bandwidth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       Config
+		transfers int
+		size      int
+		want      time.Duration
+	}{
+		{name: "per transfer", cfg: Config{PerTransfer: 20000}, transfers: 1, size: 10000, want: 500 * time.Millisecond},
+		{name: "per transfer applies to each transfer", cfg: Config{PerTransfer: 20000}, transfers: 2, size: 10000, want: 500 * time.Millisecond},
+		{name: "total is shared", cfg: Config{Total: 40000}, transfers: 2, size: 10000, want: 500 * time.Millisecond},
+		{name: "lowest limit applies", cfg: Config{PerTransfer: 100000, Total: 20000}, transfers: 1, size: 10000, want: 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.cfg)
+			content := bytes.Repeat([]byte("a"), tt.size)
+			start := time.Now()
+			var wg sync.WaitGroup
+			errs := make(chan error, tt.transfers)
+			for i := 0; i < tt.transfers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					got, err := io.ReadAll(l.Reader(context.Background(), bytes.NewReader(content)))
+					if err == nil && !bytes.Equal(got, content) {
+						err = errors.New("content mismatch")
+					}
+					errs <- err
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			elapsed := time.Since(start)
+			// allow for the granularity of the limiter, which waits after each chunk is read.
+			if min := tt.want - 50*time.Millisecond; elapsed < min {
+				t.Fatalf("expected the transfer to take at least %v, took: %v", min, elapsed)
+			}
+		})
+	}
+}
+
+func TestReaderUnlimited(t *testing.T) {
+	l := New(Config{})
+	if l != nil {
+		t.Fatal("expected a nil Limiter")
+	}
+	r := bytes.NewReader([]byte("content"))
+	if got := l.Reader(context.Background(), r); got != r {
+		t.Fatal("expected the reader to be returned as is")
+	}
+}
+
+func TestReaderCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := io.ReadAll(New(Config{PerTransfer: 1000}).Reader(ctx, bytes.NewReader([]byte("content"))))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, got: %v", context.Canceled, err)
+	}
+}
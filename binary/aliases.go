@@ -0,0 +1,18 @@
+package binary
+
+// DefaultAliases maps file names commonly requested by client firmware to the names of the embedded binaries in Files.
+// It covers the UEFI removable media defaults (bootx64.efi and bootaa64.efi), architecture names (x86_64.efi,
+// arm64.efi, ipxe-x86_64.efi and ipxe-arm64.efi), the upstream iPXE snponly.efi build, served as ipxe.efi,
+// which includes the same network driver, and the BIOS undionly.kkpxe and undionly.pxe variants.
+// It is used when Server.UseDefaultAliases is set. Callers can read it, and extend it with Server.Aliases.
+var DefaultAliases = map[string]string{
+	"bootx64.efi":     "ipxe.efi",
+	"x86_64.efi":      "ipxe.efi",
+	"snponly.efi":     "ipxe.efi",
+	"ipxe-x86_64.efi": "ipxe.efi",
+	"bootaa64.efi":    "snp.efi",
+	"arm64.efi":       "snp.efi",
+	"ipxe-arm64.efi":  "snp.efi",
+	"undionly.kkpxe":  "undionly.kpxe",
+	"undionly.pxe":    "undionly.kpxe",
+}
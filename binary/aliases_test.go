@@ -0,0 +1,14 @@
+package binary
+
+import "testing"
+
+func TestDefaultAliases(t *testing.T) {
+	for name, target := range DefaultAliases {
+		if _, ok := Files[target]; !ok {
+			t.Errorf("alias %v: %v is not an embedded binary", name, target)
+		}
+		if _, ok := Files[name]; ok {
+			t.Errorf("alias %v shadows an embedded binary", name)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package binary
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// ErrNotEmbedded is returned for a file name of Files whose binary is not compiled into this build, see Embedded.
+// It wraps os.ErrNotExist: the file is not found, like an unknown file.
+var ErrNotEmbedded = fmt.Errorf("iPXE binary not embedded in this build: %w", os.ErrNotExist)
+
+// Embedded reports whether the iPXE binary name of Files is compiled into this build. A build that doesn't build
+// every binary embeds an empty file in its place, for example `touch binary/snp.efi` without an ARM cross compiler,
+// and the binary is then not served.
+func Embedded(name string) bool {
+	return len(Files[name]) > 0
+}
+
+// Available returns the names of the iPXE binaries of Files compiled into this build, sorted, see Embedded.
+func Available() []string {
+	names := make([]string, 0, len(Files))
+	for name := range Files {
+		if Embedded(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,27 @@
+package binary
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAvailable(t *testing.T) {
+	if diff := cmp.Diff([]string{"ipxe.efi", "snp.efi", "undionly.kpxe"}, Available()); diff != "" {
+		t.Fatal(diff)
+	}
+	snp := Files["snp.efi"]
+	Files["snp.efi"] = []byte{}
+	defer func() { Files["snp.efi"] = snp }()
+	if diff := cmp.Diff([]string{"ipxe.efi", "undionly.kpxe"}, Available()); diff != "" {
+		t.Fatal(diff)
+	}
+	if Embedded("snp.efi") || Embedded("unknown.efi") || !Embedded("ipxe.efi") {
+		t.Fatal("expected only the binaries compiled in to be embedded")
+	}
+	if !errors.Is(ErrNotEmbedded, os.ErrNotExist) {
+		t.Fatal("expected a binary that is not embedded not to exist")
+	}
+}
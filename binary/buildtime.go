@@ -0,0 +1,20 @@
+package binary
+
+import (
+	"strconv"
+	"time"
+)
+
+// BuildEpoch is the time the embedded binaries were built, in seconds since the Unix epoch. It is set at link time,
+// for example with -ldflags "-X github.com/tinkerbell/ipxedust/binary.BuildEpoch=$SOURCE_DATE_EPOCH". The Makefile
+// sets it to SOURCE_DATE_EPOCH, which defaults to the time of the last commit, so that builds are reproducible.
+var BuildEpoch string
+
+// BuildTime returns BuildEpoch as a time. It returns false when BuildEpoch is not set or not a positive number.
+func BuildTime() (time.Time, bool) {
+	s, err := strconv.ParseInt(BuildEpoch, 10, 64)
+	if err != nil || s <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(s, 0).UTC(), true
+}
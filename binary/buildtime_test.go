@@ -0,0 +1,30 @@
+package binary
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildTime(t *testing.T) {
+	tests := []struct {
+		epoch  string
+		want   time.Time
+		wantOK bool
+	}{
+		{epoch: "1700000000", want: time.Date(2023, time.November, 14, 22, 13, 20, 0, time.UTC), wantOK: true},
+		{epoch: ""},
+		{epoch: "0"},
+		{epoch: "-1"},
+		{epoch: "yesterday"},
+	}
+	defer func(e string) { BuildEpoch = e }(BuildEpoch)
+	for _, tt := range tests {
+		t.Run(tt.epoch, func(t *testing.T) {
+			BuildEpoch = tt.epoch
+			got, ok := BuildTime()
+			if ok != tt.wantOK || !got.Equal(tt.want) {
+				t.Fatalf("expected %v, %v, got: %v, %v", tt.want, tt.wantOK, got, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package binary
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// FS is a read only file system of the embedded iPXE binaries in Files, flat, without directories.
+// It allows listing the embedded binaries explicitly among other file systems, see resolve.Resolver.FS.
+var FS fs.FS = filesFS{}
+
+// filesFS is an fs.FS of Files.
+type filesFS struct{}
+
+// Open opens the embedded binary name, or the root directory when name is ".".
+func (filesFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &dir{}, nil
+	}
+	content, ok := Files[name]
+	if !ok || !Embedded(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &file{Reader: bytes.NewReader(content), info: fileInfo{name: name, size: int64(len(content))}}, nil
+}
+
+// file is an open embedded binary.
+type file struct {
+	*bytes.Reader
+	info fileInfo
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *file) Close() error               { return nil }
+
+// dir is the open root directory.
+type dir struct {
+	// entries are the entries not read yet, listed by the first ReadDir.
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return fileInfo{name: ".", dir: true}, nil }
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: ".", Err: fs.ErrInvalid}
+}
+func (d *dir) Close() error { return nil }
+
+// ReadDir reads the embedded binaries, sorted by name, see fs.ReadDirFile.
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		d.read = true
+		for _, name := range Available() {
+			d.entries = append(d.entries, fs.FileInfoToDirEntry(fileInfo{name: name, size: int64(len(Files[name]))}))
+		}
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// fileInfo describes an embedded binary or the root directory.
+type fileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) ModTime() time.Time { return time.Time{} }
+func (i fileInfo) IsDir() bool        { return i.dir }
+func (i fileInfo) Sys() interface{}   { return nil }
+func (i fileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
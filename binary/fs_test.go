@@ -0,0 +1,22 @@
+package binary
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFS(t *testing.T) {
+	if err := fstest.TestFS(FS, "ipxe.efi", "snp.efi", "undionly.kpxe"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.ReadFile(FS, "snp.efi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, SNP); diff != "" {
+		t.Fatal(diff)
+	}
+}
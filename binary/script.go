@@ -0,0 +1,44 @@
+package binary
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+)
+
+// Script is the iPXE script embedded in the EFI binaries at build time, see script/embed.ipxe.
+//
+//go:embed script/embed.ipxe
+var Script []byte
+
+var (
+	// ErrNoScript is returned by ReplaceScript for a binary that doesn't contain Script in plain text,
+	// like undionly.kpxe, which is compressed.
+	ErrNoScript = errors.New("binary has no replaceable embedded script")
+	// ErrScriptTooLong is returned by ReplaceScript for a script longer than Script.
+	// The script is replaced in place, so it can't grow.
+	ErrScriptTooLong = errors.New("script is longer than the embedded script")
+)
+
+// ChainScript returns an iPXE script that configures the network with DHCP and chainloads url.
+func ChainScript(url string) []byte {
+	return []byte("#!ipxe\ndhcp\nchain " + url + "\n")
+}
+
+// ReplaceScript returns a copy of the binary b with its embedded Script replaced by script.
+// The script is padded with new lines to the length of Script. b is not modified.
+func ReplaceScript(b, script []byte) ([]byte, error) {
+	if len(script) > len(Script) {
+		return nil, fmt.Errorf("%w: %v bytes, at most %v", ErrScriptTooLong, len(script), len(Script))
+	}
+	i := bytes.Index(b, Script)
+	if i < 0 {
+		return nil, ErrNoScript
+	}
+	r := make([]byte, len(b))
+	copy(r, b)
+	n := copy(r[i:], script)
+	copy(r[i+n:i+len(Script)], bytes.Repeat([]byte("\n"), len(Script)-n))
+	return r, nil
+}
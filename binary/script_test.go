@@ -0,0 +1,52 @@
+package binary
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReplaceScript(t *testing.T) {
+	script := ChainScript("http://192.168.2.1/boot.ipxe")
+	tests := []struct {
+		name    string
+		binary  string
+		script  []byte
+		wantErr error
+	}{
+		{name: "ipxe.efi", binary: "ipxe.efi", script: script},
+		{name: "snp.efi", binary: "snp.efi", script: script},
+		{name: "undionly.kpxe is compressed", binary: "undionly.kpxe", script: script, wantErr: ErrNoScript},
+		{name: "too long", binary: "ipxe.efi", script: ChainScript("http://192.168.2.1/" + strings.Repeat("a", 64) + ".ipxe"), wantErr: ErrScriptTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := Files[tt.binary]
+			before := append([]byte(nil), orig...)
+			got, err := ReplaceScript(orig, tt.script)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error mismatch, got: %v, want: %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(orig, before) {
+				t.Fatal("expected the original binary to be left untouched")
+			}
+			if err != nil {
+				return
+			}
+			if len(got) != len(orig) {
+				t.Fatalf("expected the size to be unchanged, got: %v, want: %v", len(got), len(orig))
+			}
+			if bytes.Contains(got, Script) {
+				t.Fatal("expected the embedded script to be replaced")
+			}
+			i := bytes.Index(orig, Script)
+			if !bytes.HasPrefix(got[i:], tt.script) {
+				t.Fatal("expected the script in place of the embedded script")
+			}
+			if !bytes.Equal(got[:i], orig[:i]) || !bytes.Equal(got[i+len(Script):], orig[i+len(Script):]) {
+				t.Fatal("expected the rest of the binary to be unchanged")
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package binary
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+)
+
+// Fingerprint returns the SHA-256 fingerprint of cert, the form iPXE embeds its trusted root certificates in
+// when built with the TRUST option, for example make bin-x86_64-efi/ipxe.efi TRUST=ca.crt.
+func Fingerprint(cert *x509.Certificate) [sha256.Size]byte {
+	return sha256.Sum256(cert.Raw)
+}
+
+// Trusts reports whether the iPXE binary b trusts cert as a root certificate, by looking for its Fingerprint.
+// Compressed binaries, like undionly.kpxe, never do, as the fingerprint can't be found in them.
+// The embedded binaries trust the default root certificates of iPXE, which don't include private CAs.
+func Trusts(b []byte, cert *x509.Certificate) bool {
+	fp := Fingerprint(cert)
+	return bytes.Contains(b, fp[:])
+}
@@ -0,0 +1,68 @@
+package binary
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestTrusts(t *testing.T) {
+	ca := newTestCA(t, "private CA")
+	other := newTestCA(t, "other CA")
+	fp := Fingerprint(ca)
+	trusting := append(append(append([]byte{}, IpxeEFI[:64]...), fp[:]...), IpxeEFI[64:128]...)
+	tests := []struct {
+		name string
+		b    []byte
+		cert bool
+		want bool
+	}{
+		{name: "embedded", b: trusting, want: true},
+		{name: "other CA", b: trusting, cert: true, want: false},
+		{name: "not embedded", b: IpxeEFI, want: false},
+		{name: "compressed", b: Undionly, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := ca
+			if tt.cert {
+				cert = other
+			}
+			if got := Trusts(tt.b, cert); got != tt.want {
+				t.Fatalf("expected %v, got: %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// newTestCA returns a self-signed certificate authority with the common name cn.
+func newTestCA(t *testing.T, cn string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
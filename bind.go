@@ -0,0 +1,57 @@
+package ipxedust
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// The standard library functions binding the listeners of ListenAndServe.
+// Tests replace them to inject resolution and bind failures without depending on the OS.
+var (
+	resolveUDPAddr = net.ResolveUDPAddr
+	listenUDP      = net.ListenUDP
+	listenTCP      = net.Listen
+)
+
+// BindError is returned when a listener cannot be created on its address.
+// The error message adds a hint for the common permission denied and address in use failures.
+type BindError struct {
+	Err error
+}
+
+// Error implements the error interface.
+func (e *BindError) Error() string {
+	switch {
+	case e.PermissionDenied():
+		return fmt.Sprintf("%v: %v", e.Err, bindPermissionHint)
+	case e.AddrInUse():
+		return fmt.Sprintf("%v: another process is already listening on this address", e.Err)
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+// PermissionDenied reports whether binding failed because the process is not allowed to bind the address.
+// This is usually because the port is privileged (below 1024).
+func (e *BindError) PermissionDenied() bool {
+	return errors.Is(e.Err, syscall.EACCES) || errors.Is(e.Err, syscall.EPERM)
+}
+
+// AddrInUse reports whether binding failed because the address is already in use.
+func (e *BindError) AddrInUse() bool {
+	return errors.Is(e.Err, syscall.EADDRINUSE)
+}
+
+// bindError wraps err in a *BindError, unless err is nil.
+func bindError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &BindError{Err: err}
+}
@@ -0,0 +1,73 @@
+package ipxedust
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	bindPermissionHint = "binding ports below 1024 requires root or the CAP_NET_BIND_SERVICE capability " +
+		"(for example: setcap cap_net_bind_service=+ep <binary>, or add it to the ambient capabilities)"
+	// capNetBindService is the bit number of CAP_NET_BIND_SERVICE, see capabilities(7).
+	capNetBindService = 10
+)
+
+// NetBindServiceCapability reports whether the process has CAP_NET_BIND_SERVICE in its effective
+// capability set, which allows binding ports below 1024, and in its ambient capability set,
+// which means the capability is passed on to executed child processes.
+func NetBindServiceCapability() (effective bool, ambient bool, err error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false, false, err
+	}
+	defer f.Close()
+	return netBindServiceCapability(f)
+}
+
+// netBindServiceCapability parses the CapEff and CapAmb lines of a /proc/[pid]/status file.
+func netBindServiceCapability(r io.Reader) (effective bool, ambient bool, err error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		kv := strings.SplitN(s.Text(), ":", 2)
+		if len(kv) != 2 || (kv[0] != "CapEff" && kv[0] != "CapAmb") {
+			continue
+		}
+		k := kv[0]
+		caps, err := strconv.ParseUint(strings.TrimSpace(kv[1]), 16, 64)
+		if err != nil {
+			return false, false, fmt.Errorf("parsing %v: %w", k, err)
+		}
+		has := caps&(1<<capNetBindService) != 0
+		if k == "CapEff" {
+			effective = has
+		} else {
+			ambient = has
+		}
+	}
+	return effective, ambient, s.Err()
+}
+
+// DropPrivileges changes the user and group of the process to uid and gid and clears the supplementary groups.
+// It is used to create listeners on privileged ports as root and then serve with the privileges of another user:
+//
+//	tcp, _ := net.Listen("tcp", ":80")
+//	udp, _ := net.ListenPacket("udp", ":69")
+//	if err := ipxedust.DropPrivileges(65534, 65534); err != nil { ... }
+//	err := (&ipxedust.Server{}).Serve(ctx, tcp, udp)
+func DropPrivileges(uid, gid int) error {
+	if err := syscall.Setgroups(nil); err != nil {
+		return fmt.Errorf("clearing supplementary groups failed: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setting gid to %v failed: %w", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setting uid to %v failed: %w", uid, err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package ipxedust
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBindError(t *testing.T) {
+	tests := []struct {
+		name                 string
+		listen               func() error
+		wantPermissionDenied bool
+		wantAddrInUse        bool
+		wantHint             string
+	}{
+		{
+			name: "address in use",
+			listen: func() error {
+				l, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					return err
+				}
+				defer l.Close()
+				_, err = net.Listen("tcp", l.Addr().String())
+				return err
+			},
+			wantAddrInUse: true,
+			wantHint:      "another process is already listening",
+		},
+		{
+			name: "permission denied",
+			listen: func() error {
+				_, err := net.ListenPacket("udp", "127.0.0.1:69")
+				return err
+			},
+			wantPermissionDenied: true,
+			wantHint:             "CAP_NET_BIND_SERVICE",
+		},
+		{
+			name:   "other",
+			listen: func() error { return errors.New("boom") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if effective, _, _ := NetBindServiceCapability(); tt.wantPermissionDenied && effective {
+				t.Skip("process is allowed to bind privileged ports")
+			}
+			err := tt.listen()
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			var be *BindError
+			if !errors.As(bindError(err), &be) {
+				t.Fatalf("expected *BindError, got: %T", err)
+			}
+			if diff := cmp.Diff(be.PermissionDenied(), tt.wantPermissionDenied); diff != "" {
+				t.Error(diff)
+			}
+			if diff := cmp.Diff(be.AddrInUse(), tt.wantAddrInUse); diff != "" {
+				t.Error(diff)
+			}
+			if !strings.HasPrefix(be.Error(), err.Error()) || !strings.Contains(be.Error(), tt.wantHint) {
+				t.Errorf("unexpected error message: %v", be)
+			}
+			if !errors.Is(be, err) {
+				t.Error("expected the underlying error to be unwrapped")
+			}
+		})
+	}
+}
+
+func TestBindErrorSyscall(t *testing.T) {
+	tests := map[syscall.Errno]struct{ permission, inUse bool }{
+		syscall.EACCES:     {permission: true},
+		syscall.EPERM:      {permission: true},
+		syscall.EADDRINUSE: {inUse: true},
+		syscall.EINVAL:     {},
+	}
+	for errno, want := range tests {
+		be := &BindError{Err: &net.OpError{Op: "listen", Net: "udp", Err: os.NewSyscallError("bind", errno)}}
+		if be.PermissionDenied() != want.permission || be.AddrInUse() != want.inUse {
+			t.Errorf("%v: got permission denied %v, address in use %v, want %v, %v", errno, be.PermissionDenied(), be.AddrInUse(), want.permission, want.inUse)
+		}
+	}
+}
+
+func TestNetBindServiceCapability(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        string
+		wantEffective bool
+		wantAmbient   bool
+		wantErr       bool
+	}{
+		{name: "none", status: "Name:\tipxe\nCapEff:\t0000000000000000\nCapAmb:\t0000000000000000\n"},
+		{name: "root", status: "CapEff:\t000001ffffffffff\nCapAmb:\t0000000000000000\n", wantEffective: true},
+		{name: "ambient", status: "CapInh:\t0000000000000400\nCapEff:\t0000000000000400\nCapAmb:\t0000000000000400\n", wantEffective: true, wantAmbient: true},
+		{name: "other capabilities", status: "CapEff:\t0000000000003000\n"},
+		{name: "invalid", status: "CapEff:\tzzz\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			effective, ambient, err := netBindServiceCapability(strings.NewReader(tt.status))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("wantErr: %v, got: %v", tt.wantErr, err)
+			}
+			if diff := cmp.Diff([]bool{effective, ambient}, []bool{tt.wantEffective, tt.wantAmbient}); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
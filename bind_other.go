@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package ipxedust
+
+import "errors"
+
+const bindPermissionHint = "binding ports below 1024 requires elevated privileges"
+
+// errNotLinux is returned by helpers that are only supported on Linux.
+var errNotLinux = errors.New("only supported on Linux")
+
+// NetBindServiceCapability is only supported on Linux.
+func NetBindServiceCapability() (effective bool, ambient bool, err error) {
+	return false, false, errNotLinux
+}
+
+// DropPrivileges is only supported on Linux.
+func DropPrivileges(uid, gid int) error {
+	return errNotLinux
+}
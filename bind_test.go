@@ -0,0 +1,184 @@
+package ipxedust
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"inet.af/netaddr"
+)
+
+// stubBind replaces the functions binding the listeners for the duration of the test.
+func stubBind(t *testing.T, resolveUDP func(network, addr string) (*net.UDPAddr, error), udp func(network string, laddr *net.UDPAddr) (*net.UDPConn, error), tcp func(network, addr string) (net.Listener, error)) {
+	t.Helper()
+	r, u, l := resolveUDPAddr, listenUDP, listenTCP
+	t.Cleanup(func() { resolveUDPAddr, listenUDP, listenTCP = r, u, l })
+	if resolveUDP != nil {
+		resolveUDPAddr = resolveUDP
+	}
+	if udp != nil {
+		listenUDP = udp
+	}
+	if tcp != nil {
+		listenTCP = tcp
+	}
+}
+
+func TestBindFailures(t *testing.T) {
+	errResolve := &net.DNSError{Err: "no such host", Name: "tftp.invalid", IsNotFound: true}
+	inUse := func(network string) error {
+		return &net.OpError{Op: "listen", Net: network, Err: &os.SyscallError{Syscall: "bind", Err: syscall.EADDRINUSE}}
+	}
+	tests := []struct {
+		name          string
+		resolveUDP    func(network, addr string) (*net.UDPAddr, error)
+		udp           func(network string, laddr *net.UDPAddr) (*net.UDPConn, error)
+		tcp           func(network, addr string) (net.Listener, error)
+		wantErr       error
+		wantBindError bool
+	}{
+		{
+			name:       "tftp resolve error",
+			resolveUDP: func(string, string) (*net.UDPAddr, error) { return nil, errResolve },
+			wantErr:    errResolve,
+		},
+		{
+			name:          "tftp bind error",
+			udp:           func(network string, _ *net.UDPAddr) (*net.UDPConn, error) { return nil, inUse(network) },
+			wantErr:       syscall.EADDRINUSE,
+			wantBindError: true,
+		},
+		{
+			name:          "http bind error",
+			tcp:           func(network, _ string) (net.Listener, error) { return nil, inUse(network) },
+			wantErr:       syscall.EADDRINUSE,
+			wantBindError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubBind(t, tt.resolveUDP, tt.udp, tt.tcp)
+			c := &Server{
+				Log:  logr.Discard(),
+				TFTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), 0)},
+				HTTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), 0)},
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			err := c.ListenAndServe(ctx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got: %v", tt.wantErr, err)
+			}
+			var be *BindError
+			if got := errors.As(err, &be); got != tt.wantBindError {
+				t.Fatalf("expected a *BindError: %v, got: %v", tt.wantBindError, err)
+			}
+			if tt.wantBindError && !be.AddrInUse() {
+				t.Fatalf("expected the address in use to be reported, got: %v", be)
+			}
+		})
+	}
+}
+
+func TestTFTPOptional(t *testing.T) {
+	denied := func(network string) error {
+		return &net.OpError{Op: "listen", Net: network, Err: &os.SyscallError{Syscall: "bind", Err: syscall.EACCES}}
+	}
+	tests := []struct {
+		name     string
+		optional bool
+		wantErr  bool
+	}{
+		{name: "fail fast by default", wantErr: true},
+		{name: "optional", optional: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deny int32 = 1
+			stubBind(t, nil, func(network string, laddr *net.UDPAddr) (*net.UDPConn, error) {
+				if atomic.LoadInt32(&deny) == 1 {
+					return nil, denied(network)
+				}
+				return net.ListenUDP(network, laddr)
+			}, nil)
+			var logs []string
+			var mu sync.Mutex
+			ready := make(chan Protocol, 2)
+			c := &Server{
+				Log: funcr.New(func(_, args string) {
+					mu.Lock()
+					defer mu.Unlock()
+					logs = append(logs, args)
+				}, funcr.Options{}),
+				TFTP:         ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), 0)},
+				HTTP:         ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), 0)},
+				TFTPOptional: tt.optional,
+				OnReady:      func(p Protocol, _ net.Addr) { ready <- p },
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			errChan := make(chan error, 1)
+			go func() { errChan <- c.ListenAndServe(ctx) }()
+			if tt.wantErr {
+				var be *BindError
+				if err := <-errChan; !errors.As(err, &be) || !be.PermissionDenied() {
+					t.Fatalf("expected the TFTP bind error, got: %v", err)
+				}
+				return
+			}
+
+			if p := <-ready; p != ProtocolHTTP {
+				t.Fatalf("expected HTTP to be served, got: %v", p)
+			}
+			deadline := time.Now().Add(10 * time.Second)
+			for {
+				_, err := c.Listener(ProtocolTFTP)
+				if errors.Is(err, ErrProtocolDisabled) {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("expected TFTP to be disabled, got: %v", err)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			mu.Lock()
+			warned := false
+			for _, l := range logs {
+				warned = warned || strings.Contains(l, "TFTP failed to bind, serving HTTP only")
+			}
+			mu.Unlock()
+			if !warned {
+				t.Fatal("expected the TFTP bind failure to be logged as a warning")
+			}
+
+			// TFTP is bound once it is permitted.
+			atomic.StoreInt32(&deny, 0)
+			if err := c.EnableProtocol(ProtocolTFTP); err != nil {
+				t.Fatal(err)
+			}
+			if p := <-ready; p != ProtocolTFTP {
+				t.Fatalf("expected TFTP to be served, got: %v", p)
+			}
+			cancel()
+			if err := <-errChan; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestTFTPOptionalHTTPDisabled(t *testing.T) {
+	c := &Server{Log: logr.Discard(), TFTPOptional: true, HTTP: ServerSpec{Disabled: true}}
+	if err := c.ListenAndServe(context.Background()); err == nil || !strings.Contains(err.Error(), "TFTP can't be optional") {
+		t.Fatalf("expected TFTP not to be optional with HTTP disabled, got: %v", err)
+	}
+}
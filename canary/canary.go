@@ -0,0 +1,80 @@
+// Package canary selects the HTTP clients served canary binaries, to roll out a new iPXE build to part of a fleet.
+package canary
+
+import (
+	"hash/fnv"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"inet.af/netaddr"
+)
+
+// Values of the header and cookie of Rule that select a variant, regardless of Rule.Percent.
+const (
+	// Canary selects the canary binaries.
+	Canary = "canary"
+	// Stable selects the stable binaries.
+	Stable = "stable"
+)
+
+// Rule selects the requests served the binaries of FS, the canary, in place of the stable ones.
+// The zero value selects no request.
+type Rule struct {
+	// FS holds the canary binaries. Files it doesn't hold are served from the stable sources. A nil FS disables the rule.
+	FS fs.FS
+	// Percent is the percentage, from 0 to 100, of clients served the canary. Clients are selected by a hash of
+	// their IP address, so a client is served the same variant on every request, and a client selected at a
+	// percentage stays selected when it is raised.
+	Percent int
+	// Header, when not empty, is the name of a request header that selects a variant: Canary or Stable.
+	// Other values are ignored. It takes precedence over Percent.
+	Header string
+	// Cookie, when not empty, is the name of a cookie that selects a variant, like Header. Header takes precedence.
+	Cookie string
+	// Select, when not nil, is called first and selects the canary for req from client when ok is true, for example
+	// for the clients of a rack. Header, Cookie and Percent select it otherwise.
+	Select func(req *http.Request, client netaddr.IP) (canary, ok bool)
+}
+
+// Selected reports whether req from client is served the canary.
+func (r Rule) Selected(req *http.Request, client netaddr.IP) bool {
+	if r.FS == nil {
+		return false
+	}
+	if r.Select != nil {
+		if canary, ok := r.Select(req, client); ok {
+			return canary
+		}
+	}
+	if v, ok := r.requested(req); ok {
+		return v == Canary
+	}
+	return Bucket(client) < r.Percent
+}
+
+// requested returns the variant requested by the header or the cookie of req.
+func (r Rule) requested(req *http.Request) (string, bool) {
+	if r.Header != "" {
+		if v := strings.ToLower(strings.TrimSpace(req.Header.Get(r.Header))); v == Canary || v == Stable {
+			return v, true
+		}
+	}
+	if r.Cookie != "" {
+		if c, err := req.Cookie(r.Cookie); err == nil {
+			if v := strings.ToLower(c.Value); v == Canary || v == Stable {
+				return v, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Bucket returns the bucket, from 0 to 99, of client: the canary is served to the clients whose bucket is less than
+// Rule.Percent.
+func Bucket(client netaddr.IP) int {
+	h := fnv.New32a()
+	b := client.Unmap().As16()
+	_, _ = h.Write(b[:])
+	return int(h.Sum32() % 100)
+}
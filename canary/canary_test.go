@@ -0,0 +1,80 @@
+package canary
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"inet.af/netaddr"
+)
+
+func TestSelectedPercent(t *testing.T) {
+	clients := make([]netaddr.IP, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		clients = append(clients, netaddr.IPv4(10, 0, byte(i>>8), byte(i)))
+	}
+	req := httptest.NewRequest(http.MethodGet, "/ipxe.efi", nil)
+	for _, percent := range []int{0, 10, 50, 100} {
+		r := Rule{FS: fstest.MapFS{}, Percent: percent}
+		selected := 0
+		for _, client := range clients {
+			got := r.Selected(req, client)
+			if got {
+				selected++
+			}
+			if r.Selected(req, client) != got {
+				t.Fatalf("expected %v to be served the same variant on every request", client)
+			}
+			// a client selected at a percentage stays selected when it is raised.
+			if got && !(Rule{FS: r.FS, Percent: percent + 10}).Selected(req, client) {
+				t.Fatalf("expected %v to stay selected at %v%%", client, percent+10)
+			}
+		}
+		if want := percent * len(clients) / 100; selected < want-50 || selected > want+50 {
+			t.Errorf("%v%%: expected about %v clients selected, got: %v", percent, want, selected)
+		}
+	}
+}
+
+func TestSelectedRequested(t *testing.T) {
+	client := netaddr.IPv4(10, 0, 0, 1)
+	tests := []struct {
+		name   string
+		rule   Rule
+		header string
+		cookie string
+		want   bool
+		noFS   bool
+	}{
+		{name: "none", rule: Rule{Header: "X-Ipxe-Variant"}},
+		{name: "header canary", rule: Rule{Header: "X-Ipxe-Variant"}, header: "canary", want: true},
+		{name: "header case", rule: Rule{Header: "X-Ipxe-Variant"}, header: " Canary ", want: true},
+		{name: "header stable", rule: Rule{Header: "X-Ipxe-Variant", Percent: 100}, header: "stable"},
+		{name: "header ignored value", rule: Rule{Header: "X-Ipxe-Variant", Percent: 100}, header: "beta", want: true},
+		{name: "header not configured", rule: Rule{}, header: "canary"},
+		{name: "cookie canary", rule: Rule{Cookie: "ipxe-variant"}, cookie: "canary", want: true},
+		{name: "cookie stable", rule: Rule{Cookie: "ipxe-variant", Percent: 100}, cookie: "stable"},
+		{name: "header before cookie", rule: Rule{Header: "X-Ipxe-Variant", Cookie: "ipxe-variant"}, header: "stable", cookie: "canary"},
+		{name: "select", rule: Rule{Header: "X-Ipxe-Variant", Select: func(*http.Request, netaddr.IP) (bool, bool) { return true, true }}, header: "stable", want: true},
+		{name: "select declined", rule: Rule{Header: "X-Ipxe-Variant", Select: func(*http.Request, netaddr.IP) (bool, bool) { return true, false }}, header: "canary", want: true},
+		{name: "no file system", rule: Rule{Header: "X-Ipxe-Variant", Percent: 100}, header: "canary", noFS: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.noFS {
+				tt.rule.FS = fstest.MapFS{}
+			}
+			req := httptest.NewRequest(http.MethodGet, "/ipxe.efi", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Ipxe-Variant", tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "ipxe-variant", Value: tt.cookie})
+			}
+			if got := tt.rule.Selected(req, client); got != tt.want {
+				t.Fatalf("got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+// Package capture records the timeline of TFTP transfers, for debugging the interoperability issues of the firmware
+// of a client: the options it negotiated, when each block was read to be sent and how the transfer ended.
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tinkerbell/ipxedust/clock"
+	"inet.af/netaddr"
+)
+
+// MaxEvents is the maximum number of events of a Record. The events of longer transfers are dropped and counted.
+const MaxEvents = 1 << 16
+
+// Record is the capture of a transfer.
+type Record struct {
+	// Client is the IP address of the client.
+	Client string `json:"client"`
+	// Filename is the file name requested by the client, as requested.
+	Filename string `json:"filename"`
+	// Start is when the transfer started, after the file was resolved.
+	Start time.Time `json:"start"`
+	// Mode is the transfer mode requested, "octet" or "netascii".
+	Mode string `json:"mode,omitempty"`
+	// Options are the options of the request acknowledged by the server, with their granted values, for example
+	// blksize and tsize. github.com/pin/tftp doesn't make the options as requested by the client available.
+	Options map[string]string `json:"options,omitempty"`
+	// Size is the size of the content served.
+	Size int64 `json:"size"`
+	// BytesSent is the number of bytes of the content sent to the client.
+	BytesSent int64 `json:"bytesSent"`
+	// Duration is how long the transfer took.
+	Duration time.Duration `json:"duration"`
+	// DatagramsSent and DatagramsAcked are the numbers of datagrams sent, retransmissions included, and acknowledged.
+	DatagramsSent  int `json:"datagramsSent"`
+	DatagramsAcked int `json:"datagramsAcked"`
+	// Error is the error the transfer failed with, empty when it succeeded.
+	Error string `json:"error,omitempty"`
+	// Events are the reads of the content to send, in order. The server reads a block once the previous block was
+	// acknowledged, so they are the timeline of the acknowledgements. Retransmissions are not visible, compare
+	// DatagramsSent to DatagramsAcked.
+	Events []Event `json:"events"`
+	// EventsDropped counts the events past MaxEvents.
+	EventsDropped int `json:"eventsDropped,omitempty"`
+}
+
+// Event is a read of the content of a transfer.
+type Event struct {
+	// At is the time since the start of the transfer.
+	At time.Duration `json:"at"`
+	// Offset is the offset of the bytes read in the content.
+	Offset int64 `json:"offset"`
+	// Bytes is the number of bytes read.
+	Bytes int `json:"bytes"`
+}
+
+// Stats are the statistics of a transfer reported by the TFTP server, see Recorder.Negotiated.
+type Stats struct {
+	Mode           string
+	Options        map[string]string
+	DatagramsSent  int
+	DatagramsAcked int
+}
+
+// Config selects the transfers to capture and where their records go.
+type Config struct {
+	// Clients limits the capture to the clients of these prefixes, for example the address of a single node.
+	// Empty captures the transfers of all clients.
+	Clients []netaddr.IPPrefix
+	// Dir, when not empty, is the directory each record is written to, as a JSON file, see ReadFile.
+	Dir string
+	// OnRecord, when not nil, is called with each record.
+	OnRecord func(Record)
+	// Clock times the events. Defaults to the real clock.
+	Clock clock.Clock
+}
+
+// Recorder captures transfers. A nil *Recorder captures nothing, at the cost of a nil check.
+type Recorder struct {
+	config Config
+
+	mu sync.Mutex
+	// pending holds the transfers in progress, by client and file name, so that Negotiated finds them.
+	pending map[string][]*Transfer
+}
+
+// New returns a Recorder capturing the transfers selected by c.
+func New(c Config) *Recorder {
+	c.Clock = clock.Or(c.Clock)
+	return &Recorder{config: c, pending: make(map[string][]*Transfer)}
+}
+
+// Start starts capturing the transfer of filename, as requested, to client, with the content of size bytes read
+// from r. It returns nil and r unchanged when the client isn't captured, and the Transfer and the reader to send
+// the content from otherwise. The Transfer must be ended with End.
+func (r *Recorder) Start(client netaddr.IP, filename string, size int64, rd io.Reader) (*Transfer, io.Reader) {
+	if r == nil || !r.captured(client) {
+		return nil, rd
+	}
+	t := &Transfer{recorder: r, key: key(client, filename), r: rd, start: r.config.Clock.Now()}
+	t.record = Record{Client: client.String(), Filename: filename, Start: t.start, Size: size, Events: []Event{}}
+	r.mu.Lock()
+	r.pending[t.key] = append(r.pending[t.key], t)
+	r.mu.Unlock()
+	return t, t
+}
+
+// Negotiated adds the statistics reported by the TFTP server to the transfer of filename to client in progress,
+// for example from a tftp.Hook. It does nothing when no such transfer is captured.
+func (r *Recorder) Negotiated(client netaddr.IP, filename string, s Stats) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.pending[key(client, filename)] {
+		if !t.negotiated {
+			t.negotiated = true
+			t.record.Mode, t.record.Options = s.Mode, s.Options
+			t.record.DatagramsSent, t.record.DatagramsAcked = s.DatagramsSent, s.DatagramsAcked
+			return
+		}
+	}
+}
+
+// captured reports whether the transfers of client are captured.
+func (r *Recorder) captured(client netaddr.IP) bool {
+	if len(r.config.Clients) == 0 {
+		return true
+	}
+	for _, p := range r.config.Clients {
+		if p.Contains(client) {
+			return true
+		}
+	}
+	return false
+}
+
+func key(client netaddr.IP, filename string) string {
+	return client.String() + " " + filename
+}
+
+// Transfer is a transfer being captured.
+type Transfer struct {
+	recorder   *Recorder
+	key        string
+	r          io.Reader
+	start      time.Time
+	offset     int64
+	negotiated bool
+	record     Record
+}
+
+// Read implements io.Reader, recording an Event for every read.
+func (t *Transfer) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if len(t.record.Events) < MaxEvents {
+			t.record.Events = append(t.record.Events, Event{At: t.recorder.config.Clock.Now().Sub(t.start), Offset: t.offset, Bytes: n})
+		} else {
+			t.record.EventsDropped++
+		}
+		t.offset += int64(n)
+	}
+	return n, err
+}
+
+// End ends the capture of the transfer, which sent bytes of the content and failed with err, when not nil. The record
+// is written to the directory of the Recorder, and passed to its OnRecord. It returns the error writing the record.
+func (t *Transfer) End(sent int64, err error) (Record, error) {
+	r := t.recorder
+	r.mu.Lock()
+	l := r.pending[t.key]
+	for i, p := range l {
+		if p == t {
+			l = append(l[:i], l[i+1:]...)
+			break
+		}
+	}
+	if len(l) == 0 {
+		delete(r.pending, t.key)
+	} else {
+		r.pending[t.key] = l
+	}
+	rec := t.record
+	r.mu.Unlock()
+
+	rec.BytesSent = sent
+	rec.Duration = r.config.Clock.Now().Sub(t.start)
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	var werr error
+	if r.config.Dir != "" {
+		werr = writeRecord(r.config.Dir, rec)
+	}
+	if r.config.OnRecord != nil {
+		r.config.OnRecord(rec)
+	}
+	return rec, werr
+}
+
+// writeRecord writes rec to a JSON file of dir, named after its start, client and file name.
+func writeRecord(dir string, rec Record) error {
+	b, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%v-%v-%v.json", rec.Start.UTC().Format("20060102T150405.000000000"), rec.Client, filepath.Base(rec.Filename))
+	name = strings.NewReplacer(":", "_", "/", "_").Replace(name)
+	return os.WriteFile(filepath.Join(dir, name), b, 0o600)
+}
+
+// ReadFile returns the record of the JSON file name, written by a Recorder, for analysis.
+func ReadFile(name string) (Record, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return Record{}, err
+	}
+	var rec Record
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return Record{}, fmt.Errorf("parsing the capture %v: %w", name, err)
+	}
+	return rec, nil
+}
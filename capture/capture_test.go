@@ -0,0 +1,105 @@
+package capture
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/clock"
+	"inet.af/netaddr"
+)
+
+func TestRecorder(t *testing.T) {
+	start := time.Date(2022, 1, 6, 12, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	var records []Record
+	dir := t.TempDir()
+	r := New(Config{Dir: dir, OnRecord: func(rec Record) { records = append(records, rec) }, Clock: fake})
+	client := netaddr.IPv4(192, 168, 2, 10)
+
+	tr, rd := r.Start(client, "0a:00:27:00:00:02/snp.efi", 10, bytes.NewReader([]byte("0123456789")))
+	buf := make([]byte, 4)
+	for {
+		fake.Advance(100 * time.Millisecond)
+		if _, err := rd.Read(buf); errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	r.Negotiated(client, "0a:00:27:00:00:02/snp.efi", Stats{Mode: "octet", Options: map[string]string{"blksize": "4"}, DatagramsSent: 5, DatagramsAcked: 4})
+	got, err := tr.End(10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Record{
+		Client:         "192.168.2.10",
+		Filename:       "0a:00:27:00:00:02/snp.efi",
+		Start:          start,
+		Mode:           "octet",
+		Options:        map[string]string{"blksize": "4"},
+		Size:           10,
+		BytesSent:      10,
+		Duration:       400 * time.Millisecond,
+		DatagramsSent:  5,
+		DatagramsAcked: 4,
+		Events: []Event{
+			{At: 100 * time.Millisecond, Offset: 0, Bytes: 4},
+			{At: 200 * time.Millisecond, Offset: 4, Bytes: 4},
+			{At: 300 * time.Millisecond, Offset: 8, Bytes: 2},
+		},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff(records, []Record{want}); diff != "" {
+		t.Fatal(diff)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected a single file, got: %v, %v", files, err)
+	}
+	rec, err := ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(rec, want); diff != "" {
+		t.Fatal(diff)
+	}
+	if len(r.pending) != 0 {
+		t.Fatalf("expected the ended transfer not to be pending, got: %v", r.pending)
+	}
+}
+
+func TestRecorderClients(t *testing.T) {
+	r := New(Config{Clients: []netaddr.IPPrefix{netaddr.IPPrefixFrom(netaddr.IPv4(192, 168, 2, 10), 32)}, OnRecord: func(Record) {}})
+	for _, tt := range []struct {
+		client netaddr.IP
+		want   bool
+	}{{netaddr.IPv4(192, 168, 2, 10), true}, {netaddr.IPv4(192, 168, 2, 11), false}} {
+		tr, _ := r.Start(tt.client, "snp.efi", 0, bytes.NewReader(nil))
+		if got := tr != nil; got != tt.want {
+			t.Fatalf("%v: expected captured: %v, got: %v", tt.client, tt.want, got)
+		}
+	}
+
+	var nilRecorder *Recorder
+	in := bytes.NewReader(nil)
+	if tr, rd := nilRecorder.Start(netaddr.IPv4(192, 168, 2, 10), "snp.efi", 0, in); tr != nil || rd != in {
+		t.Fatal("expected a nil Recorder to capture nothing")
+	}
+	nilRecorder.Negotiated(netaddr.IPv4(192, 168, 2, 10), "snp.efi", Stats{})
+}
+
+func TestReadFileInvalid(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "capture.json")
+	if err := os.WriteFile(name, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(name); err == nil {
+		t.Fatal("expected an invalid capture to fail")
+	}
+}
@@ -0,0 +1,27 @@
+package ipxedust
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+// chainFiles returns the embedded binaries with their script replaced to chainload ChainURL.
+// Binaries without a replaceable script are left out, so they are served unchanged, as are those not compiled in.
+func (c *Server) chainFiles() (map[string][]byte, error) {
+	script := binary.ChainScript(c.ChainURL)
+	files := make(map[string][]byte, len(binary.Files))
+	for _, name := range binary.Available() {
+		b, err := binary.ReplaceScript(binary.Files[name], script)
+		if errors.Is(err, binary.ErrNoScript) {
+			c.log().Info("warning: binary doesn't support chainloading, serving it unchanged", "binary", name, "chainURL", c.ChainURL)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("chainloading %v from %v: %w", name, c.ChainURL, err)
+		}
+		files[name] = b
+	}
+	return files, nil
+}
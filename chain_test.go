@@ -0,0 +1,48 @@
+package ipxedust
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/binary"
+	"inet.af/netaddr"
+)
+
+func TestChainURL(t *testing.T) {
+	c := &Server{ChainURL: "http://192.168.2.1/boot.ipxe"}
+	if err := c.start(context.Background(), Server{Log: logr.Discard()}); err != nil {
+		t.Fatal(err)
+	}
+	client := netaddr.IPPortFrom(netaddr.IPv4(192, 168, 2, 10), 68)
+	want := []byte("#!ipxe\ndhcp\nchain http://192.168.2.1/boot.ipxe\n")
+	for _, name := range []string{"ipxe.efi", "snp.efi"} {
+		got, err := c.resolver().Resolve(context.Background(), client, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(got, want) {
+			t.Fatalf("expected %v to contain the chain script", name)
+		}
+		if bytes.Contains(got, binary.Script) {
+			t.Fatalf("expected the embedded script of %v to be replaced", name)
+		}
+	}
+	got, err := c.resolver().Resolve(context.Background(), client, "undionly.kpxe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, binary.Undionly) {
+		t.Fatal("expected undionly.kpxe to be served unchanged")
+	}
+}
+
+func TestChainURLTooLong(t *testing.T) {
+	c := &Server{ChainURL: "http://192.168.2.1/" + strings.Repeat("a", 64) + ".ipxe"}
+	if err := c.start(context.Background(), Server{Log: logr.Discard()}); !errors.Is(err, binary.ErrScriptTooLong) {
+		t.Fatalf("expected %v, got: %v", binary.ErrScriptTooLong, err)
+	}
+}
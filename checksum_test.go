@@ -0,0 +1,88 @@
+package ipxedust
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/firstboot"
+	"inet.af/netaddr"
+)
+
+func TestChecksumFiles(t *testing.T) {
+	ready := make(chan struct{}, 2)
+	addrs := make(map[Protocol]net.Addr)
+	var mu sync.Mutex
+	c := &Server{
+		Log:                 logr.Discard(),
+		EnableChecksumFiles: true,
+		OnReady: func(p Protocol, addr net.Addr) {
+			mu.Lock()
+			addrs[p] = addr
+			mu.Unlock()
+			ready <- struct{}{}
+		},
+	}
+	conn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Serve(ctx, conn, uconn) }()
+	defer func() {
+		cancel()
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}()
+	<-ready
+	<-ready
+	mu.Lock()
+	httpAddr, tftpAddr := addrs[ProtocolHTTP].String(), addrs[ProtocolTFTP].String()
+	mu.Unlock()
+
+	for name := range binary.Files {
+		t.Run(name, func(t *testing.T) {
+			tests := map[Protocol]func() []byte{
+				ProtocolHTTP: func() []byte { return httpGet(t, httpAddr, name+".sha256") },
+				ProtocolTFTP: func() []byte { return tftpGet(t, tftpAddr, name+".sha256") },
+			}
+			for p, get := range tests {
+				sum := sha256.Sum256(binary.Files[name])
+				got := strings.Fields(string(get()))
+				if len(got) != 2 || got[0] != hex.EncodeToString(sum[:]) || got[1] != name {
+					t.Fatalf("%v: expected the SHA-256 of %v, got: %q", p, name, got)
+				}
+			}
+		})
+	}
+}
+
+func TestChecksumFilesFirstBoot(t *testing.T) {
+	c := &Server{
+		EnableChecksumFiles: true,
+		FirstBoot:           &firstboot.Tracker{Name: "boot.ipxe", First: []byte("install"), Later: []byte("local")},
+	}
+	if err := c.start(context.Background(), Server{Log: logr.Discard()}); err != nil {
+		t.Fatal(err)
+	}
+	client := netaddr.MustParseIPPort("192.168.2.10:1234")
+	// the checksum of the first boot script isn't served, and requesting it doesn't use up the first boot.
+	if _, err := c.resolver().Resolve(context.Background(), client, "boot.ipxe.sha256"); err == nil {
+		t.Fatal("expected no checksum of the first boot script")
+	}
+	if got, err := c.resolver().Resolve(context.Background(), client, "boot.ipxe"); err != nil || string(got) != "install" {
+		t.Fatalf("expected the first boot script, got: %q, %v", got, err)
+	}
+}
@@ -0,0 +1,52 @@
+package ipxedust
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tinkerbell/ipxedust/firstboot"
+	"github.com/tinkerbell/ipxedust/resolve"
+	"inet.af/netaddr"
+)
+
+// ResetClientState forgets what the running server remembers about the client IP address, as if it never booted:
+// its next request of the first boot script of FirstBoot gets the first boot script, it is no longer rate limited,
+// its recent boots are dropped and the binaries cached from DynamicBinary for it are generated again.
+// The clients identified by a client ID, see resolve.ClientID, are remembered by FirstBoot under their ID, not their IP
+// address, and are not forgotten, see ResetClientIDState. Transfers in progress, and the TFTP transfers of the client counted by
+// MaxTFTPTransfersPerClient, are not affected. It is safe to call while serving.
+func (c *Server) ResetClientState(client netaddr.IP) error {
+	c.limiter.Forget(client)
+	c.recentBoots.Forget(client.String())
+	c.dynamicCache.ForgetClient(client)
+	if c.FirstBoot != nil {
+		return c.FirstBoot.Forget(firstboot.Key(context.Background(), netaddr.IPPortFrom(client, 0)))
+	}
+	return nil
+}
+
+// ResetClientIDState forgets what FirstBoot remembers about the client identified by the client ID id, see
+// resolve.ClientID, as if it never booted: its next request of the first boot script gets the first boot script.
+// The rest of the state of the client is remembered by IP address, see ResetClientState. It is safe to call while serving.
+func (c *Server) ResetClientIDState(id string) error {
+	if id == "" {
+		return errors.New("empty client ID")
+	}
+	if c.FirstBoot != nil {
+		return c.FirstBoot.Forget(firstboot.Key(resolve.WithClientID(context.Background(), id), netaddr.IPPort{}))
+	}
+	return nil
+}
+
+// ResetAllClientState forgets what the running server remembers about all the clients, see ResetClientState,
+// including the clients identified by a client ID. It returns firstboot.ErrNotResettable when the Store of FirstBoot
+// can't forget all the clients, after resetting the rest. It is safe to call while serving.
+func (c *Server) ResetAllClientState() error {
+	c.limiter.Reset()
+	c.recentBoots.Reset()
+	c.dynamicCache.Purge()
+	if c.FirstBoot != nil {
+		return c.FirstBoot.Reset()
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package ipxedust
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/firstboot"
+	"github.com/tinkerbell/ipxedust/ratelimit"
+	"github.com/tinkerbell/ipxedust/recent"
+	"github.com/tinkerbell/ipxedust/resolve"
+	"inet.af/netaddr"
+)
+
+func TestResetClientState(t *testing.T) {
+	c := &Server{
+		FirstBoot:        &firstboot.Tracker{Name: "first.ipxe", First: []byte("install"), Later: []byte("local")},
+		RateLimit:        ratelimit.Config{PerClientRequestsPerSecond: 0.001},
+		TrackRecentBoots: true,
+	}
+	if err := c.start(context.Background(), Server{Log: logr.Discard()}); err != nil {
+		t.Fatal(err)
+	}
+	clients := []netaddr.IPPort{netaddr.MustParseIPPort("192.168.2.10:1234"), netaddr.MustParseIPPort("192.168.2.11:1234")}
+	// boot returns the first boot script served to client, and whether its request was rate limited.
+	boot := func(client netaddr.IPPort) []string {
+		got, err := c.resolver().Resolve(context.Background(), client, "first.ipxe")
+		if err != nil {
+			t.Fatal(err)
+		}
+		allowed, _ := c.limiter.Allow(client.IP())
+		return []string{string(got), map[bool]string{true: "allowed", false: "limited"}[allowed]}
+	}
+	for _, client := range clients {
+		boot(client)
+		c.recentBoots.Add(recent.Boot{Client: client.IP().String(), Filename: "first.ipxe"})
+	}
+
+	if err := c.ResetClientState(clients[0].IP()); err != nil {
+		t.Fatal(err)
+	}
+	got := [][]string{boot(clients[0]), boot(clients[1])}
+	if diff := cmp.Diff(got, [][]string{{"install", "allowed"}, {"local", "limited"}}); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff(c.recentBoots.List(), []recent.Boot{{Client: "192.168.2.11", Filename: "first.ipxe"}}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if err := c.ResetAllClientState(); err != nil {
+		t.Fatal(err)
+	}
+	got = [][]string{boot(clients[0]), boot(clients[1])}
+	if diff := cmp.Diff(got, [][]string{{"install", "allowed"}, {"install", "allowed"}}); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff(c.recentBoots.List(), []recent.Boot{}); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestResetClientIDState(t *testing.T) {
+	c := &Server{FirstBoot: &firstboot.Tracker{Name: "first.ipxe", First: []byte("install"), Later: []byte("local")}}
+	if err := c.start(context.Background(), Server{Log: logr.Discard()}); err != nil {
+		t.Fatal(err)
+	}
+	client := netaddr.MustParseIPPort("192.168.2.10:1234")
+	// boot returns the first boot script served to the client identified by id.
+	boot := func(id string) string {
+		got, err := c.resolver().Resolve(resolve.WithClientID(context.Background(), id), client, "first.ipxe")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(got)
+	}
+	boot("machine-1")
+	boot("machine-2")
+
+	if err := c.ResetClientState(client.IP()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ResetClientIDState("machine-1"); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{boot("machine-1"), boot("machine-2")}, []string{"install", "local"}); diff != "" {
+		t.Fatal(diff)
+	}
+	if err := c.ResetClientIDState(""); err == nil {
+		t.Fatal("expected an error for an empty client ID")
+	}
+}
+
+func TestResetClientStateNotServing(t *testing.T) {
+	// the state is created when serving starts, there is nothing to reset before.
+	c := &Server{}
+	if err := c.ResetClientState(netaddr.MustParseIP("192.168.2.10")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ResetClientIDState("machine-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ResetAllClientState(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,136 @@
+// Package clock abstracts the time, so that time dependent code can be tested without sleeping.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// Sleep pauses the current goroutine for at least d.
+	Sleep(d time.Duration)
+}
+
+// Or returns c, or the Real clock when c is nil. It lets a nil Clock field mean the real clock.
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real{}
+	}
+	return c
+}
+
+// After returns a channel receiving the time once d elapsed on c, for a select, and a function stopping the timer,
+// which releases it early. The Real clock is used when c is nil, or doesn't have an After method like Real and Fake.
+func After(c Clock, d time.Duration) (<-chan time.Time, func()) {
+	if t, ok := c.(interface {
+		After(time.Duration) (<-chan time.Time, func())
+	}); ok {
+		return t.After(d)
+	}
+	return Real{}.After(d)
+}
+
+// Real is the Clock of the system.
+type Real struct{}
+
+// Now returns time.Now().
+func (Real) Now() time.Time { return time.Now() }
+
+// Sleep calls time.Sleep.
+func (Real) Sleep(d time.Duration) { time.Sleep(d) }
+
+// After returns the channel of a time.Timer of d, and a function stopping it.
+func (Real) After(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTimer(d)
+	return t.C, func() { t.Stop() }
+}
+
+// Fake is a Clock whose time only moves when Advance is called. It is safe for concurrent use.
+type Fake struct {
+	mu       sync.Mutex
+	now      time.Time
+	sleepers []sleeper
+}
+
+// sleeper is a goroutine blocked in Fake.Sleep, or a timer of Fake.After when fire is not nil.
+type sleeper struct {
+	until time.Time
+	wake  chan struct{}
+	fire  chan time.Time
+}
+
+// NewFake returns a Fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the time of the fake clock.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Sleep blocks until the fake clock is advanced by at least d.
+func (f *Fake) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	f.mu.Lock()
+	s := sleeper{until: f.now.Add(d), wake: make(chan struct{})}
+	f.sleepers = append(f.sleepers, s)
+	f.mu.Unlock()
+	<-s.wake
+}
+
+// After returns a channel receiving the time of the fake clock once it is advanced by at least d, and a function
+// stopping the timer.
+func (f *Fake) After(d time.Duration) (<-chan time.Time, func()) {
+	fire := make(chan time.Time, 1)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if d <= 0 {
+		fire <- f.now
+		return fire, func() {}
+	}
+	f.sleepers = append(f.sleepers, sleeper{until: f.now.Add(d), fire: fire})
+	return fire, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for i, s := range f.sleepers {
+			if s.fire == fire {
+				f.sleepers = append(f.sleepers[:i], f.sleepers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Advance moves the fake clock forward by d and wakes up the sleepers, and fires the timers, whose time has come.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	sort.Slice(f.sleepers, func(i, j int) bool { return f.sleepers[i].until.Before(f.sleepers[j].until) })
+	i := 0
+	for ; i < len(f.sleepers) && !f.sleepers[i].until.After(f.now); i++ {
+		if f.sleepers[i].fire != nil {
+			f.sleepers[i].fire <- f.now
+			continue
+		}
+		close(f.sleepers[i].wake)
+	}
+	f.sleepers = f.sleepers[i:]
+}
+
+// Sleepers returns the number of goroutines blocked in Sleep, and of the timers of After pending.
+// Tests use it to wait for a goroutine to sleep, or to start a timer, before advancing the clock.
+func (f *Fake) Sleepers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.sleepers)
+}
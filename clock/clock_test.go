@@ -0,0 +1,89 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOr(t *testing.T) {
+	if _, ok := Or(nil).(Real); !ok {
+		t.Fatal("expected the real clock for a nil clock")
+	}
+	f := NewFake(time.Unix(0, 0))
+	if Or(f) != Clock(f) {
+		t.Fatal("expected the given clock")
+	}
+}
+
+func TestFake(t *testing.T) {
+	start := time.Date(2022, 1, 6, 0, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+	if got := f.Now(); !got.Equal(start) {
+		t.Fatalf("expected %v, got: %v", start, got)
+	}
+	f.Advance(time.Minute)
+	if got := f.Now(); !got.Equal(start.Add(time.Minute)) {
+		t.Fatalf("expected %v, got: %v", start.Add(time.Minute), got)
+	}
+	f.Sleep(0)
+}
+
+func TestFakeSleep(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+	woke := make(chan time.Duration, 2)
+	for _, d := range []time.Duration{time.Second, 3 * time.Second} {
+		d := d
+		go func() {
+			f.Sleep(d)
+			woke <- d
+		}()
+	}
+	for f.Sleepers() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	f.Advance(500 * time.Millisecond)
+	select {
+	case d := <-woke:
+		t.Fatalf("expected no sleeper to wake up, %v did", d)
+	default:
+	}
+	f.Advance(time.Second)
+	if d := <-woke; d != time.Second {
+		t.Fatalf("expected the %v sleeper to wake up, got: %v", time.Second, d)
+	}
+	if f.Sleepers() != 1 {
+		t.Fatalf("expected 1 sleeper, got: %v", f.Sleepers())
+	}
+	f.Advance(2 * time.Second)
+	if d := <-woke; d != 3*time.Second {
+		t.Fatalf("expected the %v sleeper to wake up, got: %v", 3*time.Second, d)
+	}
+}
+
+func TestFakeAfter(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+	fired, _ := After(f, time.Second)
+	stopped, stop := After(f, time.Second)
+	stop()
+	if f.Sleepers() != 1 {
+		t.Fatalf("expected 1 timer, got: %v", f.Sleepers())
+	}
+	f.Advance(500 * time.Millisecond)
+	select {
+	case <-fired:
+		t.Fatal("expected the timer not to fire yet")
+	default:
+	}
+	f.Advance(500 * time.Millisecond)
+	if got := <-fired; !got.Equal(time.Unix(1, 0)) {
+		t.Fatalf("expected the timer to fire at %v, got: %v", time.Unix(1, 0), got)
+	}
+	select {
+	case <-stopped:
+		t.Fatal("expected the stopped timer not to fire")
+	default:
+	}
+	if now, _ := After(f, 0); !(<-now).Equal(f.Now()) {
+		t.Fatal("expected a timer of zero to fire at once")
+	}
+}
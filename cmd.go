@@ -2,8 +2,12 @@ package ipxedust
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"io"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -13,6 +17,7 @@ import (
 	"github.com/peterbourgon/ff/v3"
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"github.com/rs/zerolog"
+	"github.com/tinkerbell/ipxedust/ratelimit"
 	"inet.af/netaddr"
 )
 
@@ -20,12 +25,16 @@ import (
 type Command struct {
 	// TFTPAddr is the TFTP server address:port.
 	TFTPAddr string `validate:"required,hostname_port"`
-	// TFTPTimeout is the timeout for serving individual TFTP requests.
-	TFTPTimeout time.Duration `validate:"required,gte=1s"`
-	// HTTPAddr is the HTTP server address:port.
-	HTTPAddr string `validate:"required,hostname_port"`
+	// TFTPTimeout is the per packet timeout of TFTP transfers: how long a transfer waits for the client to
+	// acknowledge a block before sending it again, see ServerSpec.Timeout. It doesn't limit the whole transfer,
+	// TFTPMaxTransferDuration does. It may be shorter than a second, to retransmit sooner on lossy networks.
+	TFTPTimeout time.Duration `validate:"required,gte=100ms"`
+	// HTTPAddr is the HTTP server address:port, or the path of a Unix domain socket prefixed with "unix:".
+	HTTPAddr string `validate:"required,hostname_port|startswith=unix:"`
 	// HTTPTimeout is the timeout for serving individual HTTP requests.
 	HTTPTimeout time.Duration `validate:"required,gte=1s"`
+	// Port, when not zero, replaces the port of both TFTPAddr and HTTPAddr, see WithPort.
+	Port uint `validate:"lte=65535"`
 	// Log is the logging implementation.
 	Log logr.Logger
 	// LogLevel defines the logging level.
@@ -42,35 +51,164 @@ type Command struct {
 	// experimental and "Enabling this will negatively impact performance". Please take this into
 	// consideration when using this option.
 	EnableTFTPSinglePort bool
+	// TFTPDisabled disables the TFTP server.
+	TFTPDisabled bool
+	// TFTPOptional keeps serving HTTP when the TFTP server fails to bind, see Server.TFTPOptional.
+	TFTPOptional bool
+	// HTTPDisabled disables the HTTP server.
+	HTTPDisabled bool
+	// PrintConfig prints the effective configuration, see Server.EffectiveConfig, and exits instead of serving.
+	PrintConfig bool
+	// RateLimit is the request rate, per second, across all clients and both protocols. Zero means unlimited.
+	RateLimit float64 `validate:"gte=0"`
+	// RateLimitBurst is the number of requests allowed to exceed RateLimit at once. Defaults to RateLimit rounded up.
+	RateLimitBurst int `validate:"gte=0"`
+	// ClientRateLimit is the request rate, per second, of a single client IP. Zero means unlimited.
+	ClientRateLimit float64 `validate:"gte=0"`
+	// ClientRateLimitBurst is the number of requests a single client IP is allowed to exceed ClientRateLimit by at once.
+	// Defaults to ClientRateLimit rounded up.
+	ClientRateLimitBurst int `validate:"gte=0"`
+	// MaxTFTPTransfersPerClient caps the number of concurrent TFTP transfers from a single client IP. Zero means unlimited.
+	MaxTFTPTransfersPerClient int `validate:"gte=0"`
+	// TFTPMaxTransferDuration is a hard limit on the duration of a single TFTP transfer, regardless of activity,
+	// independent of TFTPTimeout. Zero means no limit.
+	TFTPMaxTransferDuration time.Duration `validate:"gte=0"`
+	// HTTPMaxTransferDuration is a hard limit on the duration of a single HTTP transfer. Zero means no limit.
+	HTTPMaxTransferDuration time.Duration `validate:"gte=0"`
+	// ShutdownTimeout is how long Execute waits for the transfers in flight to complete once its context is done,
+	// for example on SIGTERM, before giving up with ErrShutdownTimeout. Zero waits until they complete.
+	// Set it below the termination grace period of the orchestrator, so the forced exit is logged.
+	ShutdownTimeout time.Duration `validate:"gte=0"`
+	// ArtificialLatency delays every request of both protocols, to test how clients handle a slow server, see
+	// Server.ArtificialLatency. Zero adds no delay.
+	ArtificialLatency time.Duration `validate:"gte=0"`
 }
 
+// DefaultShutdownTimeout is the default Command.ShutdownTimeout.
+const DefaultShutdownTimeout = 20 * time.Second
+
+// ErrShutdownTimeout is returned by Execute when serving doesn't stop within Command.ShutdownTimeout.
+// The process should exit, abandoning the transfers still in flight.
+var ErrShutdownTimeout = errors.New("graceful shutdown timed out")
+
 // Execute runs the ipxe command.
 // Flags are registered, cli/env vars are parsed, the Command struct is validated,
 // and the tftp and http services are run.
+//
+// Every flag can also be set with an environment variable of the same name, upper cased,
+// with dashes replaced by underscores and prefixed with "IPXE_". For example, -tftp-addr
+// can be set with IPXE_TFTP_ADDR and -http-disabled with IPXE_HTTP_DISABLED.
+// Flags take precedence over environment variables, which take precedence over the defaults.
+//
+// A SIGHUP reloads the configuration, re-reading the flags and environment variables, without
+// closing the listeners or interrupting in-flight transfers. Timeouts, limits and the log level are applied.
+// Changes to other settings, like the listen addresses, are logged as requiring a restart and are ignored.
+//
+// Once ctx is done, the transfers in flight are given -shutdown-timeout to complete. When they don't,
+// ErrShutdownTimeout is returned without waiting for them, and the caller is expected to exit.
 func Execute(ctx context.Context, args []string) error {
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+	load := func() (*Command, error) {
+		c := &Command{}
+		if err := newCommand(c).Parse(args); err != nil {
+			return nil, err
+		}
+		if err := c.configure(); err != nil {
+			return nil, err
+		}
+		return c, c.setDefaults()
+	}
 	c := &Command{}
+	cmd := newCommand(c)
+	cmd.Exec = func(ctx context.Context, _ []string) error {
+		if err := c.configure(); err != nil {
+			return err
+		}
+		if err := c.setDefaults(); err != nil {
+			return err
+		}
+		if c.PrintConfig {
+			return c.printConfig(os.Stdout)
+		}
+		return c.withShutdownTimeout(ctx, func(ctx context.Context) error {
+			return c.serveReloadable(ctx, reload, load)
+		})
+	}
+	return cmd.ParseAndRun(ctx, args)
+}
+
+// withShutdownTimeout calls serve and returns its error. Once ctx is done, serve is given ShutdownTimeout to return,
+// after which ErrShutdownTimeout is returned without waiting for it any longer. Whether the shutdown was graceful is logged.
+func (c *Command) withShutdownTimeout(ctx context.Context, serve func(context.Context) error) error {
+	errCh := make(chan error, 1)
+	go func() { errCh <- serve(ctx) }()
+	var timeout <-chan time.Time
+	select {
+	case err := <-errCh:
+		if ctx.Err() != nil {
+			c.Log.Info("shutdown complete", "graceful", true)
+		}
+		return err
+	case <-ctx.Done():
+	}
+	if c.ShutdownTimeout > 0 {
+		t := time.NewTimer(c.ShutdownTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case err := <-errCh:
+		c.Log.Info("shutdown complete", "graceful", true)
+		return err
+	case <-timeout:
+		c.Log.Info("shutdown timed out, exiting with transfers in flight", "graceful", false, "timeout", c.ShutdownTimeout)
+		return ErrShutdownTimeout
+	}
+}
+
+// newCommand returns the ffcli command that parses flags and environment variables into c and runs it.
+func newCommand(c *Command) *ffcli.Command {
 	fs := flag.NewFlagSet("ipxe", flag.ExitOnError)
 	c.RegisterFlags(fs)
-	cmd := &ffcli.Command{
+	return &ffcli.Command{
 		Name:       "ipxe",
 		ShortUsage: "Run TFTP and HTTP iPXE binary server",
 		FlagSet:    fs,
 		Options:    []ff.Option{ff.WithEnvVarPrefix("IPXE")},
 		Exec: func(ctx context.Context, args []string) error {
-			c.Log = defaultLogger(c.LogLevel)
-			c.Log = c.Log.WithName("ipxe")
-			if err := c.Validate(); err != nil {
+			if err := c.configure(); err != nil {
 				return err
 			}
 
 			return c.Run(ctx)
 		},
 	}
-	return cmd.ParseAndRun(ctx, args)
+}
+
+// configure sets the logger from the log level and validates c.
+func (c *Command) configure() error {
+	c.Log = defaultLogger(c.LogLevel)
+	c.Log = c.Log.WithName("ipxe")
+	return c.Validate()
 }
 
 // Run listens and serves the TFTP and HTTP services.
 func (c *Command) Run(ctx context.Context) error {
+	if err := c.setDefaults(); err != nil {
+		return err
+	}
+	srv, err := c.server()
+	if err != nil {
+		return err
+	}
+	return srv.ListenAndServe(ctx)
+}
+
+// setDefaults merges the default values into any zero value fields of c.
+func (c *Command) setDefaults() error {
 	defaults := Command{
 		TFTPAddr:    "0.0.0.0:69",
 		TFTPTimeout: 5 * time.Second,
@@ -80,45 +218,106 @@ func (c *Command) Run(ctx context.Context) error {
 		LogLevel:    "info",
 	}
 
-	err := mergo.Merge(c, defaults)
+	return mergo.Merge(c, defaults)
+}
+
+// printConfig writes the effective configuration of the Server configured by c to w.
+func (c *Command) printConfig(w io.Writer) error {
+	srv, err := c.server()
 	if err != nil {
 		return err
 	}
+	_, err = io.WriteString(w, srv.EffectiveConfig())
+	return err
+}
+
+// server returns the Server configured by c.
+func (c *Command) server() (*Server, error) {
 	tAddr, err := netaddr.ParseIPPort(c.TFTPAddr)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	hAddr, err := netaddr.ParseIPPort(c.HTTPAddr)
-	if err != nil {
-		return err
+	var hAddr netaddr.IPPort
+	unixSocket, unix := unixSocketPath(c.HTTPAddr)
+	if !unix {
+		if hAddr, err = netaddr.ParseIPPort(c.HTTPAddr); err != nil {
+			return nil, err
+		}
 	}
-	srv := Server{
+	srv := &Server{
 		TFTP: ServerSpec{
-			Addr:    tAddr,
-			Timeout: c.TFTPTimeout,
+			Addr:                tAddr,
+			Timeout:             c.TFTPTimeout,
+			Disabled:            c.TFTPDisabled,
+			MaxTransferDuration: c.TFTPMaxTransferDuration,
 		},
 		HTTP: ServerSpec{
-			Addr:    hAddr,
-			Timeout: c.HTTPTimeout,
+			Addr:                hAddr,
+			Timeout:             c.HTTPTimeout,
+			Disabled:            c.HTTPDisabled,
+			MaxTransferDuration: c.HTTPMaxTransferDuration,
+			UnixSocket:          unixSocket,
+		},
+		Log:                  c.Log,
+		EnableTFTPSinglePort: c.EnableTFTPSinglePort,
+		TFTPOptional:         c.TFTPOptional,
+		RateLimit: ratelimit.Config{
+			RequestsPerSecond:          c.RateLimit,
+			Burst:                      c.RateLimitBurst,
+			PerClientRequestsPerSecond: c.ClientRateLimit,
+			PerClientBurst:             c.ClientRateLimitBurst,
 		},
-		Log: c.Log,
+		MaxTFTPTransfersPerClient: c.MaxTFTPTransfersPerClient,
+		ArtificialLatency:         c.ArtificialLatency,
 	}
-	return srv.ListenAndServe(ctx)
+	if c.Port != 0 {
+		if err := srv.setPort(uint16(c.Port)); err != nil {
+			return nil, err
+		}
+	}
+	return srv, nil
 }
 
 // RegisterFlags registers a flag set for the ipxe command.
 func (c *Command) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&c.TFTPAddr, "tftp-addr", "0.0.0.0:69", "TFTP server address")
-	f.DurationVar(&c.TFTPTimeout, "tftp-timeout", time.Second*5, "TFTP server timeout")
-	f.StringVar(&c.HTTPAddr, "http-addr", "0.0.0.0:8080", "HTTP server address")
+	f.DurationVar(&c.TFTPTimeout, "tftp-timeout", time.Second*5, "Time a TFTP transfer waits for each block to be acknowledged before sending it again (per packet, see -tftp-max-transfer-duration)")
+	f.StringVar(&c.HTTPAddr, "http-addr", "0.0.0.0:8080", "HTTP server address, or unix:<path> for a Unix domain socket")
 	f.DurationVar(&c.HTTPTimeout, "http-timeout", time.Second*5, "HTTP server timeout")
+	f.UintVar(&c.Port, "port", 0, "Port of both the TFTP (UDP) and HTTP (TCP) servers, replacing the ports of -tftp-addr and -http-addr (0 keeps them)")
 	f.StringVar(&c.LogLevel, "log-level", "info", "Log level")
 	f.BoolVar(&c.EnableTFTPSinglePort, "tftp-single-port", false, "Enable single port mode for TFTP server (needed for container deploys)")
+	f.BoolVar(&c.TFTPDisabled, "tftp-disabled", false, "Disable the TFTP server")
+	f.BoolVar(&c.TFTPOptional, "tftp-optional", false, "Keep serving HTTP when the TFTP server fails to bind, instead of exiting")
+	f.BoolVar(&c.HTTPDisabled, "http-disabled", false, "Disable the HTTP server")
+	f.BoolVar(&c.PrintConfig, "print-config", false, "Print the effective configuration and exit")
+	f.Float64Var(&c.RateLimit, "rate-limit", 0, "Requests per second across all clients (0 is unlimited)")
+	f.IntVar(&c.RateLimitBurst, "rate-limit-burst", 0, "Requests allowed to exceed the rate limit at once (defaults to the rate limit)")
+	f.Float64Var(&c.ClientRateLimit, "client-rate-limit", 0, "Requests per second of a single client IP (0 is unlimited)")
+	f.IntVar(&c.ClientRateLimitBurst, "client-rate-limit-burst", 0, "Requests a single client IP is allowed to exceed the client rate limit by at once (defaults to the client rate limit)")
+	f.IntVar(&c.MaxTFTPTransfersPerClient, "tftp-max-transfers-per-client", 0, "Concurrent TFTP transfers of a single client IP (0 is unlimited)")
+	f.DurationVar(&c.TFTPMaxTransferDuration, "tftp-max-transfer-duration", 0, "Maximum duration of a whole TFTP transfer, regardless of activity (0 is unlimited)")
+	f.DurationVar(&c.HTTPMaxTransferDuration, "http-max-transfer-duration", 0, "Maximum duration of an HTTP transfer (0 is unlimited)")
+	f.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", DefaultShutdownTimeout, "Time to wait for transfers to complete on shutdown before exiting (0 waits until they complete)")
+	f.DurationVar(&c.ArtificialLatency, "artificial-latency", 0, "Delay before serving every request, to test client timeouts against a slow server (testing only, 0 is no delay)")
 }
 
-// Validate checks the Command struct for validation errors.
+// Validate checks the Command struct for validation errors, and the ports for configurations that can't serve
+// clients, see ErrSuspiciousPorts. Port configurations that are likely mistakes are logged as warnings.
 func (c *Command) Validate() error {
-	return validator.New().Struct(c)
+	if err := validator.New().Struct(c); err != nil {
+		return err
+	}
+	warnings, err := c.checkPorts()
+	if err != nil {
+		return err
+	}
+	if c.Log.GetSink() != nil {
+		for _, w := range warnings {
+			c.Log.Info("warning: "+w, "tftpAddr", c.TFTPAddr, "httpAddr", c.HTTPAddr)
+		}
+	}
+	return nil
 }
 
 // defaultLogger is a zerolog logr implementation.
@@ -1,17 +1,26 @@
 package ipxedust
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/phayes/freeport"
+	"github.com/tinkerbell/ipxedust/ratelimit"
+	"inet.af/netaddr"
 )
 
 func TestCommand_RegisterFlags(t *testing.T) {
@@ -23,11 +32,25 @@ func TestCommand_RegisterFlags(t *testing.T) {
 			c := &Command{}
 			fs := flag.NewFlagSet("ipxe", flag.ExitOnError)
 			fs.StringVar(&c.TFTPAddr, "tftp-addr", "0.0.0.0:69", "TFTP server address")
-			fs.DurationVar(&c.TFTPTimeout, "tftp-timeout", time.Second*5, "TFTP server timeout")
-			fs.StringVar(&c.HTTPAddr, "http-addr", "0.0.0.0:8080", "HTTP server address")
+			fs.DurationVar(&c.TFTPTimeout, "tftp-timeout", time.Second*5, "Time a TFTP transfer waits for each block to be acknowledged before sending it again (per packet, see -tftp-max-transfer-duration)")
+			fs.StringVar(&c.HTTPAddr, "http-addr", "0.0.0.0:8080", "HTTP server address, or unix:<path> for a Unix domain socket")
 			fs.DurationVar(&c.HTTPTimeout, "http-timeout", time.Second*5, "HTTP server timeout")
+			fs.UintVar(&c.Port, "port", 0, "Port of both the TFTP (UDP) and HTTP (TCP) servers, replacing the ports of -tftp-addr and -http-addr (0 keeps them)")
 			fs.StringVar(&c.LogLevel, "log-level", "info", "Log level")
 			fs.BoolVar(&c.EnableTFTPSinglePort, "tftp-single-port", false, "Enable single port mode for TFTP server (needed for container deploys)")
+			fs.BoolVar(&c.TFTPDisabled, "tftp-disabled", false, "Disable the TFTP server")
+			fs.BoolVar(&c.TFTPOptional, "tftp-optional", false, "Keep serving HTTP when the TFTP server fails to bind, instead of exiting")
+			fs.BoolVar(&c.HTTPDisabled, "http-disabled", false, "Disable the HTTP server")
+			fs.BoolVar(&c.PrintConfig, "print-config", false, "Print the effective configuration and exit")
+			fs.Float64Var(&c.RateLimit, "rate-limit", 0, "Requests per second across all clients (0 is unlimited)")
+			fs.IntVar(&c.RateLimitBurst, "rate-limit-burst", 0, "Requests allowed to exceed the rate limit at once (defaults to the rate limit)")
+			fs.Float64Var(&c.ClientRateLimit, "client-rate-limit", 0, "Requests per second of a single client IP (0 is unlimited)")
+			fs.IntVar(&c.ClientRateLimitBurst, "client-rate-limit-burst", 0, "Requests a single client IP is allowed to exceed the client rate limit by at once (defaults to the client rate limit)")
+			fs.IntVar(&c.MaxTFTPTransfersPerClient, "tftp-max-transfers-per-client", 0, "Concurrent TFTP transfers of a single client IP (0 is unlimited)")
+			fs.DurationVar(&c.TFTPMaxTransferDuration, "tftp-max-transfer-duration", 0, "Maximum duration of a whole TFTP transfer, regardless of activity (0 is unlimited)")
+			fs.DurationVar(&c.HTTPMaxTransferDuration, "http-max-transfer-duration", 0, "Maximum duration of an HTTP transfer (0 is unlimited)")
+			fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", DefaultShutdownTimeout, "Time to wait for transfers to complete on shutdown before exiting (0 waits until they complete)")
+			fs.DurationVar(&c.ArtificialLatency, "artificial-latency", 0, "Delay before serving every request, to test client timeouts against a slow server (testing only, 0 is no delay)")
 			return fs
 		}()},
 	}
@@ -55,7 +78,7 @@ func TestCommand_Run(t *testing.T) {
 		wantErr error
 	}{
 		{"success", &Command{TFTPAddr: fmt.Sprintf("0.0.0.0:%v", getPort()), HTTPAddr: fmt.Sprintf("0.0.0.0:%v", getPort())}, nil},
-		{"fail permission denied", &Command{TFTPAddr: "127.0.0.1:80"}, fmt.Errorf("listen udp 127.0.0.1:80: bind: permission denied")},
+		{"fail permission denied", &Command{TFTPAddr: "127.0.0.1:80"}, fmt.Errorf("listen udp 127.0.0.1:80: bind: permission denied: %v", bindPermissionHint)},
 		{"fail parse error", &Command{TFTPAddr: "127.0.0.1:AF"}, fmt.Errorf(`invalid port "AF" parsing "127.0.0.1:AF"`)},
 		{"fail parse error", &Command{HTTPAddr: "127.0.0.1:AF"}, fmt.Errorf(`invalid port "AF" parsing "127.0.0.1:AF"`)},
 	}
@@ -101,6 +124,48 @@ func TestCommand_Validate(t *testing.T) {
 			Log:         logr.Discard(),
 			LogLevel:    "info",
 		}, fmt.Errorf(`Key: 'Command.TFTPAddr' Error:Field validation for 'TFTPAddr' failed on the 'required' tag`)},
+		{"success unix domain socket", &Command{
+			TFTPAddr:    "0.0.0.0:69",
+			TFTPTimeout: 5 * time.Second,
+			HTTPAddr:    "unix:/run/ipxe.sock",
+			HTTPTimeout: 5 * time.Second,
+			Log:         logr.Discard(),
+			LogLevel:    "info",
+		}, nil},
+		{"fail port out of range", &Command{
+			TFTPAddr:    "0.0.0.0:69",
+			TFTPTimeout: 5 * time.Second,
+			HTTPAddr:    "0.0.0.0:8080",
+			HTTPTimeout: 5 * time.Second,
+			Port:        65536,
+			Log:         logr.Discard(),
+			LogLevel:    "info",
+		}, fmt.Errorf(`Key: 'Command.Port' Error:Field validation for 'Port' failed on the 'lte' tag`)},
+		{"success short TFTP timeout", &Command{
+			TFTPAddr:    "0.0.0.0:69",
+			TFTPTimeout: 200 * time.Millisecond,
+			HTTPAddr:    "0.0.0.0:8080",
+			HTTPTimeout: 5 * time.Second,
+			Log:         logr.Discard(),
+			LogLevel:    "info",
+		}, nil},
+		{"fail TFTP timeout too short", &Command{
+			TFTPAddr:    "0.0.0.0:69",
+			TFTPTimeout: 10 * time.Millisecond,
+			HTTPAddr:    "0.0.0.0:8080",
+			HTTPTimeout: 5 * time.Second,
+			Log:         logr.Discard(),
+			LogLevel:    "info",
+		}, fmt.Errorf(`Key: 'Command.TFTPTimeout' Error:Field validation for 'TFTPTimeout' failed on the 'gte' tag`)},
+		{"fail negative limit", &Command{
+			TFTPAddr:    "0.0.0.0:69",
+			TFTPTimeout: 5 * time.Second,
+			HTTPAddr:    "0.0.0.0:8080",
+			HTTPTimeout: 5 * time.Second,
+			Log:         logr.Discard(),
+			LogLevel:    "info",
+			RateLimit:   -1,
+		}, fmt.Errorf(`Key: 'Command.RateLimit' Error:Field validation for 'RateLimit' failed on the 'gte' tag`)},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -141,3 +206,285 @@ func TestExecute(t *testing.T) {
 		})
 	}
 }
+
+func TestCommand_Env(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want Server
+	}{
+		{
+			name: "defaults",
+			want: Server{
+				TFTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 69), Timeout: 5 * time.Second},
+				HTTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 8080), Timeout: 5 * time.Second},
+			},
+		},
+		{
+			name: "env vars",
+			env: map[string]string{
+				"IPXE_TFTP_ADDR":        "127.0.0.1:6969",
+				"IPXE_TFTP_DISABLED":    "true",
+				"IPXE_HTTP_ADDR":        "127.0.0.1:9090",
+				"IPXE_HTTP_TIMEOUT":     "10s",
+				"IPXE_TFTP_SINGLE_PORT": "true",
+			},
+			want: Server{
+				TFTP:                 ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), 6969), Timeout: 5 * time.Second, Disabled: true},
+				HTTP:                 ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), 9090), Timeout: 10 * time.Second},
+				EnableTFTPSinglePort: true,
+			},
+		},
+		{
+			name: "flags take precedence over env vars",
+			env: map[string]string{
+				"IPXE_HTTP_ADDR":     "127.0.0.1:9090",
+				"IPXE_HTTP_DISABLED": "true",
+			},
+			args: []string{"--http-addr=127.0.0.1:7070", "--http-disabled=false"},
+			want: Server{
+				TFTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 69), Timeout: 5 * time.Second},
+				HTTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), 7070), Timeout: 5 * time.Second},
+			},
+		},
+		{
+			name: "unix domain socket",
+			env:  map[string]string{"IPXE_HTTP_ADDR": "unix:/run/ipxe.sock"},
+			want: Server{
+				TFTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 69), Timeout: 5 * time.Second},
+				HTTP: ServerSpec{Timeout: 5 * time.Second, UnixSocket: "/run/ipxe.sock"},
+			},
+		},
+		{
+			name: "timeouts",
+			args: []string{"--tftp-timeout=500ms", "--tftp-max-transfer-duration=10m"},
+			want: Server{
+				TFTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 69), Timeout: 500 * time.Millisecond, MaxTransferDuration: 10 * time.Minute},
+				HTTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 8080), Timeout: 5 * time.Second},
+			},
+		},
+		{
+			name: "limits",
+			env: map[string]string{
+				"IPXE_RATE_LIMIT":                    "100",
+				"IPXE_TFTP_MAX_TRANSFERS_PER_CLIENT": "4",
+			},
+			args: []string{
+				"--rate-limit-burst=200",
+				"--client-rate-limit=0.5",
+				"--client-rate-limit-burst=3",
+				"--tftp-max-transfer-duration=2m",
+				"--http-max-transfer-duration=10m",
+			},
+			want: Server{
+				TFTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 69), Timeout: 5 * time.Second, MaxTransferDuration: 2 * time.Minute},
+				HTTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 8080), Timeout: 5 * time.Second, MaxTransferDuration: 10 * time.Minute},
+				RateLimit: ratelimit.Config{
+					RequestsPerSecond:          100,
+					Burst:                      200,
+					PerClientRequestsPerSecond: 0.5,
+					PerClientBurst:             3,
+				},
+				MaxTFTPTransfersPerClient: 4,
+			},
+		},
+		{
+			name: "artificial latency",
+			env:  map[string]string{"IPXE_ARTIFICIAL_LATENCY": "2s"},
+			want: Server{
+				TFTP:              ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 69), Timeout: 5 * time.Second},
+				HTTP:              ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 8080), Timeout: 5 * time.Second},
+				ArtificialLatency: 2 * time.Second,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			c := &Command{}
+			if err := newCommand(c).Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.server()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.TFTP != tt.want.TFTP {
+				t.Errorf("TFTP: got %+v, want %+v", got.TFTP, tt.want.TFTP)
+			}
+			if got.HTTP != tt.want.HTTP {
+				t.Errorf("HTTP: got %+v, want %+v", got.HTTP, tt.want.HTTP)
+			}
+			if got.EnableTFTPSinglePort != tt.want.EnableTFTPSinglePort {
+				t.Errorf("EnableTFTPSinglePort: got %v, want %v", got.EnableTFTPSinglePort, tt.want.EnableTFTPSinglePort)
+			}
+			if got.RateLimit != tt.want.RateLimit {
+				t.Errorf("RateLimit: got %+v, want %+v", got.RateLimit, tt.want.RateLimit)
+			}
+			if got.MaxTFTPTransfersPerClient != tt.want.MaxTFTPTransfersPerClient {
+				t.Errorf("MaxTFTPTransfersPerClient: got %v, want %v", got.MaxTFTPTransfersPerClient, tt.want.MaxTFTPTransfersPerClient)
+			}
+			if got.ArtificialLatency != tt.want.ArtificialLatency {
+				t.Errorf("ArtificialLatency: got %v, want %v", got.ArtificialLatency, tt.want.ArtificialLatency)
+			}
+		})
+	}
+}
+
+func TestCommand_PrintConfig(t *testing.T) {
+	c := &Command{}
+	if err := newCommand(c).Parse([]string{"--print-config", "--http-addr=127.0.0.1:7070", "--rate-limit=10"}); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := c.printConfig(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"tftp.addr: 0.0.0.0:69\n", "http.addr: 127.0.0.1:7070\n", "rateLimit.requestsPerSecond: 10\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in the config, got:\n%v", want, out.String())
+		}
+	}
+}
+
+func TestCommand_Port(t *testing.T) {
+	c := &Command{}
+	if err := newCommand(c).Parse([]string{"--print-config", "--tftp-addr=127.0.0.1:69", "--port=6969"}); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := c.printConfig(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"tftp.addr: 127.0.0.1:6969\n", "http.addr: 0.0.0.0:6969\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in the config, got:\n%v", want, out.String())
+		}
+	}
+
+	c = &Command{}
+	if err := newCommand(c).Parse([]string{"--print-config", "--http-addr=unix:/run/ipxe.sock", "--port=6969"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.printConfig(io.Discard); err == nil {
+		t.Fatal("expected an error setting the port of a Unix domain socket")
+	}
+}
+
+func TestCommand_ShutdownTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		stuck   bool
+		wantErr error
+	}{
+		{name: "graceful", timeout: time.Minute},
+		{name: "stuck", timeout: 50 * time.Millisecond, stuck: true, wantErr: ErrShutdownTimeout},
+		{name: "no timeout", timeout: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var logged []string
+			c := &Command{
+				ShutdownTimeout: tt.timeout,
+				Log: funcr.New(func(prefix, args string) {
+					mu.Lock()
+					defer mu.Unlock()
+					logged = append(logged, args)
+				}, funcr.Options{}),
+			}
+			// the serve func outlives the subtest when it is stuck, so it doesn't read tt.
+			stuck := tt.stuck
+			release := make(chan struct{})
+			defer close(release)
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			start := time.Now()
+			err := c.withShutdownTimeout(ctx, func(ctx context.Context) error {
+				<-ctx.Done()
+				if stuck {
+					<-release
+				}
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error mismatch, got: %v, want: %v", err, tt.wantErr)
+			}
+			if elapsed := time.Since(start); tt.stuck && elapsed < tt.timeout {
+				t.Fatalf("expected to wait %v before exiting, waited: %v", tt.timeout, elapsed)
+			}
+			want := fmt.Sprintf(`"graceful"=%v`, !tt.stuck)
+			mu.Lock()
+			defer mu.Unlock()
+			if len(logged) != 1 || !strings.Contains(logged[0], want) {
+				t.Fatalf("expected %v to be logged, got: %v", want, logged)
+			}
+		})
+	}
+}
+
+func TestCommand_ServeError(t *testing.T) {
+	c := &Command{ShutdownTimeout: time.Minute, Log: logr.Discard()}
+	errServe := errors.New("bind failed")
+	err := c.withShutdownTimeout(context.Background(), func(context.Context) error { return errServe })
+	if !errors.Is(err, errServe) {
+		t.Fatalf("error mismatch, got: %v, want: %v", err, errServe)
+	}
+}
+
+func TestExecuteTFTPOptional(t *testing.T) {
+	taken, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	tests := []struct {
+		name     string
+		optional bool
+	}{
+		{name: "fail fast by default"},
+		{name: "optional", optional: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpAddr := fmt.Sprintf("127.0.0.1:%v", getPort())
+			args := []string{"--tftp-addr=" + taken.LocalAddr().String(), "--http-addr=" + httpAddr, fmt.Sprintf("--tftp-optional=%v", tt.optional)}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			errChan := make(chan error, 1)
+			go func() { errChan <- Execute(ctx, args) }()
+			if !tt.optional {
+				var be *BindError
+				if err := <-errChan; !errors.As(err, &be) || !be.AddrInUse() {
+					t.Fatalf("expected the TFTP bind error, got: %v", err)
+				}
+				return
+			}
+
+			// HTTP is served with the TFTP address taken.
+			deadline := time.Now().Add(10 * time.Second)
+			for {
+				resp, err := http.Get("http://" + httpAddr + "/snp.efi")
+				if err == nil {
+					resp.Body.Close()
+					if resp.StatusCode != http.StatusOK {
+						t.Fatalf("unexpected status code: %v", resp.StatusCode)
+					}
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("expected HTTP to be served, got: %v", err)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			cancel()
+			if err := <-errChan; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package ipxedust
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"path"
+
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/itftp"
+)
+
+// compressedFiles returns gzip compressed variants of the embedded EFI binaries, named with itftp.CompressedSuffix.
+// The binaries in files replace the embedded binaries of the same name. undionly.kpxe is already compressed and is left out, as are the binaries not compiled in.
+func compressedFiles(files map[string][]byte) (map[string][]byte, error) {
+	compressed := make(map[string][]byte, len(binary.Files))
+	for _, name := range binary.Available() {
+		content := binary.Files[name]
+		if path.Ext(name) != ".efi" {
+			continue
+		}
+		if b, ok := files[name]; ok {
+			content = b
+		}
+		var buf bytes.Buffer
+		zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := zw.Write(content); err != nil {
+			return nil, fmt.Errorf("compressing %v: %w", name, err)
+		}
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("compressing %v: %w", name, err)
+		}
+		compressed[name+itftp.CompressedSuffix] = buf.Bytes()
+	}
+	return compressed, nil
+}
@@ -0,0 +1,53 @@
+package ipxedust
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/binary"
+	"inet.af/netaddr"
+)
+
+func TestCompressedBinaries(t *testing.T) {
+	c := &Server{CompressedBinaries: true, ChainURL: "http://192.168.2.1/boot.ipxe"}
+	if err := c.start(context.Background(), Server{Log: logr.Discard()}); err != nil {
+		t.Fatal(err)
+	}
+	client := netaddr.IPPortFrom(netaddr.IPv4(192, 168, 2, 10), 68)
+	for _, name := range []string{"ipxe.efi", "snp.efi"} {
+		want, err := c.resolver().Resolve(context.Background(), client, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.resolver().Resolve(context.Background(), client, name+".gz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) >= len(want) {
+			t.Fatalf("expected %v.gz to be smaller than %v, got: %v bytes, %v bytes", name, name, len(got), len(want))
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(got))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("expected %v.gz to decompress to %v as served", name, name)
+		}
+		if bytes.Equal(got, binary.Files[name]) {
+			t.Fatalf("expected %v.gz to chainload ChainURL", name)
+		}
+	}
+	if _, err := c.resolver().Resolve(context.Background(), client, "undionly.kpxe.gz"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected %v, got: %v", os.ErrNotExist, err)
+	}
+}
@@ -0,0 +1,148 @@
+package ipxedust
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/imdario/mergo"
+)
+
+// EffectiveConfig returns the configuration ListenAndServe uses, after merging the defaults, for debugging.
+// It is one "key: value" line per setting, in a stable order: the addresses and timeouts of each protocol,
+// the enabled protocols, limits and the optional features. Hooks are reported as set or not, and only
+// the number of TLS certificates is reported, never their keys. c is not modified.
+func (c *Server) EffectiveConfig() string {
+	// only the protocol specs have defaults that are reported, merge them like setDefaults does.
+	// Copying c as a whole would race with the runtime state, like the maintenance mode.
+	specs := Server{TFTP: c.TFTP, HTTP: c.HTTP}
+	if !c.NoDefaults {
+		_ = mergo.Merge(&specs, listenAndServeDefaults(), mergo.WithTransformers(&specs))
+	}
+	var b strings.Builder
+	line := func(key string, value interface{}) {
+		fmt.Fprintf(&b, "%v: %v\n", key, value)
+	}
+	line("protocols", specs.protocols())
+	for _, p := range []struct {
+		name string
+		spec ServerSpec
+	}{{"tftp", specs.TFTP}, {"http", specs.HTTP}} {
+		line(p.name+".disabled", p.spec.Disabled)
+		if p.spec.UnixSocket != "" {
+			line(p.name+".addr", unixAddrPrefix+p.spec.UnixSocket)
+		} else {
+			line(p.name+".addr", p.spec.Addr)
+		}
+		line(p.name+".timeout", p.spec.Timeout)
+		line(p.name+".maxTransferDuration", p.spec.MaxTransferDuration)
+		line(p.name+".log", p.spec.Log.GetSink() != nil)
+	}
+	line("tftp.singlePort", c.EnableTFTPSinglePort)
+	line("tftp.optional", c.TFTPOptional)
+	line("tftp.readBufferSize", specs.TFTP.ReadBufferSize)
+	line("tftp.dataPorts", specs.TFTP.DataPorts)
+	line("tftp.disabledOptions", specs.TFTP.DisabledOptions)
+	line("tftp.maxTransfersPerClient", c.MaxTFTPTransfersPerClient)
+	line("tftp.workers", c.TFTPWorkers)
+	line("tftp.workerQueue", c.TFTPWorkerQueue)
+	line("tftp.multicast", c.TFTPMulticast)
+	line("tftp.multicastAddr", c.TFTPMulticastAddr)
+	line("tftp.multicastSessions", c.TFTPMulticastSessions)
+	line("tftp.captureTransfers", c.CaptureTransfers)
+	line("tftp.captureClients", c.CaptureClients)
+	line("tftp.captureDir", c.CaptureDir)
+	line("tftp.resume", c.EnableTFTPResume)
+	line("tftp.clientIDOption", c.ClientIDOption)
+	line("http.pathPrefix", pathPrefix(c.HTTPPathPrefix))
+	line("http.index", c.HTTPIndex != nil)
+	line("http.idleTimeout", specs.HTTP.IdleTimeout)
+	line("http.keepAlive", specs.HTTP.KeepAlive)
+	line("http.listenBacklog", specs.HTTP.ListenBacklog)
+	line("http.reusePort", specs.HTTP.ReusePort)
+	line("http.tlsCertificates", len(c.TLSCertificates))
+	line("http.clientAuth", c.ClientAuth)
+	line("http.allowClientCert", c.AllowClientCert != nil)
+	line("ipxeTrustAnchors", len(c.IPXETrustAnchors))
+	line("http.clientIDHeader", c.ClientIDHeader)
+	line("http.readyPath", c.ReadyPath)
+	line("http.manifestPath", c.ManifestPath)
+	line("http.optionsHeader", headerNames(c.HTTPOptionsHeader))
+	line("http.allowInlineScript", c.AllowInlineScript)
+	line("filenames", c.Filenames)
+	line("rateLimit.requestsPerSecond", c.RateLimit.RequestsPerSecond)
+	line("rateLimit.burst", c.RateLimit.Burst)
+	line("rateLimit.perClientRequestsPerSecond", c.RateLimit.PerClientRequestsPerSecond)
+	line("rateLimit.perClientBurst", c.RateLimit.PerClientBurst)
+	line("bandwidth.perTransfer", c.Bandwidth.PerTransfer)
+	line("bandwidth.total", c.Bandwidth.Total)
+	line("upstream.url", c.UpstreamURL)
+	line("upstream.ttl", c.UpstreamTTL)
+	line("upstream.maxSize", c.UpstreamMaxSize)
+	line("oci.reference", c.OCIReference)
+	line("oci.cacheDir", c.OCICacheDir)
+	line("chainURL", c.ChainURL)
+	line("compressedBinaries", c.CompressedBinaries)
+	line("useDefaultAliases", c.UseDefaultAliases)
+	line("aliases", len(c.Aliases))
+	line("archives", sortedKeys(c.Archives))
+	line("fileSystems", len(c.FileSystems))
+	line("fileSystemReadAhead", c.FileSystemReadAhead)
+	if t := c.embeddedModTime(); t.IsZero() {
+		line("embeddedModTime", "")
+	} else {
+		line("embeddedModTime", t.Format(time.RFC3339))
+	}
+	line("missingFileScript", c.MissingFileScript != nil)
+	line("defaultEFIBinary", c.DefaultEFIBinary)
+	line("tenants", c.tenantNames())
+	line("canary", c.CanaryRule.FS != nil)
+	line("canary.percent", c.CanaryRule.Percent)
+	line("canary.header", c.CanaryRule.Header)
+	line("canary.cookie", c.CanaryRule.Cookie)
+	line("canary.select", c.CanaryRule.Select != nil)
+	line("templates", len(c.Templates))
+	line("templateParams", len(c.TemplateParams))
+	line("uefiHTTPBoot", sortedKeys(c.UEFIHTTPBoot))
+	line("infoFile", c.EnableInfoFile)
+	line("checksumFiles", c.EnableChecksumFiles)
+	line("trackRecentBoots", c.TrackRecentBoots)
+	line("logRequestServed", c.LogRequestServed)
+	line("requestLogVerbosity", c.RequestLogVerbosity)
+	line("pprof", c.EnablePprof)
+	line("pprofAddr", c.PprofAddr)
+	line("tftpSelfTest", c.EnableTFTPSelfTest)
+	line("startPaused", c.StartPaused)
+	line("artificialLatency", c.ArtificialLatency)
+	line("dynamicBinary", c.DynamicBinary != nil)
+	line("firstBoot", c.FirstBoot != nil)
+	line("redirectResolver", c.RedirectResolver != nil)
+	line("authorize", c.Authorize != nil)
+	line("newTFTPServer", c.NewTFTPServer != nil)
+	line("recoverableErr", c.RecoverableErr != nil)
+	line("name", c.Name)
+	line("noDefaults", c.NoDefaults)
+	return b.String()
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// headerNames returns the canonical names of the headers of h in order. Their values are not reported.
+func headerNames(h http.Header) []string {
+	names := make([]string, 0, len(h))
+	for k := range h {
+		names = append(names, http.CanonicalHeaderKey(k))
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,94 @@
+package ipxedust
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/tinkerbell/ipxedust/ratelimit"
+	"inet.af/netaddr"
+)
+
+func TestEffectiveConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		server *Server
+		want   []string
+	}{
+		{
+			name:   "defaults",
+			server: &Server{},
+			want: []string{
+				"protocols: [tftp http]",
+				"tftp.addr: 0.0.0.0:69",
+				"tftp.timeout: 5s",
+				"http.addr: 0.0.0.0:8080",
+				"http.timeout: 5s",
+				"http.pathPrefix: /",
+				"noDefaults: false",
+			},
+		},
+		{
+			name: "overrides",
+			server: &Server{
+				TFTP:             ServerSpec{Disabled: true},
+				HTTP:             ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), 9090), Timeout: 10 * time.Second},
+				HTTPPathPrefix:   "ipxe",
+				RateLimit:        ratelimit.Config{PerClientRequestsPerSecond: 2},
+				Archives:         map[string]string{"scripts.tar": "/srv/scripts.tar", "bin.zip": "/srv/bin.zip"},
+				TrackRecentBoots: true,
+			},
+			want: []string{
+				"protocols: [http]",
+				"tftp.disabled: true",
+				"tftp.addr: 0.0.0.0:69",
+				"http.addr: 127.0.0.1:9090",
+				"http.timeout: 10s",
+				"http.pathPrefix: /ipxe/",
+				"rateLimit.perClientRequestsPerSecond: 2",
+				"archives: [bin.zip scripts.tar]",
+				"trackRecentBoots: true",
+			},
+		},
+		{
+			name:   "no defaults",
+			server: &Server{NoDefaults: true, HTTP: ServerSpec{UnixSocket: "/run/ipxe.sock"}},
+			want: []string{
+				"tftp.addr: invalid IPPort",
+				"tftp.timeout: 0s",
+				"http.addr: unix:/run/ipxe.sock",
+				"noDefaults: true",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := *tt.server
+			got := tt.server.EffectiveConfig()
+			lines := strings.Split(got, "\n")
+			for _, want := range tt.want {
+				if !contains(lines, want) {
+					t.Errorf("expected %q, got:\n%v", want, got)
+				}
+			}
+			if diff := cmp.Diff(tt.server.TFTP, before.TFTP, cmp.Comparer(func(a, b netaddr.IPPort) bool { return a == b }), cmpopts.IgnoreFields(ServerSpec{}, "Log")); diff != "" {
+				t.Fatal("expected the server to be left unchanged:", diff)
+			}
+			if got != tt.server.EffectiveConfig() {
+				t.Fatal("expected a stable output")
+			}
+		})
+	}
+}
+
+// contains reports whether lines contains line.
+func contains(lines []string, line string) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
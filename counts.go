@@ -0,0 +1,68 @@
+package ipxedust
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+// ServedOther is the ServedCounts key counting the files served that are not embedded binaries,
+// like templates and files from upstream.
+const ServedOther = "other"
+
+// AvailableBinaries returns the names of the embedded iPXE binaries compiled into this build, sorted. A build that
+// doesn't build every binary, like snp.efi without an ARM cross compiler, embeds an empty file in its place: it is not
+// served and a warning is logged when it is requested. See binary.Available.
+func AvailableBinaries() []string {
+	return binary.Available()
+}
+
+// ErrNoBinaries is returned when serving starts without any iPXE binary to serve: none is compiled into this build,
+// see AvailableBinaries, and no other source of binaries is configured, unless DynamicBinary is set.
+var ErrNoBinaries = errors.New("no iPXE binaries to serve: none compiled into this build and no other source configured")
+
+// servesBinaries reports whether the running server has a source of iPXE binaries other than DynamicBinary: the
+// embedded binaries compiled into this build, the files of ChainURL or OCIReference, FileSystems, Tenants or UpstreamURL.
+func (c *Server) servesBinaries() bool {
+	return len(binary.Available()) > 0 || len(c.files) > 0 || len(c.FileSystems) > 0 || len(c.Tenants) > 0 || c.upstream != nil
+}
+
+// servedCounts counts the files served per embedded binary name, plus ServedOther.
+// The keys are fixed when it is created, so it is bounded and the counters are updated without locking.
+type servedCounts map[string]*uint64
+
+// newServedCounts returns a servedCounts with a zero counter for each embedded binary and ServedOther.
+func newServedCounts() servedCounts {
+	s := make(servedCounts, len(binary.Files)+1)
+	for name := range binary.Files {
+		s[name] = new(uint64)
+	}
+	s[ServedOther] = new(uint64)
+	return s
+}
+
+// add counts a serve of the file name.
+func (s servedCounts) add(name string) {
+	n, ok := s[name]
+	if !ok {
+		n = s[ServedOther]
+	}
+	atomic.AddUint64(n, 1)
+}
+
+// ServedCounts returns the number of times each embedded binary was served, over both protocols, since serving started,
+// keyed by binary name. Files that are not embedded binaries are counted together under ServedOther.
+// Files requested by an alias are counted under the name of the binary they are an alias of.
+// It is safe to call concurrently with serving. Before serving starts, all counts are zero.
+func (c *Server) ServedCounts() map[string]uint64 {
+	s := c.served
+	if s == nil {
+		s = newServedCounts()
+	}
+	counts := make(map[string]uint64, len(s))
+	for name, n := range s {
+		counts[name] = atomic.LoadUint64(n)
+	}
+	return counts
+}
@@ -0,0 +1,115 @@
+package ipxedust
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/binary"
+	"inet.af/netaddr"
+)
+
+func TestAvailableBinaries(t *testing.T) {
+	snp := binary.Files["snp.efi"]
+	binary.Files["snp.efi"] = []byte{}
+	defer func() { binary.Files["snp.efi"] = snp }()
+	if diff := cmp.Diff([]string{"ipxe.efi", "undionly.kpxe"}, AvailableBinaries()); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestStartNoBinaries(t *testing.T) {
+	files := binary.Files
+	binary.Files = map[string][]byte{"ipxe.efi": {}, "snp.efi": {}, "undionly.kpxe": {}}
+	defer func() { binary.Files = files }()
+	dynamic := func(ctx context.Context, client netaddr.IPPort, name string) ([]byte, bool, error) {
+		return []byte("dynamic"), true, nil
+	}
+	tests := []struct {
+		name string
+		c    *Server
+		want error
+	}{
+		{name: "empty binary set", c: &Server{}, want: ErrNoBinaries},
+		{name: "empty binary set with dynamic resolver", c: &Server{DynamicBinary: dynamic}},
+		{name: "empty binary set with file systems", c: &Server{FileSystems: []fs.FS{fstest.MapFS{}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.c.start(context.Background(), Server{Log: logr.Discard()})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestServedCounts(t *testing.T) {
+	ready := make(chan struct{}, 2)
+	addrs := make(map[Protocol]net.Addr)
+	var mu sync.Mutex
+	c := &Server{
+		Log:               logr.Discard(),
+		UseDefaultAliases: true,
+		Templates:         New(WithTemplate("boot.ipxe", "#!ipxe\nautoboot\n")).Templates,
+		OnReady: func(p Protocol, addr net.Addr) {
+			mu.Lock()
+			addrs[p] = addr
+			mu.Unlock()
+			ready <- struct{}{}
+		},
+	}
+	want := map[string]uint64{"ipxe.efi": 0, "snp.efi": 0, "undionly.kpxe": 0, ServedOther: 0}
+	if diff := cmp.Diff(c.ServedCounts(), want); diff != "" {
+		t.Fatal("before serving:", diff)
+	}
+	conn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Serve(ctx, conn, uconn) }()
+	defer func() {
+		cancel()
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}()
+	<-ready
+	<-ready
+	mu.Lock()
+	httpAddr, tftpAddr := addrs[ProtocolHTTP].String(), addrs[ProtocolTFTP].String()
+	mu.Unlock()
+
+	httpGet(t, httpAddr, "snp.efi")
+	httpGet(t, httpAddr, "0a:00:27:00:00:02/snp.efi")
+	httpGet(t, httpAddr, "bootx64.efi")
+	httpGet(t, httpAddr, "boot.ipxe")
+	tftpGet(t, tftpAddr, "snp.efi")
+	tftpGet(t, tftpAddr, "undionly.kpxe")
+	want = map[string]uint64{"ipxe.efi": 1, "snp.efi": 3, "undionly.kpxe": 1, ServedOther: 1}
+	// the TFTP handler counts the transfer once the client acknowledged the last block, after the client returns.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		diff := cmp.Diff(c.ServedCounts(), want)
+		if diff == "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(diff)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,89 @@
+package ipxedust
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// errorList collects the errors of the goroutines serving the protocols.
+type errorList struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+// add records err, when it is not nil, and returns it so it can be returned to an errgroup.
+func (l *errorList) add(err error) error {
+	if err == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errs = append(l.errs, err)
+	return err
+}
+
+// err returns all the recorded errors joined, see joinErrors.
+func (l *errorList) err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return joinErrors(l.errs...)
+}
+
+// joinErrors returns an error that wraps all the non-nil errs, with errors.Is and errors.As matching any of them.
+// It returns nil when there are no errors and the error itself when there is only one.
+// It stands in for errors.Join, which requires Go 1.20.
+func joinErrors(errs ...error) error {
+	var nonNil []error
+	for _, err := range errs {
+		if err != nil {
+			nonNil = append(nonNil, err)
+		}
+	}
+	switch len(nonNil) {
+	case 0:
+		return nil
+	case 1:
+		return nonNil[0]
+	}
+	return &joinError{errs: nonNil}
+}
+
+// joinError is an error that wraps several errors.
+type joinError struct {
+	errs []error
+}
+
+// Error returns the messages of the wrapped errors, one per line, like errors.Join.
+func (e *joinError) Error() string {
+	msgs := make([]string, 0, len(e.errs))
+	for _, err := range e.errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns the wrapped errors. errors.Is and errors.As use it from Go 1.20.
+func (e *joinError) Unwrap() []error {
+	return e.errs
+}
+
+// Is reports whether any of the wrapped errors matches target.
+func (e *joinError) Is(target error) bool {
+	for _, err := range e.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first wrapped error that matches target.
+func (e *joinError) As(target interface{}) bool {
+	for _, err := range e.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package ipxedust
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"inet.af/netaddr"
+)
+
+func TestJoinErrors(t *testing.T) {
+	err1 := errors.New("tftp failed")
+	err2 := &BindError{Err: os.ErrPermission}
+	tests := []struct {
+		name    string
+		errs    []error
+		wantNil bool
+		wantMsg string
+		wantIs  []error
+	}{
+		{name: "none", wantNil: true},
+		{name: "only nil", errs: []error{nil, nil}, wantNil: true},
+		{name: "single", errs: []error{nil, err1}, wantMsg: "tftp failed", wantIs: []error{err1}},
+		{name: "several", errs: []error{err1, nil, err2}, wantMsg: "tftp failed\n" + err2.Error(), wantIs: []error{err1, os.ErrPermission}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := joinErrors(tt.errs...)
+			if (err == nil) != tt.wantNil {
+				t.Fatalf("expected a nil error: %v, got: %v", tt.wantNil, err)
+			}
+			if err == nil {
+				return
+			}
+			if err.Error() != tt.wantMsg {
+				t.Fatalf("expected message %q, got: %q", tt.wantMsg, err.Error())
+			}
+			for _, want := range tt.wantIs {
+				if !errors.Is(err, want) {
+					t.Fatalf("expected errors.Is(%v) to be true", want)
+				}
+			}
+		})
+	}
+	var be *BindError
+	if !errors.As(joinErrors(err1, err2), &be) || be != err2 {
+		t.Fatalf("expected errors.As to find %v", err2)
+	}
+	if single := joinErrors(err1); single != err1 {
+		t.Fatalf("expected a single error to be returned as is, got: %v", single)
+	}
+}
+
+func TestListenAndServeBothFail(t *testing.T) {
+	// occupy the TFTP port so its bind fails with EADDRINUSE.
+	used, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer used.Close()
+	tftpAddr, err := netaddr.ParseIPPort(used.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Server{
+		Log:  logr.Discard(),
+		TFTP: ServerSpec{Addr: tftpAddr},
+		// an address that isn't on this host fails with EADDRNOTAVAIL.
+		HTTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(192, 0, 2, 1), 0)},
+	}
+	err = c.ListenAndServe(context.Background())
+	for _, want := range []error{syscall.EADDRINUSE, syscall.EADDRNOTAVAIL} {
+		if !errors.Is(err, want) {
+			t.Fatalf("expected the error to wrap %v, got: %v", want, err)
+		}
+	}
+	if msg := err.Error(); !strings.Contains(msg, "listen udp") || !strings.Contains(msg, "listen tcp") {
+		t.Fatalf("expected the errors of both protocols, got: %v", msg)
+	}
+}
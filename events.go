@@ -0,0 +1,96 @@
+package ipxedust
+
+import "github.com/go-logr/logr"
+
+// Event is the name of a Server lifecycle event.
+// Lifecycle events are logged, at info level, with the event name as both the log message
+// and the value of the LogKeyEvent field. Event names and field names are stable,
+// so they can be relied on by downstream log processing and alerting.
+type Event string
+
+const (
+	// EventStarting is logged once when the Server starts, after defaults are applied.
+	// Fields: LogKeyProtocols.
+	EventStarting Event = "starting"
+	// EventListening is logged once per protocol when it is ready to serve requests.
+	// Fields: LogKeyProtocol, LogKeyAddr.
+	EventListening Event = "listening"
+	// EventRequestServed is logged for every file served when Server.LogRequestServed is true.
+	// Fields: LogKeyProtocol, LogKeyClient, LogKeyFilename, LogKeyBytes.
+	EventRequestServed Event = "request served"
+	// EventDraining is logged once when the Server context is done and shutdown begins.
+	EventDraining Event = "draining"
+	// EventStopped is logged once after all protocols have stopped.
+	// Fields: LogKeyError, only when the Server stopped because of an error.
+	EventStopped Event = "stopped"
+)
+
+// Field names of lifecycle events.
+const (
+	LogKeyEvent     = "event"
+	LogKeyProtocols = "protocols"
+	LogKeyProtocol  = "protocol"
+	LogKeyAddr      = "addr"
+	LogKeyClient    = "client"
+	LogKeyFilename  = "filename"
+	LogKeyBytes     = "bytesSent"
+	LogKeyError     = "error"
+	// LogKeyInstance is the field of the Server Name, on every log line of a named Server.
+	LogKeyInstance = "instance"
+)
+
+// log returns the logger of c, with the Name of c as LogKeyInstance when it is set.
+func (c *Server) log() logr.Logger {
+	return c.named(c.Log)
+}
+
+// protocolLog returns the logger of protocol p: the Log of its ServerSpec when it is set, or else the logger of c.
+func (c *Server) protocolLog(p Protocol) logr.Logger {
+	l := c.Log
+	if spec := c.spec(p); spec.Log.GetSink() != nil {
+		l = spec.Log
+	}
+	return c.named(l)
+}
+
+// named returns l with the Name of c as LogKeyInstance when it is set.
+func (c *Server) named(l logr.Logger) logr.Logger {
+	if c.Name == "" {
+		return l
+	}
+	return l.WithValues(LogKeyInstance, c.Name)
+}
+
+// logEvent logs the lifecycle event e with the given key/value pairs.
+func (c *Server) logEvent(e Event, keysAndValues ...interface{}) {
+	c.log().WithCallDepth(1).Info(string(e), append([]interface{}{LogKeyEvent, e}, keysAndValues...)...)
+}
+
+// logStopped logs EventStopped, including err if it is not nil.
+func (c *Server) logStopped(err error) {
+	if err != nil {
+		c.logEvent(EventStopped, LogKeyError, err.Error())
+		return
+	}
+	c.logEvent(EventStopped)
+}
+
+// protocols returns the enabled protocols.
+func (c *Server) protocols() []Protocol {
+	var p []Protocol
+	if !c.TFTP.Disabled {
+		p = append(p, ProtocolTFTP)
+	}
+	if !c.HTTP.Disabled {
+		p = append(p, ProtocolHTTP)
+	}
+	return p
+}
+
+// spec returns the ServerSpec of protocol p.
+func (c *Server) spec(p Protocol) ServerSpec {
+	if p == ProtocolHTTP {
+		return c.HTTP
+	}
+	return c.TFTP
+}
@@ -0,0 +1,236 @@
+package ipxedust
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"github.com/google/go-cmp/cmp"
+)
+
+// eventSink is a logr.LogSink that records the lifecycle events that are logged.
+type eventSink struct {
+	mu     *sync.Mutex
+	events *[]Event
+}
+
+func (e eventSink) Init(logr.RuntimeInfo)                  {}
+func (e eventSink) Enabled(int) bool                       { return true }
+func (e eventSink) Error(error, string, ...interface{})    {}
+func (e eventSink) WithValues(...interface{}) logr.LogSink { return e }
+func (e eventSink) WithName(string) logr.LogSink           { return e }
+
+func (e eventSink) Info(_ int, msg string, keysAndValues ...interface{}) {
+	if len(keysAndValues) < 2 || keysAndValues[0] != LogKeyEvent {
+		return
+	}
+	if ev, ok := keysAndValues[1].(Event); ok && string(ev) == msg {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		*e.events = append(*e.events, ev)
+	}
+}
+
+func TestLifecycleEvents(t *testing.T) {
+	var mu sync.Mutex
+	var events []Event
+	ready := make(chan net.Addr, 2)
+	addrs := make(map[Protocol]net.Addr)
+	got := &Server{
+		Log:              logr.New(eventSink{mu: &mu, events: &events}),
+		LogRequestServed: true,
+		OnReady: func(p Protocol, addr net.Addr) {
+			mu.Lock()
+			addrs[p] = addr
+			mu.Unlock()
+			ready <- addr
+		},
+	}
+	conn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- got.Serve(ctx, conn, uconn) }()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-ready:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for OnReady")
+		}
+	}
+	mu.Lock()
+	httpAddr, tftpAddr := addrs[ProtocolHTTP].String(), addrs[ProtocolTFTP].String()
+	mu.Unlock()
+	httpGet(t, httpAddr, "snp.efi")
+	tftpGet(t, tftpAddr, "snp.efi")
+	// the TFTP transfer finishes server side after the client receives the last block.
+	deadline := time.Now().Add(2 * time.Second)
+	for served := 0; served < 2 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		mu.Lock()
+		served = 0
+		for _, e := range events {
+			if e == EventRequestServed {
+				served++
+			}
+		}
+		mu.Unlock()
+	}
+	cancel()
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Event{EventStarting, EventListening, EventListening, EventRequestServed, EventRequestServed, EventDraining, EventStopped}
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff(events, want); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestLogInstance(t *testing.T) {
+	var mu sync.Mutex
+	var logged []string
+	log := funcr.New(func(prefix, args string) {
+		mu.Lock()
+		defer mu.Unlock()
+		logged = append(logged, args)
+	}, funcr.Options{})
+	ready := make(chan net.Addr, 4)
+	serve := func(ctx context.Context, name string) (string, string, <-chan error) {
+		c := &Server{Log: log, Name: name, OnReady: func(_ Protocol, addr net.Addr) { ready <- addr }}
+		conn, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		errChan := make(chan error, 1)
+		go func() { errChan <- c.Serve(ctx, conn, uconn) }()
+		<-ready
+		<-ready
+		return conn.Addr().String(), uconn.LocalAddr().String(), errChan
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	httpAddr, tftpAddr, errA := serve(ctx, "vlan10")
+	_, _, errB := serve(ctx, "vlan20")
+
+	httpGet(t, httpAddr, "snp.efi")
+	tftpGet(t, tftpAddr, "snp.efi")
+	cancel()
+	for _, errChan := range []<-chan error{errA, errB} {
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	instances := map[string]int{}
+	for _, l := range logged {
+		if !strings.Contains(l, `"msg"="file served"`) {
+			continue
+		}
+		switch {
+		case strings.Contains(l, `"instance"="vlan10"`):
+			instances["vlan10"]++
+		case strings.Contains(l, `"instance"="vlan20"`):
+			instances["vlan20"]++
+		default:
+			t.Errorf("expected the instance to be logged, got: %v", l)
+		}
+	}
+	if diff := cmp.Diff(map[string]int{"vlan10": 2}, instances); diff != "" {
+		t.Fatalf("unexpected instances of the files served: %v", diff)
+	}
+	for _, name := range []string{"vlan10", "vlan20"} {
+		found := false
+		for _, l := range logged {
+			found = found || strings.Contains(l, `"msg"="starting"`) && strings.Contains(l, `"instance"="`+name+`"`)
+		}
+		if !found {
+			t.Errorf("expected the starting event of %v to be logged with its instance", name)
+		}
+	}
+}
+
+func TestProtocolLog(t *testing.T) {
+	var mu sync.Mutex
+	logged := map[string][]string{}
+	sink := func(name string) logr.Logger {
+		return funcr.New(func(_, args string) {
+			mu.Lock()
+			defer mu.Unlock()
+			logged[name] = append(logged[name], args)
+		}, funcr.Options{})
+	}
+	ready := make(chan net.Addr, 2)
+	c := &Server{
+		Log:     sink("shared"),
+		Name:    "vlan10",
+		TFTP:    ServerSpec{Log: sink("tftp")},
+		HTTP:    ServerSpec{Log: sink("http")},
+		OnReady: func(_ Protocol, addr net.Addr) { ready <- addr },
+	}
+	conn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Serve(ctx, conn, uconn) }()
+	<-ready
+	<-ready
+	httpGet(t, conn.Addr().String(), "snp.efi")
+	tftpGet(t, uconn.LocalAddr().String(), "ipxe.efi")
+	cancel()
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	served := map[string][]string{}
+	for name, lines := range logged {
+		for _, l := range lines {
+			if !strings.Contains(l, `"msg"="file served"`) {
+				continue
+			}
+			if !strings.Contains(l, `"instance"="vlan10"`) {
+				t.Errorf("expected the instance to be logged, got: %v", l)
+			}
+			for _, f := range []string{"snp.efi", "ipxe.efi"} {
+				if strings.Contains(l, `"filename"="`+f+`"`) {
+					served[name] = append(served[name], f)
+				}
+			}
+		}
+	}
+	if diff := cmp.Diff(map[string][]string{"http": {"snp.efi"}, "tftp": {"ipxe.efi"}}, served); diff != "" {
+		t.Fatalf("unexpected files served per log: %v", diff)
+	}
+	found := false
+	for _, l := range logged["shared"] {
+		found = found || strings.Contains(l, `"msg"="stopped"`)
+	}
+	if !found {
+		t.Fatal("expected the lifecycle events to be logged with the shared logger")
+	}
+}
@@ -0,0 +1,58 @@
+// Package fault injects failures into transfers, to test how boot flows handle server side failures.
+//
+// It is a testing aid. The fault hooks of the Server and the handlers are only compiled in with the
+// "faultinject" build tag, for example `go test -tags faultinject ./...`. Without the tag, Hook has
+// no fields and never injects a fault, so production builds can't be made to fail on purpose.
+// Latency, which only slows requests down, is always compiled in, see Server.ArtificialLatency.
+package fault
+
+import (
+	"context"
+	"time"
+
+	"github.com/tinkerbell/ipxedust/clock"
+	"inet.af/netaddr"
+)
+
+// Request describes the request a fault may be injected into.
+type Request struct {
+	// Protocol is "tftp" or "http".
+	Protocol string
+	// Client is the IP of the client.
+	Client netaddr.IP
+	// Filename is the requested file name, without the optional MAC address and traceparent.
+	Filename string
+}
+
+// Fault is the failure to inject into a request. The zero value injects nothing.
+type Fault struct {
+	// Err, when not nil, fails the request. TFTP clients get Err, HTTP clients get Status.
+	Err error
+	// Status is the HTTP status code sent when Err is not nil. Defaults to 500.
+	Status int
+	// Delay pauses the request before it is served, or failed.
+	Delay time.Duration
+	// Truncate serves only the first Size bytes of the file. The announced size, the TFTP transfer
+	// size option or the HTTP Content-Length, is the full size, like a transfer that broke off.
+	Truncate bool
+	Size     int64
+}
+
+// Func returns the Fault to inject into a request.
+type Func func(Request) Fault
+
+// Latency waits for d on c, the artificial latency of a request, or until ctx is done, returning ctx.Err().
+// A d of zero or less doesn't wait.
+func Latency(ctx context.Context, c clock.Clock, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	wait, stop := clock.After(c, d)
+	defer stop()
+	select {
+	case <-wait:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,40 @@
+package fault
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tinkerbell/ipxedust/clock"
+)
+
+func TestLatency(t *testing.T) {
+	fc := clock.NewFake(time.Now())
+	done := make(chan error, 1)
+	go func() { done <- Latency(context.Background(), fc, time.Second) }()
+	for fc.Sleepers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	fc.Advance(time.Second / 2)
+	select {
+	case err := <-done:
+		t.Fatalf("expected the latency to be waited for, returned: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	fc.Advance(time.Second / 2)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLatencyCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Latency(ctx, clock.NewFake(time.Now()), time.Hour); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, got: %v", context.Canceled, err)
+	}
+	if err := Latency(ctx, nil, 0); err != nil {
+		t.Fatalf("expected no wait without latency, got: %v", err)
+	}
+}
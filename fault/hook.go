@@ -0,0 +1,24 @@
+//go:build faultinject
+// +build faultinject
+
+package fault
+
+import "time"
+
+// Hook is embedded in the Server and the handlers to inject faults.
+type Hook struct {
+	// Fault, when not nil, is called for every request and its Fault is injected.
+	Fault Func
+}
+
+// Inject returns the Fault to inject into r, after waiting for its Delay.
+func (h Hook) Inject(r Request) Fault {
+	if h.Fault == nil {
+		return Fault{}
+	}
+	f := h.Fault(r)
+	if f.Delay > 0 {
+		time.Sleep(f.Delay)
+	}
+	return f
+}
@@ -0,0 +1,12 @@
+//go:build !faultinject
+// +build !faultinject
+
+package fault
+
+// Hook is embedded in the Server and the handlers to inject faults. It is empty without the "faultinject" build tag.
+type Hook struct{}
+
+// Inject never injects a fault without the "faultinject" build tag.
+func (Hook) Inject(Request) Fault {
+	return Fault{}
+}
@@ -0,0 +1,16 @@
+//go:build !faultinject
+// +build !faultinject
+
+package fault
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInjectDisabled(t *testing.T) {
+	if diff := cmp.Diff(Hook{}.Inject(Request{Protocol: "tftp", Filename: "snp.efi"}), Fault{}); diff != "" {
+		t.Fatal(diff)
+	}
+}
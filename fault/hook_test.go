@@ -0,0 +1,48 @@
+//go:build faultinject
+// +build faultinject
+
+package fault
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestInject(t *testing.T) {
+	errInjected := errors.New("injected")
+	tests := []struct {
+		name string
+		hook Hook
+		want Fault
+	}{
+		{name: "no hook"},
+		{name: "error", hook: Hook{Fault: func(Request) Fault { return Fault{Err: errInjected} }}, want: Fault{Err: errInjected}},
+		{name: "per file", hook: Hook{Fault: func(r Request) Fault {
+			if r.Filename == "snp.efi" {
+				return Fault{Truncate: true, Size: 10}
+			}
+			return Fault{}
+		}}, want: Fault{Truncate: true, Size: 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.hook.Inject(Request{Protocol: "tftp", Filename: "snp.efi"})
+			if diff := cmp.Diff(got, tt.want, cmpopts.EquateErrors()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestInjectDelay(t *testing.T) {
+	h := Hook{Fault: func(Request) Fault { return Fault{Delay: 20 * time.Millisecond} }}
+	start := time.Now()
+	h.Inject(Request{})
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatalf("expected the request to be delayed by %v, took: %v", 20*time.Millisecond, time.Since(start))
+	}
+}
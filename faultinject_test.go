@@ -0,0 +1,109 @@
+//go:build faultinject
+// +build faultinject
+
+package ipxedust
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/pin/tftp"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/fault"
+)
+
+// TestFaultInjection shows how a boot flow is tested against server side failures:
+// the Server is built with the "faultinject" build tag and its Fault hook fails chosen requests.
+func TestFaultInjection(t *testing.T) {
+	ready := make(chan struct{}, 2)
+	addrs := make(map[Protocol]net.Addr)
+	var mu sync.Mutex
+	c := &Server{
+		Log: logr.Discard(),
+		OnReady: func(p Protocol, addr net.Addr) {
+			mu.Lock()
+			addrs[p] = addr
+			mu.Unlock()
+			ready <- struct{}{}
+		},
+	}
+	c.Fault = func(r fault.Request) fault.Fault {
+		switch r.Filename {
+		case "snp.efi":
+			return fault.Fault{Err: errors.New("disk on fire"), Status: http.StatusServiceUnavailable}
+		case "undionly.kpxe":
+			return fault.Fault{Truncate: true, Size: 1000}
+		}
+		return fault.Fault{}
+	}
+	conn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Serve(ctx, conn, uconn) }()
+	defer func() {
+		cancel()
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}()
+	<-ready
+	<-ready
+	mu.Lock()
+	httpAddr, tftpAddr := addrs[ProtocolHTTP].String(), addrs[ProtocolTFTP].String()
+	mu.Unlock()
+
+	t.Run("http error", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://%v/snp.efi", httpAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("expected status %v, got: %v", http.StatusServiceUnavailable, resp.StatusCode)
+		}
+	})
+	t.Run("http truncated", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://%v/undionly.kpxe", httpAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if !errors.Is(err, io.ErrUnexpectedEOF) || len(b) != 1000 {
+			t.Fatalf("expected the transfer to break off after 1000 bytes, got %v bytes and error: %v", len(b), err)
+		}
+	})
+	t.Run("tftp error", func(t *testing.T) {
+		tc, err := tftp.NewClient(tftpAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tc.Receive("snp.efi", "octet"); err == nil {
+			t.Fatal("expected the transfer to fail")
+		}
+	})
+	t.Run("tftp truncated", func(t *testing.T) {
+		if got := tftpGet(t, tftpAddr, "undionly.kpxe"); len(got) != 1000 {
+			t.Fatalf("expected 1000 bytes, got: %v", len(got))
+		}
+	})
+	t.Run("no fault", func(t *testing.T) {
+		if got := httpGet(t, httpAddr, "ipxe.efi"); len(got) != len(binary.Files["ipxe.efi"]) {
+			t.Fatalf("expected %v bytes, got: %v", len(binary.Files["ipxe.efi"]), len(got))
+		}
+	})
+}
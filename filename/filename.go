@@ -0,0 +1,79 @@
+// Package filename validates the file names requested by clients, before they are looked up: their length and the
+// characters they are made of. It complements the sanitization of the lookups, like fs.ValidPath, by only accepting
+// the names that are known to be sound.
+package filename
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultMaxLength is the maximum length, in bytes, of a requested file name when Policy.MaxLength is not set.
+	// It leaves room for the optional MAC address directory and traceparent suffix of the requests of iPXE.
+	DefaultMaxLength = 255
+	// DefaultPunctuation are the characters allowed in a requested file name, besides ASCII letters and digits, when
+	// Policy.Punctuation is not set. They cover the MAC address directories, like "0a:00:27:00:00:02/snp.efi", the
+	// traceparent suffixes and the resume offsets of TFTP, like "ipxe.efi@65536".
+	DefaultPunctuation = "-._~:@/"
+)
+
+// ErrInvalid is returned for a requested file name that is too long or has a character that is not allowed.
+var ErrInvalid = errors.New("invalid file name")
+
+// Policy limits the requested file names. The zero value applies the defaults.
+type Policy struct {
+	// Disabled accepts all file names.
+	Disabled bool
+	// MaxLength is the maximum length of a file name, in bytes. Defaults to DefaultMaxLength.
+	MaxLength int
+	// Punctuation are the characters allowed besides ASCII letters and digits. Defaults to DefaultPunctuation.
+	Punctuation string
+}
+
+// maxLength returns MaxLength, or DefaultMaxLength when it is not set.
+func (p Policy) maxLength() int {
+	if p.MaxLength <= 0 {
+		return DefaultMaxLength
+	}
+	return p.MaxLength
+}
+
+// punctuation returns Punctuation, or DefaultPunctuation when it is not set.
+func (p Policy) punctuation() string {
+	if p.Punctuation == "" {
+		return DefaultPunctuation
+	}
+	return p.Punctuation
+}
+
+// String describes the file names accepted, with the defaults applied.
+func (p Policy) String() string {
+	if p.Disabled {
+		return "any"
+	}
+	return fmt.Sprintf("at most %v bytes of ASCII letters, digits and %q", p.maxLength(), p.punctuation())
+}
+
+// Check returns an error wrapping ErrInvalid when name is empty, longer than MaxLength, or has a character that is
+// neither an ASCII letter, nor a digit, nor one of Punctuation.
+func (p Policy) Check(name string) error {
+	if p.Disabled {
+		return nil
+	}
+	max, punctuation := p.maxLength(), p.punctuation()
+	if name == "" {
+		return fmt.Errorf("%w: empty", ErrInvalid)
+	}
+	if len(name) > max {
+		return fmt.Errorf("%w: %v bytes long, at most %v", ErrInvalid, len(name), max)
+	}
+	for _, r := range name {
+		if r < 0x80 && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') || strings.ContainsRune(punctuation, r) {
+			continue
+		}
+		return fmt.Errorf("%w: character %q not allowed", ErrInvalid, r)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package filename
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		filename string
+		wantErr  bool
+	}{
+		{name: "binary", filename: "ipxe.efi"},
+		{name: "mac directory", filename: "/0a:00:27:00:00:02/snp.efi"},
+		{name: "traceparent", filename: "snp.efi-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01"},
+		{name: "resume offset", filename: "ipxe.efi@65536"},
+		{name: "empty", filename: "", wantErr: true},
+		{name: "max length", filename: strings.Repeat("a", DefaultMaxLength)},
+		{name: "too long", filename: strings.Repeat("a", DefaultMaxLength+1), wantErr: true},
+		{name: "custom max length", policy: Policy{MaxLength: 8}, filename: "snp.efi.gz", wantErr: true},
+		{name: "space", filename: "ipxe .efi", wantErr: true},
+		{name: "backslash", filename: `..\ipxe.efi`, wantErr: true},
+		{name: "null", filename: "ipxe.efi\x00.txt", wantErr: true},
+		{name: "new line", filename: "ipxe.efi\n", wantErr: true},
+		{name: "percent", filename: "ipxe%2eefi", wantErr: true},
+		{name: "non ASCII letter", filename: "ïpxe.efi", wantErr: true},
+		{name: "invalid UTF-8", filename: "ipxe\xff.efi", wantErr: true},
+		{name: "custom punctuation", policy: Policy{Punctuation: "."}, filename: "0a:00:27:00:00:02/snp.efi", wantErr: true},
+		{name: "custom punctuation allowed", policy: Policy{Punctuation: ".+"}, filename: "ipxe+snp.efi"},
+		{name: "disabled", policy: Policy{Disabled: true}, filename: "ipxe .efi\x00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Check(tt.filename)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("expected an error: %v, got: %v", tt.wantErr, err)
+			}
+			if err != nil && !errors.Is(err, ErrInvalid) {
+				t.Fatalf("expected ErrInvalid, got: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// Package firstboot serves a different iPXE script on the first boot of a client than on the boots that follow,
+// for install-once workflows: the first boot runs an installer, the boots that follow boot from the local disk.
+package firstboot
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sync"
+
+	"github.com/tinkerbell/ipxedust/resolve"
+	"inet.af/netaddr"
+)
+
+// DefaultSize is the number of clients the in-memory Store of a Tracker remembers when no Store is given.
+const DefaultSize = 4096
+
+// ErrNoScript is returned by Tracker.Validate when the name or the first boot script of a Tracker is missing.
+var ErrNoScript = errors.New("no first boot script")
+
+// ErrNotResettable is returned by Tracker.Reset when its Store doesn't implement Resetter.
+var ErrNotResettable = errors.New("first boot store can't forget all clients")
+
+// ErrNotPeekable is returned by Tracker.Peek when its Store doesn't implement Peeker.
+var ErrNotPeekable = errors.New("first boot store can't tell whether a client booted without marking it")
+
+// Store remembers the clients that already booted. It must be safe for concurrent use.
+type Store interface {
+	// Mark records that the client key booted, and reports whether it is the first time it did.
+	Mark(key string) (first bool, err error)
+	// Forget drops the client key, so that its next boot is a first boot again, for example to reinstall the machine.
+	Forget(key string) error
+}
+
+// Resetter is implemented by the Stores that can forget all the clients at once, like Memory and File.
+type Resetter interface {
+	// Reset drops all the clients, so that the next boot of every client is a first boot again.
+	Reset() error
+}
+
+// Peeker is implemented by the Stores that can tell whether a client booted without marking it, like Memory and File.
+type Peeker interface {
+	// Booted reports whether the client key booted, without marking it.
+	Booted(key string) (bool, error)
+}
+
+// Tracker serves the script First the first time a client requests Name, and Later when it requests it again. Its
+// Dynamic method is a resolve.Resolver hook. A client is marked as booted when it is handed First, whether or not the
+// transfer completes: a client whose first boot failed gets Later, until it is forgotten.
+type Tracker struct {
+	// Name is the file name of the script, for example "boot.ipxe". Other files are looked up as usual.
+	Name string
+	// First is the script served on the first boot, for example one chaining to the installer.
+	First []byte
+	// Later is the script served on the boots that follow, for example one booting from the local disk with
+	// "sanboot --drive 0x80" or "exit". When it is nil, the file is looked up as usual on the boots that follow.
+	Later []byte
+	// Store remembers the clients that already booted, see Key. A nil Store is a Memory of DefaultSize clients,
+	// which forgets them all on restart; use a File, or a Store of another system, to remember them across restarts.
+	Store Store
+
+	once sync.Once
+	mem  *Memory
+}
+
+// Key returns the key of client in the Store: the client identifier of ctx when it has one, see resolve.ClientID,
+// for example a MAC address passed in a header by a cooperating proxy, and the client IP address otherwise.
+func Key(ctx context.Context, client netaddr.IPPort) string {
+	if id, ok := resolve.ClientID(ctx); ok && id != "" {
+		return "id:" + id
+	}
+	return "ip:" + client.IP().String()
+}
+
+// Validate returns ErrNoScript when Name or First is empty.
+func (t *Tracker) Validate() error {
+	if t.Name == "" || len(t.First) == 0 {
+		return ErrNoScript
+	}
+	return nil
+}
+
+// Dynamic returns First, the first time client requests Name, and Later, with true when it isn't nil, afterwards.
+// It returns false for the other file names. Only the base name of name is compared.
+func (t *Tracker) Dynamic(ctx context.Context, client netaddr.IPPort, name string) ([]byte, bool, error) {
+	if path.Base(name) != t.Name {
+		return nil, false, nil
+	}
+	first, err := t.store().Mark(Key(ctx, client))
+	if err != nil {
+		return nil, false, err
+	}
+	if first {
+		return t.First, true, nil
+	}
+	return t.Later, t.Later != nil, nil
+}
+
+// Peek returns what Dynamic would, without marking client as booted, for example to answer a HEAD request. It returns
+// ErrNotPeekable for Name when the Store doesn't implement Peeker.
+func (t *Tracker) Peek(ctx context.Context, client netaddr.IPPort, name string) ([]byte, bool, error) {
+	if path.Base(name) != t.Name {
+		return nil, false, nil
+	}
+	p, ok := t.store().(Peeker)
+	if !ok {
+		return nil, false, ErrNotPeekable
+	}
+	booted, err := p.Booted(Key(ctx, client))
+	if err != nil {
+		return nil, false, err
+	}
+	if !booted {
+		return t.First, true, nil
+	}
+	return t.Later, t.Later != nil, nil
+}
+
+// Forget drops the client key, see Key, so that its next boot is a first boot again.
+func (t *Tracker) Forget(key string) error {
+	return t.store().Forget(key)
+}
+
+// Reset forgets all the clients, so that the next boot of every client is a first boot again. It returns
+// ErrNotResettable when the Store doesn't implement Resetter.
+func (t *Tracker) Reset() error {
+	r, ok := t.store().(Resetter)
+	if !ok {
+		return ErrNotResettable
+	}
+	return r.Reset()
+}
+
+// store returns Store, or the Memory used in its place.
+func (t *Tracker) store() Store {
+	if t.Store != nil {
+		return t.Store
+	}
+	t.once.Do(func() { t.mem = NewMemory(DefaultSize) })
+	return t.mem
+}
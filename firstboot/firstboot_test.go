@@ -0,0 +1,176 @@
+package firstboot
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/resolve"
+	"inet.af/netaddr"
+)
+
+var (
+	install = []byte("#!ipxe\nchain http://192.168.2.1/install.ipxe\n")
+	local   = []byte("#!ipxe\nsanboot --drive 0x80\n")
+)
+
+func TestDynamic(t *testing.T) {
+	a := netaddr.IPPortFrom(netaddr.IPv4(192, 168, 2, 10), 68)
+	b := netaddr.IPPortFrom(netaddr.IPv4(192, 168, 2, 11), 68)
+	type request struct {
+		client netaddr.IPPort
+		id     string
+		name   string
+	}
+	tests := []struct {
+		name     string
+		later    []byte
+		requests []request
+		want     []string
+	}{
+		{
+			name:     "first then later",
+			later:    local,
+			requests: []request{{client: a, name: "boot.ipxe"}, {client: a, name: "boot.ipxe"}, {client: a, name: "0a:00:27:00:00:02/boot.ipxe"}},
+			want:     []string{string(install), string(local), string(local)},
+		},
+		{
+			name:     "clients apart",
+			later:    local,
+			requests: []request{{client: a, name: "boot.ipxe"}, {client: b, name: "boot.ipxe"}, {client: b, name: "boot.ipxe"}},
+			want:     []string{string(install), string(install), string(local)},
+		},
+		{
+			// the same machine with a new address is told apart by its client ID.
+			name:     "client id",
+			later:    local,
+			requests: []request{{client: a, id: "0a:00:27:00:00:02", name: "boot.ipxe"}, {client: b, id: "0a:00:27:00:00:02", name: "boot.ipxe"}, {client: a, name: "boot.ipxe"}},
+			want:     []string{string(install), string(local), string(install)},
+		},
+		{
+			name:     "no later script",
+			requests: []request{{client: a, name: "boot.ipxe"}, {client: a, name: "boot.ipxe"}},
+			want:     []string{string(install), "not handled"},
+		},
+		{
+			name:     "other file",
+			later:    local,
+			requests: []request{{client: a, name: "snp.efi"}, {client: a, name: "boot.ipxe"}},
+			want:     []string{"not handled", string(install)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &Tracker{Name: "boot.ipxe", First: install, Later: tt.later}
+			got := []string{}
+			for _, req := range tt.requests {
+				ctx := context.Background()
+				if req.id != "" {
+					ctx = resolve.WithClientID(ctx, req.id)
+				}
+				b, ok, err := tr.Dynamic(ctx, req.client, req.name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ok {
+					got = append(got, "not handled")
+					continue
+				}
+				got = append(got, string(b))
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestResolver(t *testing.T) {
+	tr := &Tracker{Name: "boot.ipxe", First: install, Later: local}
+	// the first boot script isn't cached with what Dynamic returns.
+	r := resolve.Resolver{Stateful: tr.Dynamic, Cache: resolve.NewCache(resolve.CacheConfig{MaxEntries: 10})}
+	client := netaddr.IPPortFrom(netaddr.IPv4(192, 168, 2, 10), 68)
+	got := []string{}
+	for _, name := range []string{"boot.ipxe", "boot.ipxe", "snp.efi"} {
+		b, err := r.Resolve(context.Background(), client, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(b))
+	}
+	if diff := cmp.Diff(got, []string{string(install), string(local), string(binary.Files["snp.efi"])}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// a forgotten client boots as a first boot again.
+	if err := tr.Forget(Key(context.Background(), client)); err != nil {
+		t.Fatal(err)
+	}
+	b, err := r.Resolve(context.Background(), client, "boot.ipxe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(b), string(install)); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+// failingStore is a Store that fails to mark clients.
+type failingStore struct{ err error }
+
+func (f failingStore) Mark(string) (bool, error) { return false, f.err }
+func (f failingStore) Forget(string) error       { return f.err }
+
+func TestDynamicStoreError(t *testing.T) {
+	errStore := errors.New("store unavailable")
+	tr := &Tracker{Name: "boot.ipxe", First: install, Later: local, Store: failingStore{err: errStore}}
+	_, _, err := tr.Dynamic(context.Background(), netaddr.IPPortFrom(netaddr.IPv4(192, 168, 2, 10), 68), "boot.ipxe")
+	if !errors.Is(err, errStore) {
+		t.Fatalf("error mismatch, got: %v, want: %v", err, errStore)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	client := netaddr.IPPortFrom(netaddr.IPv4(192, 168, 2, 10), 68)
+	tr := &Tracker{Name: "boot.ipxe", First: install, Later: local}
+	got := []string{}
+	for _, call := range []func(context.Context, netaddr.IPPort, string) ([]byte, bool, error){tr.Peek, tr.Peek, tr.Dynamic, tr.Peek} {
+		content, _, err := call(context.Background(), client, "boot.ipxe")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(content))
+	}
+	// peeking doesn't mark the client, so it is the Dynamic call that is the first boot.
+	if diff := cmp.Diff(got, []string{string(install), string(install), string(install), string(local)}); diff != "" {
+		t.Fatal(diff)
+	}
+	if _, ok, err := tr.Peek(context.Background(), client, "ipxe.efi"); ok || err != nil {
+		t.Fatalf("expected other files to be declined, got: %v, %v", ok, err)
+	}
+	tr = &Tracker{Name: "boot.ipxe", First: install, Store: failingStore{}}
+	if _, _, err := tr.Peek(context.Background(), client, "boot.ipxe"); !errors.Is(err, ErrNotPeekable) {
+		t.Fatalf("error mismatch, got: %v, want: %v", err, ErrNotPeekable)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tracker *Tracker
+		wantErr error
+	}{
+		{name: "valid", tracker: &Tracker{Name: "boot.ipxe", First: install}},
+		{name: "no name", tracker: &Tracker{First: install}, wantErr: ErrNoScript},
+		{name: "no first script", tracker: &Tracker{Name: "boot.ipxe", Later: local}, wantErr: ErrNoScript},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.tracker.Validate(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error mismatch, got: %v, want: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package firstboot
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Memory is a Store remembering at most a number of clients, in memory. When it is full, the client marked first is
+// forgotten to remember a new one, which then boots as a first boot again: size it for all the clients that boot.
+type Memory struct {
+	mu    sync.Mutex
+	size  int
+	seen  map[string]struct{}
+	order []string
+}
+
+// NewMemory returns a Memory remembering size clients, or DefaultSize when size is not greater than zero.
+func NewMemory(size int) *Memory {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &Memory{size: size, seen: make(map[string]struct{})}
+}
+
+// Mark implements Store.
+func (m *Memory) Mark(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mark(key), nil
+}
+
+// mark records key, forgetting the oldest key when full. It reports whether key is new.
+func (m *Memory) mark(key string) bool {
+	if _, ok := m.seen[key]; ok {
+		return false
+	}
+	if len(m.order) >= m.size {
+		delete(m.seen, m.order[0])
+		m.order = m.order[1:]
+	}
+	m.seen[key] = struct{}{}
+	m.order = append(m.order, key)
+	return true
+}
+
+// Booted implements Peeker.
+func (m *Memory) Booted(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.seen[key]
+	return ok, nil
+}
+
+// Forget implements Store.
+func (m *Memory) Forget(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.forget(key)
+	return nil
+}
+
+func (m *Memory) forget(key string) {
+	if _, ok := m.seen[key]; !ok {
+		return
+	}
+	delete(m.seen, key)
+	for i, k := range m.order {
+		if k == key {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Reset implements Resetter.
+func (m *Memory) Reset() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reset()
+	return nil
+}
+
+// reset drops all the keys.
+func (m *Memory) reset() {
+	m.seen = make(map[string]struct{})
+	m.order = nil
+}
+
+// Keys returns the clients remembered, the oldest first.
+func (m *Memory) Keys() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string{}, m.order...)
+}
+
+// File is a Memory persisted to a JSON file, the array of the keys of the clients remembered, so that they are
+// remembered across restarts. The file is written, atomically, every time a client is marked or forgotten, or
+// the clients are reset.
+type File struct {
+	*Memory
+	name string
+}
+
+// NewFile returns a File remembering size clients, see NewMemory, in the file name, loaded when it exists.
+func NewFile(name string, size int) (*File, error) {
+	f := &File{Memory: NewMemory(size), name: name}
+	b, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		f.mark(k)
+	}
+	return f, nil
+}
+
+// Mark implements Store. The client isn't remembered when writing the file fails.
+func (f *File) Mark(key string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.mark(key) {
+		return false, nil
+	}
+	if err := f.write(); err != nil {
+		f.forget(key)
+		return false, err
+	}
+	return true, nil
+}
+
+// Forget implements Store.
+func (f *File) Forget(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.forget(key)
+	return f.write()
+}
+
+// Reset implements Resetter.
+func (f *File) Reset() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reset()
+	return f.write()
+}
+
+// write replaces the file with the keys remembered.
+func (f *File) write() error {
+	b, err := json.Marshal(f.order)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.name), ".firstboot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.name)
+}
@@ -0,0 +1,126 @@
+package firstboot
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMemory(t *testing.T) {
+	m := NewMemory(2)
+	got := []bool{}
+	for _, k := range []string{"a", "a", "b", "c", "a", "c"} {
+		first, err := m.Mark(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, first)
+	}
+	// marking c forgets a, the oldest client, which boots as a first boot again and then forgets b.
+	if diff := cmp.Diff(got, []bool{true, false, true, true, true, false}); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff(m.Keys(), []string{"c", "a"}); diff != "" {
+		t.Fatal(diff)
+	}
+	if err := m.Forget("c"); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(m.Keys(), []string{"a"}); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "firstboot.json")
+	f, err := NewFile(name, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"ip:192.168.2.10", "id:0a:00:27:00:00:02", "ip:192.168.2.11"} {
+		if _, err := f.Mark(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Forget("ip:192.168.2.11"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the clients are remembered across restarts.
+	f, err = NewFile(name, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(f.Keys(), []string{"ip:192.168.2.10", "id:0a:00:27:00:00:02"}); diff != "" {
+		t.Fatal(diff)
+	}
+	first, err := f.Mark("ip:192.168.2.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first {
+		t.Fatal("expected a client marked before the restart not to boot as a first boot")
+	}
+}
+
+func TestFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	corrupt := filepath.Join(dir, "corrupt.json")
+	if err := os.WriteFile(corrupt, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFile(corrupt, 10); err == nil {
+		t.Fatal("expected a corrupt file to fail")
+	}
+
+	// a client isn't remembered when the file can't be written.
+	f, err := NewFile(filepath.Join(dir, "missing", "firstboot.json"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Mark("ip:192.168.2.10"); err == nil {
+		t.Fatal("expected marking to fail")
+	}
+	if diff := cmp.Diff(f.Keys(), []string{}); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestReset(t *testing.T) {
+	f, err := NewFile(filepath.Join(t.TempDir(), "firstboot.json"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &Tracker{Store: f}
+	for _, k := range []string{"ip:192.168.2.10", "ip:192.168.2.11"} {
+		if _, err := f.Mark(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tr.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	// the reset is written to the file, and the clients boot as a first boot again.
+	f, err = NewFile(f.name, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(f.Keys(), []string{}); diff != "" {
+		t.Fatal(diff)
+	}
+	first, err := f.Mark("ip:192.168.2.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first {
+		t.Fatal("expected a client to boot as a first boot after a reset")
+	}
+
+	tr = &Tracker{Store: failingStore{}}
+	if err := tr.Reset(); !errors.Is(err, ErrNotResettable) {
+		t.Fatalf("expected %v, got: %v", ErrNotResettable, err)
+	}
+}
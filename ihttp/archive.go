@@ -0,0 +1,256 @@
+package ihttp
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// errInvalidMember is returned when a requested archive member name is not allowed.
+var errInvalidMember = errors.New("invalid archive member name")
+
+// splitArchivePath splits an HTTP request path into a registered archive name and a member name.
+// The archive name is the first path element that is a key of archives. It may be preceded by other
+// elements, like a MAC address. The member is everything after the archive name and is empty when the
+// whole archive is requested. ok is false when the path does not reference a registered archive.
+func splitArchivePath(archives map[string]string, p string) (name, member string, ok bool) {
+	if len(archives) == 0 {
+		return "", "", false
+	}
+	elems := strings.Split(strings.Trim(p, "/"), "/")
+	for i, e := range elems {
+		if _, found := archives[e]; found {
+			return e, strings.Join(elems[i+1:], "/"), true
+		}
+	}
+	return "", "", false
+}
+
+// validMember reports whether member is a safe archive member name:
+// relative, already clean, and without any ".." elements.
+func validMember(member string) bool {
+	if member == "" || path.IsAbs(member) || path.Clean(member) != member {
+		return false
+	}
+	for _, e := range strings.Split(member, "/") {
+		if e == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// memberLocation is where the data of an archive member is stored in the archive file.
+type memberLocation struct {
+	// offset and size are those of the data in the archive file, compressed with method, zip.Store or zip.Deflate.
+	offset, size int64
+	method       uint16
+	// uncompressed is the size of the member.
+	uncompressed int64
+}
+
+// errNotSeekable is returned by locateMember for compressed tar archives, whose members are at an offset of the
+// uncompressed stream, and can only be read by decompressing the archive up to them.
+var errNotSeekable = errors.New("archive members can't be located")
+
+// locateMember looks up the regular file named member in the archive at file, open as f, of size bytes: the
+// central directory of zip archives, and the headers of tar archives up to the member.
+// The archive format is determined by the file extension: .zip, .tar, .tar.gz or .tgz.
+func locateMember(f io.ReaderAt, size int64, file, member string) (memberLocation, error) {
+	if !validMember(member) {
+		return memberLocation{}, fmt.Errorf("%w: %q", errInvalidMember, member)
+	}
+	switch {
+	case strings.HasSuffix(file, ".zip"):
+		return locateZipMember(f, size, member)
+	case strings.HasSuffix(file, ".tar"):
+		return locateTarMember(f, size, member)
+	case strings.HasSuffix(file, ".tar.gz"), strings.HasSuffix(file, ".tgz"):
+		return memberLocation{}, errNotSeekable
+	}
+	return memberLocation{}, fmt.Errorf("unsupported archive format: %v", path.Base(file))
+}
+
+func locateZipMember(f io.ReaderAt, size int64, member string) (memberLocation, error) {
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return memberLocation{}, err
+	}
+	for _, zf := range zr.File {
+		if memberName(zf.Name) != member || !zf.Mode().IsRegular() {
+			continue
+		}
+		if zf.Method != zip.Store && zf.Method != zip.Deflate {
+			return memberLocation{}, fmt.Errorf("archive member %q: %w", member, zip.ErrAlgorithm)
+		}
+		offset, err := zf.DataOffset()
+		if err != nil {
+			return memberLocation{}, err
+		}
+		return memberLocation{offset: offset, size: int64(zf.CompressedSize64), method: zf.Method, uncompressed: int64(zf.UncompressedSize64)}, nil
+	}
+	return memberLocation{}, fmt.Errorf("archive member %q: %w", member, os.ErrNotExist)
+}
+
+func locateTarMember(f io.ReaderAt, size int64, member string) (memberLocation, error) {
+	// the tar.Reader reads the headers without reading ahead, so the offset read is the offset of the data of the
+	// member once its header is read.
+	cr := &countingReader{r: io.NewSectionReader(f, 0, size)}
+	tr := tar.NewReader(cr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return memberLocation{}, err
+		}
+		if memberName(hdr.Name) == member && hdr.Typeflag == tar.TypeReg {
+			return memberLocation{offset: cr.n, size: hdr.Size, method: zip.Store, uncompressed: hdr.Size}, nil
+		}
+	}
+	return memberLocation{}, fmt.Errorf("archive member %q: %w", member, os.ErrNotExist)
+}
+
+// countingReader is an io.Reader counting the bytes read. It hides the io.Seeker of the reader it reads.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// openTarMember opens the regular file named member in the compressed tar archive at file, reading the archive only
+// up to, and including, the member. It returns its size.
+func openTarMember(file, member string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		if memberName(hdr.Name) == member && hdr.Typeflag == tar.TypeReg {
+			return readCloser{Reader: tr, close: f.Close}, hdr.Size, nil
+		}
+	}
+	f.Close()
+	return nil, 0, fmt.Errorf("archive member %q: %w", member, os.ErrNotExist)
+}
+
+// memberName normalizes the name of an archive entry so it can be compared with a requested member.
+func memberName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// readCloser is an io.ReadCloser with a custom close function.
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error {
+	return r.close()
+}
+
+// coalesce calls locate once for all concurrent calls with the same key and shares the result.
+// Without a group, locate is called every time.
+func coalesce(g *singleflight.Group, key string, locate func() (memberLocation, error)) (memberLocation, error) {
+	if g == nil {
+		return locate()
+	}
+	v, err, _ := g.Do(key, func() (interface{}, error) {
+		return locate()
+	})
+	if err != nil {
+		return memberLocation{}, err
+	}
+	return v.(memberLocation), nil
+}
+
+// serveArchive serves the whole archive at file, or only member when it is not empty, streamed from the file, with
+// the modification time of the archive. Concurrent lookups of the same member in the same version of the archive are
+// coalesced with the Handler ReadGroup. It returns the number of bytes of the file or member.
+func (s Handler) serveArchive(w http.ResponseWriter, req *http.Request, file, member string) (int64, error) {
+	if member != "" && !validMember(member) {
+		return 0, fmt.Errorf("%w: %q", errInvalidMember, member)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if member == "" {
+		http.ServeContent(w, req, path.Base(file), fi.ModTime(), f)
+		return fi.Size(), nil
+	}
+	key := fmt.Sprintf("%v:%v:%v:%v", file, member, fi.Size(), fi.ModTime().UnixNano())
+	loc, err := coalesce(s.ReadGroup, key, func() (memberLocation, error) {
+		return locateMember(f, fi.Size(), file, member)
+	})
+	if errors.Is(err, errNotSeekable) {
+		rc, size, err := openTarMember(file, member)
+		if err != nil {
+			return 0, err
+		}
+		defer rc.Close()
+		return serveStream(w, req, fi.ModTime(), size, rc)
+	}
+	if err != nil {
+		return 0, err
+	}
+	data := io.NewSectionReader(f, loc.offset, loc.size)
+	if loc.method == zip.Store {
+		http.ServeContent(w, req, path.Base(member), fi.ModTime(), data)
+		return loc.size, nil
+	}
+	rc := flate.NewReader(data)
+	defer rc.Close()
+	return serveStream(w, req, fi.ModTime(), loc.uncompressed, rc)
+}
+
+// serveStream serves the size bytes of r, which can't seek, modified at modTime, without support for ranges.
+func serveStream(w http.ResponseWriter, req *http.Request, modTime time.Time, size int64, r io.Reader) (int64, error) {
+	if notModifiedSince(w, req, modTime) {
+		w.WriteHeader(http.StatusNotModified)
+		return 0, nil
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	if req.Method == http.MethodHead {
+		return 0, nil
+	}
+	return io.Copy(w, r)
+}
@@ -0,0 +1,246 @@
+package ihttp
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sync/singleflight"
+)
+
+var testMembers = map[string]string{
+	"boot.ipxe":          "#!ipxe\nchain http://192.168.2.1/auto.ipxe\n",
+	"scripts/shell.ipxe": "#!ipxe\nshell\n",
+}
+
+func writeTestTar(t testing.TB, file string, gz bool, members map[string]string) {
+	t.Helper()
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var w io.Writer = f
+	if gz {
+		zw := gzip.NewWriter(f)
+		defer zw.Close()
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	if err := tw.WriteHeader(&tar.Header{Name: "scripts/", Typeflag: tar.TypeDir, Mode: 0o755}); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range members {
+		if err := tw.WriteHeader(&tar.Header{Name: "./" + name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func writeTestZip(t testing.TB, file string, method uint16, members map[string]string) {
+	t.Helper()
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	defer zw.Close()
+	for name, content := range members {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHandleArchive(t *testing.T) {
+	dir := t.TempDir()
+	archives := map[string]string{
+		"scripts.tar":    filepath.Join(dir, "scripts.tar"),
+		"scripts.tar.gz": filepath.Join(dir, "scripts.tar.gz"),
+		"scripts.zip":    filepath.Join(dir, "scripts.zip"),
+		"stored.zip":     filepath.Join(dir, "stored.zip"),
+	}
+	writeTestTar(t, archives["scripts.tar"], false, testMembers)
+	writeTestTar(t, archives["scripts.tar.gz"], true, testMembers)
+	writeTestZip(t, archives["scripts.zip"], zip.Deflate, testMembers)
+	writeTestZip(t, archives["stored.zip"], zip.Store, testMembers)
+	modTime := time.Date(2021, 10, 4, 12, 0, 0, 0, time.UTC)
+	for _, file := range archives {
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	since := modTime.Add(time.Hour).Format(http.TimeFormat)
+	whole, err := os.ReadFile(archives["scripts.tar"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		header   http.Header
+		wantCode int
+		wantBody string
+	}{
+		{name: "whole archive", path: "/scripts.tar", wantCode: http.StatusOK, wantBody: string(whole)},
+		{name: "tar member", path: "/scripts.tar/boot.ipxe", wantCode: http.StatusOK, wantBody: testMembers["boot.ipxe"]},
+		{name: "tar.gz nested member", path: "/scripts.tar.gz/scripts/shell.ipxe", wantCode: http.StatusOK, wantBody: testMembers["scripts/shell.ipxe"]},
+		{name: "zip member", path: "/scripts.zip/scripts/shell.ipxe", wantCode: http.StatusOK, wantBody: testMembers["scripts/shell.ipxe"]},
+		{name: "stored zip member", path: "/stored.zip/scripts/shell.ipxe", wantCode: http.StatusOK, wantBody: testMembers["scripts/shell.ipxe"]},
+		{name: "whole archive not modified", path: "/scripts.tar", header: http.Header{"If-Modified-Since": {since}}, wantCode: http.StatusNotModified},
+		{name: "tar member not modified", path: "/scripts.tar/boot.ipxe", header: http.Header{"If-Modified-Since": {since}}, wantCode: http.StatusNotModified},
+		{name: "tar.gz member not modified", path: "/scripts.tar.gz/boot.ipxe", header: http.Header{"If-Modified-Since": {since}}, wantCode: http.StatusNotModified},
+		{name: "zip member not modified", path: "/scripts.zip/boot.ipxe", header: http.Header{"If-Modified-Since": {since}}, wantCode: http.StatusNotModified},
+		{name: "tar member range", path: "/scripts.tar/scripts/shell.ipxe", header: http.Header{"Range": {"bytes=7-"}}, wantCode: http.StatusPartialContent, wantBody: "shell\n"},
+		{name: "stored zip member range", path: "/stored.zip/scripts/shell.ipxe", header: http.Header{"Range": {"bytes=0-5"}}, wantCode: http.StatusPartialContent, wantBody: "#!ipxe"},
+		{name: "member with mac", path: "/0a:00:27:00:00:02/scripts.zip/boot.ipxe", wantCode: http.StatusOK, wantBody: testMembers["boot.ipxe"]},
+		{name: "directory member", path: "/scripts.tar/scripts", wantCode: http.StatusNotFound},
+		{name: "missing member", path: "/scripts.zip/missing.ipxe", wantCode: http.StatusNotFound},
+		{name: "traversal", path: "/scripts.tar/../../etc/passwd", wantCode: http.StatusBadRequest},
+		{name: "unclean member", path: "/scripts.tar/scripts//shell.ipxe", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Handler{Log: logr.Discard(), Archives: archives}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			h.Handle(w, req)
+			resp := w.Result()
+			defer resp.Body.Close()
+			if diff := cmp.Diff(resp.StatusCode, tt.wantCode); diff != "" {
+				t.Fatal(diff)
+			}
+			if tt.wantCode != http.StatusOK && tt.wantCode != http.StatusPartialContent {
+				return
+			}
+			if diff := cmp.Diff(resp.Header.Get("Last-Modified"), modTime.Format(http.TimeFormat)); diff != "" {
+				t.Fatal(diff)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(body), tt.wantBody); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	var lookups int32
+	release := make(chan struct{})
+	want := memberLocation{offset: 512, size: 14, method: zip.Store, uncompressed: 14}
+	locate := func() (memberLocation, error) {
+		atomic.AddInt32(&lookups, 1)
+		<-release
+		return want, nil
+	}
+	g := &singleflight.Group{}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := coalesce(g, "key", locate)
+			if err != nil || got != want {
+				t.Errorf("unexpected result: %+v, %v", got, err)
+			}
+		}()
+	}
+	// wait for the first lookup to start, give the others time to join it.
+	for atomic.LoadInt32(&lookups) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if diff := cmp.Diff(atomic.LoadInt32(&lookups), int32(1)); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+// BenchmarkArchiveMember serves a member of 1 MiB requested concurrently, with and without coalescing the member
+// lookups. The member is streamed from the archive, the allocations per request don't depend on its size.
+func BenchmarkArchiveMember(b *testing.B) {
+	dir := b.TempDir()
+	members := map[string]string{"boot.ipxe": testMembers["boot.ipxe"], "images/large.img": strings.Repeat("ipxedust", 1<<17)}
+	archives := map[string]string{
+		"scripts.tar":    filepath.Join(dir, "scripts.tar"),
+		"scripts.tar.gz": filepath.Join(dir, "scripts.tar.gz"),
+		"scripts.zip":    filepath.Join(dir, "scripts.zip"),
+	}
+	writeTestTar(b, archives["scripts.tar"], false, members)
+	writeTestTar(b, archives["scripts.tar.gz"], true, members)
+	writeTestZip(b, archives["scripts.zip"], zip.Deflate, members)
+	for _, archive := range []string{"scripts.tar", "scripts.tar.gz", "scripts.zip"} {
+		for _, g := range []*singleflight.Group{{}, nil} {
+			name := archive + "/singleflight"
+			if g == nil {
+				name = archive + "/no singleflight"
+			}
+			b.Run(name, func(b *testing.B) {
+				h := Handler{Log: logr.Discard(), Archives: archives, ReadGroup: g}
+				b.ReportAllocs()
+				b.SetBytes(int64(len(members["images/large.img"])))
+				b.SetParallelism(16)
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						w := &discardResponseWriter{header: http.Header{}}
+						h.Handle(w, httptest.NewRequest(http.MethodGet, "/"+archive+"/images/large.img", nil))
+						if w.code != http.StatusOK {
+							b.Errorf("unexpected status: %v", w.code)
+						}
+					}
+				})
+			})
+		}
+	}
+}
+
+// discardResponseWriter is an http.ResponseWriter discarding the body, so that benchmarks only measure the handler.
+type discardResponseWriter struct {
+	header http.Header
+	code   int
+}
+
+func (w *discardResponseWriter) Header() http.Header { return w.header }
+
+func (w *discardResponseWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return len(p), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
@@ -0,0 +1,66 @@
+package ihttp
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	"inet.af/netaddr"
+)
+
+// ErrUnauthorized is returned by Handler.Authorize, wrapped or not, when a request has no credentials or invalid
+// ones, like a missing or expired bearer token. Such requests get a 401, other errors get a 403.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// authorize calls Authorize, when it is set, and answers req with a 401 or a 403 when it returns an error.
+// The error is logged, the response only has the status text, so that the details of why a request was
+// rejected, like which signature didn't match, are not leaked to the client. It reports whether req was answered.
+func (s Handler) authorize(w http.ResponseWriter, req *http.Request, log logr.Logger) bool {
+	if s.Authorize == nil {
+		return false
+	}
+	err := s.Authorize(req)
+	if err == nil {
+		return false
+	}
+	code := http.StatusForbidden
+	if errors.Is(err, ErrUnauthorized) {
+		code = http.StatusUnauthorized
+	}
+	log.Info("request rejected, not authorized", "path", req.URL.Path, "error", err.Error())
+	http.Error(w, http.StatusText(code), code)
+	return true
+}
+
+// rejectClient answers req with a 403 when the client at ip is not Allowed, or its client certificate is not allowed
+// by AllowClientCert. It reports whether req was answered.
+func (s Handler) rejectClient(w http.ResponseWriter, req *http.Request, log logr.Logger, ip netaddr.IP) bool {
+	if !s.Allowed.Allow(ip) {
+		log.Info("request rejected, client not allowed", "path", req.URL.Path)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return true
+	}
+	if s.AllowClientCert != nil {
+		if subject, ok := VerifiedClient(req); !ok || !s.AllowClientCert(subject) {
+			log.Info("request rejected, client certificate not allowed", "path", req.URL.Path, "clientCert", subject)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return true
+		}
+	}
+	return false
+}
+
+// Protect returns h only serving the clients Handle serves files to: the requests rejected by Allowed,
+// AllowClientCert or Authorize get a 403, or a 401, like requests of files.
+func (s Handler) Protect(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host, port, _ := net.SplitHostPort(req.RemoteAddr)
+		log := s.Log.WithValues("host", host, "port", port)
+		ip, _ := netaddr.ParseIP(host)
+		if s.rejectClient(w, req, log, ip) || s.authorize(w, req, log) {
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
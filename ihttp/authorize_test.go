@@ -0,0 +1,113 @@
+package ihttp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"github.com/tinkerbell/ipxedust/allowlist"
+	"inet.af/netaddr"
+)
+
+func TestHandleAuthorize(t *testing.T) {
+	authorize := func(r *http.Request) error {
+		switch r.Header.Get("Authorization") {
+		case "Bearer valid":
+			return nil
+		case "":
+			return fmt.Errorf("no bearer token: %w", ErrUnauthorized)
+		default:
+			return errors.New("token signed by key 3f2a, not trusted")
+		}
+	}
+	tests := []struct {
+		name       string
+		authorize  func(*http.Request) error
+		token      string
+		wantStatus int
+		wantLogged string
+	}{
+		{name: "no hook", wantStatus: http.StatusOK},
+		{name: "authorized", authorize: authorize, token: "Bearer valid", wantStatus: http.StatusOK},
+		{name: "unauthorized", authorize: authorize, wantStatus: http.StatusUnauthorized, wantLogged: "no bearer token: unauthorized"},
+		{name: "forbidden", authorize: authorize, token: "Bearer other", wantStatus: http.StatusForbidden, wantLogged: "token signed by key 3f2a, not trusted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged []string
+			h := Handler{
+				Log:       funcr.New(func(_, args string) { logged = append(logged, args) }, funcr.Options{}),
+				Authorize: tt.authorize,
+			}
+			req := httptest.NewRequest("GET", "/snp.efi", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", tt.token)
+			}
+			w := httptest.NewRecorder()
+			h.Handle(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %v, got: %v", tt.wantStatus, w.Code)
+			}
+			if tt.wantLogged == "" {
+				return
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != http.StatusText(tt.wantStatus) {
+				t.Fatalf("expected only the status text in the response, got: %q", got)
+			}
+			found := false
+			for _, l := range logged {
+				found = found || strings.Contains(l, "request rejected, not authorized") && strings.Contains(l, tt.wantLogged)
+			}
+			if !found {
+				t.Fatalf("expected the error to be logged, got: %v", logged)
+			}
+		})
+	}
+}
+
+func TestProtect(t *testing.T) {
+	allowed := &allowlist.Set{}
+	allowed.Replace([]netaddr.IP{netaddr.MustParseIP("192.168.2.10")})
+	h := Handler{
+		Log:     logr.Discard(),
+		Allowed: allowed,
+		Authorize: func(r *http.Request) error {
+			if r.Header.Get("Authorization") != "Bearer valid" {
+				return ErrUnauthorized
+			}
+			return nil
+		},
+	}
+	protected := h.Protect(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "recent boots")
+	}))
+	tests := []struct {
+		name       string
+		remoteAddr string
+		token      string
+		wantStatus int
+	}{
+		{name: "allowed and authorized", remoteAddr: "192.168.2.10:1234", token: "Bearer valid", wantStatus: http.StatusOK},
+		{name: "client not allowed", remoteAddr: "192.168.2.11:1234", token: "Bearer valid", wantStatus: http.StatusForbidden},
+		{name: "not authorized", remoteAddr: "192.168.2.10:1234", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/recent-boots", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.token != "" {
+				req.Header.Set("Authorization", tt.token)
+			}
+			w := httptest.NewRecorder()
+			protected.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %v, got: %v", tt.wantStatus, w.Code)
+			}
+		})
+	}
+}
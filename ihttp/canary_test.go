@@ -0,0 +1,51 @@
+package ihttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/canary"
+	"github.com/tinkerbell/ipxedust/resolve"
+)
+
+func TestHandleCanary(t *testing.T) {
+	canaryFS := fstest.MapFS{"ipxe.efi": {Data: []byte("canary build")}}
+	tests := []struct {
+		name     string
+		rule     canary.Rule
+		resolver resolve.Resolver
+		header   string
+		path     string
+		want     []byte
+	}{
+		{name: "stable", rule: canary.Rule{FS: canaryFS, Header: "X-Ipxe-Variant"}, path: "/ipxe.efi", want: binary.Files["ipxe.efi"]},
+		{name: "header", rule: canary.Rule{FS: canaryFS, Header: "X-Ipxe-Variant"}, header: "canary", path: "/ipxe.efi", want: []byte("canary build")},
+		{name: "percent", rule: canary.Rule{FS: canaryFS, Percent: 100}, path: "/ipxe.efi", want: []byte("canary build")},
+		{name: "header opts out", rule: canary.Rule{FS: canaryFS, Percent: 100, Header: "X-Ipxe-Variant"}, header: "stable", path: "/ipxe.efi", want: binary.Files["ipxe.efi"]},
+		{name: "not in canary", rule: canary.Rule{FS: canaryFS, Percent: 100}, path: "/snp.efi", want: binary.Files["snp.efi"]},
+		{name: "before files", rule: canary.Rule{FS: canaryFS, Percent: 100}, resolver: resolve.Resolver{Files: map[string][]byte{"ipxe.efi": []byte("pulled")}}, path: "/ipxe.efi", want: []byte("canary build")},
+		{name: "alias", rule: canary.Rule{FS: canaryFS, Percent: 100}, resolver: resolve.Resolver{Aliases: map[string]string{"bootx64.efi": "ipxe.efi"}}, path: "/bootx64.efi", want: []byte("canary build")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Handler{Log: logr.Discard(), Resolver: tt.resolver, Canary: tt.rule}
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("X-Ipxe-Variant", tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.Handle(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status %v, got: %v", http.StatusOK, w.Code)
+			}
+			if diff := cmp.Diff(tt.want, w.Body.Bytes()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package ihttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+func TestHandleNotEmbedded(t *testing.T) {
+	snp := binary.Files["snp.efi"]
+	binary.Files["snp.efi"] = []byte{}
+	defer func() { binary.Files["snp.efi"] = snp }()
+	tests := []struct {
+		name    string
+		path    string
+		want    []string
+		wantNot string
+	}{
+		{name: "known but not embedded", path: "/snp.efi", want: []string{`"msg"="warning: requested iPXE binary not compiled into this build"`, `"available"=["ipxe.efi","undionly.kpxe"]`}, wantNot: `"msg"="requested file not found"`},
+		{name: "unknown", path: "/unknown.efi", want: []string{`"msg"="requested file not found"`}, wantNot: `"msg"="warning: requested iPXE binary not compiled into this build"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged []string
+			h := Handler{Log: funcr.New(func(_, args string) { logged = append(logged, args) }, funcr.Options{})}
+			w := httptest.NewRecorder()
+			h.Handle(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != http.StatusNotFound {
+				t.Fatalf("expected status %v, got: %v", http.StatusNotFound, w.Code)
+			}
+			all := strings.Join(logged, "\n")
+			for _, want := range tt.want {
+				if !strings.Contains(all, want) {
+					t.Fatalf("expected %v logged, got: %v", want, logged)
+				}
+			}
+			if strings.Contains(all, tt.wantNot) {
+				t.Fatalf("expected %v not logged, got: %v", tt.wantNot, logged)
+			}
+		})
+	}
+}
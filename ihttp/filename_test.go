@@ -0,0 +1,40 @@
+package ihttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/filename"
+)
+
+func TestHandleFilenames(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     filename.Policy
+		path       string
+		wantStatus int
+	}{
+		{name: "valid", path: "/snp.efi", wantStatus: http.StatusOK},
+		{name: "mac directory", path: "/0a:00:27:00:00:02/snp.efi", wantStatus: http.StatusOK},
+		{name: "too long", path: "/" + strings.Repeat("a", filename.DefaultMaxLength) + ".efi", wantStatus: http.StatusBadRequest},
+		{name: "custom max length", policy: filename.Policy{MaxLength: 6}, path: "/snp.efi", wantStatus: http.StatusBadRequest},
+		{name: "space", path: "/snp%20.efi", wantStatus: http.StatusBadRequest},
+		{name: "control character", path: "/snp.efi%0a", wantStatus: http.StatusBadRequest},
+		{name: "disallowed punctuation", path: "/snp$.efi", wantStatus: http.StatusBadRequest},
+		{name: "custom punctuation", policy: filename.Policy{Punctuation: "./$"}, path: "/snp$.efi", wantStatus: http.StatusNotFound},
+		{name: "disabled", policy: filename.Policy{Disabled: true}, path: "/snp%20.efi", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Handler{Log: logr.Discard(), Filenames: tt.policy}
+			w := httptest.NewRecorder()
+			h.Handle(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %v, got: %v: %v", tt.wantStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
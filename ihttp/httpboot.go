@@ -0,0 +1,26 @@
+package ihttp
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// UEFIHTTPBootUserAgent is the prefix of the User-Agent header sent by the UEFI HTTP Boot client of EDK II based
+// firmware, for example "UefiHttpBoot/1.0". iPXE sends "iPXE/<version>".
+const UEFIHTTPBootUserAgent = "UefiHttpBoot"
+
+// EFIContentType is the Content-Type of the EFI binaries served to UEFI HTTP Boot clients. The firmware
+// checks it before loading the image and falls back to the file name extension for other types.
+const EFIContentType = "application/efi"
+
+// IsUEFIHTTPBoot reports whether req is made by the UEFI firmware of a machine booting over HTTP, instead of by iPXE,
+// based on its User-Agent header, see UEFIHTTPBootUserAgent.
+func IsUEFIHTTPBoot(req *http.Request) bool {
+	return strings.HasPrefix(req.UserAgent(), UEFIHTTPBootUserAgent)
+}
+
+// isEFI reports whether filename is the name of an EFI binary.
+func isEFI(filename string) bool {
+	return strings.EqualFold(path.Ext(filename), ".efi")
+}
@@ -0,0 +1,52 @@
+package ihttp
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+func TestHandleUEFIHTTPBoot(t *testing.T) {
+	script := "#!ipxe\nautoboot\n"
+	h := Handler{
+		Log:          logr.Discard(),
+		Templates:    map[string]*template.Template{"boot.ipxe": template.Must(template.New("boot.ipxe").Parse(script))},
+		UEFIHTTPBoot: map[string]string{"boot.ipxe": "ipxe.efi"},
+	}
+	tests := []struct {
+		name            string
+		userAgent       string
+		path            string
+		want            []byte
+		wantContentType string
+	}{
+		{name: "firmware", userAgent: "UefiHttpBoot/1.0", path: "/boot.ipxe", want: binary.IpxeEFI, wantContentType: EFIContentType},
+		{name: "firmware with mac", userAgent: "UefiHttpBoot/1.0", path: "/0a:00:27:00:00:02/boot.ipxe", want: binary.IpxeEFI, wantContentType: EFIContentType},
+		{name: "ipxe", userAgent: "iPXE/1.21.1+ (g8ea4)", path: "/boot.ipxe", want: []byte(script), wantContentType: "text/plain; charset=utf-8"},
+		{name: "firmware not mapped", userAgent: "UefiHttpBoot/1.0", path: "/snp.efi", want: binary.SNP, wantContentType: EFIContentType},
+		{name: "ipxe not mapped", userAgent: "iPXE/1.21.1+ (g8ea4)", path: "/snp.efi", want: binary.SNP, wantContentType: "application/octet-stream"},
+		{name: "firmware not efi", userAgent: "UefiHttpBoot/1.0", path: "/undionly.kpxe", want: binary.Undionly, wantContentType: "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			w := httptest.NewRecorder()
+			h.Handle(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected status code: %v", w.Code)
+			}
+			if !bytes.Equal(w.Body.Bytes(), tt.want) {
+				t.Fatalf("unexpected content served: %.20q", w.Body.Bytes())
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Fatalf("expected Content-Type %q, got: %q", tt.wantContentType, got)
+			}
+		})
+	}
+}
@@ -3,26 +3,167 @@ package ihttp
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net"
 	"net/http"
+	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/allowlist"
+	"github.com/tinkerbell/ipxedust/bandwidth"
 	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/canary"
+	"github.com/tinkerbell/ipxedust/clock"
+	"github.com/tinkerbell/ipxedust/fault"
+	"github.com/tinkerbell/ipxedust/filename"
+	"github.com/tinkerbell/ipxedust/progress"
+	"github.com/tinkerbell/ipxedust/ratelimit"
+	"github.com/tinkerbell/ipxedust/resolve"
+	"github.com/tinkerbell/ipxedust/timing"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 	"inet.af/netaddr"
 )
 
 // Handler is the struct that implements the http.Handler interface.
 type Handler struct {
+	// Resolver resolves requested file names to content.
+	resolve.Resolver
+	// Hook injects faults into requests, with the "faultinject" build tag only. See the fault package.
+	fault.Hook
 	Log logr.Logger
+	// ServedVerbosity is the V-level the files, archives and templates served are logged at, for example 1 to only
+	// log them when debugging a busy server. Zero logs them at info level. Failures are logged regardless.
+	ServedVerbosity int
+	// Allowed restricts the clients served to a set of IP addresses, others get a 403. A nil Allowed allows all clients.
+	Allowed *allowlist.Set
+	// AllowClientCert, when not nil, restricts the clients served to those presenting a verified client certificate
+	// whose subject, see VerifiedClient, it allows. Others get a 403.
+	AllowClientCert func(subject string) bool
+	// Limiter rate limits requests. A nil Limiter allows all requests.
+	Limiter *ratelimit.Limiter
+	// Authorize, when not nil, is called for every request of a file, archive or template, after Allowed and Limiter,
+	// for example to check a bearer token or a signed URL issued by a provisioning controller. When it returns an
+	// error the request gets a 401, for ErrUnauthorized, or a 403, and the error is logged. A nil Authorize allows all
+	// requests.
+	Authorize func(r *http.Request) error
+	// Filenames limits the length and the characters of the requested paths, which are answered with a 400 when they
+	// are not valid, before they are looked up.
+	Filenames filename.Policy
+	// Archives maps archive names to the paths of tar (.tar, .tar.gz, .tgz) or zip (.zip) files to serve.
+	// Request /<name> to download a whole archive, or /<name>/<member> to download a single member.
+	// Member names must be relative and clean, and must not contain ".." elements.
+	Archives map[string]string
+	// ReadGroup coalesces the concurrent lookups of the same archive member, in its central directory or its
+	// headers, so that it is looked up once for all waiting requests, which then stream it from the archive.
+	// A nil ReadGroup looks up the member for every request.
+	ReadGroup *singleflight.Group
+	// OnServed, when not nil, is called after a file is served successfully.
+	// mac is the optional MAC address from the requested path, it is nil when there is none.
+	OnServed func(client netaddr.IP, mac net.HardwareAddr, filename string, bytesSent int64)
+	// OnProgress, when not nil, is called periodically while a file is sent with the bytes sent so far
+	// and the total size, in the order the bytes are sent. The calls are throttled by Progress.
+	// Archives don't report progress.
+	OnProgress func(client netaddr.IP, filename string, sent, total int64)
+	// Progress throttles the calls to OnProgress.
+	Progress progress.Throttle
+	// OnTimings, when not nil, is called after a file is served successfully with the time spent in each phase of the
+	// request, which are also logged with the outcome of the transfer. Archives and templates are not timed.
+	OnTimings func(client netaddr.IP, filename string, phases timing.Phases)
+	// OnTruncated, when not nil, is called after a file transfer ended with fewer bytes sent than the size of the file,
+	// none included, for a reason other than an error on the server side, one of the Truncated constants. Such a
+	// transfer is logged as truncated, it is neither served nor failed. Archives are not checked.
+	OnTruncated func(client netaddr.IP, filename string, sent, size int64, reason string)
+	// Clock measures the phases of requests and ArtificialLatency. Defaults to the real clock.
+	Clock clock.Clock
+	// ArtificialLatency, when positive, delays every file served by this much before it is sent, to test the timeouts
+	// and retries of the clients against a slow server. It is a testing aid, see fault.Latency.
+	ArtificialLatency time.Duration
+	// Bandwidth throttles the rate files are sent at. A nil Bandwidth doesn't throttle. Archives are not throttled.
+	Bandwidth *bandwidth.Limiter
+	// Redirect, when not nil, is called with the requested file name, without the optional MAC address and traceparent.
+	// When it returns true the client is redirected with a 302 to the returned URL, for example a signed CDN URL,
+	// instead of being served the file. Archives are never redirected.
+	Redirect func(name string, r *http.Request) (string, bool)
+	// Maintenance, when not nil, reports whether the server is in maintenance mode.
+	// New requests get a 503 while it returns true, transfers in flight complete.
+	Maintenance func() bool
+	// Paused, when not nil, reports whether the server is paused, like a warm standby.
+	// New requests get a 503 while it returns true, transfers in flight complete.
+	Paused func() bool
+	// ClientIDHeader, when not empty, is the name of a request header, like "X-Machine-ID", holding an identifier
+	// of the client set by a cooperating DHCP server or proxy. It is logged as "clientID" with the request and
+	// passed to the Resolver hooks in the context, see resolve.ClientID. Values are capped at MaxClientIDLength.
+	ClientIDHeader string
+	// Templates maps file names to templates that are rendered for every request of the file, instead of serving
+	// a file, for example a boot script that points the client at the server. They are rendered with TemplateData
+	// and served as text/plain. A template that fails to render is logged and answered with a 500.
+	Templates map[string]*template.Template
+	// TemplateParams maps the file names of Templates to the query parameters, of NodeParams, their requests must
+	// have. The recognized query parameters are validated for all templates, see ParseNodeIdentity.
+	TemplateParams map[string][]string
+	// UEFIHTTPBoot maps requested file names to the files served in their place to UEFI HTTP Boot clients,
+	// detected with IsUEFIHTTPBoot. For example mapping "boot.ipxe" to "ipxe.efi" lets the firmware and iPXE share
+	// a single boot URL: the firmware gets iPXE, which then requests the same URL and gets the boot script.
+	// EFI binaries served to UEFI HTTP Boot clients have the EFIContentType, mapped or not.
+	UEFIHTTPBoot map[string]string
+	// Tenants, when not nil, maps tenant keys to the resolvers of their files, for multi-tenant setups. The leading
+	// element of every request path is the tenant key, for example "tenant-a" in /tenant-a/ipxe.efi, and the rest of
+	// the path is handled as usual with the resolver of the tenant, in place of Resolver. Requests without a known
+	// tenant get a 404.
+	Tenants map[string]resolve.Resolver
+	// Canary selects the requests served canary binaries, from the file system of the rule looked up before the
+	// other sources of files, with or without Tenants, see canary.Rule. Archives and templates are not affected.
+	Canary canary.Rule
+	// InlineScript embeds the iPXE script of the ParamScript query parameter, base64 encoded, in the binary served,
+	// in place of binary.Script, for one-off chainloads without building a binary, for example
+	// /ipxe.efi?script=IyFpcHhlCmRoY3AKY2hhaW4gaHR0cDovLzE5Mi4xNjguMi4xL2Jvb3QuaXB4ZQo=. The script is validated,
+	// see ErrInvalidScript, and is at most as long as binary.Script. An invalid script, or a binary without an embedded
+	// script, like undionly.kpxe, is answered with a 400. When InlineScript is false, the parameter is ignored.
+	InlineScript bool
+	// OptionsHeader holds headers added to the responses to OPTIONS requests, for example the Access-Control-Allow
+	// headers answering the preflight requests of CORS. The Allow header is always set, to AllowedMethods.
+	OptionsHeader http.Header
+	// Index, when not nil, answers the requests of the root path, / or an empty path, for example with a page listing
+	// the files or a redirect to a boot script. A nil Index answers them with a 404. A path with a trailing slash,
+	// like /ipxe.efi/, gets a 404 too: it names a directory, not a file.
+	Index http.Handler
+	// Timeout aborts sending a file, with ErrIdleTimeout, when it makes no progress for this long, for example
+	// because the client stopped reading without closing the connection. Zero means no limit. It only limits the time
+	// the transfer is idle, unlike the ReadTimeout and WriteTimeout of the http.Server, which limit the time reading
+	// the request and writing the whole response. It requires the http.Server to set ConnContext to ConnContext.
+	// Archives and templates are not limited.
+	Timeout time.Duration
+}
+
+// MaxClientIDLength is the maximum length of a client identifier read from Handler.ClientIDHeader. Longer values are truncated.
+const MaxClientIDLength = 256
+
+// clientID returns the client identifier of req from ClientIDHeader, or an empty string when there is none.
+func (s Handler) clientID(req *http.Request) string {
+	if s.ClientIDHeader == "" {
+		return ""
+	}
+	id := strings.TrimSpace(req.Header.Get(s.ClientIDHeader))
+	if len(id) > MaxClientIDLength {
+		id = id[:MaxClientIDLength]
+	}
+	return id
 }
 
 // ListenAndServe is a patterned after http.ListenAndServe.
@@ -50,27 +191,91 @@ func Serve(_ context.Context, conn net.Listener, h *http.Server) error {
 }
 
 // Handle handles responses to HTTP requests.
+// A panic while handling the request, for example in a hook, is logged and answered with a 500,
+// instead of relying on net/http, which logs it without the request context and drops the connection.
 func (s Handler) Handle(w http.ResponseWriter, req *http.Request) {
+	defer s.recoverPanic(w, req)
+	sw := timing.Start(s.Clock)
 	s.Log.V(1).Info("handling request", "method", req.Method, "path", req.URL.Path)
-	if req.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if s.handleMethod(w, req) {
 		return
 	}
 	host, port, _ := net.SplitHostPort(req.RemoteAddr)
 	log := s.Log.WithValues("host", host, "port", port)
+	if s.Maintenance != nil && s.Maintenance() {
+		log.Info("request rejected, in maintenance mode", "path", req.URL.Path)
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if s.Paused != nil && s.Paused() {
+		log.Info("request rejected, paused", "path", req.URL.Path)
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	ip, _ := netaddr.ParseIP(host)
+	if s.rejectClient(w, req, log, ip) {
+		return
+	}
+	if ok, wait := s.Limiter.Allow(ip); !ok {
+		log.Info("rate limit exceeded", "path", req.URL.Path, "retryAfter", wait)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		return
+	}
+	if s.authorize(w, req, log) {
+		return
+	}
+	sw.Queued()
+	if s.handlePath(w, req, log) {
+		return
+	}
+	if err := s.Filenames.Check(req.URL.Path); err != nil {
+		log.Info("invalid file name requested", "path", req.URL.Path, "error", err.Error())
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.Tenants != nil {
+		tenant, rest, ok := splitTenant(req.URL.Path)
+		r, known := s.Tenants[tenant]
+		if !ok || !known {
+			log.Info("requested tenant unknown", "path", req.URL.Path)
+			http.NotFound(w, req)
+			return
+		}
+		log = log.WithValues("tenant", tenant)
+		s.Resolver = r
+		req = withPath(req, rest)
+	}
+	if s.Canary.Selected(req, ip) {
+		log = log.WithValues("variant", canary.Canary)
+		s.Resolver.Overlay = s.Canary.FS
+	}
 	// If a mac address is provided (/0a:00:27:00:00:02/snp.efi), parse and log it.
 	// Mac address is optional.
 	optionalMac, _ := net.ParseMAC(strings.TrimPrefix(path.Dir(req.URL.Path), "/"))
 	log = log.WithValues("macFromURI", optionalMac.String())
+	ctx := req.Context()
+	if identity, ok := VerifiedClient(req); ok {
+		log = log.WithValues("clientCert", identity)
+		ctx = resolve.WithVerifiedClient(ctx, identity)
+	}
+	if id := s.clientID(req); id != "" {
+		log = log.WithValues("clientID", id)
+		ctx = resolve.WithClientID(ctx, id)
+	}
+	if name, member, ok := splitArchivePath(s.Archives, req.URL.Path); ok {
+		s.handleArchive(w, req, log, ip, optionalMac, name, member)
+		return
+	}
 	filename := filepath.Base(req.URL.Path)
 	log = log.WithValues("filename", filename)
 
 	// clients can send traceparent over HTTP by appending the traceparent string
 	// to the end of the filename they really want
 	longfile := filename // hang onto this to report in traces
-	ctx, shortfile, err := extractTraceparentFromFilename(context.Background(), filename)
+	tctx, shortfile, err := extractTraceparentFromFilename(context.Background(), filename)
 	if err != nil {
-		log.Error(err, "failed to extract traceparent from filename")
+		log.Info("ignoring invalid traceparent", "error", err.Error())
 	}
 	if shortfile != filename {
 		log = log.WithValues("shortfile", shortfile)
@@ -79,7 +284,7 @@ func (s Handler) Handle(w http.ResponseWriter, req *http.Request) {
 	}
 
 	tracer := otel.Tracer("HTTP")
-	_, span := tracer.Start(ctx, "HTTP get",
+	_, span := tracer.Start(tctx, "HTTP get",
 		trace.WithSpanKind(trace.SpanKindServer),
 		trace.WithAttributes(attribute.String("filename", filename)),
 		trace.WithAttributes(attribute.String("requested-filename", longfile)),
@@ -90,19 +295,252 @@ func (s Handler) Handle(w http.ResponseWriter, req *http.Request) {
 	span.SetStatus(codes.Ok, filename)
 	span.End()
 
-	file, found := binary.Files[filename]
-	if !found {
-		log.Info("requested file not found")
+	uefi := IsUEFIHTTPBoot(req)
+	if nbp, ok := s.UEFIHTTPBoot[filename]; ok && uefi {
+		log.Info("serving UEFI HTTP Boot client", "nbp", nbp)
+		filename = nbp
+	}
+	if s.Redirect != nil {
+		if u, ok := s.Redirect(filename, req); ok {
+			log.Info("redirecting request", "location", u)
+			http.Redirect(w, req, u, http.StatusFound)
+			return
+		}
+	}
+	if _, ok := s.Templates[filename]; ok {
+		s.handleTemplate(w, req, log, ip, optionalMac, filename)
+		return
+	}
+	var script []byte
+	if q := req.URL.Query(); s.InlineScript && q.Has(ParamScript) {
+		if script, err = parseInlineScript(q.Get(ParamScript)); err != nil {
+			log.Info("invalid inline script", "error", err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Method == http.MethodHead {
+		// a HEAD doesn't change the state of the server, like the boots recorded by a first boot tracker.
+		s.Resolver.Stateful = s.Resolver.Peek
+	}
+	clientAddr, _ := netaddr.ParseIPPort(req.RemoteAddr)
+	file, size, err := s.Open(ctx, clientAddr, filename)
+	sw.Resolved()
+	if err != nil {
+		switch {
+		case errors.Is(err, binary.ErrNotEmbedded):
+			log.Info("warning: requested iPXE binary not compiled into this build", "available", binary.Available())
+		case errors.Is(err, os.ErrNotExist):
+			log.Info("requested file not found")
+		default:
+			log.Error(transferError(req, err), "resolving requested file failed", sw.Phases().KeysAndValues()...)
+		}
 		http.NotFound(w, req)
 		return
 	}
-	b, err := w.Write(file)
+	defer file.Close()
+	if script != nil {
+		if file, err = embedScript(file, size, script); err != nil {
+			if errors.Is(err, binary.ErrNoScript) {
+				log.Info("binary doesn't support inline scripts")
+				http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+			} else {
+				log.Error(transferError(req, err), "embedding inline script failed")
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			}
+			return
+		}
+		log = log.WithValues("inlineScript", true)
+	}
+	if err := fault.Latency(ctx, s.Clock, s.ArtificialLatency); err != nil {
+		log.Info("request canceled during the artificial latency", "error", err)
+		return
+	}
+	if f := s.Inject(fault.Request{Protocol: "http", Client: ip, Filename: filename}); f.Err != nil || f.Truncate {
+		injectFault(w, log, f, file, size)
+		return
+	}
+	if uefi && isEFI(filename) {
+		w.Header().Set("Content-Type", EFIContentType)
+	}
+	if notModified(w, req, file) {
+		log.Info("file not modified", "lastModified", w.Header().Get("Last-Modified"))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if req.Method == http.MethodHead {
+		log.V(1).Info("file head served", "fileSize", size)
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		return
+	}
+	b, err := s.write(req, w, ip, filename, file, size)
+	sw.Transferred()
+	phases := sw.Phases()
+	log = log.WithValues(phases.KeysAndValues()...)
+	if reason := truncation(err, b, size); reason != "" {
+		tlog := log
+		if reason == TruncatedClientGone {
+			// routine, for example a node that was reset while booting.
+			tlog = log.V(1)
+		}
+		tlog.Info("transfer truncated", "reason", reason, "bytesSent", b, "fileSize", size)
+		if s.OnTruncated != nil {
+			s.OnTruncated(ip, filename, b, size, reason)
+		}
+		return
+	}
+	if clientGone(err) {
+		// routine, for example a node that was reset while booting.
+		log.V(1).Info("client disconnected during transfer", "error", err.Error(), "bytesSent", b, "fileSize", size)
+		return
+	}
+	if errors.Is(err, ErrIdleTimeout) {
+		log.Info("transfer aborted, client idle", "timeout", s.Timeout, "bytesSent", b, "fileSize", size)
+		return
+	}
 	if err != nil {
-		log.Error(err, "error serving file")
+		log.Error(transferError(req, err), "error serving file")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	log.Info("file served", "bytesSent", b, "fileSize", len(file))
+	log.V(s.ServedVerbosity).Info("file served", "bytesSent", b, "fileSize", size)
+	s.onServed(req, ip, optionalMac, filename, b)
+	if s.OnTimings != nil {
+		s.OnTimings(ip, filename, phases)
+	}
+}
+
+// injectFault fails the response with f instead of serving file, of size bytes.
+func injectFault(w http.ResponseWriter, log logr.Logger, f fault.Fault, file io.Reader, size int64) {
+	if f.Err != nil {
+		status := f.Status
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		log.Info("injected fault", "error", f.Err, "status", status)
+		http.Error(w, f.Err.Error(), status)
+		return
+	}
+	truncated := f.Size
+	if truncated < 0 || truncated > size {
+		truncated = size
+	}
+	log.Info("injected fault", "truncatedTo", truncated)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	_, _ = io.CopyN(w, file, truncated)
+	if fl, ok := w.(http.Flusher); ok {
+		fl.Flush()
+	}
+	// break the connection off, like a transfer that failed part way.
+	panic(http.ErrAbortHandler)
+}
+
+// write writes file, of size bytes, to the response w to req, in chunks that are throttled by Bandwidth and report
+// progress to OnProgress when they are set. The transfer is aborted with ErrIdleTimeout when it stalls for longer
+// than Timeout.
+func (s Handler) write(req *http.Request, w http.ResponseWriter, client netaddr.IP, filename string, file io.Reader, size int64) (int64, error) {
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	out, stop := newIdleWriter(w, req, s.Timeout)
+	defer stop()
+	r := file
+	if s.OnProgress != nil || s.Bandwidth != nil {
+		var fn progress.Func
+		if s.OnProgress != nil {
+			fn = func(sent, total int64) { s.OnProgress(client, filename, sent, total) }
+		}
+		r = progress.NewReader(s.Bandwidth.Reader(req.Context(), file), size, s.Progress, fn)
+	}
+	n, err := io.Copy(out, r)
+	if iw, ok := out.(*idleWriter); ok && err == nil {
+		err = iw.flush()
+	}
+	return n, err
+}
+
+// notModified sets the Last-Modified header of the response w to req, when the modification time of file is known,
+// see resolve.ModTimer, and reports whether the copy of the client is current, from the If-Modified-Since header.
+func notModified(w http.ResponseWriter, req *http.Request, file io.Reader) bool {
+	mt, ok := file.(resolve.ModTimer)
+	if !ok {
+		return false
+	}
+	return notModifiedSince(w, req, mt.ModTime())
+}
+
+// notModifiedSince sets the Last-Modified header of content modified at modTime, and reports whether req is
+// conditional and the content wasn't modified since.
+func notModifiedSince(w http.ResponseWriter, req *http.Request, modTime time.Time) bool {
+	// the header has a resolution of a second.
+	modTime = modTime.UTC().Truncate(time.Second)
+	w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+	since, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	return err == nil && !modTime.After(since)
+}
+
+// transferError wraps err, of the response to req, with the requested path and the client address,
+// so it can be correlated with the node. Errors.Is and errors.As match the wrapped error.
+func transferError(req *http.Request, err error) error {
+	return fmt.Errorf("serving %v to %v failed: %w", req.URL.Path, req.RemoteAddr, err)
+}
+
+// clientGone reports whether err means the client went away during the response, by closing or resetting
+// the connection or by canceling the request, as opposed to a failure of the server.
+func clientGone(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, net.ErrClosed) || errors.Is(err, context.Canceled)
+}
+
+// handleArchive serves the registered archive name, or only its member when member is not empty.
+func (s Handler) handleArchive(w http.ResponseWriter, req *http.Request, log logr.Logger, ip netaddr.IP, mac net.HardwareAddr, name, member string) {
+	log = log.WithValues("archive", name, "member", member)
+	b, err := s.serveArchive(w, req, s.Archives[name], member)
+	switch {
+	case errors.Is(err, errInvalidMember):
+		log.Info("invalid archive member requested")
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	case errors.Is(err, os.ErrNotExist):
+		log.Info("requested archive member not found")
+		http.NotFound(w, req)
+		return
+	case err != nil:
+		log.Error(transferError(req, err), "error serving archive")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	log.V(s.ServedVerbosity).Info("archive served", "bytesSent", b)
+	filename := name
+	if member != "" {
+		filename = path.Join(name, member)
+	}
+	s.onServed(req, ip, mac, filename, b)
+}
+
+// recoverPanic recovers a panic of the handler of req, logs it with its stack trace and responds with a 500.
+// The status is lost when the response was already started. It must be deferred.
+func (s Handler) recoverPanic(w http.ResponseWriter, req *http.Request) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if err, ok := r.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+		// net/http aborts the response silently.
+		panic(r)
+	}
+	if s.Log.GetSink() != nil {
+		s.Log.Error(transferError(req, fmt.Errorf("panic: %v", r)), "recovered from panic in handler", "method", req.Method, "path", req.URL.Path, "stack", string(debug.Stack()))
+	}
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
+
+// VerifiedClient returns the subject of the client certificate of a mutual TLS request, for example for
+// Handler.Authorize. The hooks called with the context of the request get it with resolve.VerifiedClient.
+// It returns false when the request was not made over TLS or the client certificate was not verified.
+func VerifiedClient(req *http.Request) (string, bool) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	return req.TLS.VerifiedChains[0][0].Subject.String(), true
 }
 
 // extractTraceparentFromFilename takes a context and filename and checks the filename for
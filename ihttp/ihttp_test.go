@@ -3,20 +3,34 @@ package ihttp
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"text/template"
+	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
 	"github.com/go-logr/stdr"
 	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/bandwidth"
 	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/ratelimit"
+	"github.com/tinkerbell/ipxedust/resolve"
+	"github.com/tinkerbell/ipxedust/upstream"
 	"go.opentelemetry.io/otel/trace"
 	"inet.af/netaddr"
 )
@@ -260,3 +274,367 @@ func TestExtractTraceparentFromFilename(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleRateLimit(t *testing.T) {
+	h := Handler{Log: logr.Discard(), Limiter: ratelimit.New(ratelimit.Config{PerClientRequestsPerSecond: 0.001})}
+	want := []int{http.StatusOK, http.StatusTooManyRequests}
+	for i, status := range want {
+		w := httptest.NewRecorder()
+		h.Handle(w, httptest.NewRequest("GET", "/snp.efi", nil))
+		resp := w.Result()
+		resp.Body.Close()
+		if diff := cmp.Diff(resp.StatusCode, status); diff != "" {
+			t.Fatalf("request %v: %v", i, diff)
+		}
+		if status == http.StatusTooManyRequests && resp.Header.Get("Retry-After") == "" {
+			t.Fatal("expected a Retry-After header")
+		}
+	}
+}
+
+func TestVerifiedClient(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "machine-1"}}
+	tests := []struct {
+		name     string
+		tls      *tls.ConnectionState
+		want     string
+		wantBool bool
+	}{
+		{name: "not tls"},
+		{name: "unverified", tls: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		{name: "verified", tls: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}, want: "CN=machine-1", wantBool: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/undionly.kpxe", nil)
+			req.TLS = tt.tls
+			got, ok := VerifiedClient(req)
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+			if ok != tt.wantBool {
+				t.Fatalf("want %v, got %v", tt.wantBool, ok)
+			}
+		})
+	}
+}
+
+func TestHandleUpstream(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/custom.efi":
+			_, _ = w.Write([]byte("custom content"))
+		case "/broken.efi":
+			http.Error(w, "boom", http.StatusBadGateway)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer up.Close()
+	cache, err := upstream.New(up.URL, time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+		wantBody []byte
+	}{
+		{name: "embedded file", path: "/snp.efi", wantCode: http.StatusOK, wantBody: binary.Files["snp.efi"]},
+		{name: "upstream file", path: "/custom.efi", wantCode: http.StatusOK, wantBody: []byte("custom content")},
+		{name: "not found upstream", path: "/missing.efi", wantCode: http.StatusNotFound},
+		{name: "upstream error", path: "/broken.efi", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Handler{Log: logr.Discard(), Resolver: resolve.Resolver{Upstream: cache}}
+			w := httptest.NewRecorder()
+			h.Handle(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			resp := w.Result()
+			defer resp.Body.Close()
+			if diff := cmp.Diff(resp.StatusCode, tt.wantCode); diff != "" {
+				t.Fatal(diff)
+			}
+			if tt.wantBody == nil {
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(body, tt.wantBody); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestHandlePanic(t *testing.T) {
+	var logged bytes.Buffer
+	h := Handler{
+		Log:      stdr.New(log.New(&logged, "", 0)),
+		Resolver: resolve.Resolver{Info: func() []byte { panic("resolver bug") }},
+	}
+	w := httptest.NewRecorder()
+	h.Handle(w, httptest.NewRequest("GET", "/"+resolve.InfoFileName, nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status %v, got: %v", http.StatusInternalServerError, w.Code)
+	}
+	for _, want := range []string{"recovered from panic in handler", "resolver bug", "goroutine"} {
+		if !bytes.Contains(logged.Bytes(), []byte(want)) {
+			t.Fatalf("expected %q to be logged, got: %v", want, logged.String())
+		}
+	}
+
+	// the handler keeps serving.
+	w = httptest.NewRecorder()
+	h.Handle(w, httptest.NewRequest("GET", "/snp.efi", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %v, got: %v", http.StatusOK, w.Code)
+	}
+}
+
+func TestHandlePanicAbortHandler(t *testing.T) {
+	h := Handler{
+		Log:      logr.Discard(),
+		Resolver: resolve.Resolver{Info: func() []byte { panic(http.ErrAbortHandler) }},
+	}
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Fatalf("expected http.ErrAbortHandler to be re-panicked, got: %v", r)
+		}
+	}()
+	h.Handle(httptest.NewRecorder(), httptest.NewRequest("GET", "/"+resolve.InfoFileName, nil))
+}
+
+func TestHandleRedirect(t *testing.T) {
+	h := Handler{
+		Log: logr.Discard(),
+		Redirect: func(name string, r *http.Request) (string, bool) {
+			if name != "ipxe.efi" {
+				return "", false
+			}
+			return "https://cdn.example.com/ipxe/" + name + "?sig=abc", true
+		},
+	}
+	tests := []struct {
+		name         string
+		path         string
+		wantStatus   int
+		wantLocation string
+	}{
+		{name: "redirected", path: "/ipxe.efi", wantStatus: http.StatusFound, wantLocation: "https://cdn.example.com/ipxe/ipxe.efi?sig=abc"},
+		{name: "redirected with mac", path: "/0a:00:27:00:00:02/ipxe.efi", wantStatus: http.StatusFound, wantLocation: "https://cdn.example.com/ipxe/ipxe.efi?sig=abc"},
+		{name: "not redirected", path: "/snp.efi", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.Handle(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %v, got: %v", tt.wantStatus, w.Code)
+			}
+			if diff := cmp.Diff(w.Header().Get("Location"), tt.wantLocation); diff != "" {
+				t.Fatal(diff)
+			}
+			if tt.wantStatus == http.StatusOK && !bytes.Equal(w.Body.Bytes(), binary.Files["snp.efi"]) {
+				t.Fatal("expected the file to be served")
+			}
+		})
+	}
+}
+
+func TestHandleClientID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		wantID string
+	}{
+		{name: "header", header: "X-Machine-ID", value: "machine-1", wantID: "machine-1"},
+		{name: "header not sent", header: "X-Machine-ID"},
+		{name: "disabled", value: "machine-1"},
+		{name: "capped", header: "X-Machine-ID", value: strings.Repeat("a", MaxClientIDLength+10), wantID: strings.Repeat("a", MaxClientIDLength)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged bytes.Buffer
+			var hookID string
+			h := Handler{
+				Log:            stdr.New(log.New(&logged, "", 0)),
+				ClientIDHeader: tt.header,
+				Resolver: resolve.Resolver{Dynamic: func(ctx context.Context, _ netaddr.IPPort, _ string) ([]byte, bool, error) {
+					hookID, _ = resolve.ClientID(ctx)
+					return nil, false, nil
+				}},
+			}
+			req := httptest.NewRequest("GET", "/snp.efi", nil)
+			if tt.value != "" {
+				req.Header.Set("X-Machine-ID", tt.value)
+			}
+			w := httptest.NewRecorder()
+			h.Handle(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status %v, got: %v", http.StatusOK, w.Code)
+			}
+			if hookID != tt.wantID {
+				t.Fatalf("expected the hook to get client ID %q, got: %q", tt.wantID, hookID)
+			}
+			wantLog := fmt.Sprintf("%q=%q", "clientID", tt.wantID)
+			if got := strings.Contains(logged.String(), wantLog); got != (tt.wantID != "") {
+				t.Fatalf("expected %v logged: %v, got: %v", wantLog, tt.wantID != "", logged.String())
+			}
+		})
+	}
+}
+
+func TestHandleBandwidth(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), 10000)
+	h := Handler{
+		Log:       logr.Discard(),
+		Resolver:  resolve.Resolver{Files: map[string][]byte{"custom.efi": content}},
+		Bandwidth: bandwidth.New(bandwidth.Config{PerTransfer: 20000}),
+	}
+	w := httptest.NewRecorder()
+	start := time.Now()
+	h.Handle(w, httptest.NewRequest("GET", "/custom.efi", nil))
+	if elapsed, min := time.Since(start), 450*time.Millisecond; elapsed < min {
+		t.Fatalf("expected the transfer to take at least %v, took: %v", min, elapsed)
+	}
+	if !bytes.Equal(w.Body.Bytes(), content) {
+		t.Fatal("content mismatch")
+	}
+	if got := w.Header().Get("Content-Length"); got != "10000" {
+		t.Fatalf("unexpected content length: %v", got)
+	}
+}
+
+func TestHandleClientDisconnect(t *testing.T) {
+	var mu sync.Mutex
+	var logged []string
+	done := make(chan struct{})
+	h := Handler{
+		Log: funcr.New(func(prefix, args string) {
+			mu.Lock()
+			logged = append(logged, args)
+			mu.Unlock()
+		}, funcr.Options{Verbosity: 1}),
+		// large enough not to fit in the socket buffers, so the handler is still writing when the client goes away.
+		Resolver: resolve.Resolver{Files: map[string][]byte{"big.efi": make([]byte, 64<<20)}},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer close(done)
+		h.Handle(w, req)
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(conn, "GET /big.efi HTTP/1.1\r\nHost: ipxe\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the handler to stop writing")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var quiet bool
+	for _, l := range logged {
+		if strings.Contains(l, "error serving file") {
+			t.Fatalf("expected no error logged, got: %v", l)
+		}
+		if strings.Contains(l, `"level"=1 "msg"="transfer truncated"`) && strings.Contains(l, `"reason"="client gone"`) {
+			quiet = true
+		}
+	}
+	if !quiet {
+		t.Fatalf("expected the disconnect to be logged at debug level, got: %v", logged)
+	}
+}
+
+func TestClientGone(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil},
+		{name: "broken pipe", err: &net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)}, want: true},
+		{name: "connection reset", err: &net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.ECONNRESET)}, want: true},
+		{name: "closed pipe", err: io.ErrClosedPipe, want: true},
+		{name: "request canceled", err: fmt.Errorf("throttling: %w", context.Canceled), want: true},
+		{name: "server failure", err: errors.New("disk on fire")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clientGone(tt.err); got != tt.want {
+				t.Fatalf("expected %v, got: %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHandleErrorContext(t *testing.T) {
+	errDynamic := errors.New("generation failed")
+	tests := []struct {
+		name string
+		h    Handler
+		path string
+	}{
+		{
+			name: "resolve",
+			h: Handler{Resolver: resolve.Resolver{Dynamic: func(context.Context, netaddr.IPPort, string) ([]byte, bool, error) {
+				return nil, false, errDynamic
+			}}},
+			path: "/0a:00:27:00:00:02/broken.efi",
+		},
+		{
+			name: "template",
+			h:    Handler{Templates: map[string]*template.Template{"boot.ipxe": template.Must(template.New("boot.ipxe").Parse(`{{ index .Query.arch 5 }}`))}},
+			path: "/boot.ipxe",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged []string
+			tt.h.Log = funcr.New(func(prefix, args string) { logged = append(logged, args) }, funcr.Options{})
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = "192.168.2.10:54321"
+			tt.h.Handle(httptest.NewRecorder(), req)
+			want := fmt.Sprintf(`"error"="serving %v to 192.168.2.10:54321 failed: `, tt.path)
+			for _, l := range logged {
+				if strings.Contains(l, want) {
+					return
+				}
+			}
+			t.Fatalf("expected an error with the client address and path logged, got: %v", logged)
+		})
+	}
+}
+
+func TestHandleInvalidTraceparent(t *testing.T) {
+	var logged []string
+	h := Handler{Log: funcr.New(func(prefix, args string) { logged = append(logged, args) }, funcr.Options{})}
+	req := httptest.NewRequest(http.MethodGet, "/snp.efi-00-00000000000000000000000000000000-d887dc3912240434-01", nil)
+	h.Handle(httptest.NewRecorder(), req)
+	// a malformed traceparent is the mistake of the client, it is not logged as a failure of the server.
+	for _, l := range logged {
+		if strings.Contains(l, "ignoring invalid traceparent") {
+			if !strings.Contains(l, `"level"=0`) || strings.Contains(l, `"error"="serving `) {
+				t.Fatalf("expected the invalid traceparent to be logged at info level, got: %v", l)
+			}
+			return
+		}
+	}
+	t.Fatalf("expected the invalid traceparent to be logged, got: %v", logged)
+}
@@ -0,0 +1,64 @@
+package ihttp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+// ParamScript is the query parameter of the base64 encoded iPXE script embedded in the binary served, see
+// Handler.InlineScript.
+const ParamScript = "script"
+
+// ErrInvalidScript is returned for an inline script that can't be embedded in a binary.
+var ErrInvalidScript = errors.New("invalid inline script")
+
+// parseInlineScript returns the script of the ParamScript query parameter, decoded from base64, in the standard
+// or the URL safe alphabet, padded or not, escaped or not. The script must start with "#!ipxe", must be at most as long as
+// binary.Script, which it replaces, and must be text without control characters but tabs and new lines.
+func parseInlineScript(encoded string) ([]byte, error) {
+	if max := base64.StdEncoding.EncodedLen(len(binary.Script)); len(encoded) > max {
+		return nil, fmt.Errorf("%w: %v encoded bytes, at most %v", ErrInvalidScript, len(encoded), max)
+	}
+	// a "+" that was not escaped in the query string is decoded as a space.
+	encoded = strings.NewReplacer("-", "+", "_", "/", " ", "+").Replace(strings.TrimRight(encoded, "="))
+	script, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidScript, err)
+	}
+	if len(script) > len(binary.Script) {
+		return nil, fmt.Errorf("%w: %v bytes, at most %v", ErrInvalidScript, len(script), len(binary.Script))
+	}
+	if !bytes.HasPrefix(script, []byte("#!ipxe")) {
+		return nil, fmt.Errorf("%w: must start with #!ipxe", ErrInvalidScript)
+	}
+	if !utf8.Valid(script) {
+		return nil, fmt.Errorf("%w: not valid UTF-8", ErrInvalidScript)
+	}
+	for _, r := range string(script) {
+		if r < ' ' && r != '\t' && r != '\n' && r != '\r' || r == 0x7f {
+			return nil, fmt.Errorf("%w: control character %U", ErrInvalidScript, r)
+		}
+	}
+	return script, nil
+}
+
+// embedScript returns the content of file, of size bytes, with script embedded in place of binary.Script.
+// It fails with binary.ErrNoScript for a binary that doesn't have it, like undionly.kpxe.
+func embedScript(file io.Reader, size int64, script []byte) (io.ReadCloser, error) {
+	content, err := io.ReadAll(io.LimitReader(file, size))
+	if err != nil {
+		return nil, err
+	}
+	b, err := binary.ReplaceScript(content, script)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
@@ -0,0 +1,58 @@
+package ihttp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+func TestHandleInlineScript(t *testing.T) {
+	script := []byte("#!ipxe\ndhcp\nchain http://192.168.2.1/boot.ipxe\n")
+	want, err := binary.ReplaceScript(binary.Files["ipxe.efi"], script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		disabled   bool
+		path       string
+		script     string
+		wantStatus int
+		want       []byte
+	}{
+		{name: "valid", path: "/ipxe.efi", script: base64.StdEncoding.EncodeToString(script), wantStatus: http.StatusOK, want: want},
+		{name: "url safe unpadded", path: "/ipxe.efi", script: base64.RawURLEncoding.EncodeToString(script), wantStatus: http.StatusOK, want: want},
+		{name: "not escaped", path: "/ipxe.efi?script=" + base64.StdEncoding.EncodeToString([]byte("#!ipxe\necho >>>\n")), wantStatus: http.StatusOK},
+		{name: "disabled", disabled: true, path: "/ipxe.efi", script: base64.StdEncoding.EncodeToString(script), wantStatus: http.StatusOK, want: binary.Files["ipxe.efi"]},
+		{name: "oversized", path: "/ipxe.efi", script: base64.StdEncoding.EncodeToString(append([]byte("#!ipxe\n"), bytes.Repeat([]byte("echo\n"), 20)...)), wantStatus: http.StatusBadRequest},
+		{name: "oversized encoding", path: "/ipxe.efi", script: strings.Repeat("A", 1<<20), wantStatus: http.StatusBadRequest},
+		{name: "not base64", path: "/ipxe.efi", script: "#!ipxe", wantStatus: http.StatusBadRequest},
+		{name: "not a script", path: "/ipxe.efi", script: base64.StdEncoding.EncodeToString([]byte("chain http://192.168.2.1/\n")), wantStatus: http.StatusBadRequest},
+		{name: "control character", path: "/ipxe.efi", script: base64.StdEncoding.EncodeToString([]byte("#!ipxe\necho \x1b[2J\n")), wantStatus: http.StatusBadRequest},
+		{name: "binary without script", path: "/undionly.kpxe", script: base64.StdEncoding.EncodeToString(script), wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Handler{Log: logr.Discard(), InlineScript: !tt.disabled}
+			w := httptest.NewRecorder()
+			target := tt.path
+			if tt.script != "" {
+				target += "?" + url.Values{ParamScript: {tt.script}}.Encode()
+			}
+			h.Handle(w, httptest.NewRequest("GET", target, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %v, got: %v: %v", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.want != nil && !bytes.Equal(w.Body.Bytes(), tt.want) {
+				t.Fatal("unexpected content served")
+			}
+		})
+	}
+}
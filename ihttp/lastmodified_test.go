@@ -0,0 +1,60 @@
+package ihttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/resolve"
+)
+
+func TestHandleLastModified(t *testing.T) {
+	built := time.Date(2023, time.November, 14, 22, 13, 20, 0, time.UTC)
+	tests := []struct {
+		name             string
+		filename         string
+		ifModifiedSince  string
+		wantStatus       int
+		wantLastModified string
+	}{
+		{name: "no condition", filename: "snp.efi", wantStatus: http.StatusOK, wantLastModified: "Tue, 14 Nov 2023 22:13:20 GMT"},
+		{name: "not modified", filename: "snp.efi", ifModifiedSince: "Tue, 14 Nov 2023 22:13:20 GMT", wantStatus: http.StatusNotModified, wantLastModified: "Tue, 14 Nov 2023 22:13:20 GMT"},
+		{name: "not modified since later", filename: "snp.efi", ifModifiedSince: "Wed, 15 Nov 2023 08:00:00 GMT", wantStatus: http.StatusNotModified, wantLastModified: "Tue, 14 Nov 2023 22:13:20 GMT"},
+		{name: "modified", filename: "snp.efi", ifModifiedSince: "Tue, 14 Nov 2023 22:13:19 GMT", wantStatus: http.StatusOK, wantLastModified: "Tue, 14 Nov 2023 22:13:20 GMT"},
+		{name: "malformed condition", filename: "snp.efi", ifModifiedSince: "yesterday", wantStatus: http.StatusOK, wantLastModified: "Tue, 14 Nov 2023 22:13:20 GMT"},
+		{name: "not embedded", filename: "custom.efi", ifModifiedSince: "Wed, 15 Nov 2023 08:00:00 GMT", wantStatus: http.StatusOK},
+		{name: "embedded replaced", filename: "ipxe.efi", ifModifiedSince: "Wed, 15 Nov 2023 08:00:00 GMT", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Handler{
+				Log: logr.Discard(),
+				Resolver: resolve.Resolver{
+					Files:           map[string][]byte{"custom.efi": []byte("custom"), "ipxe.efi": []byte("replaced")},
+					EmbeddedModTime: built.Add(500 * time.Millisecond),
+				},
+			}
+			req := httptest.NewRequest("GET", "/"+tt.filename, nil)
+			if tt.ifModifiedSince != "" {
+				req.Header.Set("If-Modified-Since", tt.ifModifiedSince)
+			}
+			w := httptest.NewRecorder()
+			h.Handle(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %v, got: %v", tt.wantStatus, w.Code)
+			}
+			if got := w.Header().Get("Last-Modified"); got != tt.wantLastModified {
+				t.Fatalf("expected Last-Modified %q, got: %q", tt.wantLastModified, got)
+			}
+			if tt.wantStatus == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Fatalf("expected no body, got %v bytes", w.Body.Len())
+			}
+			if tt.filename == "snp.efi" && tt.wantStatus == http.StatusOK && w.Body.Len() != len(binary.SNP) {
+				t.Fatalf("expected the binary to be served, got %v bytes", w.Body.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package ihttp
+
+import (
+	"net"
+	"net/http"
+
+	"inet.af/netaddr"
+)
+
+// AllowedMethods are the methods of the requests Handle serves, as listed in the Allow header of the responses to
+// the other methods. HEAD requests are answered with the headers of the response to a GET, without transferring
+// the file.
+const AllowedMethods = "GET, HEAD"
+
+// handleMethod answers the requests whose method is not one of AllowedMethods: OPTIONS with a 204, and the other
+// methods with a 405, both with an Allow header listing AllowedMethods. The OPTIONS responses have OptionsHeader too.
+// It reports whether req was answered.
+func (s Handler) handleMethod(w http.ResponseWriter, req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		return false
+	case http.MethodOptions:
+		for k, v := range s.OptionsHeader {
+			w.Header()[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+		}
+		w.Header().Set("Allow", AllowedMethods)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", AllowedMethods)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+	return true
+}
+
+// onServed calls OnServed, when it is set, unless req is a HEAD request, whose file was not transferred.
+func (s Handler) onServed(req *http.Request, ip netaddr.IP, mac net.HardwareAddr, filename string, bytesSent int64) {
+	if s.OnServed != nil && req.Method != http.MethodHead {
+		s.OnServed(ip, mac, filename, bytesSent)
+	}
+}
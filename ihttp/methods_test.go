@@ -0,0 +1,74 @@
+package ihttp
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/firstboot"
+	"inet.af/netaddr"
+)
+
+func TestHandleMethods(t *testing.T) {
+	tests := []struct {
+		method     string
+		wantStatus int
+		wantHeader http.Header
+		wantServed int
+	}{
+		{method: "OPTIONS", wantStatus: http.StatusNoContent, wantHeader: http.Header{"Allow": {"GET, HEAD"}, "Access-Control-Allow-Origin": {"*"}}},
+		{method: "POST", wantStatus: http.StatusMethodNotAllowed, wantHeader: http.Header{"Allow": {"GET, HEAD"}}},
+		{method: "PUT", wantStatus: http.StatusMethodNotAllowed, wantHeader: http.Header{"Allow": {"GET, HEAD"}}},
+		{method: "DELETE", wantStatus: http.StatusMethodNotAllowed, wantHeader: http.Header{"Allow": {"GET, HEAD"}}},
+		{method: "HEAD", wantStatus: http.StatusOK, wantHeader: http.Header{"Content-Length": {strconv.Itoa(len(binary.Files["snp.efi"]))}}},
+		{method: "GET", wantStatus: http.StatusOK, wantHeader: http.Header{"Content-Length": {strconv.Itoa(len(binary.Files["snp.efi"]))}}, wantServed: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			served := 0
+			h := Handler{
+				Log:           logr.Discard(),
+				OptionsHeader: http.Header{"access-control-allow-origin": {"*"}},
+				OnServed:      func(netaddr.IP, net.HardwareAddr, string, int64) { served++ },
+			}
+			w := httptest.NewRecorder()
+			h.Handle(w, httptest.NewRequest(tt.method, "/snp.efi", nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %v, got: %v", tt.wantStatus, w.Code)
+			}
+			for k := range tt.wantHeader {
+				if diff := cmp.Diff(tt.wantHeader.Values(k), w.Header().Values(k)); diff != "" {
+					t.Errorf("%v: %v", k, diff)
+				}
+			}
+			if tt.method != "GET" && w.Body.Len() > 0 && tt.wantStatus != http.StatusMethodNotAllowed {
+				t.Errorf("expected no body, got %v bytes", w.Body.Len())
+			}
+			if served != tt.wantServed {
+				t.Errorf("expected OnServed to be called %v times, got: %v", tt.wantServed, served)
+			}
+		})
+	}
+}
+
+func TestHandleHeadStateless(t *testing.T) {
+	tr := &firstboot.Tracker{Name: "boot.ipxe", First: []byte("install"), Later: []byte("boot")}
+	h := Handler{Log: logr.Discard()}
+	h.Stateful, h.Peek = tr.Dynamic, tr.Peek
+	got := []string{}
+	for _, method := range []string{"HEAD", "GET", "HEAD", "GET"} {
+		w := httptest.NewRecorder()
+		h.Handle(w, httptest.NewRequest(method, "/boot.ipxe", nil))
+		got = append(got, method+" "+strconv.Itoa(w.Code)+" "+w.Header().Get("Content-Length")+" "+w.Body.String())
+	}
+	// the HEADs get the headers of the script the next GET is served, without recording a boot.
+	want := []string{"HEAD 200 7 ", "GET 200 7 install", "HEAD 200 4 ", "GET 200 4 boot"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,27 @@
+package ihttp
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-logr/logr"
+)
+
+// handlePath answers the requests of the paths that don't name a file, before they are resolved: the root path, /
+// or an empty path, is answered by Index, or with a 404 when Index is nil, and so is a path with a trailing slash,
+// which names a directory rather than a file. It reports whether req was answered.
+// Mounted on an http.ServeMux, like by ipxedust.Server, the paths with runs of slashes, like //ipxe.efi, never get
+// here: the mux redirects them to their clean form, with a 301.
+func (s Handler) handlePath(w http.ResponseWriter, req *http.Request, log logr.Logger) bool {
+	p := req.URL.Path
+	switch {
+	case (p == "" || p == "/") && s.Index != nil:
+		s.Index.ServeHTTP(w, req)
+		return true
+	case p == "", strings.HasSuffix(p, "/"):
+		log.Info("requested path is not a file", "path", p)
+		http.NotFound(w, req)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package ihttp
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/resolve"
+)
+
+func TestHandlePath(t *testing.T) {
+	index := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte("index")) })
+	tests := []struct {
+		name       string
+		path       string
+		index      http.Handler
+		tenants    bool
+		wantStatus int
+		want       []byte
+	}{
+		{name: "file", path: "/ipxe.efi", wantStatus: http.StatusOK, want: binary.Files["ipxe.efi"]},
+		{name: "root", path: "/", index: index, wantStatus: http.StatusOK, want: []byte("index")},
+		{name: "empty", path: "", index: index, wantStatus: http.StatusOK, want: []byte("index")},
+		{name: "root without index", path: "/", wantStatus: http.StatusNotFound},
+		{name: "empty without index", path: "", wantStatus: http.StatusNotFound},
+		{name: "no leading slash", path: "ipxe.efi", wantStatus: http.StatusOK, want: binary.Files["ipxe.efi"]},
+		{name: "trailing slash", path: "/ipxe.efi/", index: index, wantStatus: http.StatusNotFound},
+		{name: "trailing slashes", path: "/ipxe.efi//", wantStatus: http.StatusNotFound},
+		{name: "mac directory", path: "/0a:00:27:00:00:02/", wantStatus: http.StatusNotFound},
+		{name: "tenant", path: "/tenant-a/ipxe.efi", tenants: true, wantStatus: http.StatusOK, want: binary.Files["ipxe.efi"]},
+		{name: "tenant directory", path: "/tenant-a/", tenants: true, wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Handler{Log: logr.Discard(), Index: tt.index}
+			if tt.tenants {
+				h.Tenants = map[string]resolve.Resolver{"tenant-a": {}}
+			}
+			req := httptest.NewRequest("GET", "/", nil)
+			req.URL.Path = tt.path
+			w := httptest.NewRecorder()
+			h.Handle(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %v, got: %v: %v", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.want != nil && !bytes.Equal(w.Body.Bytes(), tt.want) {
+				t.Fatalf("unexpected content served: %.20q", w.Body.Bytes())
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package ihttp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-logr/logr"
+	"inet.af/netaddr"
+)
+
+// TemplateData is the data a template registered in Handler.Templates is rendered with.
+type TemplateData struct {
+	// Client is the IP address of the client, for example "192.168.2.10".
+	Client string
+	// MAC is the optional MAC address from the requested path, empty when there is none.
+	MAC string
+	// Path is the requested URL path, after the path prefix of the server is stripped.
+	Path string
+	// Query holds the query parameters of the request, for example {{ .Query.Get "arch" }}.
+	Query url.Values
+	// Node is the identity of the node, from the recognized query parameters of the request, see NodeParams.
+	Node NodeIdentity
+}
+
+// The query parameters of a template request recognized as the identity of the node, see NodeIdentity.
+// iPXE scripts pass them with settings, for example "boot.ipxe?mac=${net0/mac}&serial=${serial}&asset=${asset}".
+const (
+	ParamMAC      = "mac"
+	ParamSerial   = "serial"
+	ParamAssetTag = "asset"
+)
+
+// NodeParams are the recognized query parameters, see NodeIdentity.
+var NodeParams = []string{ParamMAC, ParamSerial, ParamAssetTag}
+
+// MaxNodeParamLength is the maximum length of the serial number and asset tag query parameters.
+const MaxNodeParamLength = 128
+
+var (
+	// ErrMissingParam is returned when a query parameter required by a template is missing or empty.
+	ErrMissingParam = errors.New("missing required query parameter")
+	// ErrInvalidParam is returned when a recognized query parameter is malformed.
+	ErrInvalidParam = errors.New("invalid query parameter")
+)
+
+// NodeIdentity is the identity of a node passed in the query parameters of a template request.
+// The fields are empty when their parameter isn't passed.
+type NodeIdentity struct {
+	// MAC is the MAC address from ParamMAC, in any format net.ParseMAC accepts, like "0a:00:27:00:00:02"
+	// or "0a-00-27-00-00-02". {{ .Node.MAC }} renders it with colons.
+	MAC net.HardwareAddr
+	// Serial is the serial number from ParamSerial, like the SMBIOS serial number of iPXE's ${serial}.
+	Serial string
+	// AssetTag is the asset tag from ParamAssetTag, like the SMBIOS asset tag of iPXE's ${asset}.
+	AssetTag string
+}
+
+// ParseNodeIdentity returns the identity of a node from the query parameters q. It fails with ErrMissingParam when
+// a parameter of required is missing or empty, and with ErrInvalidParam when a recognized parameter is malformed:
+// a MAC address that doesn't parse, or a serial number or an asset tag that is longer than MaxNodeParamLength or
+// holds control characters. Control characters, like a new line, are rejected so that a value rendered in a script
+// can't add commands to it.
+func ParseNodeIdentity(q url.Values, required []string) (NodeIdentity, error) {
+	for _, name := range required {
+		if q.Get(name) == "" {
+			return NodeIdentity{}, fmt.Errorf("%w: %v", ErrMissingParam, name)
+		}
+	}
+	var n NodeIdentity
+	if v := q.Get(ParamMAC); v != "" {
+		mac, err := net.ParseMAC(v)
+		if err != nil {
+			return NodeIdentity{}, fmt.Errorf("%w: %v: %q is not a MAC address", ErrInvalidParam, ParamMAC, v)
+		}
+		n.MAC = mac
+	}
+	for _, p := range []struct {
+		name  string
+		value *string
+	}{{ParamSerial, &n.Serial}, {ParamAssetTag, &n.AssetTag}} {
+		v := q.Get(p.name)
+		if err := checkNodeParam(v); err != nil {
+			return NodeIdentity{}, fmt.Errorf("%w: %v: %v", ErrInvalidParam, p.name, err)
+		}
+		*p.value = v
+	}
+	return n, nil
+}
+
+// checkNodeParam checks the value of a free form recognized query parameter, see ParseNodeIdentity.
+func checkNodeParam(v string) error {
+	if len(v) > MaxNodeParamLength {
+		return fmt.Errorf("longer than %v bytes", MaxNodeParamLength)
+	}
+	if !utf8.ValidString(v) {
+		return errors.New("not valid UTF-8")
+	}
+	for _, r := range v {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("control character %q", r)
+		}
+	}
+	return nil
+}
+
+// CheckNodeParams returns an error when names, the query parameters required by a template, are not NodeParams.
+func CheckNodeParams(names []string) error {
+	for _, name := range names {
+		known := false
+		for _, p := range NodeParams {
+			known = known || name == p
+		}
+		if !known {
+			return fmt.Errorf("%w: %q is not one of %v", ErrInvalidParam, name, NodeParams)
+		}
+	}
+	return nil
+}
+
+// handleTemplate renders the template registered for filename and serves the result as text/plain.
+// A request with a recognized query parameter that is malformed, or without one required by TemplateParams,
+// gets a 400, see ParseNodeIdentity.
+func (s Handler) handleTemplate(w http.ResponseWriter, req *http.Request, log logr.Logger, ip netaddr.IP, mac net.HardwareAddr, filename string) {
+	query := req.URL.Query()
+	node, err := ParseNodeIdentity(query, s.TemplateParams[filename])
+	if err != nil {
+		log.Info("invalid template request", "error", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data := TemplateData{Client: ip.String(), MAC: mac.String(), Path: req.URL.Path, Query: query, Node: node}
+	var buf bytes.Buffer
+	if err := s.Templates[filename].Execute(&buf, data); err != nil {
+		log.Error(transferError(req, err), "rendering template failed")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	b, err := w.Write(buf.Bytes())
+	if clientGone(err) {
+		log.V(1).Info("client disconnected during transfer", "error", err.Error(), "bytesSent", b)
+		return
+	}
+	if err != nil {
+		log.Error(transferError(req, err), "error serving template")
+		return
+	}
+	log.V(s.ServedVerbosity).Info("template served", "bytesSent", b)
+	s.onServed(req, ip, mac, filename, int64(b))
+}
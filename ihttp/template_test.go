@@ -0,0 +1,144 @@
+package ihttp
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/binary"
+	"inet.af/netaddr"
+)
+
+func TestHandleTemplate(t *testing.T) {
+	script := template.Must(template.New("boot.ipxe").Option("missingkey=error").Parse(
+		"#!ipxe\nchain http://boot.example.com/{{ .Client }}/{{ .Query.Get \"arch\" }}.ipxe?mac={{ .MAC }}&path={{ .Path }}\n"))
+	broken := template.Must(template.New("broken.ipxe").Option("missingkey=error").Parse(`{{ index .Query.arch 5 }}`))
+	node := template.Must(template.New("node.ipxe").Option("missingkey=error").Parse(
+		"#!ipxe\nchain http://boot.example.com/{{ .Node.MAC }}/{{ .Node.Serial }}/{{ .Node.AssetTag }}\n"))
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		want       string
+	}{
+		{
+			name:       "rendered",
+			path:       "/boot.ipxe?arch=x86_64",
+			wantStatus: http.StatusOK,
+			want:       "#!ipxe\nchain http://boot.example.com/192.0.2.1/x86_64.ipxe?mac=&path=/boot.ipxe\n",
+		},
+		{
+			name:       "rendered with mac",
+			path:       "/0a:00:27:00:00:02/boot.ipxe",
+			wantStatus: http.StatusOK,
+			want:       "#!ipxe\nchain http://boot.example.com/192.0.2.1/.ipxe?mac=0a:00:27:00:00:02&path=/0a:00:27:00:00:02/boot.ipxe\n",
+		},
+		{name: "render failure", path: "/broken.ipxe?arch=x86_64", wantStatus: http.StatusInternalServerError, want: "Internal Server Error\n"},
+		{name: "not a template", path: "/snp.efi", wantStatus: http.StatusOK, want: string(binary.Files["snp.efi"])},
+		{
+			name:       "node identity",
+			path:       "/node.ipxe?mac=0a-00-27-00-00-02&serial=ABC123&asset=rack+4",
+			wantStatus: http.StatusOK,
+			want:       "#!ipxe\nchain http://boot.example.com/0a:00:27:00:00:02/ABC123/rack 4\n",
+		},
+		{
+			name:       "missing required parameter",
+			path:       "/node.ipxe?serial=ABC123",
+			wantStatus: http.StatusBadRequest,
+			want:       "missing required query parameter: mac\n",
+		},
+		{
+			name:       "malformed mac",
+			path:       "/node.ipxe?mac=0a:00:27",
+			wantStatus: http.StatusBadRequest,
+			want:       "invalid query parameter: mac: \"0a:00:27\" is not a MAC address\n",
+		},
+		{
+			name:       "malformed parameter not required",
+			path:       "/boot.ipxe?arch=x86_64&mac=nope",
+			wantStatus: http.StatusBadRequest,
+			want:       "invalid query parameter: mac: \"nope\" is not a MAC address\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var served string
+			h := Handler{
+				Log:            logr.Discard(),
+				Templates:      map[string]*template.Template{"boot.ipxe": script, "broken.ipxe": broken, "node.ipxe": node},
+				TemplateParams: map[string][]string{"node.ipxe": {ParamMAC}},
+				OnServed: func(_ netaddr.IP, _ net.HardwareAddr, filename string, _ int64) {
+					served = filename
+				},
+			}
+			w := httptest.NewRecorder()
+			h.Handle(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %v, got: %v", tt.wantStatus, w.Code)
+			}
+			if !bytes.Equal(w.Body.Bytes(), []byte(tt.want)) {
+				t.Fatal(cmp.Diff(w.Body.String(), tt.want))
+			}
+			if tt.wantStatus == http.StatusOK && served == "" {
+				t.Fatal("expected OnServed to be called")
+			}
+			if tt.name == "rendered" {
+				if got := w.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+					t.Fatalf("unexpected content type: %v", got)
+				}
+			}
+		})
+	}
+}
+
+func TestParseNodeIdentity(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    url.Values
+		required []string
+		want     NodeIdentity
+		wantErr  error
+	}{
+		{name: "none", query: url.Values{"arch": {"x86_64"}}},
+		{
+			name:     "all",
+			query:    url.Values{"mac": {"0a:00:27:00:00:02"}, "serial": {"ABC123"}, "asset": {"rack 4, slot 2"}},
+			required: []string{ParamMAC, ParamSerial},
+			want:     NodeIdentity{MAC: net.HardwareAddr{0x0a, 0x00, 0x27, 0x00, 0x00, 0x02}, Serial: "ABC123", AssetTag: "rack 4, slot 2"},
+		},
+		{name: "missing required", query: url.Values{"serial": {"ABC123"}}, required: []string{ParamMAC}, wantErr: ErrMissingParam},
+		{name: "empty required", query: url.Values{"mac": {""}}, required: []string{ParamMAC}, wantErr: ErrMissingParam},
+		{name: "malformed mac", query: url.Values{"mac": {"0a:00:27:00:00"}}, wantErr: ErrInvalidParam},
+		{name: "serial with a new line", query: url.Values{"serial": {"ABC\nshell"}}, wantErr: ErrInvalidParam},
+		{name: "asset tag too long", query: url.Values{"asset": {strings.Repeat("a", MaxNodeParamLength+1)}}, wantErr: ErrInvalidParam},
+		{name: "invalid utf-8", query: url.Values{"asset": {"\xff"}}, wantErr: ErrInvalidParam},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseNodeIdentity(tt.query, tt.required)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error mismatch, got: %v, want: %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestCheckNodeParams(t *testing.T) {
+	if err := CheckNodeParams(NodeParams); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckNodeParams([]string{ParamMAC, "uuid"}); !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("expected %v, got: %v", ErrInvalidParam, err)
+	}
+}
@@ -0,0 +1,29 @@
+package ihttp
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// splitTenant splits an HTTP request path into its leading element, the tenant key, and the rest of the path.
+// ok is false when the path has no leading element.
+func splitTenant(p string) (tenant, rest string, ok bool) {
+	p = strings.TrimPrefix(p, "/")
+	i := strings.IndexByte(p, '/')
+	if i <= 0 {
+		return "", "", false
+	}
+	return p[:i], p[i:], true
+}
+
+// withPath returns a shallow copy of req for path p, like http.StripPrefix does.
+func withPath(req *http.Request, p string) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	r.URL = new(url.URL)
+	*r.URL = *req.URL
+	r.URL.Path = p
+	r.URL.RawPath = ""
+	return r
+}
@@ -0,0 +1,48 @@
+package ihttp
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/resolve"
+)
+
+func TestHandleTenants(t *testing.T) {
+	h := Handler{
+		Log: logr.Discard(),
+		Tenants: map[string]resolve.Resolver{
+			"tenant-a": {Files: map[string][]byte{"ipxe.efi": []byte("tenant-a")}},
+			"tenant-b": {Files: map[string][]byte{"ipxe.efi": []byte("tenant-b")}},
+		},
+	}
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		want       []byte
+	}{
+		{name: "tenant a", path: "/tenant-a/ipxe.efi", wantStatus: http.StatusOK, want: []byte("tenant-a")},
+		{name: "tenant b", path: "/tenant-b/ipxe.efi", wantStatus: http.StatusOK, want: []byte("tenant-b")},
+		{name: "tenant with mac", path: "/tenant-b/0a:00:27:00:00:02/ipxe.efi", wantStatus: http.StatusOK, want: []byte("tenant-b")},
+		{name: "embedded fallback", path: "/tenant-a/snp.efi", wantStatus: http.StatusOK, want: binary.SNP},
+		{name: "unknown tenant", path: "/tenant-c/ipxe.efi", wantStatus: http.StatusNotFound},
+		{name: "no tenant", path: "/ipxe.efi", wantStatus: http.StatusNotFound},
+		{name: "missing file", path: "/tenant-a/missing.efi", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.Handle(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status code %v, got: %v", tt.wantStatus, w.Code)
+			}
+			if tt.want != nil && !bytes.Equal(w.Body.Bytes(), tt.want) {
+				t.Fatalf("unexpected content served: %.20q", w.Body.Bytes())
+			}
+		})
+	}
+}
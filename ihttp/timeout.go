@@ -0,0 +1,76 @@
+package ihttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ErrIdleTimeout is returned when sending a response makes no progress for longer than Handler.Timeout.
+var ErrIdleTimeout = errors.New("transfer idle timeout exceeded")
+
+// connKey is the context key of the connection of a request, see ConnContext.
+type connKey struct{}
+
+// ConnContext returns ctx holding the connection c. It is the http.Server ConnContext function
+// that gives Handler.Timeout access to the connection of each request. Without it, Timeout has no effect.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// idleWriter writes to the response w and closes the connection conn when a write, or a flush, blocks
+// for longer than timeout, for example because the client stopped reading. The write then fails with ErrIdleTimeout.
+type idleWriter struct {
+	w        http.ResponseWriter
+	timeout  time.Duration
+	timer    *time.Timer
+	timedOut int32
+}
+
+// newIdleWriter returns w guarded by timeout, or w unchanged when timeout is not positive
+// or the connection of req isn't known. The returned stop function must be called when done writing.
+func newIdleWriter(w http.ResponseWriter, req *http.Request, timeout time.Duration) (io.Writer, func()) {
+	conn, ok := req.Context().Value(connKey{}).(net.Conn)
+	if timeout <= 0 || !ok {
+		return w, func() {}
+	}
+	iw := &idleWriter{w: w, timeout: timeout}
+	iw.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&iw.timedOut, 1)
+		conn.Close()
+	})
+	iw.timer.Stop()
+	return iw, func() { iw.timer.Stop() }
+}
+
+func (i *idleWriter) Write(p []byte) (int, error) {
+	i.timer.Reset(i.timeout)
+	n, err := i.w.Write(p)
+	i.timer.Stop()
+	return n, i.err(err)
+}
+
+// flush sends the buffered response, so that a client that stopped reading is detected before the handler returns.
+func (i *idleWriter) flush() error {
+	f, ok := i.w.(http.Flusher)
+	if !ok {
+		return nil
+	}
+	i.timer.Reset(i.timeout)
+	f.Flush()
+	i.timer.Stop()
+	return i.err(nil)
+}
+
+// err returns ErrIdleTimeout in place of err once the connection was closed for being idle.
+func (i *idleWriter) err(err error) error {
+	if atomic.LoadInt32(&i.timedOut) == 1 {
+		return fmt.Errorf("%w: no progress for %v", ErrIdleTimeout, i.timeout)
+	}
+	return err
+}
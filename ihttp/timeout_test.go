@@ -0,0 +1,82 @@
+package ihttp
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/tinkerbell/ipxedust/resolve"
+)
+
+func TestHandleTimeout(t *testing.T) {
+	tests := []time.Duration{100 * time.Millisecond, 400 * time.Millisecond}
+	for _, timeout := range tests {
+		t.Run(timeout.String(), func(t *testing.T) {
+			var mu sync.Mutex
+			var logged []string
+			done := make(chan time.Time, 1)
+			h := Handler{
+				Log: funcr.New(func(prefix, args string) {
+					mu.Lock()
+					logged = append(logged, args)
+					mu.Unlock()
+				}, funcr.Options{}),
+				// large enough not to fit in the socket buffers, so the handler is still writing when the client stalls.
+				Resolver: resolve.Resolver{Files: map[string][]byte{"big.efi": make([]byte, 64<<20)}},
+				Timeout:  timeout,
+			}
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				h.Handle(w, req)
+				done <- time.Now()
+			}))
+			srv.Config.ConnContext = ConnContext
+			srv.Start()
+			defer srv.Close()
+
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			sent := time.Now()
+			if _, err := fmt.Fprintf(conn, "GET /big.efi HTTP/1.1\r\nHost: ipxe\r\n\r\n"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadFull(conn, make([]byte, 1024)); err != nil {
+				t.Fatal(err)
+			}
+			// stop reading, without closing the connection.
+			var aborted time.Time
+			select {
+			case aborted = <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("timed out waiting for the transfer to be aborted")
+			}
+			if got := aborted.Sub(sent); got < timeout || got > timeout+5*time.Second {
+				t.Fatalf("expected the transfer to be aborted after %v, got: %v", timeout, got)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			var idle bool
+			for _, l := range logged {
+				if strings.Contains(l, "error serving file") {
+					t.Fatalf("expected no error logged, got: %v", l)
+				}
+				if strings.Contains(l, `"msg"="transfer truncated"`) && strings.Contains(l, `"reason"="client idle"`) {
+					idle = true
+				}
+			}
+			if !idle {
+				t.Fatalf("expected the idle client to be logged, got: %v", logged)
+			}
+		})
+	}
+}
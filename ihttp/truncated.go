@@ -0,0 +1,29 @@
+package ihttp
+
+import "errors"
+
+// Reasons a transfer is truncated, see Handler.OnTruncated.
+const (
+	// TruncatedClientGone is a client that closed the connection, for example a node that was reset while booting.
+	TruncatedClientGone = "client gone"
+	// TruncatedClientIdle is a client that stopped reading for longer than Handler.Timeout.
+	TruncatedClientIdle = "client idle"
+	// TruncatedShortContent is content that ended before its size, for example a file truncated while it was sent.
+	TruncatedShortContent = "short content"
+)
+
+// truncation returns why a transfer that ended with err after sending sent bytes of size is truncated, or an empty
+// string when it isn't: it sent the whole file, or it failed on the server side.
+func truncation(err error, sent, size int64) string {
+	switch {
+	case sent >= size:
+		return ""
+	case clientGone(err):
+		return TruncatedClientGone
+	case errors.Is(err, ErrIdleTimeout):
+		return TruncatedClientIdle
+	case err == nil:
+		return TruncatedShortContent
+	}
+	return ""
+}
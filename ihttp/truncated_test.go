@@ -0,0 +1,141 @@
+package ihttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/resolve"
+	"inet.af/netaddr"
+)
+
+// truncated is a call to Handler.OnTruncated.
+type truncated struct {
+	Filename   string
+	Sent, Size int64
+	Reason     string
+}
+
+func TestHandleTruncated(t *testing.T) {
+	got := make(chan truncated, 1)
+	h := Handler{
+		Log: logr.Discard(),
+		// large enough not to fit in the socket buffers, so the handler is still writing when the client goes away.
+		Resolver: resolve.Resolver{Files: map[string][]byte{"big.efi": make([]byte, 64<<20)}},
+		OnServed: func(netaddr.IP, net.HardwareAddr, string, int64) {
+			t.Error("expected a truncated transfer not to be served")
+		},
+		OnTruncated: func(_ netaddr.IP, filename string, sent, size int64, reason string) {
+			got <- truncated{Filename: filename, Sent: sent, Size: size, Reason: reason}
+		},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(h.Handle))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(conn, "GET /big.efi HTTP/1.1\r\nHost: ipxe\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	var tr truncated
+	select {
+	case tr = <-got:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the truncated transfer")
+	}
+	if tr.Filename != "big.efi" || tr.Size != 64<<20 || tr.Sent >= tr.Size || tr.Reason != TruncatedClientGone {
+		t.Fatalf("unexpected truncated transfer: %+v", tr)
+	}
+}
+
+// shortFS is a file system whose files are shorter than their size.
+type shortFS struct {
+	fstest.MapFS
+	size int64
+}
+
+func (s shortFS) Open(name string) (fs.File, error) {
+	f, err := s.MapFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return shortFile{File: f, size: s.size}, nil
+}
+
+type shortFile struct {
+	fs.File
+	size int64
+}
+
+func (s shortFile) Stat() (fs.FileInfo, error) {
+	info, err := s.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return shortInfo{FileInfo: info, size: s.size}, nil
+}
+
+type shortInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (s shortInfo) Size() int64 { return s.size }
+
+func TestTruncation(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		sent, size int64
+		want       string
+	}{
+		{name: "served", sent: 10, size: 10},
+		{name: "empty file", sent: 0, size: 0},
+		{name: "client gone", err: net.ErrClosed, sent: 4, size: 10, want: TruncatedClientGone},
+		{name: "zero bytes", err: net.ErrClosed, sent: 0, size: 10, want: TruncatedClientGone},
+		{name: "client idle", err: fmt.Errorf("%w: no progress for 1s", ErrIdleTimeout), sent: 4, size: 10, want: TruncatedClientIdle},
+		{name: "short content", sent: 4, size: 10, want: TruncatedShortContent},
+		{name: "server error", err: errors.New("read failed"), sent: 4, size: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncation(tt.err, tt.sent, tt.size); got != tt.want {
+				t.Fatalf("got: %q, want: %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleShortContent(t *testing.T) {
+	var got []truncated
+	h := Handler{
+		Log: logr.Discard(),
+		Resolver: resolve.Resolver{
+			FS:        []fs.FS{shortFS{MapFS: fstest.MapFS{"custom.efi": {Data: []byte("short")}}, size: 10}},
+			ReadAhead: 4096,
+		},
+		OnTruncated: func(_ netaddr.IP, filename string, sent, size int64, reason string) {
+			got = append(got, truncated{Filename: filename, Sent: sent, Size: size, Reason: reason})
+		},
+	}
+	h.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/custom.efi", nil))
+	want := []truncated{{Filename: "custom.efi", Sent: 5, Size: 10, Reason: TruncatedShortContent}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,55 @@
+package ihttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/tinkerbell/ipxedust/resolve"
+	"inet.af/netaddr"
+)
+
+func TestServedVerbosity(t *testing.T) {
+	dynamic := func(_ context.Context, _ netaddr.IPPort, name string) ([]byte, bool, error) {
+		if name == "broken.efi" {
+			return nil, false, errors.New("generation failed")
+		}
+		return nil, false, nil
+	}
+	tests := []struct {
+		name       string
+		verbosity  int
+		path       string
+		want       string
+		wantLogged bool
+	}{
+		{name: "served at info", path: "/ipxe.efi", want: `"level"=0 "msg"="file served"`, wantLogged: true},
+		{name: "served at verbosity", verbosity: 2, path: "/ipxe.efi", want: `"level"=2 "msg"="file served"`, wantLogged: true},
+		{name: "served suppressed", verbosity: 3, path: "/ipxe.efi", want: `"msg"="file served"`},
+		{name: "error", verbosity: 3, path: "/broken.efi", want: `"msg"="resolving requested file failed"`, wantLogged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged []string
+			h := Handler{
+				Log:             funcr.New(func(_, args string) { logged = append(logged, args) }, funcr.Options{Verbosity: 2}),
+				ServedVerbosity: tt.verbosity,
+				Resolver:        resolve.Resolver{Dynamic: dynamic},
+			}
+			h.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+			found := false
+			for _, l := range logged {
+				if strings.Contains(l, tt.want) {
+					found = true
+				}
+			}
+			if found != tt.wantLogged {
+				t.Fatalf("expected %v logged: %v, got: %v", tt.want, tt.wantLogged, logged)
+			}
+		})
+	}
+}
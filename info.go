@@ -0,0 +1,44 @@
+package ipxedust
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/tinkerbell/ipxedust/clock"
+)
+
+// modulePath is the module path of this package, used to find its version in the build info.
+const modulePath = "github.com/tinkerbell/ipxedust"
+
+// infoFile returns the content of the info file: the version, uptime and enabled protocols of the Server.
+func (c *Server) infoFile() []byte {
+	protocols := make([]string, 0, 2)
+	for _, p := range c.protocols() {
+		protocols = append(protocols, string(p))
+	}
+	var b strings.Builder
+	b.WriteString("ipxedust\n")
+	fmt.Fprintf(&b, "version: %v\n", version())
+	fmt.Fprintf(&b, "uptime: %v\n", clock.Or(c.Clock).Now().Sub(c.started).Round(time.Second))
+	fmt.Fprintf(&b, "protocols: %v\n", strings.Join(protocols, ", "))
+	return []byte(b.String())
+}
+
+// version returns the version of this module from the build info of the running binary.
+func version() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if bi.Main.Path == modulePath {
+		return bi.Main.Version
+	}
+	for _, d := range bi.Deps {
+		if d.Path == modulePath {
+			return d.Version
+		}
+	}
+	return "unknown"
+}
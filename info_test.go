@@ -0,0 +1,104 @@
+package ipxedust
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/clock"
+	"github.com/tinkerbell/ipxedust/resolve"
+)
+
+func TestInfoFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+	}{
+		{name: "enabled", enabled: true},
+		{name: "disabled", enabled: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready := make(chan struct{}, 2)
+			addrs := make(map[Protocol]net.Addr)
+			var mu sync.Mutex
+			s := &Server{
+				Log:            logr.Discard(),
+				EnableInfoFile: tt.enabled,
+				OnReady: func(p Protocol, addr net.Addr) {
+					mu.Lock()
+					addrs[p] = addr
+					mu.Unlock()
+					ready <- struct{}{}
+				},
+			}
+			conn, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			errChan := make(chan error, 1)
+			go func() { errChan <- s.Serve(ctx, conn, uconn) }()
+			defer func() {
+				cancel()
+				if err := <-errChan; err != nil {
+					t.Fatal(err)
+				}
+			}()
+			for i := 0; i < 2; i++ {
+				select {
+				case <-ready:
+				case <-time.After(5 * time.Second):
+					t.Fatal("timed out waiting for OnReady")
+				}
+			}
+			mu.Lock()
+			httpAddr, tftpAddr := addrs[ProtocolHTTP].String(), addrs[ProtocolTFTP].String()
+			mu.Unlock()
+
+			if !tt.enabled {
+				resp, err := http.Get(fmt.Sprintf("http://%v/%v", httpAddr, resolve.InfoFileName))
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusNotFound {
+					t.Fatalf("expected %v, got: %v", http.StatusNotFound, resp.StatusCode)
+				}
+				return
+			}
+			for name, got := range map[Protocol][]byte{
+				ProtocolHTTP: httpGet(t, httpAddr, resolve.InfoFileName),
+				ProtocolTFTP: tftpGet(t, tftpAddr, resolve.InfoFileName),
+			} {
+				for _, want := range []string{"ipxedust\n", "version: ", "uptime: ", "protocols: tftp, http\n"} {
+					if !strings.Contains(string(got), want) {
+						t.Errorf("%v: expected info file to contain %q, got: %q", name, want, got)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestInfoFileUptime(t *testing.T) {
+	fake := clock.NewFake(time.Date(2022, 1, 6, 0, 0, 0, 0, time.UTC))
+	s := &Server{Clock: fake}
+	if err := s.start(context.Background(), Server{Log: logr.Discard()}); err != nil {
+		t.Fatal(err)
+	}
+	fake.Advance(90 * time.Minute)
+	if got := string(s.infoFile()); !strings.Contains(got, "uptime: 1h30m0s\n") {
+		t.Fatalf("expected an uptime of 1h30m0s, got: %q", got)
+	}
+}
@@ -3,21 +3,63 @@ package ipxedust
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"net"
 	"net/http"
+	"os"
+	"path"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/imdario/mergo"
 	"github.com/pin/tftp"
+	"github.com/tinkerbell/ipxedust/allowlist"
+	"github.com/tinkerbell/ipxedust/bandwidth"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/canary"
+	"github.com/tinkerbell/ipxedust/capture"
+	"github.com/tinkerbell/ipxedust/clock"
+	"github.com/tinkerbell/ipxedust/fault"
+	"github.com/tinkerbell/ipxedust/filename"
+	"github.com/tinkerbell/ipxedust/firstboot"
 	"github.com/tinkerbell/ipxedust/ihttp"
 	"github.com/tinkerbell/ipxedust/itftp"
+	"github.com/tinkerbell/ipxedust/oci"
+	"github.com/tinkerbell/ipxedust/progress"
+	"github.com/tinkerbell/ipxedust/ratelimit"
+	"github.com/tinkerbell/ipxedust/recent"
+	"github.com/tinkerbell/ipxedust/resolve"
+	"github.com/tinkerbell/ipxedust/timing"
+	"github.com/tinkerbell/ipxedust/upstream"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 	"inet.af/netaddr"
 )
 
+// Protocol identifies one of the protocols a Server serves iPXE binaries over.
+type Protocol string
+
+const (
+	// ProtocolTFTP is the TFTP protocol.
+	ProtocolTFTP Protocol = "tftp"
+	// ProtocolHTTP is the HTTP protocol.
+	ProtocolHTTP Protocol = "http"
+)
+
+// DefaultRecentBootsPath is the HTTP path the recent boots are served at when Server.RecentBootsPath is not set.
+const DefaultRecentBootsPath = "/recent-boots"
+
 // Server holds the details for configuring the iPXE service.
 type Server struct {
 	// TFTP holds the details specific for the TFTP server.
@@ -26,6 +68,9 @@ type Server struct {
 	HTTP ServerSpec
 	// Log is the logger to use.
 	Log logr.Logger
+	// Name, when not empty, tells this Server apart from the others running in the same process, for example one
+	// per VLAN. It is added to every line logged by the Server and its protocol handlers, as LogKeyInstance.
+	Name string
 	// EnableTFTPSinglePort is a flag to enable single port mode for the TFTP server.
 	// A standard TFTP server implementation receives requests on port 69 and
 	// allocates a new high port (over 1024) dedicated to that request. In single
@@ -38,16 +83,411 @@ type Server struct {
 	// experimental and "Enabling this will negatively impact performance". Please take this into
 	// consideration when using this option.
 	EnableTFTPSinglePort bool
+	// OnReady is an optional callback invoked once for each enabled protocol,
+	// right after its listener is bound and serving has begun.
+	// addr is the address the listener is bound to.
+	// It is called from the goroutine serving the protocol, so it must be safe for concurrent use.
+	OnReady func(p Protocol, addr net.Addr)
+	// OnFirstServe, when not nil, is called once for each protocol, the first time it serves a file successfully after
+	// serving started, from the goroutine of the transfer. It is called once even when the first transfers complete
+	// concurrently.
+	OnFirstServe func(p Protocol)
+	// OnTFTPStartup, when not nil, is called with how long the default TFTP server, github.com/pin/tftp, took
+	// to be ready to shut down after serving started, for example to record it as a metric. It is called from
+	// the goroutine serving TFTP, before a shutdown is handled. The duration is also logged at debug level.
+	OnTFTPStartup func(d time.Duration)
+	// NoDefaults disables merging default values into the Server when calling ListenAndServe or Serve.
+	// By default, the following fields are set when they hold their zero value:
+	//
+	// TFTP.Addr: "0.0.0.0:69" (ListenAndServe only).
+	//
+	// HTTP.Addr: "0.0.0.0:8080" (ListenAndServe only).
+	//
+	// TFTP.Timeout and HTTP.Timeout: 5 seconds.
+	//
+	// Log: logr.Discard().
+	//
+	// When NoDefaults is true the Server is used exactly as configured, so zero values are respected.
+	// For example, a zero HTTP.Timeout means no read timeout. Log must be set in this mode.
+	NoDefaults bool
+	// RateLimit configures token bucket rate limiting of requests for both protocols.
+	// A single limiter is shared by TFTP and HTTP. The zero value means unlimited.
+	// HTTP requests over the limit are answered with a 429 and a Retry-After header,
+	// TFTP requests over the limit are answered with a TFTP error.
+	RateLimit ratelimit.Config
+	// Bandwidth throttles the rate files are sent at, per transfer and for all transfers of both protocols together,
+	// for example to keep a boot image download from saturating a constrained link. The zero value means unlimited.
+	Bandwidth bandwidth.Config
+
+	// EnablePprof enables the net/http/pprof profiling handlers under /debug/pprof/.
+	//
+	// Profiles expose internals of the running process (command line, memory contents of stack traces, etc.)
+	// and profiling requests can consume significant CPU, so only enable this for debugging and never
+	// on a listener reachable by untrusted clients. Prefer setting PprofAddr to a loopback or management address.
+	EnablePprof bool
+	// PprofAddr is the address:port to serve the pprof handlers on when EnablePprof is true.
+	// When not set, the pprof handlers are served by the HTTP server alongside the iPXE binaries.
+	PprofAddr netaddr.IPPort
+
+	// NewTFTPServer, when not nil, creates the TFTP server serving requests with the read and write handlers,
+	// in place of github.com/pin/tftp. The read handler type asserts rf to tftp.OutgoingTransfer for the client
+	// address and the transfer size option, so implementations should pass values implementing it.
+	// TFTP.Timeout and EnableTFTPSinglePort are applied to the returned TFTPServer.
+	NewTFTPServer func(read func(filename string, rf io.ReaderFrom) error, write func(filename string, wt io.WriterTo) error) TFTPServer
+
+	// EnableTFTPSelfTest downloads a small embedded binary from the TFTP server once it is bound, over loopback or the
+	// bound address, and logs the result, to catch networks where TFTP silently doesn't work. It is skipped when the
+	// server wouldn't serve the binary to the loopback client, for example when it is paused.
+	EnableTFTPSelfTest bool
+	// FailFastSelfTest makes ListenAndServe and Serve return an error when the TFTP self-test fails,
+	// instead of only logging the failure.
+	FailFastSelfTest bool
+
+	// TLSCertificates enables HTTPS for the HTTP server, using these certificates.
+	TLSCertificates []tls.Certificate
+	// ClientCAs is the pool of certificate authorities used to verify HTTPS client certificates.
+	ClientCAs *x509.CertPool
+	// ClientAuth is the HTTPS client certificate (mutual TLS) policy. It is only used when TLSCertificates is set.
+	// For example, tls.RequireAndVerifyClientCert along with ClientCAs only serves clients presenting a certificate
+	// signed by one of the ClientCAs. Failed client authentication is logged with the client address and reason.
+	ClientAuth tls.ClientAuthType
+	// AllowClientCert, when not nil, only serves the HTTP clients with a verified client certificate it allows.
+	AllowClientCert func(subject string) bool
+
+	// IPXETrustAnchors are root certificates, like a private CA, the iPXE binaries must trust to chainload over HTTPS.
+	// iPXE embeds them at build time, so serve binaries built with them, like with OCIReference. When serving starts, a
+	// warning is logged for each EFI binary served under an embedded name that doesn't trust all of them.
+	IPXETrustAnchors []*x509.Certificate
+
+	// EnableTFTPResume lets TFTP clients resume an interrupted transfer by requesting the file name followed by
+	// itftp.ResumeSeparator and the number of bytes received, like "ipxe.efi@65536". Firmware and iPXE don't do this.
+	EnableTFTPResume bool
+
+	// TFTPOptional keeps serving HTTP when the TFTP listener of ListenAndServe fails to bind, logging a warning, instead
+	// of failing. TFTP is then disabled, see Listener and EnableProtocol. It requires HTTP to be enabled.
+	TFTPOptional bool
+
+	// MaxTFTPTransfersPerClient caps the number of concurrent TFTP transfers from a single client IP.
+	// Transfers beyond the cap are rejected with a TFTP error. Zero means unlimited.
+	MaxTFTPTransfersPerClient int
+
+	// TFTPWorkers caps the number of TFTP transfers in progress, of all clients, bounding the sockets and memory held
+	// by a burst of requests. Every request still gets a goroutine of its own, the requests over the cap return as
+	// soon as they are rejected. Zero, the default, starts every transfer as it arrives.
+	TFTPWorkers int
+	// TFTPWorkerQueue is the number of TFTP transfers waiting for one of the TFTPWorkers when they are all busy.
+	// Transfers arriving when the queue is full are rejected with a TFTP error, so that the client retries later.
+	// It has no effect without TFTPWorkers.
+	TFTPWorkerQueue int
+
+	// TFTPMulticast, when not the zero value, is the multicast group address and port the files requested with the
+	// multicast option of RFC 2090, like iPXE does for tftm:// URLs, are sent to, once for all the clients downloading
+	// a file at the same time. It requires TFTPMulticastAddr. See itftp.Multicast.
+	TFTPMulticast netaddr.IPPort
+	// TFTPMulticastAddr is the address:port multicast requests are received at, like the port of tftm:// URLs, apart
+	// from TFTP.Addr, whose requests keep the *net.UDPConn github.com/pin/tftp needs for blocks over 512 bytes. It is
+	// bound by Serve too. Its requests served over unicast get blocks of at most 512 bytes.
+	TFTPMulticastAddr netaddr.IPPort
+	// TFTPMulticastSessions is the number of files served over multicast at once, each sent to its own port, from
+	// the port of TFTPMulticast. Requests of other files are served over unicast. Defaults to
+	// itftp.DefaultMulticastSessions.
+	TFTPMulticastSessions int
+
+	// CaptureTransfers records the negotiated options and the timeline of the TFTP transfers, when each block was read
+	// to be sent, to debug the interoperability issues of the firmware of a client, see capture.Record. It is off by
+	// default, and costs nothing then. It requires CaptureDir or OnCapture.
+	CaptureTransfers bool
+	// CaptureClients limits CaptureTransfers to the clients of these prefixes, for example the address of the node
+	// being debugged. Empty captures the transfers of all clients.
+	CaptureClients []netaddr.IPPrefix
+	// CaptureDir, when not empty, is the existing directory each captured transfer is written to, as a JSON file,
+	// see capture.ReadFile.
+	CaptureDir string
+	// OnCapture, when not nil, is called with each captured transfer, once it ended.
+	OnCapture func(capture.Record)
+
+	// Filenames limits the requested file names, over both protocols, to filename.DefaultMaxLength bytes of ASCII
+	// letters, digits and filename.DefaultPunctuation by default. Other requests are rejected, with an HTTP 400 or a
+	// TFTP error, before the file is looked up. HTTP requests are checked with their whole path, after HTTPPathPrefix.
+	Filenames filename.Policy
+
+	// Archives maps archive names to the paths of tar (.tar, .tar.gz, .tgz) or zip (.zip) files served over HTTP.
+	// See ihttp.Handler.Archives for the path syntax.
+	Archives map[string]string
+
+	// LogRequestServed logs the EventRequestServed lifecycle event for every file served.
+	// This is high volume so it is off by default.
+	LogRequestServed bool
+	// RequestLogVerbosity is the logr V-level the files served are logged at by both protocols, for example 1 to
+	// log them only when debugging a busy server, with a logger verbosity of at least 1. Zero logs them at info
+	// level. Rejected, truncated and failed requests are logged regardless.
+	RequestLogVerbosity int
+
+	// UpstreamURL enables fetching files that are not embedded from an upstream HTTP(S) server,
+	// for example "https://artifacts.example.com/ipxe/". The file name is appended to the URL.
+	// Fetched files are cached in memory for UpstreamTTL. If upstream fails, the file is not found.
+	UpstreamURL string
+	// UpstreamTTL is how long files fetched from UpstreamURL are cached. Defaults to upstream.DefaultTTL.
+	UpstreamTTL time.Duration
+	// UpstreamMaxSize is the size of the largest file fetched from UpstreamURL. Defaults to upstream.DefaultMaxSize.
+	UpstreamMaxSize int64
+
+	// ChainURL, when not empty, makes the embedded EFI binaries chainload this URL on boot, for example
+	// "http://192.168.2.1/boot.ipxe", instead of running autoboot. It can be at most 54 bytes long, see
+	// binary.ErrScriptTooLong. undionly.kpxe and the binaries of OCIReference are served unchanged.
+	ChainURL string
+
+	// CompressedBinaries serves gzip compressed variants of the embedded EFI binaries, named with
+	// itftp.CompressedSuffix, like "ipxe.efi.gz", for clients on slow links. A TFTP request of a compressed variant that
+	// doesn't exist is served the uncompressed file.
+	CompressedBinaries bool
+
+	// UseDefaultAliases serves the embedded binaries, over both protocols, under the names client firmware commonly
+	// requests, listed in binary.DefaultAliases. For example a request of bootx64.efi is served ipxe.efi.
+	UseDefaultAliases bool
+	// Aliases maps requested file names to the names they are served as, for example "custom.efi" to "ipxe.efi".
+	// They take precedence over binary.DefaultAliases.
+	Aliases map[string]string
+
+	// FileSystems are consulted in order for the files requested over both protocols, by base name, for example a local
+	// override directory and then a shared volume. The embedded binaries are consulted last, unless listed as binary.FS.
+	FileSystems []fs.FS
+	// FileSystemReadAhead, when positive, streams the files of FileSystems and Tenants, reading them ahead of the
+	// transfer in buffers of this many bytes, instead of whole before it starts. Files held in memory are not affected.
+	FileSystemReadAhead int
+
+	// EmbeddedModTime is the Last-Modified time of the embedded iPXE binaries over HTTP, so that clients can revalidate
+	// them with If-Modified-Since. Defaults to binary.BuildTime. Without either, no Last-Modified header is sent.
+	EmbeddedModTime time.Time
+
+	// MissingFileScript, when not nil, is served, over both protocols, in place of the iPXE scripts (named "*.ipxe")
+	// that are not found, for example a script that prints a message and retries. Other files are still not found.
+	MissingFileScript []byte
+
+	// DefaultEFIBinary, when not empty, is the name of the binary served, over both protocols, in place of the EFI
+	// binaries (named "*.efi") that are not found, for example "ipxe.efi". It must be the name of an EFI binary.
+	DefaultEFIBinary string
+
+	// Tenants, when not nil, serves a separate set of files over HTTP to each tenant, keyed by the leading element of
+	// the request path, like /tenant-a/ipxe.efi, consulted like FileSystems. Unknown tenants get a 404. ChainURL,
+	// CompressedBinaries, OCIReference and FileSystems don't apply to tenants.
+	Tenants map[string][]fs.FS
+
+	// CanaryRule serves canary binaries over HTTP to a share of the clients, the same variant on every request, to
+	// roll out a new iPXE build gradually. The zero value serves no canary. See canary.Rule.
+	CanaryRule canary.Rule
+
+	// OCIReference, when not empty, is an OCI artifact, like "ghcr.io/example/ipxe:v1.0.0", holding iPXE binaries.
+	// The artifact is pulled when serving starts and its files are served in place of the embedded binaries
+	// of the same name, over both protocols. Serving fails to start when the pull fails. See the oci package.
+	OCIReference string
+	// OCIDockerConfig is the path of a Docker config file, like ~/.docker/config.json, with the registry credentials.
+	// Without it, the artifact is pulled anonymously.
+	OCIDockerConfig string
+	// OCICacheDir, when not empty, caches the pulled blobs on disk so they are only downloaded once.
+	OCICacheDir string
+	// OCIPlainHTTP pulls over HTTP instead of HTTPS, for local registries.
+	OCIPlainHTTP bool
+
+	// TrackRecentBoots records the files recently served to each client, over both protocols,
+	// and serves them as JSON over HTTP at RecentBootsPath, to the clients allowed to download files.
+	TrackRecentBoots bool
+	// RecentBootsPath is the HTTP path the recent boots are served at. Defaults to DefaultRecentBootsPath.
+	RecentBootsPath string
+	// RecentBootsSize is the number of recent boots kept. Defaults to recent.DefaultSize.
+	RecentBootsSize int
+
+	// HTTPPathPrefix is the URL path prefix iPXE binaries are served under by the HTTP server, for example "/ipxe/".
+	// File names, and optional MAC addresses, are taken from the path after the prefix.
+	// Requests outside the prefix get a 404. Defaults to "/".
+	HTTPPathPrefix string
+	// HTTPIndex, when not nil, answers the HTTP requests of the root path, HTTPPathPrefix itself, for example with a
+	// page listing the files or a redirect to a boot script. A nil HTTPIndex answers them with a 404.
+	// See ihttp.Handler.Index for how the other paths are normalized.
+	HTTPIndex http.Handler
+
+	// DynamicBinary, when not nil, is called for every file requested, over both protocols, before any other lookup.
+	// When it returns true its content is served, uncached unless DynamicBinaryCache is set, and when it returns false
+	// the file is looked up as usual. An error fails the request.
+	DynamicBinary func(ctx context.Context, client netaddr.IPPort, name string) ([]byte, bool, error)
+	// DynamicBinaryCache bounds an in memory cache of the binaries returned by DynamicBinary, shared by both protocols,
+	// keyed by client IP, client ID and file name. The zero value disables it. See PurgeDynamicBinaryCache.
+	DynamicBinaryCache resolve.CacheConfig
+
+	// FirstBoot, when not nil, serves its First script the first time a client requests its Name, over both
+	// protocols, and its Later script afterwards. It is consulted before DynamicBinary and never cached.
+	FirstBoot *firstboot.Tracker
+
+	// ClientIDHeader, when not empty, is the HTTP request header, like "X-Machine-ID", holding a client identifier
+	// set by a cooperating DHCP server or proxy. It is passed to DynamicBinary and FirstBoot, see resolve.ClientID.
+	ClientIDHeader string
+	// ClientIDOption, when not empty, is the TFTP read request option, like "machine-id", holding a client identifier
+	// set by a cooperating DHCP server or proxy, the TFTP counterpart of ClientIDHeader, see itftp.ClientIDs.
+	// github.com/pin/tftp doesn't pass the options to its handler, so they are read from the request conn, which
+	// costs the TFTP transfers their block sizes over 512 bytes, like TFTP.DisabledOptions does.
+	ClientIDOption string
+
+	// RedirectResolver, when not nil, is called for every HTTP request of a file with the requested file name.
+	// When it returns true, the client is redirected with a 302 to the returned URL, instead of being served the file.
+	// iPXE follows redirects. This allows, for example, sending clients to a signed CDN URL.
+	RedirectResolver func(name string, r *http.Request) (string, bool)
+
+	// Authorize, when not nil, is called for every HTTP request of a file. When it returns an error the client gets a
+	// 401, if the error wraps ihttp.ErrUnauthorized, or else a 403. TFTP requests are not authorized.
+	Authorize func(r *http.Request) error
+
+	// HTTPOptionsHeader holds headers added to the responses to HTTP OPTIONS requests, for example the
+	// Access-Control-Allow headers of CORS. OPTIONS requests are answered with a 204 and the other methods, but GET
+	// and HEAD, with a 405, both with an Allow header of ihttp.AllowedMethods.
+	HTTPOptionsHeader http.Header
+
+	// AllowInlineScript embeds the base64 encoded iPXE script of the "script" query parameter of HTTP requests in
+	// the binary served, see binary.Script. Anyone who can reach the HTTP server can then run their script, only enable
+	// it on a trusted network. When ChainURL is set, the requests are answered with a 400.
+	AllowInlineScript bool
+
+	// Templates maps file names to templates, rendered with ihttp.TemplateData for every HTTP request of the file and
+	// served as text/plain. Use WithTemplate to register one. A template that fails to render is answered with a 500.
+	Templates map[string]*template.Template
+	// TemplateParams maps the file names of Templates to the ihttp.NodeParams their requests must have, like "mac".
+	// A request missing one, or where one is malformed, is answered with a 400, see ihttp.ParseNodeIdentity.
+	TemplateParams map[string][]string
+
+	// UEFIHTTPBoot maps requested file names to the files served in their place over HTTP to the UEFI HTTP Boot
+	// clients of firmware, see ihttp.IsUEFIHTTPBoot, for example {"boot.ipxe": "ipxe.efi"} to boot from a single URL.
+	UEFIHTTPBoot map[string]string
+
+	// EnableInfoFile serves a virtual text file, named resolve.InfoFileName ("ipxedust.txt"), over both protocols.
+	// It holds the version, uptime and enabled protocols, to confirm which ipxedust instance a client is talking to.
+	EnableInfoFile bool
+
+	// EnableChecksumFiles serves the SHA-256 of each file served, over both protocols, under its name with
+	// resolve.ChecksumExtension appended, like "ipxe.efi.sha256", in the format of sha256sum.
+	EnableChecksumFiles bool
+
+	// OnProgress, when not nil, is called periodically while a file is sent over protocol p, with the bytes
+	// sent so far and the total size. It is useful to track long downloads, like large EFI binaries over TFTP.
+	// Calls for a single transfer are made in order, calls for concurrent transfers are made concurrently.
+	OnProgress func(p Protocol, client netaddr.IP, filename string, sent, total int64)
+	// Progress throttles the calls to OnProgress. See progress.Throttle for the defaults.
+	Progress progress.Throttle
+	// OnTimings, when not nil, is called after a file is served over protocol p with the time spent in each phase of
+	// the request: waiting to be admitted, resolving the file and transferring it, for example to record them as
+	// metrics. The phases are logged with the outcome of every transfer regardless. See timing.Phases.
+	OnTimings func(p Protocol, client netaddr.IP, filename string, phases timing.Phases)
+	// OnTruncated, when not nil, is called after a transfer over protocol p ended with fewer bytes sent than the size
+	// of the file, with the reason, one of the ihttp or itftp Truncated constants.
+	OnTruncated func(p Protocol, client netaddr.IP, filename string, sent, size int64, reason string)
+
+	// ReadyPath, when not empty, is the HTTP path of a readiness endpoint, for example "/ready".
+	// It responds 200 while serving and 503 in maintenance mode. See SetMaintenance.
+	ReadyPath string
+	// ManifestPath, when not empty, is the HTTP path the files served are listed at as JSON, for example "/manifest",
+	// with their size, ETag, source and aliases. See resolve.Resolver.Manifest. The list may disclose file names
+	// that are otherwise only known to the clients booting them.
+	ManifestPath string
+
+	// RecoverableErr, when not nil, reports whether an error accepting on the listener, or reading from the conn,
+	// passed to Serve is transient. A transient error is logged and retried, after a wait of up to a second.
+	// A *net.UDPConn is not affected, nor are the listener and conn of ListenAndServe.
+	RecoverableErr func(error) bool
+
+	// Clock tells the time to the time dependent features, like timestamps, rate limits, deadlines and caches.
+	// Defaults to the real clock. Tests set a clock.Fake to control the time without sleeping.
+	// Socket deadlines and timeouts always use the real clock.
+	Clock clock.Clock
+
+	// Hook injects faults into the requests of both protocols, with the "faultinject" build tag only.
+	// It is a testing aid, see the fault package.
+	fault.Hook
+	// ArtificialLatency, when positive, delays every request of both protocols by this much before it is served, to
+	// test the timeouts and retries of the clients against a slow server. It is a testing and chaos aid, never set it
+	// in production. Zero, the default, adds no delay. It is waited for on Clock.
+	ArtificialLatency time.Duration
+
+	// StartPaused binds the listeners but doesn't answer requests until Resume is called, for a warm standby
+	// that takes over without rebinding. It applies when serving starts for the first time. See Pause.
+	StartPaused bool
+
+	// maintenance is 1 in maintenance mode. It is accessed atomically.
+	maintenance uint32
+	// paused is 1 while paused. It is accessed atomically.
+	paused uint32
+	// allowed is the allowlist of clients, see SetAllowedClients. It is safe for concurrent use.
+	allowed allowlist.Set
+	// started is when serving started.
+	started time.Time
+	// limiter enforces RateLimit. It is created when serving starts.
+	limiter *ratelimit.Limiter
+	// bandwidth enforces Bandwidth. It is created when serving starts.
+	bandwidth *bandwidth.Limiter
+	// upstream fetches and caches files from UpstreamURL. It is created when serving starts.
+	upstream *upstream.Cache
+	// aliases are the Aliases and, when UseDefaultAliases is set, binary.DefaultAliases. They are merged when serving starts.
+	aliases map[string]string
+	// files replace the embedded binaries: the binaries chainloading ChainURL and the files pulled from OCIReference,
+	// along with the compressed variants of CompressedBinaries.
+	// They are created when serving starts.
+	files map[string][]byte
+	// recentBoots records recent boots when TrackRecentBoots is set. It is created when serving starts.
+	recentBoots *recent.Boots
+	// served counts the files served, see ServedCounts. It is created when serving starts.
+	served servedCounts
+	// firstServe guards the OnFirstServe call of each protocol. It is created when serving starts.
+	firstServe map[Protocol]*sync.Once
+	// dynamicCache caches the binaries returned by DynamicBinary. It is created when serving starts.
+	dynamicCache *resolve.Cache
+	// checksums caches the checksums of the files served when EnableChecksumFiles is set. It is created when serving starts.
+	checksums *resolve.Checksums
+	// running holds the *protocolRunners serving the protocols, see DisableProtocol. It is set when serving starts.
+	running atomic.Value
 }
 
 // ServerSpec holds details used to configure a server.
 type ServerSpec struct {
 	// Addr is the address:port to listen on for requests.
 	Addr netaddr.IPPort
-	// Timeout is the timeout for serving individual requests.
+	// Timeout is how long a transfer may be idle, not its whole duration, see MaxTransferDuration: for TFTP, the per
+	// packet timeout, see itftp.Handler.Timeout, and for HTTP, the time to read the request and to make progress sending
+	// the response. Zero means no limit for HTTP and the default of github.com/pin/tftp for TFTP.
 	Timeout time.Duration
 	// Disabled allows a server to be disabled. Useful, for example, to disable TFTP.
 	Disabled bool
+	// MaxTransferDuration is a hard limit on the duration of a single transfer, regardless of activity. Zero means
+	// no limit. TFTP checks it before each block is sent, so a transfer may be aborted up to one Timeout late.
+	MaxTransferDuration time.Duration
+	// ReadBufferSize is the size, in bytes, of the socket receive buffer of the TFTP server, to not drop requests
+	// when many clients boot at once. Zero keeps the OS default. With Serve, it is set when the conn supports it.
+	ReadBufferSize int
+	// UnixSocket, when not empty, is the path of a Unix domain socket ListenAndServe listens on instead of Addr,
+	// replaced when stale and removed on shutdown. HTTP only. Client IPs aren't known, so per client rate limits apply
+	// to all clients together.
+	UnixSocket string
+	// IdleTimeout is how long an idle keep-alive connection is kept open waiting for the next request. HTTP only.
+	// Zero uses Timeout, like net/http does. Lower it when clients or proxies hold many idle connections open.
+	IdleTimeout time.Duration
+	// KeepAlive is the period of the TCP keep-alive probes of the connections, which detect dead peers,
+	// like a node that was powered off. HTTP only. Zero keeps the Go default, 15 seconds, a negative value disables them.
+	KeepAlive time.Duration
+	// ListenBacklog, when positive, is the length of the queue of the connections the HTTP server didn't accept yet,
+	// to raise when many machines boot at once. HTTP only, Linux only, capped to net.core.somaxconn. With Serve, it is
+	// applied when the listener exposes its socket, a warning is logged when it can't be.
+	ListenBacklog int
+	// ReusePort sets SO_REUSEPORT on the HTTP listener bound by ListenAndServe, so that more than one process, like
+	// the old and new process of a restart, can listen on Addr. HTTP only, Linux only. It is ignored with Serve.
+	ReusePort bool
+	// DataPorts, when not the zero value, is the range of ports the TFTP transfers are bound to, instead of ephemeral
+	// ports, so a firewall only needs to open this range. TFTP only. github.com/pin/tftp doesn't support it, see
+	// TFTPPortRangeSetter: serving fails with ErrPortRangeUnsupported, unless in single port mode, where it is ignored.
+	DataPorts PortRange
+	// DisabledOptions are the TFTP options that are not acknowledged even when a client requests them, to work around
+	// misbehaving firmware. TFTP only. Disabling blksize reads the requests through itftp.OptionsConn, and multicast
+	// only applies to the requests of Server.TFTPMulticastAddr.
+	DisabledOptions TFTPOptions
+	// Log, when set, is the logger of the requests of this protocol, and of the messages about its listener, in place
+	// of Server.Log, for example to send the TFTP and HTTP logs to different files. The lifecycle events, like
+	// starting and stopped, are logged with Server.Log regardless.
+	Log logr.Logger
 }
 
 // ListenAndServe will listen and serve iPXE binaries over TFTP and HTTP.
@@ -60,121 +500,243 @@ type ServerSpec struct {
 //
 // Override the defaults by setting the Config struct fields.
 // See binary/binary.go for the iPXE files that are served.
+//
+// When more than one protocol fails, the returned error wraps the errors of all of them.
+//
+// When ctx is already done, it returns nil immediately, without binding any listener.
 func (c *Server) ListenAndServe(ctx context.Context) error {
-	defaults := Server{
-		TFTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 69), Timeout: 5 * time.Second},
-		HTTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 8080), Timeout: 5 * time.Second},
-		Log:  logr.Discard(),
+	if ctx.Err() != nil {
+		return nil
 	}
-
-	err := mergo.Merge(c, defaults, mergo.WithTransformers(c))
+	err := c.start(ctx, listenAndServeDefaults())
 	if err != nil {
 		return err
 	}
 
+	// errs keeps the errors of all the protocols, g.Wait only returns the first one.
+	var errs errorList
 	g, ctx := errgroup.WithContext(ctx)
+	r := c.runProtocols(ctx, g, &errs)
+	if c.TFTP.Addr.IsValid() {
+		r.setAddr(ProtocolTFTP, c.TFTP.Addr.UDPAddr())
+	}
+	switch {
+	case c.HTTP.UnixSocket != "":
+		r.setAddr(ProtocolHTTP, &net.UnixAddr{Name: c.HTTP.UnixSocket, Net: "unix"})
+	case c.HTTP.Addr.IsValid():
+		r.setAddr(ProtocolHTTP, c.HTTP.Addr.TCPAddr())
+	}
 	if !c.TFTP.Disabled {
-		g.Go(func() error {
-			return c.listenAndServeTFTP(ctx)
+		r.start(ProtocolTFTP, func() (serveFunc, net.Addr, error) {
+			conn, err := c.bindTFTP()
+			if err != nil {
+				return nil, nil, c.optionalTFTP(err)
+			}
+			return c.tftpServe(conn), conn.LocalAddr(), nil
 		})
 	}
 	if !c.HTTP.Disabled {
+		r.start(ProtocolHTTP, func() (serveFunc, net.Addr, error) {
+			l, err := c.bindHTTP()
+			if err != nil {
+				return nil, nil, err
+			}
+			return c.httpServe(l), l.Addr(), nil
+		})
+	}
+	if c.EnablePprof && !c.PprofAddr.IsZero() {
 		g.Go(func() error {
-			return c.listenAndServeHTTP(ctx)
+			return errs.add(c.listenAndServePprof(ctx))
 		})
 	}
 
 	<-ctx.Done()
-	err = g.Wait()
-	c.Log.Info("shutting down")
+	c.logEvent(EventDraining)
+	r.close()
+	_ = g.Wait()
+	err = errs.err()
+	c.logStopped(err)
 
 	return err
 }
 
 // Serve iPXE binaries over TFTP using udpConn and HTTP using tcpConn.
+// The conn of a disabled protocol may be nil.
+// When more than one protocol fails, the returned error wraps the errors of all of them.
+//
+// When ctx is already done, it returns nil immediately, without serving or closing tcpConn and udpConn.
 func (c *Server) Serve(ctx context.Context, tcpConn net.Listener, udpConn net.PacketConn) error {
-	if tcpConn == nil {
+	if tcpConn == nil && !c.HTTP.Disabled {
 		return errors.New("tcp listener must not be nil")
 	}
-	if udpConn == nil {
+	if udpConn == nil && !c.TFTP.Disabled {
 		return errors.New("udp conn must not be nil")
 	}
+	if ctx.Err() != nil {
+		return nil
+	}
 	defaults := Server{
 		TFTP: ServerSpec{Timeout: 5 * time.Second},
 		HTTP: ServerSpec{Timeout: 5 * time.Second},
 		Log:  logr.Discard(),
 	}
 
-	err := mergo.Merge(c, defaults, mergo.WithTransformers(c))
+	err := c.start(ctx, defaults)
 	if err != nil {
 		return err
 	}
+	tcpConn, udpConn = c.recoverable(tcpConn, udpConn)
 
+	// errs keeps the errors of all the protocols, g.Wait only returns the first one.
+	var errs errorList
 	g, ctx := errgroup.WithContext(ctx)
+	r := c.runProtocols(ctx, g, &errs)
+	if !isNil(udpConn) {
+		r.setAddr(ProtocolTFTP, udpConn.LocalAddr())
+	}
+	if !isNil(tcpConn) {
+		r.setAddr(ProtocolHTTP, tcpConn.Addr())
+	}
 	if !c.TFTP.Disabled {
-		g.Go(func() error {
-			return c.serveTFTP(ctx, udpConn)
+		r.start(ProtocolTFTP, func() (serveFunc, net.Addr, error) {
+			return c.tftpServe(udpConn), nil, nil
 		})
 	}
 	if !c.HTTP.Disabled {
+		r.start(ProtocolHTTP, func() (serveFunc, net.Addr, error) {
+			return c.httpServe(tcpConn), nil, nil
+		})
+	}
+	if c.EnablePprof && !c.PprofAddr.IsZero() {
 		g.Go(func() error {
-			return c.serveHTTP(ctx, tcpConn)
+			return errs.add(c.listenAndServePprof(ctx))
 		})
 	}
 
 	<-ctx.Done()
-	err = g.Wait()
-	c.Log.Info("shutting down")
+	c.logEvent(EventDraining)
+	r.close()
+	_ = g.Wait()
+	err = errs.err()
+	c.logStopped(err)
 
 	return err
 }
 
-func (c *Server) listenAndServeHTTP(ctx context.Context) error {
-	s := ihttp.Handler{Log: c.Log}
-	router := http.NewServeMux()
-	router.HandleFunc("/", s.Handle)
-	hs := &http.Server{
-		Handler:     router,
-		BaseContext: func(net.Listener) context.Context { return ctx },
-		ReadTimeout: c.HTTP.Timeout,
+// bindHTTP binds the HTTP listener at the configured address.
+func (c *Server) bindHTTP() (net.Listener, error) {
+	if c.HTTP.UnixSocket != "" {
+		l, err := listenUnix(c.HTTP.UnixSocket)
+		if err != nil {
+			return nil, bindError(err)
+		}
+		return l, nil
 	}
-	c.Log.Info("serving HTTP", "addr", c.HTTP.Addr.String(), "timeout", c.HTTP.Timeout)
-	g, ctx := errgroup.WithContext(ctx)
-	g.Go(func() error {
-		return ihttp.ListenAndServe(ctx, c.HTTP.Addr, hs)
-	})
-
-	<-ctx.Done()
-	err := hs.Shutdown(ctx)
-	if err != nil {
-		return err
+	listen := listenTCP
+	if c.HTTP.ReusePort {
+		listen = listenReusePort
 	}
-	err = g.Wait()
-	if errors.Is(err, http.ErrServerClosed) {
-		err = nil
+	l, err := listen("tcp", c.HTTP.Addr.String())
+	if err != nil {
+		return nil, bindError(err)
 	}
-	return err
+	return l, nil
 }
 
 func (c *Server) serveHTTP(ctx context.Context, l net.Listener) error {
-	if l == nil || reflect.ValueOf(l).IsNil() {
+	return c.serveHTTPUntil(ctx, nil, l)
+}
+
+// serveHTTPUntil serves HTTP on l until ctx is done or stop is closed. When stop is closed,
+// the requests in flight complete before it returns.
+func (c *Server) serveHTTPUntil(ctx context.Context, stop <-chan struct{}, l net.Listener) error {
+	if isNil(l) {
 		return errors.New("listener must not be nil")
 	}
-	s := ihttp.Handler{Log: c.Log}
+	s := ihttp.Handler{
+		Log:               c.protocolLog(ProtocolHTTP),
+		ServedVerbosity:   c.RequestLogVerbosity,
+		Allowed:           &c.allowed,
+		AllowClientCert:   c.AllowClientCert,
+		Filenames:         c.Filenames,
+		Limiter:           c.limiter,
+		Archives:          c.Archives,
+		ReadGroup:         &singleflight.Group{},
+		Resolver:          c.resolver(),
+		OnServed:          c.onServed(ProtocolHTTP),
+		OnProgress:        c.onProgress(ProtocolHTTP),
+		OnTimings:         c.onTimings(ProtocolHTTP),
+		OnTruncated:       c.onTruncated(ProtocolHTTP),
+		Clock:             c.Clock,
+		ArtificialLatency: c.ArtificialLatency,
+		Progress:          c.progress(),
+		Redirect:          c.RedirectResolver,
+		Authorize:         c.Authorize,
+		Hook:              c.Hook,
+		Maintenance:       c.InMaintenance,
+		Paused:            c.Paused,
+		ClientIDHeader:    c.ClientIDHeader,
+		Templates:         c.Templates,
+		TemplateParams:    c.TemplateParams,
+		UEFIHTTPBoot:      c.UEFIHTTPBoot,
+		Tenants:           c.tenantResolvers(),
+		Canary:            c.CanaryRule,
+		Bandwidth:         c.bandwidth,
+		Timeout:           c.HTTP.Timeout,
+		OptionsHeader:     c.HTTPOptionsHeader,
+		InlineScript:      c.AllowInlineScript,
+		Index:             c.HTTPIndex,
+	}
 	router := http.NewServeMux()
-	router.HandleFunc("/", s.Handle)
+	prefix := pathPrefix(c.HTTPPathPrefix)
+	router.Handle(prefix, http.StripPrefix(strings.TrimSuffix(prefix, "/"), http.HandlerFunc(s.Handle)))
+	if c.EnablePprof && c.PprofAddr.IsZero() {
+		registerPprof(router)
+	}
+	if c.recentBoots != nil {
+		p := c.RecentBootsPath
+		if p == "" {
+			p = DefaultRecentBootsPath
+		}
+		router.Handle(p, s.Protect(c.recentBoots))
+	}
+	if c.ReadyPath != "" {
+		router.HandleFunc(c.ReadyPath, c.serveReady)
+	}
+	if c.ManifestPath != "" {
+		router.HandleFunc(c.ManifestPath, c.serveManifest)
+	}
 	hs := &http.Server{
 		Handler:     router,
 		BaseContext: func(net.Listener) context.Context { return ctx },
+		ConnContext: ihttp.ConnContext,
 		ReadTimeout: c.HTTP.Timeout,
+		// The write deadline is set when the request headers are read and covers writing the whole response.
+		WriteTimeout: c.HTTP.MaxTransferDuration,
+		IdleTimeout:  c.HTTP.IdleTimeout,
+		TLSConfig:    c.tlsConfig(),
+		ErrorLog:     newErrorLog(c.protocolLog(ProtocolHTTP)),
+	}
+	if c.HTTP.ListenBacklog > 0 {
+		c.setListenBacklog(l)
 	}
-	c.Log.Info("serving HTTP", "addr", l.Addr().String(), "timeout", c.HTTP.Timeout)
+	if c.HTTP.KeepAlive != 0 {
+		l = keepAliveListener{Listener: l, period: c.HTTP.KeepAlive}
+	}
+	if hs.TLSConfig != nil {
+		l = tls.NewListener(l, hs.TLSConfig)
+	}
+	c.logEvent(EventListening, LogKeyProtocol, ProtocolHTTP, LogKeyAddr, l.Addr().String(), "timeout", c.HTTP.Timeout, "tls", hs.TLSConfig != nil, "clientAuth", c.ClientAuth.String())
 	g, ctx := errgroup.WithContext(ctx)
 	g.Go(func() error {
 		return ihttp.Serve(ctx, l, hs)
 	})
+	c.ready(ProtocolHTTP, l.Addr())
 
-	<-ctx.Done()
+	select {
+	case <-ctx.Done():
+	case <-stop:
+	}
 	err := hs.Shutdown(ctx)
 	if err != nil {
 		return err
@@ -186,90 +748,531 @@ func (c *Server) serveHTTP(ctx context.Context, l net.Listener) error {
 	return err
 }
 
-func (c *Server) listenAndServeTFTP(ctx context.Context) error {
-	a, err := net.ResolveUDPAddr("udp", c.TFTP.Addr.String())
+// bindTFTP binds the TFTP conn at the configured address.
+func (c *Server) bindTFTP() (net.PacketConn, error) {
+	a, err := resolveUDPAddr("udp", c.TFTP.Addr.String())
 	if err != nil {
-		return err
+		return nil, err
 	}
-	conn, err := net.ListenUDP("udp", a)
+	conn, err := listenUDP("udp", a)
 	if err != nil {
+		return nil, bindError(err)
+	}
+	return conn, nil
+}
+
+// capture returns the recorder of the TFTP transfers captured, nil unless CaptureTransfers is set.
+func (c *Server) capture() *capture.Recorder {
+	if !c.CaptureTransfers {
+		return nil
+	}
+	return capture.New(capture.Config{Clients: c.CaptureClients, Dir: c.CaptureDir, OnRecord: c.OnCapture, Clock: c.Clock})
+}
+
+// optionalTFTP returns err, the failure to bind the TFTP listener, or errProtocolSkipped, after logging it as a
+// warning, when TFTP is optional, see TFTPOptional.
+func (c *Server) optionalTFTP(err error) error {
+	if !c.TFTPOptional {
 		return err
 	}
+	c.log().Info("warning: TFTP failed to bind, serving HTTP only", "error", err.Error(), LogKeyAddr, c.TFTP.Addr.String())
+	return fmt.Errorf("%w: %v", errProtocolSkipped, err)
+}
+
+func (c *Server) serveTFTP(ctx context.Context, conn net.PacketConn) error {
+	return c.serveTFTPUntil(ctx, nil, conn)
+}
+
+// serveTFTPUntil serves TFTP on conn until ctx is done or stop is closed.
+// The transfers in flight complete before it returns, except in single port mode, where they share conn.
+func (c *Server) serveTFTPUntil(ctx context.Context, stop <-chan struct{}, conn net.PacketConn) error {
+	if isNil(conn) {
+		return errors.New("conn must not be nil")
+	}
+	if c.TFTP.ReadBufferSize > 0 {
+		c.setReadBuffer(conn)
+	}
 
-	h := &itftp.Handler{Log: c.Log}
-	ts := tftp.NewServer(h.HandleRead, h.HandleWrite)
-	ts.SetTimeout(c.TFTP.Timeout)
+	h := &itftp.Handler{
+		Log:                 c.protocolLog(ProtocolTFTP),
+		ServedVerbosity:     c.RequestLogVerbosity,
+		Allowed:             &c.allowed,
+		Filenames:           c.Filenames,
+		Limiter:             c.limiter,
+		Transfers:           itftp.NewTransfers(c.MaxTFTPTransfersPerClient),
+		Workers:             itftp.NewWorkers(c.TFTPWorkers, c.TFTPWorkerQueue),
+		ClientIDs:           itftp.NewClientIDs(c.ClientIDOption, c.Clock),
+		Capture:             c.capture(),
+		MaxTransferDuration: c.TFTP.MaxTransferDuration,
+		Clock:               c.Clock,
+		ArtificialLatency:   c.ArtificialLatency,
+		Hook:                c.Hook,
+		Resolver:            c.resolver(),
+		OnServed:            c.onServed(ProtocolTFTP),
+		OnProgress:          c.onProgress(ProtocolTFTP),
+		OnTimings:           c.onTimings(ProtocolTFTP),
+		OnTruncated:         c.onTruncated(ProtocolTFTP),
+		Progress:            c.progress(),
+		Bandwidth:           c.bandwidth,
+		Maintenance:         c.InMaintenance,
+		Paused:              c.Paused,
+		Resume:              c.EnableTFTPResume,
+		DisabledOptions:     c.TFTP.DisabledOptions.names(),
+		Timeout:             c.TFTP.Timeout,
+		Stray:               itftp.NewStrayPackets(c.Clock),
+	}
+	if c.EnableTFTPSelfTest {
+		h.SelfTestName = newSelfTestName()
+	}
+	ts, rh, err := c.configureTFTPServer(h)
+	if err != nil {
+		conn.Close()
+		return err
+	}
 	if c.EnableTFTPSinglePort {
-		ts.EnableSinglePort()
+		c.logSinglePort(conn.LocalAddr())
+	}
+	// the multicast requests are read from their own conn, so that github.com/pin/tftp reads the other requests
+	// from conn, a *net.UDPConn, which it needs to grant block sizes over 512 bytes, see itftp.Multicast.Conn.
+	m := itftp.NewMulticast(*h, c.TFTPMulticast, c.TFTPMulticastSessions)
+	var mconn net.PacketConn
+	var mts TFTPServer
+	var mrh *readyHook
+	if m != nil {
+		if mconn, err = c.bindTFTPMulticast(); err != nil {
+			conn.Close()
+			return err
+		}
+		if mts, mrh, err = c.configureTFTPServer(h); err != nil {
+			conn.Close()
+			mconn.Close()
+			return err
+		}
 	}
-	c.Log.Info("serving TFTP", "addr", c.TFTP.Addr, "timeout", c.TFTP.Timeout, "singlePortEnabled", c.EnableTFTPSinglePort)
+	c.logEvent(EventListening, LogKeyProtocol, ProtocolTFTP, LogKeyAddr, conn.LocalAddr().String(), "timeout", c.TFTP.Timeout, "maxTransferDuration", c.TFTP.MaxTransferDuration, "singlePortEnabled", c.EnableTFTPSinglePort)
 	g, ctx := errgroup.WithContext(ctx)
+	start := time.Now()
 	g.Go(func() error {
-		return itftp.Serve(ctx, conn, ts)
+		return ts.Serve(h.ClientIDs.Conn(itftp.OptionsConn(conn, TFTPOptions{Blksize: c.TFTP.DisabledOptions.Blksize}.names())))
 	})
-	// The time.Sleep(time.Second) is load bearing. It allows the tftp server shutdown below to not nil pointer error
-	// if a canceled context is passed in to the serveTFTP() function. This happens because itftp.Serve must be called
-	// for ts.conn to be populated. ts.Shutdown needs ts.conn to be populated to close the connection or else it panics.
-	// One option to "fix" this issue is to PR the following into github.com/pin/tftp:
-	/*
-			func (s *Server) Shutdown() {
-			if !s.singlePort {
-				if s.conn != nil {
-					s.conn.Close()
-				}
-			}
-			q := make(chan struct{})
-			s.quit <- q
-			<-q
-			s.wg.Wait()
+	if m != nil {
+		c.protocolLog(ProtocolTFTP).Info("listening for multicast requests", LogKeyAddr, mconn.LocalAddr().String(), "group", c.TFTPMulticast.String())
+		g.Go(func() error {
+			return mts.Serve(h.ClientIDs.Conn(m.Conn(itftp.OptionsConn(mconn, c.TFTP.DisabledOptions.names()))))
+		})
+	}
+	if c.EnableTFTPSelfTest {
+		g.Go(func() error {
+			return c.selfTestTFTP(conn.LocalAddr(), h.SelfTestName)
+		})
+	}
+	// With github.com/pin/tftp, the default TFTPServer, ts.Serve must populate ts.conn before ts.Shutdown is called,
+	// or Shutdown panics with a nil pointer error, for example when a canceled context is passed in to serveTFTP().
+	// This used to be a fixed time.Sleep(time.Second), waitTFTPReady detects it instead. It is deliberately not on
+	// c.Clock, it waits for the goroutine above to be scheduled, which a fake clock can't model.
+	// Other implementations must handle Shutdown being called before Serve received a request, see TFTPServer.
+	if c.NewTFTPServer == nil {
+		c.awaitTFTPReady(conn, rh, start)
+		if m != nil {
+			c.awaitTFTPReady(mconn, mrh, start)
 		}
-	*/
-	time.Sleep(time.Second)
-	<-ctx.Done()
+	}
+	// Once the serve loop reads requests: github.com/pin/tftp only learns the local address of the requests it reads
+	// after, which it needs to grant block sizes over 512 bytes.
+	c.ready(ProtocolTFTP, conn.LocalAddr())
+	select {
+	case <-ctx.Done():
+	case <-stop:
+	}
 	conn.Close()
 	ts.Shutdown()
-
+	if m != nil {
+		mconn.Close()
+		mts.Shutdown()
+	}
+	m.Close()
 	return g.Wait()
 }
 
-func (c *Server) serveTFTP(ctx context.Context, conn net.PacketConn) error {
-	if conn == nil || reflect.ValueOf(conn).IsNil() {
-		return errors.New("conn must not be nil")
+// configureTFTPServer returns a TFTPServer serving the requests with h, configured with the TFTP ServerSpec, and the
+// readyHook detecting when it serves, nil when the server doesn't take hooks.
+func (c *Server) configureTFTPServer(h *itftp.Handler) (TFTPServer, *readyHook, error) {
+	ts := c.newTFTPServer(h.HandleRead, h.HandleWrite)
+	var rh *readyHook
+	if hs, ok := ts.(interface{ SetHook(tftp.Hook) }); ok {
+		// log the negotiated options of each transfer at debug level, and detect when the server is ready to shut down.
+		rh = newReadyHook(h)
+		hs.SetHook(rh)
+	}
+	ts.SetTimeout(h.Timeout)
+	if err := c.setDataPorts(ts); err != nil {
+		return nil, nil, err
 	}
-
-	h := &itftp.Handler{Log: c.Log}
-	ts := tftp.NewServer(h.HandleRead, h.HandleWrite)
-	ts.SetTimeout(c.TFTP.Timeout)
 	if c.EnableTFTPSinglePort {
 		ts.EnableSinglePort()
 	}
-	c.Log.Info("serving TFTP", "addr", conn.LocalAddr().String(), "timeout", c.TFTP.Timeout, "singlePortEnabled", c.EnableTFTPSinglePort)
-	g, ctx := errgroup.WithContext(ctx)
-	g.Go(func() error {
-		return itftp.Serve(ctx, conn, ts)
-	})
-	// The time.Sleep(time.Second) is load bearing. It allows the tftp server shutdown below to not nil pointer error
-	// if a canceled context is passed in to the serveTFTP() function. This happens because itftp.Serve must be called
-	// for ts.conn to be populated. ts.Shutdown needs ts.conn to be populated to close the connection or else it panics.
-	// One option to "fix" this issue is to PR the following into github.com/pin/tftp:
-	/*
-			func (s *Server) Shutdown() {
-			if !s.singlePort {
-				if s.conn != nil {
-					s.conn.Close()
-				}
+	return ts, rh, nil
+}
+
+// bindTFTPMulticast binds the conn the multicast requests are read from at TFTPMulticastAddr.
+func (c *Server) bindTFTPMulticast() (net.PacketConn, error) {
+	a, err := resolveUDPAddr("udp", c.TFTPMulticastAddr.String())
+	if err != nil {
+		return nil, err
+	}
+	conn, err := listenUDP("udp", a)
+	if err != nil {
+		return nil, bindError(err)
+	}
+	return conn, nil
+}
+
+// onServed returns a handler hook for protocol p that counts the file served, see ServedCounts, logs EventRequestServed,
+// when LogRequestServed is true, and records the boot, when TrackRecentBoots is true.
+func (c *Server) onServed(p Protocol) func(client netaddr.IP, mac net.HardwareAddr, filename string, bytesSent int64) {
+	first := c.firstServe[p]
+	return func(client netaddr.IP, mac net.HardwareAddr, filename string, bytesSent int64) {
+		name := path.Base(filename)
+		if alias, ok := c.aliases[name]; ok {
+			name = alias
+		}
+		c.served.add(name)
+		if c.LogRequestServed {
+			c.logEvent(EventRequestServed, LogKeyProtocol, p, LogKeyClient, client.String(), LogKeyFilename, filename, LogKeyBytes, bytesSent)
+		}
+		c.recentBoots.Add(recent.Boot{Client: client.String(), MAC: mac.String(), Filename: filename, Protocol: string(p), Time: c.Clock.Now()})
+		if c.OnFirstServe != nil {
+			first.Do(func() { c.OnFirstServe(p) })
+		}
+	}
+}
+
+// onProgress returns a handler hook for protocol p that calls OnProgress. It returns nil when OnProgress is nil.
+func (c *Server) onProgress(p Protocol) func(client netaddr.IP, filename string, sent, total int64) {
+	if c.OnProgress == nil {
+		return nil
+	}
+	return func(client netaddr.IP, filename string, sent, total int64) {
+		c.OnProgress(p, client, filename, sent, total)
+	}
+}
+
+// onTimings returns a handler hook for protocol p that calls OnTimings. It returns nil when OnTimings is nil.
+func (c *Server) onTimings(p Protocol) func(client netaddr.IP, filename string, phases timing.Phases) {
+	if c.OnTimings == nil {
+		return nil
+	}
+	return func(client netaddr.IP, filename string, phases timing.Phases) {
+		c.OnTimings(p, client, filename, phases)
+	}
+}
+
+// onTruncated returns a handler hook for protocol p that calls OnTruncated. It returns nil when OnTruncated is nil.
+func (c *Server) onTruncated(p Protocol) func(client netaddr.IP, filename string, sent, size int64, reason string) {
+	if c.OnTruncated == nil {
+		return nil
+	}
+	return func(client netaddr.IP, filename string, sent, size int64, reason string) {
+		c.OnTruncated(p, client, filename, sent, size, reason)
+	}
+}
+
+// progress returns the throttle of the OnProgress calls, on c.Clock unless it has its own clock.
+func (c *Server) progress() progress.Throttle {
+	t := c.Progress
+	if t.Clock == nil {
+		t.Clock = c.Clock
+	}
+	return t
+}
+
+// pathPrefix normalizes an HTTP path prefix to start and end with a "/".
+func pathPrefix(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return "/"
+	}
+	return "/" + p + "/"
+}
+
+// resolver returns the resolver shared by the protocol handlers.
+func (c *Server) resolver() resolve.Resolver {
+	r := resolve.Resolver{Dynamic: c.DynamicBinary, Cache: c.dynamicCache, Files: c.files, FS: c.FileSystems, Upstream: c.upstream, Aliases: c.aliases, ReadAhead: c.FileSystemReadAhead, MissingScript: c.MissingFileScript, DefaultEFI: c.DefaultEFIBinary, Checksums: c.checksums, EmbeddedModTime: c.embeddedModTime()}
+	if c.EnableInfoFile {
+		r.Info = c.infoFile
+	}
+	if c.FirstBoot != nil {
+		r.Stateful, r.Peek = c.FirstBoot.Dynamic, c.FirstBoot.Peek
+	}
+	return r
+}
+
+// embeddedModTime returns EmbeddedModTime, or binary.BuildTime when it is zero.
+func (c *Server) embeddedModTime() time.Time {
+	if !c.EmbeddedModTime.IsZero() {
+		return c.EmbeddedModTime
+	}
+	t, _ := binary.BuildTime()
+	return t
+}
+
+// PurgeDynamicBinaryCache drops all binaries cached from DynamicBinary, for example after the template
+// they are generated from changed. Requests that follow call DynamicBinary again.
+func (c *Server) PurgeDynamicBinaryCache() {
+	c.dynamicCache.Purge()
+}
+
+// mergeAliases returns Aliases merged over binary.DefaultAliases, when UseDefaultAliases is set.
+func (c *Server) mergeAliases() map[string]string {
+	if !c.UseDefaultAliases {
+		return c.Aliases
+	}
+	aliases := make(map[string]string, len(binary.DefaultAliases)+len(c.Aliases))
+	for name, target := range binary.DefaultAliases {
+		aliases[name] = target
+	}
+	for name, target := range c.Aliases {
+		aliases[name] = target
+	}
+	return aliases
+}
+
+// start sets the defaults and creates the runtime state shared by the protocols.
+func (c *Server) start(ctx context.Context, defaults Server) error {
+	if err := c.setDefaults(defaults); err != nil {
+		return err
+	}
+	if c.TFTP.UnixSocket != "" && !c.TFTP.Disabled {
+		return errors.New("TFTP can't be served over a Unix domain socket")
+	}
+	if err := c.TFTP.DataPorts.validate(); err != nil {
+		return fmt.Errorf("TFTP data ports: %w", err)
+	}
+	if !c.TFTP.DataPorts.IsZero() && !c.TFTP.Disabled && !c.EnableTFTPSinglePort && c.NewTFTPServer == nil {
+		return fmt.Errorf("%w: github.com/pin/tftp binds each transfer to an ephemeral port, enable single port mode instead", ErrPortRangeUnsupported)
+	}
+	for _, p := range []struct {
+		protocol Protocol
+		spec     ServerSpec
+	}{{ProtocolTFTP, c.TFTP}, {ProtocolHTTP, c.HTTP}} {
+		if p.spec.Timeout < 0 || p.spec.MaxTransferDuration < 0 {
+			return fmt.Errorf("%v timeout %v, max transfer duration %v: must not be negative", p.protocol, p.spec.Timeout, p.spec.MaxTransferDuration)
+		}
+	}
+	if c.CaptureTransfers {
+		if c.CaptureDir == "" && c.OnCapture == nil {
+			return errors.New("capturing transfers requires a capture directory or OnCapture")
+		}
+		if c.CaptureDir != "" {
+			fi, err := os.Stat(c.CaptureDir)
+			if err != nil {
+				return fmt.Errorf("capture directory: %w", err)
+			}
+			if !fi.IsDir() {
+				return fmt.Errorf("capture directory %v: not a directory", c.CaptureDir)
 			}
-			q := make(chan struct{})
-			s.quit <- q
-			<-q
-			s.wg.Wait()
 		}
-	*/
-	time.Sleep(time.Second)
-	<-ctx.Done()
-	conn.Close()
-	ts.Shutdown()
-	return g.Wait()
+	}
+	if c.TFTPOptional && c.HTTP.Disabled {
+		return errors.New("TFTP can't be optional with HTTP disabled, nothing would be served")
+	}
+	if c.FirstBoot != nil {
+		if err := c.FirstBoot.Validate(); err != nil {
+			return fmt.Errorf("first boot: %w", err)
+		}
+	}
+	if c.TFTPWorkers < 0 || c.TFTPWorkerQueue < 0 {
+		return fmt.Errorf("TFTP workers %v, queue %v: must not be negative", c.TFTPWorkers, c.TFTPWorkerQueue)
+	}
+	if err := c.validateMulticast(); err != nil {
+		return fmt.Errorf("TFTP multicast %v: %w", c.TFTPMulticast, err)
+	}
+	if c.ArtificialLatency < 0 {
+		return fmt.Errorf("artificial latency %v: must not be negative", c.ArtificialLatency)
+	}
+	if c.RequestLogVerbosity < 0 {
+		return fmt.Errorf("request log verbosity %v: must not be negative", c.RequestLogVerbosity)
+	}
+	if p := c.CanaryRule.Percent; p < 0 || p > 100 {
+		return fmt.Errorf("canary percent %v: must be from 0 to 100", p)
+	}
+	if c.DefaultEFIBinary != "" && (!resolve.IsEFI(c.DefaultEFIBinary) || path.Base(c.DefaultEFIBinary) != c.DefaultEFIBinary) {
+		return fmt.Errorf("default EFI binary %q: must be the name of an EFI binary, ending with %v", c.DefaultEFIBinary, resolve.EFIExtension)
+	}
+	for name, params := range c.TemplateParams {
+		if err := ihttp.CheckNodeParams(params); err != nil {
+			return fmt.Errorf("template %q: %w", name, err)
+		}
+	}
+	c.Clock = clock.Or(c.Clock)
+	if c.StartPaused && c.started.IsZero() {
+		c.Pause()
+	}
+	c.started = c.Clock.Now()
+	rl := c.RateLimit
+	if rl.Clock == nil {
+		rl.Clock = c.Clock
+	}
+	c.limiter = ratelimit.New(rl)
+	bw := c.Bandwidth
+	if bw.Clock == nil {
+		bw.Clock = c.Clock
+	}
+	c.bandwidth = bandwidth.New(bw)
+	c.upstream = nil
+	if c.UpstreamURL != "" {
+		u, err := upstream.New(c.UpstreamURL, c.UpstreamTTL, nil)
+		if err != nil {
+			return err
+		}
+		u.Clock = c.Clock
+		u.MaxSize = c.UpstreamMaxSize
+		c.upstream = u
+	}
+	c.aliases = c.mergeAliases()
+	c.files = nil
+	if c.ChainURL != "" {
+		files, err := c.chainFiles()
+		if err != nil {
+			return err
+		}
+		c.files = files
+	}
+	if c.OCIReference != "" {
+		files, err := c.pullOCI(ctx)
+		if err != nil {
+			return err
+		}
+		if c.files == nil {
+			c.files = make(map[string][]byte, len(files))
+		}
+		for name, content := range files {
+			c.files[name] = content
+		}
+	}
+	if c.CompressedBinaries {
+		files, err := compressedFiles(c.files)
+		if err != nil {
+			return err
+		}
+		if c.files == nil {
+			c.files = make(map[string][]byte, len(files))
+		}
+		for name, content := range files {
+			c.files[name] = content
+		}
+	}
+	if !c.servesBinaries() && c.DynamicBinary == nil {
+		return ErrNoBinaries
+	}
+	c.served = newServedCounts()
+	c.firstServe = map[Protocol]*sync.Once{ProtocolTFTP: {}, ProtocolHTTP: {}}
+	c.recentBoots = nil
+	if c.TrackRecentBoots {
+		c.recentBoots = recent.New(c.RecentBootsSize)
+	}
+	dc := c.DynamicBinaryCache
+	if dc.Clock == nil {
+		dc.Clock = c.Clock
+	}
+	c.dynamicCache = resolve.NewCache(dc)
+	c.checksums = nil
+	if c.EnableChecksumFiles {
+		c.checksums = resolve.NewChecksums()
+	}
+	c.checkTrustAnchors(ctx)
+	c.logEvent(EventStarting, LogKeyProtocols, c.protocols())
+	return nil
+}
+
+// listenAndServeDefaults returns the defaults ListenAndServe merges into a Server, see Server.NoDefaults.
+func listenAndServeDefaults() Server {
+	return Server{
+		TFTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 69), Timeout: 5 * time.Second},
+		HTTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 8080), Timeout: 5 * time.Second},
+		Log:  logr.Discard(),
+	}
+}
+
+// setDefaults merges defaults into any zero value fields of c, unless c.NoDefaults is true.
+func (c *Server) setDefaults(defaults Server) error {
+	if !c.NoDefaults {
+		return mergo.Merge(c, defaults, mergo.WithTransformers(c))
+	}
+	if c.Log.GetSink() == nil {
+		return errors.New("log must be set when defaults are disabled")
+	}
+	return nil
+}
+
+// pullOCI pulls the files of OCIReference.
+func (c *Server) pullOCI(ctx context.Context) (map[string][]byte, error) {
+	p := oci.Puller{CacheDir: c.OCICacheDir, PlainHTTP: c.OCIPlainHTTP}
+	if c.OCIDockerConfig != "" {
+		auth, err := oci.LoadDockerConfig(c.OCIDockerConfig)
+		if err != nil {
+			return nil, err
+		}
+		p.Auth = auth
+	}
+	files, err := p.Pull(ctx, c.OCIReference)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.log().Info("pulled OCI artifact", "reference", c.OCIReference, "files", names)
+	return files, nil
+}
+
+// selfTestTFTP runs the TFTP self-test against addr, requesting name, and logs the result.
+// An error is only returned when the test fails and FailFastSelfTest is set.
+func (c *Server) selfTestTFTP(addr net.Addr, name string) error {
+	a, err := net.ResolveUDPAddr("udp", addr.String())
+	if err == nil {
+		a.IP = selfTestIP(a)
+		var want []byte
+		if want, err = c.selfTestContent(a.IP, name); err == nil {
+			err = runTFTPSelfTest(a, c.TFTP.Timeout, name, want)
+		}
+	}
+	if errors.Is(err, errSelfTestSkipped) {
+		c.log().Info(err.Error(), "addr", addr.String())
+		return nil
+	}
+	if err == nil {
+		c.log().Info("TFTP self-test passed", "addr", addr.String())
+		return nil
+	}
+	c.log().Error(err, "TFTP self-test failed", "addr", addr.String(), "failFast", c.FailFastSelfTest)
+	if c.FailFastSelfTest {
+		return fmt.Errorf("TFTP self-test failed: %w", err)
+	}
+	return nil
+}
+
+// isNil reports whether v is nil or an interface holding a nil pointer, map, slice, etc.
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice, reflect.UnsafePointer:
+		return rv.IsNil()
+	default:
+		return false
+	}
+}
+
+// ready calls the OnReady callback, if one is set.
+func (c *Server) ready(p Protocol, addr net.Addr) {
+	if c.OnReady != nil {
+		c.OnReady(p, addr)
+	}
 }
 
 // Transformer for merging the netaddr.IPPort and logr.Logger structs.
@@ -1,15 +1,40 @@
 package ipxedust
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
 	"github.com/google/go-cmp/cmp"
+	"github.com/pin/tftp"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/ihttp"
+	"github.com/tinkerbell/ipxedust/itftp"
+	"github.com/tinkerbell/ipxedust/oci"
+	"github.com/tinkerbell/ipxedust/progress"
+	"github.com/tinkerbell/ipxedust/recent"
+	"github.com/tinkerbell/ipxedust/resolve"
+	"github.com/tinkerbell/ipxedust/upstream"
 	"inet.af/netaddr"
 )
 
@@ -28,22 +53,26 @@ func TestListenAndServe(t *testing.T) {
 		{
 			name: "fail",
 			tftp: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), 69), Timeout: 5 * time.Second},
-			wantErr: &net.OpError{
+			wantErr: &BindError{Err: &net.OpError{
 				Op:   "listen",
 				Net:  "udp",
 				Addr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 69},
-				Err:  fmt.Errorf("bind: permission denied"),
-			},
+				Err:  &os.SyscallError{Syscall: "bind", Err: syscall.EACCES},
+			}},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			ready := make(chan Protocol, 2)
 			got := &Server{
 				TFTP:                 tt.tftp,
 				HTTP:                 tt.http,
 				EnableTFTPSinglePort: true,
+				OnReady:              func(p Protocol, _ net.Addr) { ready <- p },
 			}
-			ctx, cn := context.WithCancel(context.Background())
+			// The deadline ends the fail case when the bind is permitted, like for root, instead of serving forever.
+			ctx, cn := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cn()
 
 			var err error
 			var wg sync.WaitGroup
@@ -53,7 +82,11 @@ func TestListenAndServe(t *testing.T) {
 
 				wg.Done()
 			}()
-			cn()
+			if tt.wantErr == nil {
+				<-ready
+				<-ready
+				cn()
+			}
 			wg.Wait()
 
 			switch {
@@ -148,6 +181,94 @@ func TestServe(t *testing.T) {
 	}
 }
 
+func TestServeCanceledContext(t *testing.T) {
+	tests := []struct {
+		name        string
+		tftp        bool
+		http        bool
+		listenServe bool
+	}{
+		{name: "ListenAndServe both", tftp: true, http: true, listenServe: true},
+		{name: "ListenAndServe tftp only", tftp: true, listenServe: true},
+		{name: "ListenAndServe http only", http: true, listenServe: true},
+		{name: "ListenAndServe none", listenServe: true},
+		{name: "Serve both", tftp: true, http: true},
+		{name: "Serve tftp only", tftp: true},
+		{name: "Serve http only", http: true},
+		{name: "Serve none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bound int32
+			stubBind(t,
+				func(string, string) (*net.UDPAddr, error) {
+					atomic.AddInt32(&bound, 1)
+					return nil, errors.New("resolved")
+				},
+				func(string, *net.UDPAddr) (*net.UDPConn, error) {
+					atomic.AddInt32(&bound, 1)
+					return nil, errors.New("bound")
+				},
+				func(string, string) (net.Listener, error) {
+					atomic.AddInt32(&bound, 1)
+					return nil, errors.New("bound")
+				},
+			)
+			c := &Server{
+				Log:  logr.Discard(),
+				TFTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), 0), Disabled: !tt.tftp},
+				HTTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), 0), Disabled: !tt.http},
+			}
+			var l net.Listener
+			var uconn net.PacketConn
+			if !tt.listenServe {
+				var err error
+				if l, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+					t.Fatal(err)
+				}
+				defer l.Close()
+				if uconn, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+					t.Fatal(err)
+				}
+				defer uconn.Close()
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			start := time.Now()
+			var err error
+			if tt.listenServe {
+				err = c.ListenAndServe(ctx)
+			} else {
+				err = c.Serve(ctx, l, uconn)
+			}
+			if err != nil {
+				t.Fatalf("expected nil error, got: %v", err)
+			}
+			if d := time.Since(start); d > 100*time.Millisecond {
+				t.Fatalf("expected to return immediately, took %v", d)
+			}
+			if n := atomic.LoadInt32(&bound); n != 0 {
+				t.Fatalf("expected no listener to be bound, got %v", n)
+			}
+			for _, p := range []Protocol{ProtocolTFTP, ProtocolHTTP} {
+				if _, err := c.Listener(p); !errors.Is(err, ErrNotServing) {
+					t.Fatalf("expected %v not to be served, got: %v", p, err)
+				}
+			}
+			if !tt.listenServe {
+				// the conns are left to the caller, still open.
+				if err := uconn.SetDeadline(time.Now()); err != nil {
+					t.Fatalf("expected the udp conn to be open, got: %v", err)
+				}
+				if err := l.(*net.TCPListener).SetDeadline(time.Now()); err != nil {
+					t.Fatalf("expected the tcp listener to be open, got: %v", err)
+				}
+			}
+		})
+	}
+}
+
 func TestListenAndServeHTTP(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -157,12 +278,12 @@ func TestListenAndServeHTTP(t *testing.T) {
 		{
 			name: "fail net.OpError",
 			attr: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), 80), Timeout: 5 * time.Second},
-			wantErr: &net.OpError{
+			wantErr: &BindError{Err: &net.OpError{
 				Op:   "listen",
 				Net:  "tcp",
 				Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80},
-				Err:  fmt.Errorf("bind: permission denied"),
-			},
+				Err:  &os.SyscallError{Syscall: "bind", Err: syscall.EACCES},
+			}},
 		},
 		{
 			name:    "success Server Closed",
@@ -176,7 +297,12 @@ func TestListenAndServeHTTP(t *testing.T) {
 			ctx, cancel := context.WithCancel(context.Background())
 			errChan := make(chan error, 1)
 			go func() {
-				errChan <- c.listenAndServeHTTP(ctx)
+				l, err := c.bindHTTP()
+				if err != nil {
+					errChan <- err
+					return
+				}
+				errChan <- c.serveHTTP(ctx, l)
 			}()
 			cancel()
 			err := <-errChan
@@ -253,12 +379,12 @@ func TestListenAndServeTFTP(t *testing.T) {
 		{
 			name: "fail net.OpError",
 			attr: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), 80), Timeout: 5 * time.Second},
-			wantErr: &net.OpError{
+			wantErr: &BindError{Err: &net.OpError{
 				Op:   "listen",
 				Net:  "udp",
 				Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80},
-				Err:  fmt.Errorf("bind: permission denied"),
-			},
+				Err:  &os.SyscallError{Syscall: "bind", Err: syscall.EACCES},
+			}},
 		},
 		{
 			name:    "success Server Closed",
@@ -280,7 +406,12 @@ func TestListenAndServeTFTP(t *testing.T) {
 			ctx, cancel := context.WithCancel(context.Background())
 			errChan := make(chan error, 1)
 			go func() {
-				errChan <- c.listenAndServeTFTP(ctx)
+				conn, err := c.bindTFTP()
+				if err != nil {
+					errChan <- err
+					return
+				}
+				errChan <- c.serveTFTP(ctx, conn)
 			}()
 			cancel()
 			err := <-errChan
@@ -350,3 +481,956 @@ func TestServeTFTP(t *testing.T) {
 		})
 	}
 }
+
+func TestOnReady(t *testing.T) {
+	tests := []struct {
+		name   string
+		listen bool
+	}{
+		{name: "listen and serve", listen: true},
+		{name: "serve", listen: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready := make(chan Protocol, 2)
+			addrs := make(map[Protocol]net.Addr)
+			var mu sync.Mutex
+			got := &Server{
+				Log: logr.Discard(),
+				OnReady: func(p Protocol, addr net.Addr) {
+					mu.Lock()
+					addrs[p] = addr
+					mu.Unlock()
+					ready <- p
+				},
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			errChan := make(chan error, 1)
+			if tt.listen {
+				got.TFTP = ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), uint16(getPort()))}
+				got.HTTP = ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), uint16(getPort()))}
+				go func() { errChan <- got.ListenAndServe(ctx) }()
+			} else {
+				conn, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				go func() { errChan <- got.Serve(ctx, conn, uconn) }()
+			}
+
+			seen := make(map[Protocol]bool)
+			for i := 0; i < 2; i++ {
+				select {
+				case p := <-ready:
+					seen[p] = true
+				case <-time.After(5 * time.Second):
+					t.Fatal("timed out waiting for OnReady")
+				}
+			}
+			if diff := cmp.Diff(seen, map[Protocol]bool{ProtocolTFTP: true, ProtocolHTTP: true}); diff != "" {
+				t.Fatal(diff)
+			}
+
+			mu.Lock()
+			httpAddr, tftpAddr := addrs[ProtocolHTTP].String(), addrs[ProtocolTFTP].String()
+			mu.Unlock()
+			if diff := cmp.Diff(httpGet(t, httpAddr, "snp.efi"), binary.Files["snp.efi"]); diff != "" {
+				t.Fatal(diff)
+			}
+			if diff := cmp.Diff(tftpGet(t, tftpAddr, "snp.efi"), binary.Files["snp.efi"]); diff != "" {
+				t.Fatal(diff)
+			}
+
+			cancel()
+			if err := <-errChan; err != nil {
+				t.Fatalf("expected nil error, got: %v", err)
+			}
+		})
+	}
+}
+
+// httpGet downloads filename from the HTTP server listening on addr.
+func httpGet(t *testing.T, addr, filename string) []byte {
+	t.Helper()
+	resp, err := http.Get(fmt.Sprintf("http://%v/%v", addr, filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: %v", resp.StatusCode)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// tftpGet downloads filename from the TFTP server listening on addr.
+func tftpGet(t *testing.T, addr, filename string) []byte {
+	t.Helper()
+	c, err := tftp.NewClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := c.Receive(filename, "octet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := wt.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSetDefaults(t *testing.T) {
+	defaults := Server{
+		TFTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 69), Timeout: 5 * time.Second},
+		HTTP: ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 8080), Timeout: 5 * time.Second},
+		Log:  logr.Discard(),
+	}
+	override := ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), 6969), Timeout: time.Second}
+	tests := []struct {
+		name     string
+		srv      *Server
+		wantTFTP ServerSpec
+		wantHTTP ServerSpec
+		wantErr  error
+	}{
+		{
+			name:     "defaults merged",
+			srv:      &Server{TFTP: override},
+			wantTFTP: override,
+			wantHTTP: defaults.HTTP,
+		},
+		{
+			name:     "no defaults respects zero values",
+			srv:      &Server{TFTP: override, Log: logr.Discard(), NoDefaults: true},
+			wantTFTP: override,
+			wantHTTP: ServerSpec{},
+		},
+		{
+			name:     "no defaults without logger",
+			srv:      &Server{NoDefaults: true},
+			wantTFTP: ServerSpec{},
+			wantHTTP: ServerSpec{},
+			wantErr:  fmt.Errorf("log must be set when defaults are disabled"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.srv.setDefaults(defaults)
+			if diff := cmp.Diff(fmt.Sprint(err), fmt.Sprint(tt.wantErr)); diff != "" {
+				t.Fatal(diff)
+			}
+			if tt.srv.TFTP != tt.wantTFTP {
+				t.Errorf("TFTP: got %+v, want %+v", tt.srv.TFTP, tt.wantTFTP)
+			}
+			if tt.srv.HTTP != tt.wantHTTP {
+				t.Errorf("HTTP: got %+v, want %+v", tt.srv.HTTP, tt.wantHTTP)
+			}
+			if tt.wantErr == nil && tt.srv.Log.GetSink() == nil {
+				t.Error("expected a logger to be set")
+			}
+		})
+	}
+}
+
+// newTestLogger returns a logger that records every log line, and a function returning the recorded lines.
+func newTestLogger() (logr.Logger, func() []string) {
+	var mu sync.Mutex
+	var lines []string
+	log := funcr.New(func(prefix, args string) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, prefix+args)
+	}, funcr.Options{Verbosity: 1})
+	return log, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), lines...)
+	}
+}
+
+// smallBufferListener sets a small write buffer on accepted TCP connections,
+// so that a slow reader blocks the server instead of the kernel buffering the whole response.
+type smallBufferListener struct {
+	net.Listener
+}
+
+func (l smallBufferListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if tc, ok := c.(*net.TCPConn); ok {
+		_ = tc.SetWriteBuffer(4096)
+	}
+	return c, err
+}
+
+func TestServeHTTPMaxTransferDuration(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Server{Log: logr.Discard(), HTTP: ServerSpec{Timeout: 5 * time.Second, MaxTransferDuration: 200 * time.Millisecond}}
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.serveHTTP(ctx, smallBufferListener{l}) }()
+	defer func() {
+		cancel()
+		<-errChan
+	}()
+
+	// a slow client that trickles reads well under the idle timeout.
+	conn, err := net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadBuffer(4096)
+	if _, err := fmt.Fprintf(conn, "GET /ipxe.efi HTTP/1.1\r\nHost: %v\r\n\r\n", l.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	var got int
+	buf := make([]byte, 1024)
+	for time.Since(start) < 10*time.Second {
+		n, err := conn.Read(buf)
+		got += n
+		if err != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got >= len(binary.Files["ipxe.efi"]) {
+		t.Fatalf("expected the transfer to be aborted, received %v bytes", got)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("expected the transfer to be aborted after %v, took: %v", c.HTTP.MaxTransferDuration, time.Since(start))
+	}
+}
+
+func TestStartInvalidUpstream(t *testing.T) {
+	c := &Server{UpstreamURL: "ftp://example.com/ipxe"}
+	if err := c.start(context.Background(), Server{Log: logr.Discard()}); err == nil {
+		t.Fatal("expected an error for an invalid upstream URL")
+	}
+}
+
+func TestRecentBoots(t *testing.T) {
+	ready := make(chan struct{}, 2)
+	addrs := make(map[Protocol]net.Addr)
+	var mu sync.Mutex
+	got := &Server{
+		Log:              logr.Discard(),
+		TrackRecentBoots: true,
+		OnReady: func(p Protocol, addr net.Addr) {
+			mu.Lock()
+			addrs[p] = addr
+			mu.Unlock()
+			ready <- struct{}{}
+		},
+	}
+	conn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- got.Serve(ctx, conn, uconn) }()
+	defer func() {
+		cancel()
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}()
+	<-ready
+	<-ready
+	mu.Lock()
+	httpAddr, tftpAddr := addrs[ProtocolHTTP].String(), addrs[ProtocolTFTP].String()
+	mu.Unlock()
+
+	tftpGet(t, tftpAddr, "undionly.kpxe")
+	httpGet(t, httpAddr, "0a:00:27:00:00:02/snp.efi")
+
+	want := []string{"http snp.efi 0a:00:27:00:00:02", "tftp undionly.kpxe "}
+	var boots []string
+	// the TFTP transfer finishes server side after the client receives the last block.
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var l []recent.Boot
+		if err := json.Unmarshal(httpGet(t, httpAddr, DefaultRecentBootsPath[1:]), &l); err != nil {
+			t.Fatal(err)
+		}
+		boots = nil
+		for _, b := range l {
+			boots = append(boots, fmt.Sprintf("%v %v %v", b.Protocol, b.Filename, b.MAC))
+		}
+		if len(boots) == len(want) {
+			break
+		}
+	}
+	if diff := cmp.Diff(boots, want); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestHandlersResolveIdentically(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/custom.efi" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("upstream content"))
+	}))
+	defer up.Close()
+	cache, err := upstream.New(up.URL, time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := resolve.Resolver{Upstream: cache}
+	hh := ihttp.Handler{Log: logr.Discard(), Resolver: r}
+	th := itftp.Handler{Log: logr.Discard(), Resolver: r}
+
+	files := []string{
+		"snp.efi",
+		"0a:00:27:00:00:02/undionly.kpxe",
+		"ipxe.efi-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01",
+		"custom.efi",
+		"missing.efi",
+	}
+	for _, f := range files {
+		t.Run(f, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			hh.Handle(w, httptest.NewRequest(http.MethodGet, "/"+f, nil))
+			var httpContent []byte
+			if w.Code == http.StatusOK {
+				httpContent = w.Body.Bytes()
+			}
+
+			var buf bytes.Buffer
+			var tftpContent []byte
+			if err := th.HandleRead(f, &buf); err == nil {
+				tftpContent = buf.Bytes()
+			}
+			if diff := cmp.Diff(httpContent, tftpContent); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestServeHTTPPathPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		want   map[string]int
+	}{
+		{
+			name: "no prefix",
+			want: map[string]int{"/snp.efi": http.StatusOK, "/0a:00:27:00:00:02/snp.efi": http.StatusOK, "/ipxe/snp.efi": http.StatusOK},
+		},
+		{
+			name:   "prefix",
+			prefix: "/ipxe/",
+			want: map[string]int{
+				"/ipxe/snp.efi":                   http.StatusOK,
+				"/ipxe/0a:00:27:00:00:02/snp.efi": http.StatusOK,
+				"/snp.efi":                        http.StatusNotFound,
+				"/other/snp.efi":                  http.StatusNotFound,
+				"/ipxe/missing.efi":               http.StatusNotFound,
+			},
+		},
+		{
+			name:   "prefix without slashes",
+			prefix: "boot/ipxe",
+			want:   map[string]int{"/boot/ipxe/snp.efi": http.StatusOK, "/boot/snp.efi": http.StatusNotFound},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &Server{Log: logr.Discard(), HTTPPathPrefix: tt.prefix}
+			ctx, cancel := context.WithCancel(context.Background())
+			errChan := make(chan error, 1)
+			go func() { errChan <- c.serveHTTP(ctx, l) }()
+			defer func() {
+				cancel()
+				<-errChan
+			}()
+			got := make(map[string]int)
+			for p := range tt.want {
+				resp, err := http.Get(fmt.Sprintf("http://%v%v", l.Addr(), p))
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				got[p] = resp.StatusCode
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+// TestServeHTTPPaths checks how the paths that don't name a file are answered: the http.ServeMux redirects the paths
+// with runs of slashes to their clean form before the iPXE handler is called, which answers the directories with a 404.
+func TestServeHTTPPaths(t *testing.T) {
+	tests := []struct {
+		name         string
+		prefix       string
+		path         string
+		wantStatus   int
+		wantLocation string
+	}{
+		{name: "file", path: "/ipxe.efi", wantStatus: http.StatusOK},
+		{name: "leading slashes", path: "//ipxe.efi", wantStatus: http.StatusMovedPermanently, wantLocation: "/ipxe.efi"},
+		{name: "slashes after mac", path: "/0a:00:27:00:00:02//ipxe.efi", wantStatus: http.StatusMovedPermanently, wantLocation: "/0a:00:27:00:00:02/ipxe.efi"},
+		{name: "slashes after prefix", prefix: "/ipxe/", path: "/ipxe//ipxe.efi", wantStatus: http.StatusMovedPermanently, wantLocation: "/ipxe/ipxe.efi"},
+		{name: "trailing slash", path: "/ipxe.efi/", wantStatus: http.StatusNotFound},
+		{name: "root", path: "/", wantStatus: http.StatusNotFound},
+		{name: "prefix root", prefix: "/ipxe/", path: "/ipxe/", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &Server{Log: logr.Discard(), HTTPPathPrefix: tt.prefix}
+			ctx, cancel := context.WithCancel(context.Background())
+			errChan := make(chan error, 1)
+			go func() { errChan <- c.serveHTTP(ctx, l) }()
+			defer func() {
+				cancel()
+				<-errChan
+			}()
+			hc := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+			resp, err := hc.Get(fmt.Sprintf("http://%v%v", l.Addr(), tt.path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus || resp.Header.Get("Location") != tt.wantLocation {
+				t.Fatalf("expected %v %q, got: %v %q", tt.wantStatus, tt.wantLocation, resp.StatusCode, resp.Header.Get("Location"))
+			}
+		})
+	}
+}
+
+func TestOnProgress(t *testing.T) {
+	ready := make(chan struct{}, 2)
+	addrs := make(map[Protocol]net.Addr)
+	var mu sync.Mutex
+	got := make(map[Protocol][]int64)
+	totals := make(map[Protocol]int64)
+	c := &Server{
+		Log:      logr.Discard(),
+		Progress: progress.Throttle{Bytes: 16 * 1024, Interval: time.Hour},
+		OnProgress: func(p Protocol, _ netaddr.IP, _ string, sent, total int64) {
+			mu.Lock()
+			got[p] = append(got[p], sent)
+			totals[p] = total
+			mu.Unlock()
+		},
+		OnReady: func(p Protocol, addr net.Addr) {
+			mu.Lock()
+			addrs[p] = addr
+			mu.Unlock()
+			ready <- struct{}{}
+		},
+	}
+	conn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Serve(ctx, conn, uconn) }()
+	defer func() {
+		cancel()
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}()
+	<-ready
+	<-ready
+	mu.Lock()
+	httpAddr, tftpAddr := addrs[ProtocolHTTP].String(), addrs[ProtocolTFTP].String()
+	mu.Unlock()
+
+	tftpGet(t, tftpAddr, "undionly.kpxe")
+	httpGet(t, httpAddr, "snp.efi")
+
+	want := map[Protocol]int64{ProtocolHTTP: int64(len(binary.SNP)), ProtocolTFTP: int64(len(binary.Undionly))}
+	// the TFTP transfer finishes server side after the client receives the last block.
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		mu.Lock()
+		l := got[ProtocolTFTP]
+		done := len(l) > 0 && l[len(l)-1] == want[ProtocolTFTP]
+		mu.Unlock()
+		if done {
+			break
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for p, size := range want {
+		l := got[p]
+		if len(l) < 2 {
+			t.Fatalf("%v: expected several progress calls, got: %v", p, l)
+		}
+		for i := 1; i < len(l); i++ {
+			if l[i] < l[i-1] {
+				t.Fatalf("%v: expected monotonically increasing byte counts, got: %v", p, l)
+			}
+		}
+		if l[len(l)-1] != size || totals[p] != size {
+			t.Fatalf("%v: expected transfer of %v bytes to complete, got: %v of %v", p, size, l[len(l)-1], totals[p])
+		}
+	}
+}
+
+func TestServeOCIReference(t *testing.T) {
+	content := []byte("snp.efi from an OCI artifact")
+	sum := sha256.Sum256(content)
+	d := "sha256:" + hex.EncodeToString(sum[:])
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/ipxe/manifests/v1":
+			fmt.Fprintf(w, `{"layers": [{"mediaType": "application/octet-stream", "digest": %q, "size": %v, "annotations": {"org.opencontainers.image.title": "snp.efi"}}]}`, d, len(content))
+		case "/v2/ipxe/blobs/" + d:
+			_, _ = w.Write(content)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer reg.Close()
+	ref := strings.TrimPrefix(reg.URL, "http://") + "/ipxe:v1"
+
+	t.Run("pulled", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &Server{Log: logr.Discard(), OCIReference: ref, OCIPlainHTTP: true, TFTP: ServerSpec{Disabled: true}}
+		ctx, cancel := context.WithCancel(context.Background())
+		errChan := make(chan error, 1)
+		go func() { errChan <- c.Serve(ctx, l, nil) }()
+		defer func() {
+			cancel()
+			if err := <-errChan; err != nil {
+				t.Fatal(err)
+			}
+		}()
+		var got []byte
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			resp, err := http.Get(fmt.Sprintf("http://%v/snp.efi", l.Addr()))
+			if err != nil {
+				continue
+			}
+			got, _ = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			break
+		}
+		if diff := cmp.Diff(got, content); diff != "" {
+			t.Fatal(diff)
+		}
+		if diff := cmp.Diff(httpGet(t, l.Addr().String(), "ipxe.efi"), binary.Files["ipxe.efi"]); diff != "" {
+			t.Fatal("expected the embedded binaries that are not in the artifact to be served", diff)
+		}
+	})
+	t.Run("pull failure", func(t *testing.T) {
+		c := &Server{OCIReference: strings.TrimPrefix(reg.URL, "http://") + "/ipxe:missing", OCIPlainHTTP: true}
+		if err := c.start(context.Background(), Server{Log: logr.Discard()}); !errors.Is(err, oci.ErrPull) {
+			t.Fatalf("expected %v, got: %v", oci.ErrPull, err)
+		}
+	})
+}
+
+func TestDynamicBinary(t *testing.T) {
+	ready := make(chan struct{}, 2)
+	addrs := make(map[Protocol]net.Addr)
+	var mu sync.Mutex
+	c := &Server{
+		Log: logr.Discard(),
+		DynamicBinary: func(_ context.Context, client netaddr.IPPort, name string) ([]byte, bool, error) {
+			if name != "snp.efi" {
+				return nil, false, nil
+			}
+			return []byte(fmt.Sprintf("snp.efi built for %v", client.IP())), true, nil
+		},
+		OnReady: func(p Protocol, addr net.Addr) {
+			mu.Lock()
+			addrs[p] = addr
+			mu.Unlock()
+			ready <- struct{}{}
+		},
+	}
+	conn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Serve(ctx, conn, uconn) }()
+	defer func() {
+		cancel()
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}()
+	<-ready
+	<-ready
+	mu.Lock()
+	httpAddr, tftpAddr := addrs[ProtocolHTTP].String(), addrs[ProtocolTFTP].String()
+	mu.Unlock()
+
+	want := []byte("snp.efi built for 127.0.0.1")
+	if diff := cmp.Diff(httpGet(t, httpAddr, "snp.efi"), want); diff != "" {
+		t.Fatal("http:", diff)
+	}
+	if diff := cmp.Diff(tftpGet(t, tftpAddr, "0a:00:27:00:00:02/snp.efi"), want); diff != "" {
+		t.Fatal("tftp:", diff)
+	}
+	// files the generator declines are served as usual.
+	if diff := cmp.Diff(httpGet(t, httpAddr, "ipxe.efi"), binary.Files["ipxe.efi"]); diff != "" {
+		t.Fatal("http:", diff)
+	}
+	if diff := cmp.Diff(tftpGet(t, tftpAddr, "undionly.kpxe"), binary.Files["undionly.kpxe"]); diff != "" {
+		t.Fatal("tftp:", diff)
+	}
+}
+
+func TestDynamicBinaryCache(t *testing.T) {
+	var calls int
+	c := &Server{
+		DynamicBinary: func(_ context.Context, client netaddr.IPPort, name string) ([]byte, bool, error) {
+			calls++
+			return []byte(name), true, nil
+		},
+		DynamicBinaryCache: resolve.CacheConfig{MaxEntries: 10},
+	}
+	if err := c.start(context.Background(), Server{Log: logr.Discard()}); err != nil {
+		t.Fatal(err)
+	}
+	client := netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), 1000)
+	for i := 0; i < 2; i++ {
+		if _, err := c.resolver().Resolve(context.Background(), client, "snp.efi"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.PurgeDynamicBinaryCache()
+	if _, err := c.resolver().Resolve(context.Background(), client, "snp.efi"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 calls, got: %v", calls)
+	}
+}
+
+func TestDefaultAliases(t *testing.T) {
+	tests := map[string][]byte{
+		"bootx64.efi":     binary.IpxeEFI,
+		"x86_64.efi":      binary.IpxeEFI,
+		"snponly.efi":     binary.IpxeEFI,
+		"ipxe-x86_64.efi": binary.IpxeEFI,
+		"bootaa64.efi":    binary.SNP,
+		"arm64.efi":       binary.SNP,
+		"ipxe-arm64.efi":  binary.SNP,
+		"undionly.kkpxe":  binary.Undionly,
+		"undionly.pxe":    binary.Undionly,
+		// Aliases take precedence over the defaults.
+		"custom.efi":   binary.SNP,
+		"bootia32.efi": nil,
+	}
+	c := &Server{UseDefaultAliases: true, Aliases: map[string]string{"custom.efi": "snp.efi", "x86_64.efi": "ipxe.efi"}}
+	if err := c.start(context.Background(), Server{Log: logr.Discard()}); err != nil {
+		t.Fatal(err)
+	}
+	client := netaddr.IPPortFrom(netaddr.IPv4(192, 168, 2, 10), 68)
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := c.resolver().Resolve(context.Background(), client, name)
+			if want == nil {
+				if !errors.Is(err, os.ErrNotExist) {
+					t.Fatalf("expected %v, got: %v", os.ErrNotExist, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatal("unexpected binary served")
+			}
+		})
+	}
+	if len(tests)-2 != len(binary.DefaultAliases) {
+		t.Fatalf("expected every default alias to be tested, got: %v of %v", len(tests)-2, len(binary.DefaultAliases))
+	}
+}
+
+func TestAliasesDisabled(t *testing.T) {
+	c := &Server{}
+	if err := c.start(context.Background(), Server{Log: logr.Discard()}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.resolver().Resolve(context.Background(), netaddr.IPPortFrom(netaddr.IPv4(192, 168, 2, 10), 68), "bootx64.efi")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected %v, got: %v", os.ErrNotExist, err)
+	}
+}
+
+func TestFileSystems(t *testing.T) {
+	override := t.TempDir()
+	if err := os.WriteFile(filepath.Join(override, "snp.efi"), []byte("override"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	shared := fstest.MapFS{
+		"snp.efi":   {Data: []byte("shared")},
+		"boot.ipxe": {Data: []byte("#!ipxe\nautoboot\n")},
+	}
+	tests := map[string][]byte{
+		"snp.efi":       []byte("override"),
+		"boot.ipxe":     []byte("#!ipxe\nautoboot\n"),
+		"ipxe.efi":      binary.IpxeEFI,
+		"undionly.kpxe": binary.Undionly,
+		"missing.efi":   nil,
+	}
+	c := &Server{FileSystems: []fs.FS{os.DirFS(override), shared}}
+	if err := c.start(context.Background(), Server{Log: logr.Discard()}); err != nil {
+		t.Fatal(err)
+	}
+	client := netaddr.IPPortFrom(netaddr.IPv4(192, 168, 2, 10), 68)
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := c.resolver().Resolve(context.Background(), client, name)
+			if want == nil {
+				if !errors.Is(err, os.ErrNotExist) {
+					t.Fatalf("expected %v, got: %v", os.ErrNotExist, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("unexpected content served: %.20q", got)
+			}
+		})
+	}
+}
+
+func TestMissingFileScript(t *testing.T) {
+	script := []byte("#!ipxe\necho no boot script\nreboot\n")
+	ready := make(chan net.Addr, 2)
+	c := &Server{
+		Log:               logr.Discard(),
+		MissingFileScript: script,
+		OnReady:           func(_ Protocol, addr net.Addr) { ready <- addr },
+	}
+	conn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpAddr, tftpAddr := conn.Addr().String(), uconn.LocalAddr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Serve(ctx, conn, uconn) }()
+	<-ready
+	<-ready
+
+	if got := httpGet(t, httpAddr, "boot.ipxe"); !bytes.Equal(got, script) {
+		t.Fatalf("HTTP: expected the missing file script, got: %q", got)
+	}
+	if got := tftpGet(t, tftpAddr, "boot.ipxe"); !bytes.Equal(got, script) {
+		t.Fatalf("TFTP: expected the missing file script, got: %q", got)
+	}
+	if got := httpGet(t, httpAddr, "ipxe.efi"); !bytes.Equal(got, binary.IpxeEFI) {
+		t.Fatal("HTTP: expected the binary to be served")
+	}
+	resp, err := http.Get(fmt.Sprintf("http://%v/missing.efi", httpAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("HTTP: expected a missing binary to be not found, got: %v", resp.StatusCode)
+	}
+	if _, err := tftpReceive(tftpAddr, "missing.efi"); err == nil {
+		t.Fatal("TFTP: expected a missing binary to be not found")
+	}
+
+	cancel()
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOnFirstServe(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[Protocol]int{}
+	ready := make(chan net.Addr, 2)
+	c := &Server{
+		Log:     logr.Discard(),
+		OnReady: func(_ Protocol, addr net.Addr) { ready <- addr },
+		OnFirstServe: func(p Protocol) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls[p]++
+		},
+	}
+	conn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpAddr, tftpAddr := conn.Addr().String(), uconn.LocalAddr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Serve(ctx, conn, uconn) }()
+	<-ready
+	<-ready
+
+	// A failed request is not served.
+	if _, err := tftpReceive(tftpAddr, "missing.efi"); err == nil {
+		t.Fatal("TFTP: expected a missing binary to be not found")
+	}
+	mu.Lock()
+	if diff := cmp.Diff(map[Protocol]int{}, calls); diff != "" {
+		t.Fatal(diff)
+	}
+	mu.Unlock()
+
+	const n = 8
+	// a connection per request, no spare connection left in the new state, which shutting down waits for.
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*n)
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(fmt.Sprintf("http://%v/undionly.kpxe", httpAddr))
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer resp.Body.Close()
+			if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+				errs <- err
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := tftpReceive(tftpAddr, "undionly.kpxe"); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	cancel()
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	want := map[Protocol]int{ProtocolHTTP: 1, ProtocolTFTP: 1}
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff(want, calls); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestDefaultEFIBinary(t *testing.T) {
+	ready := make(chan net.Addr, 2)
+	c := &Server{
+		Log:              logr.Discard(),
+		DefaultEFIBinary: "ipxe.efi",
+		OnReady:          func(_ Protocol, addr net.Addr) { ready <- addr },
+	}
+	conn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpAddr, tftpAddr := conn.Addr().String(), uconn.LocalAddr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Serve(ctx, conn, uconn) }()
+	<-ready
+	<-ready
+
+	if got := httpGet(t, httpAddr, "grubx64.efi"); !bytes.Equal(got, binary.IpxeEFI) {
+		t.Fatal("HTTP: expected the default EFI binary")
+	}
+	if got := tftpGet(t, tftpAddr, "grubx64.efi"); !bytes.Equal(got, binary.IpxeEFI) {
+		t.Fatal("TFTP: expected the default EFI binary")
+	}
+	if got := httpGet(t, httpAddr, "snp.efi"); !bytes.Equal(got, binary.Files["snp.efi"]) {
+		t.Fatal("HTTP: expected the known binary to be served")
+	}
+	if got := tftpGet(t, tftpAddr, "snp.efi"); !bytes.Equal(got, binary.Files["snp.efi"]) {
+		t.Fatal("TFTP: expected the known binary to be served")
+	}
+	resp, err := http.Get(fmt.Sprintf("http://%v/missing.kpxe", httpAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("HTTP: expected a missing binary that isn't an EFI binary to be not found, got: %v", resp.StatusCode)
+	}
+	if _, err := tftpReceive(tftpAddr, "missing.kpxe"); err == nil {
+		t.Fatal("TFTP: expected a missing binary that isn't an EFI binary to be not found")
+	}
+
+	cancel()
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDefaultEFIBinaryInvalid(t *testing.T) {
+	for _, name := range []string{"undionly.kpxe", "efi/ipxe.efi"} {
+		c := &Server{Log: logr.Discard(), DefaultEFIBinary: name}
+		if err := c.start(context.Background(), listenAndServeDefaults()); err == nil {
+			t.Fatalf("expected an error for %q", name)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Package ipxedusttest runs an ipxedust.Server on ephemeral loopback ports for end-to-end tests, and downloads files
+// from it with real TFTP and HTTP clients, like net/http/httptest does for HTTP handlers.
+package ipxedusttest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pin/tftp"
+	"github.com/tinkerbell/ipxedust"
+)
+
+// ReadyTimeout bounds the wait for the protocols of a Server to be ready to serve.
+const ReadyTimeout = 10 * time.Second
+
+// httpClient doesn't keep connections alive, so that the server is closed without waiting for idle connections.
+var httpClient = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+// Server is an ipxedust.Server serving on ephemeral loopback ports.
+type Server struct {
+	// Server is the server serving, it must not be modified.
+	*ipxedust.Server
+	// HTTPAddr is the address HTTP is served at, for example "127.0.0.1:41234". It is empty when HTTP is disabled.
+	HTTPAddr string
+	// TFTPAddr is the address TFTP is served at. It is empty when TFTP is disabled.
+	TFTPAddr string
+
+	cancel    context.CancelFunc
+	errc      chan error
+	closeOnce sync.Once
+	err       error
+}
+
+// NewServer serves c, with ipxedust.Server.Serve, on ephemeral loopback ports and returns once the enabled protocols
+// are ready to serve. The server is closed when tb and its subtests complete, or by Close. c.OnReady is still called.
+// A failure to start fails tb.
+func NewServer(tb testing.TB, c *ipxedust.Server) *Server {
+	tb.Helper()
+	s := &Server{Server: c, errc: make(chan error, 1)}
+	var l net.Listener
+	var conn net.PacketConn
+	var err error
+	if !c.HTTP.Disabled {
+		if l, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			tb.Fatal(err)
+		}
+		s.HTTPAddr = l.Addr().String()
+	}
+	if !c.TFTP.Disabled {
+		if conn, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			if l != nil {
+				l.Close()
+			}
+			tb.Fatal(err)
+		}
+		s.TFTPAddr = conn.LocalAddr().String()
+	}
+	ready := make(chan ipxedust.Protocol, 2)
+	onReady := c.OnReady
+	c.OnReady = func(p ipxedust.Protocol, addr net.Addr) {
+		if onReady != nil {
+			onReady(p, addr)
+		}
+		select {
+		case ready <- p:
+		default:
+			// a protocol enabled again.
+		}
+	}
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	go func() { s.errc <- c.Serve(ctx, l, conn) }()
+	tb.Cleanup(func() {
+		if err := s.Close(); err != nil {
+			tb.Error(err)
+		}
+	})
+
+	timeout := time.NewTimer(ReadyTimeout)
+	defer timeout.Stop()
+	want := 0
+	if !c.HTTP.Disabled {
+		want++
+	}
+	if !c.TFTP.Disabled {
+		want++
+	}
+	for ; want > 0; want-- {
+		select {
+		case <-ready:
+		case err := <-s.errc:
+			s.errc <- err
+			tb.Fatalf("serving failed: %v", err)
+		case <-timeout.C:
+			tb.Fatalf("timed out waiting for the server to be ready after %v", ReadyTimeout)
+		}
+	}
+	return s
+}
+
+// Close stops serving and returns the error serving returned. It is safe to call more than once.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		s.cancel()
+		s.err = <-s.errc
+	})
+	return s.err
+}
+
+// TFTPGet downloads filename over TFTP, in octet mode.
+func (s *Server) TFTPGet(filename string) ([]byte, error) {
+	if s.TFTPAddr == "" {
+		return nil, errors.New("TFTP is disabled")
+	}
+	c, err := tftp.NewClient(s.TFTPAddr)
+	if err != nil {
+		return nil, err
+	}
+	wt, err := c.Receive(filename, "octet")
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := wt.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// HTTPGet downloads the file at path over HTTP, for example "ipxe.efi", or "/ipxe/ipxe.efi" with a path prefix.
+// A response other than a 200 is an error.
+func (s *Server) HTTPGet(path string) ([]byte, error) {
+	if s.HTTPAddr == "" {
+		return nil, errors.New("HTTP is disabled")
+	}
+	resp, err := httpClient.Get(s.URL(path))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %v: %v", path, resp.Status)
+	}
+	return b, nil
+}
+
+// URL returns the HTTP URL of path.
+func (s *Server) URL(path string) string {
+	return "http://" + s.HTTPAddr + "/" + strings.TrimPrefix(path, "/")
+}
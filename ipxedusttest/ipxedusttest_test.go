@@ -0,0 +1,53 @@
+package ipxedusttest_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/ipxedusttest"
+)
+
+func TestServer(t *testing.T) {
+	s := ipxedusttest.NewServer(t, &ipxedust.Server{Log: logr.Discard()})
+	for name, want := range binary.Files {
+		got, err := s.TFTPGet(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%v: TFTP content mismatch", name)
+		}
+		if got, err = s.HTTPGet(name); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%v: HTTP content mismatch", name)
+		}
+	}
+	if _, err := s.HTTPGet("missing.efi"); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerHTTPOnly(t *testing.T) {
+	s := ipxedusttest.NewServer(t, &ipxedust.Server{Log: logr.Discard(), TFTP: ipxedust.ServerSpec{Disabled: true}})
+	if s.TFTPAddr != "" {
+		t.Fatalf("expected no TFTP address, got: %v", s.TFTPAddr)
+	}
+	if _, err := s.TFTPGet("ipxe.efi"); err == nil {
+		t.Fatal("expected an error downloading over TFTP")
+	}
+	got, err := s.HTTPGet("/ipxe.efi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, binary.Files["ipxe.efi"]) {
+		t.Fatal("content mismatch")
+	}
+}
@@ -0,0 +1,87 @@
+package itftp
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pin/tftp"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/capture"
+	"github.com/tinkerbell/ipxedust/resolve"
+)
+
+func TestHandleReadCapture(t *testing.T) {
+	dir := t.TempDir()
+	// the record is written once the server sees the transfer end, which may be after the client does.
+	recorded := make(chan struct{}, 1)
+	h := Handler{Log: logr.Discard(), Resolver: resolve.Resolver{}, Capture: capture.New(capture.Config{Dir: dir, OnRecord: func(capture.Record) { recorded <- struct{}{} }})}
+	s := h.NewServer()
+	hook := newServingHook(h)
+	s.SetHook(hook)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.Serve(conn) }()
+	defer s.Shutdown()
+	// github.com/pin/tftp grants blocks of at most 512 bytes to requests read before its serve loop runs.
+	hook.wait(t, conn.LocalAddr())
+
+	c, err := tftp.NewClient(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetBlockSize(1024)
+	c.RequestTSize(true)
+	wt, err := c.Receive("snp.efi", "octet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := wt.WriteTo(io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-recorded:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the transfer to be captured")
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected a single capture, got: %v", files)
+	}
+	rec, err := capture.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(binary.Files["snp.efi"]))
+	got := []interface{}{rec.Client, rec.Filename, rec.Mode, rec.Options, rec.Size, rec.BytesSent, rec.Error}
+	want := []interface{}{"127.0.0.1", "snp.efi", "octet", map[string]string{"blksize": "1024", "tsize": strconv.FormatInt(size, 10)}, size, n, ""}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatal(diff)
+	}
+	if n != size {
+		t.Fatalf("expected %v bytes to be received, got: %v", size, n)
+	}
+	// the content is read a block at a time, in order.
+	var offset int64
+	for i, e := range rec.Events {
+		if e.Offset != offset || e.Bytes > 1024 {
+			t.Fatalf("event %v: expected a read of a block at offset %v, got: %+v", i, offset, e)
+		}
+		offset += int64(e.Bytes)
+	}
+	if offset != size || len(rec.Events) < int(size/1024) {
+		t.Fatalf("expected the events to cover the %v bytes in blocks, got: %v bytes in %v events", size, offset, len(rec.Events))
+	}
+}
@@ -0,0 +1,127 @@
+package itftp
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tinkerbell/ipxedust/clock"
+)
+
+// MaxClientIDLength is the maximum length of a client identifier read from a request option, see ClientIDs.
+// Longer values are truncated.
+const MaxClientIDLength = 256
+
+// ClientIDTTL is how long the client identifier of a read request is kept for the transfer of the request, and for
+// the transfers of its retransmissions, see ClientIDs.
+const ClientIDTTL = time.Minute
+
+// ClientIDs reads the identifiers of the clients, set by a cooperating DHCP server or proxy, from an option of their
+// read requests, like the X-Machine-ID header of HTTP requests. HandleRead logs the identifier of a request as
+// "clientID" and passes it to the Resolver hooks in the context, see resolve.ClientID.
+// github.com/pin/tftp doesn't pass the options of a request to its read handler, so they are read from the conn of
+// the server, see Conn. The option is not acknowledged. A nil *ClientIDs reads none. It is safe for concurrent use.
+type ClientIDs struct {
+	option string
+	clock  clock.Clock
+
+	mu sync.Mutex
+	// ids maps the address of the clients that sent a read request with the option to its value.
+	ids map[string]clientID
+	// pruned is the last time the identifiers older than ClientIDTTL were dropped.
+	pruned time.Time
+}
+
+// clientID is the identifier of a client and the time its read request was received.
+type clientID struct {
+	id string
+	at time.Time
+}
+
+// NewClientIDs returns ClientIDs reading the option, in any case, from the read requests, timed on c, or the real
+// clock when c is nil. An empty option reads none, for which nil is returned.
+func NewClientIDs(option string, c clock.Clock) *ClientIDs {
+	if option == "" {
+		return nil
+	}
+	return &ClientIDs{option: option, clock: clock.Or(c), ids: make(map[string]clientID)}
+}
+
+// Conn returns conn, the request conn of the TFTP server, recording the client identifiers of the read requests
+// read from it. github.com/pin/tftp reads the returned conn like any conn that is not a *net.UDPConn, see
+// OptionsConn: its transfers are sent from the address picked by routing, in blocks of at most 512 bytes.
+func (c *ClientIDs) Conn(conn net.PacketConn) net.PacketConn {
+	if c == nil {
+		return conn
+	}
+	return clientIDConn{PacketConn: conn, c: c}
+}
+
+// Lookup returns the identifier of the client at addr, from its last read request, and reports whether it sent one.
+func (c *ClientIDs) Lookup(addr net.UDPAddr) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cid, ok := c.ids[addr.String()]
+	if !ok || c.clock.Now().Sub(cid.at) >= ClientIDTTL {
+		return "", false
+	}
+	return cid.id, true
+}
+
+// record records the identifier of the client at addr when p is a read request, or forgets it when the request
+// doesn't have the option.
+func (c *ClientIDs) record(p []byte, addr net.Addr) {
+	if len(p) < 4 || binary.BigEndian.Uint16(p) != opRRQ || p[len(p)-1] != 0 {
+		return
+	}
+	// the file name, the mode, and the names and values of the options, each followed by a NUL.
+	fields := strings.Split(string(p[2:len(p)-1]), "\x00")
+	if len(fields) < 2 || len(fields)%2 != 0 {
+		return
+	}
+	id := ""
+	for i := 2; i < len(fields); i += 2 {
+		if strings.EqualFold(fields[i], c.option) {
+			id = strings.TrimSpace(fields[i+1])
+		}
+	}
+	if len(id) > MaxClientIDLength {
+		id = id[:MaxClientIDLength]
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	if now.Sub(c.pruned) >= ClientIDTTL {
+		for a, cid := range c.ids {
+			if now.Sub(cid.at) >= ClientIDTTL {
+				delete(c.ids, a)
+			}
+		}
+		c.pruned = now
+	}
+	if id == "" {
+		delete(c.ids, addr.String())
+		return
+	}
+	c.ids[addr.String()] = clientID{id: id, at: now}
+}
+
+// clientIDConn records the client identifiers of the read requests read from the PacketConn in c.
+type clientIDConn struct {
+	net.PacketConn
+	c *ClientIDs
+}
+
+// ReadFrom reads a packet into p, recording the client identifier when it is a read request.
+func (c clientIDConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	if err == nil {
+		c.c.record(p[:n], addr)
+	}
+	return n, addr, err
+}
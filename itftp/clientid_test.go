@@ -0,0 +1,113 @@
+package itftp
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/tinkerbell/ipxedust/clock"
+	"github.com/tinkerbell/ipxedust/resolve"
+	"inet.af/netaddr"
+)
+
+func TestClientIDsLookup(t *testing.T) {
+	client := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}
+	tests := []struct {
+		name    string
+		option  string
+		packets [][]byte
+		advance time.Duration
+		want    string
+		wantOK  bool
+	}{
+		{name: "option", option: "machine-id", packets: [][]byte{rrq("snp.efi", "octet", "machine-id", "m-1")}, want: "m-1", wantOK: true},
+		{name: "any case", option: "Machine-ID", packets: [][]byte{rrq("snp.efi", "octet", "blksize", "1468", "MACHINE-id", " m-1 ")}, want: "m-1", wantOK: true},
+		{name: "truncated", option: "machine-id", packets: [][]byte{rrq("snp.efi", "octet", "machine-id", strings.Repeat("m", MaxClientIDLength+1))}, want: strings.Repeat("m", MaxClientIDLength), wantOK: true},
+		{name: "no option", option: "machine-id", packets: [][]byte{rrq("snp.efi", "octet")}},
+		{name: "empty", option: "machine-id", packets: [][]byte{rrq("snp.efi", "octet", "machine-id", "")}},
+		{name: "forgotten by a request without the option", option: "machine-id", packets: [][]byte{rrq("snp.efi", "octet", "machine-id", "m-1"), rrq("snp.efi", "octet")}},
+		{name: "not a read request", option: "machine-id", packets: [][]byte{{0, opACK, 0, 1}}},
+		{name: "expired", option: "machine-id", packets: [][]byte{rrq("snp.efi", "octet", "machine-id", "m-1")}, advance: ClientIDTTL},
+		{name: "disabled", packets: [][]byte{rrq("snp.efi", "octet", "machine-id", "m-1")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := clock.NewFake(time.Unix(0, 0))
+			c := NewClientIDs(tt.option, fake)
+			for _, p := range tt.packets {
+				if c != nil {
+					c.record(p, client)
+				}
+			}
+			fake.Advance(tt.advance)
+			got, ok := c.Lookup(*client)
+			if got != tt.want || ok != tt.wantOK {
+				t.Fatalf("expected %q, %v, got: %q, %v", tt.want, tt.wantOK, got, ok)
+			}
+		})
+	}
+}
+
+func TestHandleReadClientID(t *testing.T) {
+	var mu sync.Mutex
+	var logged []string
+	var gotID string
+	served := make(chan struct{})
+	h := Handler{
+		Log: funcr.New(func(_, args string) {
+			mu.Lock()
+			defer mu.Unlock()
+			logged = append(logged, args)
+		}, funcr.Options{}),
+		ClientIDs: NewClientIDs("machine-id", nil),
+		Resolver: resolve.Resolver{Dynamic: func(ctx context.Context, _ netaddr.IPPort, _ string) ([]byte, bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			gotID, _ = resolve.ClientID(ctx)
+			return []byte("custom"), true, nil
+		}},
+		OnServed: func(netaddr.IP, net.HardwareAddr, string, int64) { close(served) },
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := h.NewServer()
+	hook := newServingHook(h)
+	s.SetHook(hook)
+	go func() { _ = s.Serve(h.ClientIDs.Conn(conn)) }()
+	// Shutdown races with Serve populating its conn until the serve loop runs.
+	hook.wait(t, conn.LocalAddr())
+	defer s.Shutdown()
+
+	c := newUDPClient(t)
+	c.send(rrq("custom.efi", "octet", "machine-id", "m-1"), conn.LocalAddr())
+	p, transfer := c.read()
+	if binary.BigEndian.Uint16(p) != opDATA || string(p[4:]) != "custom" {
+		t.Fatalf("expected the file, got: %q", p)
+	}
+	c.send(ack(1), transfer)
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the transfer to complete")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if gotID != "m-1" {
+		t.Fatalf("expected the Resolver to get the client ID, got: %q", gotID)
+	}
+	found := false
+	for _, l := range logged {
+		found = found || strings.Contains(l, `"clientID"="m-1"`)
+	}
+	if !found {
+		t.Fatalf("expected the client ID to be logged, got: %v", logged)
+	}
+}
@@ -0,0 +1,46 @@
+package itftp
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+func TestHandleReadNotEmbedded(t *testing.T) {
+	snp := binary.Files["snp.efi"]
+	binary.Files["snp.efi"] = []byte{}
+	defer func() { binary.Files["snp.efi"] = snp }()
+	tests := []struct {
+		name     string
+		filename string
+		want     []string
+		wantNot  string
+	}{
+		{name: "known but not embedded", filename: "snp.efi", want: []string{`"msg"="warning: requested iPXE binary not compiled into this build"`, `"available"=["ipxe.efi","undionly.kpxe"]`}, wantNot: `"msg"="file unknown"`},
+		{name: "unknown", filename: "unknown.efi", want: []string{`"msg"="file unknown"`}, wantNot: `"msg"="warning: requested iPXE binary not compiled into this build"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged []string
+			h := Handler{Log: funcr.New(func(_, args string) { logged = append(logged, args) }, funcr.Options{})}
+			rf := &readAllReaderFrom{fakeReaderFrom: fakeReaderFrom{addr: net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}}}
+			if err := h.HandleRead(tt.filename, rf); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("expected the file not to be found, got: %v", err)
+			}
+			all := strings.Join(logged, "\n")
+			for _, want := range tt.want {
+				if !strings.Contains(all, want) {
+					t.Fatalf("expected %v logged, got: %v", want, logged)
+				}
+			}
+			if strings.Contains(all, tt.wantNot) {
+				t.Fatalf("expected %v not logged, got: %v", tt.wantNot, logged)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package itftp
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/filename"
+	"github.com/tinkerbell/ipxedust/ratelimit"
+)
+
+func TestHandleReadFilenames(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   filename.Policy
+		filename string
+		wantErr  error
+	}{
+		{name: "valid", filename: "snp.efi"},
+		{name: "mac directory", filename: "0a:00:27:00:00:02/snp.efi"},
+		{name: "too long", filename: strings.Repeat("a", filename.DefaultMaxLength) + ".efi", wantErr: filename.ErrInvalid},
+		{name: "custom max length", policy: filename.Policy{MaxLength: 6}, filename: "snp.efi", wantErr: filename.ErrInvalid},
+		{name: "space", filename: "snp .efi", wantErr: filename.ErrInvalid},
+		{name: "control character", filename: "snp.efi\n", wantErr: filename.ErrInvalid},
+		{name: "backslash", filename: `..\snp.efi`, wantErr: filename.ErrInvalid},
+		{name: "custom punctuation", policy: filename.Policy{Punctuation: "./$"}, filename: "snp$.efi", wantErr: os.ErrNotExist},
+		{name: "disabled", policy: filename.Policy{Disabled: true}, filename: "snp .efi", wantErr: os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Handler{Log: logr.Discard(), Filenames: tt.policy}
+			rf := &readAllReaderFrom{fakeReaderFrom: fakeReaderFrom{addr: net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}}}
+			err := h.HandleRead(tt.filename, rf)
+			if tt.wantErr == nil && err != nil {
+				t.Fatal(err)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestHandleReadInvalidFilenameNotLimited(t *testing.T) {
+	client := net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}
+	h := Handler{
+		Log:       logr.Discard(),
+		Limiter:   ratelimit.New(ratelimit.Config{RequestsPerSecond: 0.001}),
+		Transfers: NewTransfers(1),
+		Workers:   NewWorkers(1, 0),
+	}
+	for i := 0; i < 3; i++ {
+		if err := h.HandleRead("snp .efi", &fakeReaderFrom{addr: client}); !errors.Is(err, filename.ErrInvalid) {
+			t.Fatalf("expected %v, got: %v", filename.ErrInvalid, err)
+		}
+	}
+	// the invalid names didn't take the only rate limit token.
+	if err := h.HandleRead("snp.efi", &fakeReaderFrom{addr: client, content: make([]byte, 1)}); err != nil {
+		t.Fatalf("expected the first valid request to be allowed, got: %v", err)
+	}
+}
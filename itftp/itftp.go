@@ -2,19 +2,31 @@
 package itftp
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"path"
-	"path/filepath"
 	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/pin/tftp"
+	"github.com/tinkerbell/ipxedust/allowlist"
+	"github.com/tinkerbell/ipxedust/bandwidth"
 	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/capture"
+	"github.com/tinkerbell/ipxedust/clock"
+	"github.com/tinkerbell/ipxedust/fault"
+	"github.com/tinkerbell/ipxedust/filename"
+	"github.com/tinkerbell/ipxedust/progress"
+	"github.com/tinkerbell/ipxedust/ratelimit"
+	"github.com/tinkerbell/ipxedust/resolve"
+	"github.com/tinkerbell/ipxedust/timing"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
@@ -22,9 +34,115 @@ import (
 	"inet.af/netaddr"
 )
 
+// CompressedSuffix is the suffix of the name of a gzip compressed variant of a file, for example "ipxe.efi.gz".
+// A request of a compressed variant that doesn't exist is served the uncompressed file, without the suffix.
+const CompressedSuffix = ".gz"
+
+var (
+	// ErrTransferDeadline is returned when a transfer exceeds Handler.MaxTransferDuration.
+	ErrTransferDeadline = errors.New("maximum transfer duration exceeded")
+	// ErrMaintenance is returned for requests received in maintenance mode.
+	ErrMaintenance = errors.New("server is in maintenance mode")
+	// ErrPaused is returned for requests received while the server is paused.
+	ErrPaused = errors.New("server is paused")
+	// ErrPanic is returned for a request whose handling panicked, for example in a hook.
+	ErrPanic = errors.New("internal error")
+)
+
 // Handler is the struct that implements the TFTP read and write function handlers.
 type Handler struct {
+	// Resolver resolves requested file names to content.
+	resolve.Resolver
+	// Hook injects faults into transfers, with the "faultinject" build tag only. See the fault package.
+	fault.Hook
 	Log logr.Logger
+	// ServedVerbosity is the V-level the files served are logged at, for example 1 to only log them when debugging a
+	// busy server. Zero logs them at info level. Failures are logged regardless.
+	ServedVerbosity int
+	// Allowed restricts the clients served to a set of IP addresses. A nil Allowed allows all clients.
+	Allowed *allowlist.Set
+	// Limiter rate limits requests. A nil Limiter allows all requests.
+	Limiter *ratelimit.Limiter
+	// Filenames limits the length and the characters of the requested file names, which fail the request when they
+	// are not valid, before they take a token of Limiter, one of Transfers or one of Workers, and are looked up.
+	Filenames filename.Policy
+	// Transfers caps concurrent transfers per client IP. A nil Transfers allows any number of transfers.
+	Transfers *Transfers
+	// ClientIDs reads the identifiers of the clients from an option of their read requests, logged as "clientID" with
+	// the request and passed to the Resolver hooks in the context, see resolve.ClientID. A nil ClientIDs reads none.
+	ClientIDs *ClientIDs
+	// Workers caps the transfers in progress, of all clients, with a bounded queue of transfers waiting to start.
+	// Requests arriving when the queue is full fail with ErrWorkersBusy. A nil Workers starts every transfer at once.
+	// The time spent in the queue is part of the queue phase, see OnTimings.
+	Workers *Workers
+	// Capture records the options and the timeline of the transfers, for debugging the quirks of the firmware of a
+	// client, see capture.Recorder. The options are added by the tftp.Hook of the Handler, see OnSuccess, so the
+	// Handler must be set as the hook of the server. A nil Capture records nothing.
+	Capture *capture.Recorder
+	// MaxTransferDuration aborts a transfer that takes longer than this, regardless of activity. Zero means no limit.
+	// The limit is checked each time a block is read for sending, so a transfer that is waiting for an
+	// acknowledgement is aborted at the latest when the idle timeout expires.
+	MaxTransferDuration time.Duration
+	// Clock measures MaxTransferDuration, ArtificialLatency, the phases of transfers and the retransmissions of
+	// multicast transfers. Defaults to the real clock.
+	Clock clock.Clock
+	// ArtificialLatency, when positive, delays every transfer by this much before it starts, to test the timeouts and
+	// retries of the clients against a slow server. It is a testing aid, see fault.Latency.
+	ArtificialLatency time.Duration
+	// OnServed, when not nil, is called after a file is served successfully.
+	// mac is the optional MAC address from the requested path, it is nil when there is none.
+	OnServed func(client netaddr.IP, mac net.HardwareAddr, filename string, bytesSent int64)
+	// OnProgress, when not nil, is called periodically during a transfer with the bytes sent so far
+	// and the total size, in the order the blocks are sent. The calls are throttled by Progress.
+	OnProgress func(client netaddr.IP, filename string, sent, total int64)
+	// Progress throttles the calls to OnProgress.
+	Progress progress.Throttle
+	// OnTimings, when not nil, is called after a file is served successfully with the time spent in each phase of the
+	// transfer, which are also logged with its outcome.
+	OnTimings func(client netaddr.IP, filename string, phases timing.Phases)
+	// OnTruncated, when not nil, is called after a transfer ended with fewer bytes sent than the size of the content,
+	// none included, for a reason other than an error on the server side, one of the Truncated constants. Such a
+	// transfer is logged as truncated, it is neither served nor failed. The bytes sent include the last block sent,
+	// whether or not the client acknowledged it.
+	OnTruncated func(client netaddr.IP, filename string, sent, size int64, reason string)
+	// Bandwidth throttles the rate files are sent at. A nil Bandwidth doesn't throttle.
+	Bandwidth *bandwidth.Limiter
+	// Maintenance, when not nil, reports whether the server is in maintenance mode.
+	// New requests are rejected with ErrMaintenance while it returns true, transfers in flight complete.
+	Maintenance func() bool
+	// Paused, when not nil, reports whether the server is paused, like a warm standby.
+	// New requests are rejected with ErrPaused while it returns true, transfers in flight complete.
+	Paused func() bool
+	// SelfTestName is the file name requested by the startup self-test of the server, when it is not empty. Its
+	// requests are not rate limited, captured or reported to the On hooks.
+	SelfTestName string
+	// Resume lets clients resume an interrupted transfer, by appending ResumeSeparator and the number of bytes they
+	// already received to the file name, for example "ipxe.efi@65536". The rest of the file is served, and the
+	// transfer size option is answered with its size. TFTP has no standard way to resume a transfer, so only clients
+	// following this convention, like scripted downloads with a TFTP client, can resume. Other requests are served
+	// the whole file. An offset past the end of the file fails the request with ErrInvalidOffset.
+	Resume bool
+	// DisabledOptions are the names of the TFTP options not acknowledged, even when requested, as if they weren't
+	// supported, to work around firmware that misbehaves when they are, for example "tsize". The handler doesn't
+	// acknowledge tsize itself. blksize and multicast are acknowledged before it is called, by github.com/pin/tftp
+	// and Multicast, they must also be removed from the requests, see OptionsConn.
+	DisabledOptions []string
+	// Stray rate limits the logs of stray packets received on the request port, see OnFailure. A nil Stray logs
+	// every stray packet.
+	Stray *StrayPackets
+	// Timeout is how long a transfer waits for the client to acknowledge a block before sending it again. The transfer
+	// is aborted when the retries are exhausted, so it bounds the time a transfer may be idle. Zero uses the default
+	// of github.com/pin/tftp, 5 seconds. The library applies a single timeout to all the transfers of a server,
+	// NewServer sets it from Timeout, a server created otherwise must be given Timeout with its SetTimeout method.
+	Timeout time.Duration
+}
+
+// NewServer returns a github.com/pin/tftp server handling requests with t, with the timeout of its transfers set
+// to Timeout.
+func (t Handler) NewServer() *tftp.Server {
+	s := tftp.NewServer(t.HandleRead, t.HandleWrite)
+	s.SetTimeout(t.Timeout)
+	return s
 }
 
 // ListenAndServe sets up the listener on the given address and serves TFTP requests.
@@ -46,28 +164,82 @@ func Serve(_ context.Context, conn net.PacketConn, s *tftp.Server) error {
 }
 
 // HandleRead handlers TFTP GET requests. The function signature satisfies the tftp.Server.readHandler parameter type.
-func (t Handler) HandleRead(filename string, rf io.ReaderFrom) error {
+// A panic while handling the request, for example in a hook, is logged and fails only this transfer, with ErrPanic.
+func (t Handler) HandleRead(filename string, rf io.ReaderFrom) (err error) {
 	client := net.UDPAddr{}
 	if rpi, ok := rf.(tftp.OutgoingTransfer); ok {
 		client = rpi.RemoteAddr()
 	}
+	defer wrapTransferError(&err, "get", filename, client)
+	defer t.recoverPanic("get", filename, &err)
+	sw := timing.Start(t.Clock)
 
 	full := filename
 	filename = path.Base(filename)
 	log := t.Log.WithValues("event", "get", "filename", filename, "uri", full, "client", client)
+	ctx := context.Background()
+	if id, ok := t.ClientIDs.Lookup(client); ok {
+		log = log.WithValues("clientID", id)
+		ctx = resolve.WithClientID(ctx, id)
+	}
+	if t.Maintenance != nil && t.Maintenance() {
+		log.Info("request rejected, in maintenance mode")
+		return ErrMaintenance
+	}
+	if t.Paused != nil && t.Paused() {
+		log.Info("request rejected, paused")
+		return ErrPaused
+	}
+	ip, _ := netaddr.FromStdIP(client.IP)
+	if !t.Allowed.Allow(ip) {
+		log.Info("request rejected, client not allowed")
+		return fmt.Errorf("%w: %v", allowlist.ErrNotAllowed, ip)
+	}
+	if err := t.Filenames.Check(full); err != nil {
+		log.Info("invalid file name requested", "error", err.Error())
+		return err
+	}
+	if full == t.SelfTestName && full != "" {
+		log = log.WithValues("selfTest", true)
+		t.OnServed, t.OnProgress, t.OnTimings, t.OnTruncated, t.Capture = nil, nil, nil, nil, nil
+	} else if ok, wait := t.Limiter.Allow(ip); !ok {
+		err := fmt.Errorf("%w, retry after %v", ratelimit.ErrLimited, wait)
+		log.Info("rate limit exceeded", "retryAfter", wait)
+		return err
+	}
+	if !t.Transfers.Acquire(ip) {
+		err := fmt.Errorf("%w from %v", ErrTooManyTransfers, ip)
+		log.Info("concurrent transfer limit reached", "active", t.Transfers.Count(ip))
+		return err
+	}
+	defer t.Transfers.Release(ip)
+	if !t.Workers.Acquire() {
+		err := fmt.Errorf("%w, %v transfers waiting", ErrWorkersBusy, t.Workers.Queued())
+		log.Info("request rejected, all workers busy", "busy", t.Workers.Busy(), "queued", t.Workers.Queued())
+		return err
+	}
+	defer t.Workers.Release()
+	sw.Queued()
 
 	// clients can send traceparent over TFTP by appending the traceparent string
 	// to the end of the filename they really want
 	longfile := filename // hang onto this to report in traces
-	ctx, shortfile, err := extractTraceparentFromFilename(context.Background(), filename)
+	ctx, shortfile, err := extractTraceparentFromFilename(ctx, filename)
 	if err != nil {
-		log.Error(err, "failed to extract traceparent from filename")
+		log.Info("ignoring invalid traceparent", "error", err.Error())
 	}
 	if shortfile != filename {
 		log = log.WithValues("shortfile", shortfile)
 		log.Info("traceparent found in filename", "filenameWithTraceparent", longfile)
 		filename = shortfile
 	}
+	var offset int64
+	if t.Resume {
+		if name, o, ok := splitResumeOffset(shortfile); ok {
+			log = log.WithValues("resumeOffset", o)
+			filename, shortfile, offset = name, name, o
+		}
+	}
 	// If a mac address is provided (0a:00:27:00:00:02/snp.efi), parse and log it.
 	// Mac address is optional.
 	optionalMac, _ := net.ParseMAC(path.Dir(full))
@@ -85,35 +257,158 @@ func (t Handler) HandleRead(filename string, rf io.ReaderFrom) error {
 	span.SetStatus(codes.Ok, filename)
 	span.End()
 
-	content, ok := binary.Files[filepath.Base(shortfile)]
-	if !ok {
-		err := fmt.Errorf("file [%v] unknown: %w", filepath.Base(shortfile), os.ErrNotExist)
-		log.Error(err, "file unknown")
+	clientAddr, _ := netaddr.FromStdAddr(client.IP, client.Port, client.Zone)
+	file, size, err := t.Open(ctx, clientAddr, shortfile)
+	if uncompressed := strings.TrimSuffix(shortfile, CompressedSuffix); errors.Is(err, os.ErrNotExist) && uncompressed != shortfile {
+		log.Info("compressed variant unknown, serving the uncompressed file", "uncompressed", uncompressed)
+		filename = uncompressed
+		file, size, err = t.Open(ctx, clientAddr, uncompressed)
+	}
+	sw.Resolved()
+	if err != nil {
+		switch {
+		case errors.Is(err, binary.ErrNotEmbedded):
+			log.Info("warning: requested iPXE binary not compiled into this build", "available", binary.Available())
+		case errors.Is(err, os.ErrNotExist):
+			log.Error(err, "file unknown")
+		default:
+			log.Error(err, "resolving requested file failed", sw.Phases().KeysAndValues()...)
+		}
+		return err
+	}
+	defer file.Close()
+	if offset > size {
+		err := fmt.Errorf("%w: %v, size %v", ErrInvalidOffset, offset, size)
+		log.Info("invalid resume offset", "contentSize", size)
+		return err
+	}
+	if offset > 0 {
+		log.Info("resuming transfer", "remaining", size-offset)
+		if _, err := io.CopyN(io.Discard, file, offset); err != nil {
+			log.Error(err, "skipping to the resume offset failed")
+			return err
+		}
+		size -= offset
+	}
+	if err := fault.Latency(ctx, t.Clock, t.ArtificialLatency); err != nil {
+		log.Info("request canceled during the artificial latency", "error", err)
 		return err
 	}
-	ct := bytes.NewReader(content)
+	f := t.Inject(fault.Request{Protocol: "tftp", Client: ip, Filename: filename})
+	if f.Err != nil {
+		log.Info("injected fault", "error", f.Err)
+		return f.Err
+	}
+	// Answer the transfer size option (RFC 2349) with the size of the resolved content.
+	// This doesn't depend on the reader passed to ReadFrom being an io.Seeker.
+	// It is a no-op when the client did not request the option. Without it, the reader not being an io.Seeker, the
+	// option is not acknowledged.
+	if ot, ok := rf.(tftp.OutgoingTransfer); ok && !t.OptionDisabled("tsize") {
+		ot.SetSize(size)
+	}
+	var ct io.Reader = file
+	if f.Truncate && f.Size >= 0 && f.Size < size {
+		log.Info("injected fault", "truncatedTo", f.Size)
+		ct = io.LimitReader(ct, f.Size)
+		size = f.Size
+	}
+	if t.MaxTransferDuration > 0 {
+		c := clock.Or(t.Clock)
+		ct = &deadlineReader{r: ct, clock: c, deadline: c.Now().Add(t.MaxTransferDuration)}
+	}
+	ct = t.Bandwidth.Reader(context.Background(), ct)
+	ct = progress.NewReader(ct, size, t.Progress, t.progressFunc(ip, filename))
 
-	b, err := rf.ReadFrom(ct)
+	capt, ct := t.Capture.Start(ip, full, size, ct)
+	rr := &readErrReader{r: ct}
+	b, err := rf.ReadFrom(rr)
+	sw.Transferred()
+	if capt != nil {
+		if _, err := capt.End(b, err); err != nil {
+			log.Error(err, "writing the transfer capture failed")
+		}
+	}
+	phases := sw.Phases()
+	log = log.WithValues(phases.KeysAndValues()...)
+	if reason := truncation(rr, err, b, size); reason != "" {
+		log.Info("transfer truncated", "reason", reason, "bytesSent", b, "contentSize", size)
+		if t.OnTruncated != nil {
+			t.OnTruncated(ip, filename, b, size, reason)
+		}
+		return err
+	}
 	if err != nil {
-		log.Error(err, "file serve failed", "b", b, "contentSize", len(content))
+		log.Error(err, "file serve failed", "b", b, "contentSize", size)
 		return err
 	}
-	log.Info("file served", "bytesSent", b, "contentSize", len(content))
+	log.V(t.ServedVerbosity).Info("file served", "bytesSent", b, "contentSize", size)
+	if t.OnServed != nil {
+		t.OnServed(ip, optionalMac, filename, b)
+	}
+	if t.OnTimings != nil {
+		t.OnTimings(ip, filename, phases)
+	}
 	return nil
 }
 
+// progressFunc returns the progress.Func reporting a transfer of filename to client to OnProgress.
+func (t Handler) progressFunc(client netaddr.IP, filename string) progress.Func {
+	if t.OnProgress == nil {
+		return nil
+	}
+	return func(sent, total int64) { t.OnProgress(client, filename, sent, total) }
+}
+
+// deadlineReader is an io.Reader that fails with ErrTransferDeadline once its deadline has passed.
+type deadlineReader struct {
+	r        io.Reader
+	clock    clock.Clock
+	deadline time.Time
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	if d.clock.Now().After(d.deadline) {
+		return 0, ErrTransferDeadline
+	}
+	return d.r.Read(p)
+}
+
 // HandleWrite handles TFTP PUT requests. It will always return an error. This library does not support PUT.
-func (t Handler) HandleWrite(filename string, wt io.WriterTo) error {
-	err := fmt.Errorf("access_violation: %w", os.ErrPermission)
+func (t Handler) HandleWrite(filename string, wt io.WriterTo) (err error) {
 	client := net.UDPAddr{}
 	if rpi, ok := wt.(tftp.OutgoingTransfer); ok {
 		client = rpi.RemoteAddr()
 	}
+	defer wrapTransferError(&err, "put", filename, client)
+	defer t.recoverPanic("put", filename, &err)
+	err = fmt.Errorf("access_violation: %w", os.ErrPermission)
 	t.Log.Error(err, "client", client, "event", "put", "filename", filename)
 
 	return err
 }
 
+// wrapTransferError wraps the error *err, when it is not nil, with the event ("get" or "put"), the requested filename
+// and the client, so it can be correlated with the node. Errors.Is and errors.As match the wrapped error.
+// It must be deferred.
+func wrapTransferError(err *error, event, filename string, client net.UDPAddr) {
+	if *err != nil {
+		*err = fmt.Errorf("%v %v for %v failed: %w", event, filename, client.String(), *err)
+	}
+}
+
+// recoverPanic recovers a panic of the handler of event for filename, logs it with its stack trace and sets err to ErrPanic.
+// It must be deferred.
+func (t Handler) recoverPanic(event, filename string, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	*err = ErrPanic
+	if t.Log.GetSink() != nil {
+		t.Log.Error(fmt.Errorf("panic: %v", r), "recovered from panic in handler", "event", event, "filename", filename, "stack", string(debug.Stack()))
+	}
+}
+
 // extractTraceparentFromFilename takes a context and filename and checks the filename for
 // a traceparent tacked onto the end of it. If there is a match, the traceparent is extracted
 // and a new SpanContext is contstructed and added to the context.Context that is returned.
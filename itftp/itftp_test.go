@@ -1,19 +1,30 @@
 package itftp
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
 	"github.com/google/go-cmp/cmp"
 	"github.com/pin/tftp"
+	"github.com/tinkerbell/ipxedust/bandwidth"
 	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/clock"
+	"github.com/tinkerbell/ipxedust/ratelimit"
+	"github.com/tinkerbell/ipxedust/resolve"
+	"github.com/tinkerbell/ipxedust/upstream"
 	"go.opentelemetry.io/otel/trace"
 	"inet.af/netaddr"
 )
@@ -228,3 +239,394 @@ func TestExtractTraceparentFromFilename(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleReadRateLimit(t *testing.T) {
+	ht := &Handler{Log: logr.Discard(), Limiter: ratelimit.New(ratelimit.Config{RequestsPerSecond: 0.001})}
+	want := []error{nil, ratelimit.ErrLimited}
+	for i, wantErr := range want {
+		rf := &fakeReaderFrom{
+			addr:    net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999},
+			content: make([]byte, len(binary.Files["snp.efi"])),
+		}
+		err := ht.HandleRead("snp.efi", rf)
+		if !errors.Is(err, wantErr) {
+			t.Fatalf("request %v: error mismatch, got: %v, want: %v", i, err, wantErr)
+		}
+	}
+}
+
+// blockingReaderFrom is a fakeReaderFrom whose ReadFrom blocks until release is closed.
+type blockingReaderFrom struct {
+	fakeReaderFrom
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingReaderFrom) ReadFrom(r io.Reader) (int64, error) {
+	close(b.started)
+	<-b.release
+	return b.fakeReaderFrom.ReadFrom(r)
+}
+
+func TestHandleReadTransferCap(t *testing.T) {
+	ht := &Handler{Log: logr.Discard(), Transfers: NewTransfers(2)}
+	client := net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}
+	release := make(chan struct{})
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		rf := &blockingReaderFrom{
+			fakeReaderFrom: fakeReaderFrom{addr: client, content: make([]byte, len(binary.Files["snp.efi"]))},
+			started:        make(chan struct{}),
+			release:        release,
+		}
+		go func() { errs <- ht.HandleRead("snp.efi", rf) }()
+		<-rf.started
+	}
+
+	// the same client is over the cap, another client is not.
+	err := ht.HandleRead("snp.efi", &fakeReaderFrom{addr: client, content: make([]byte, 1)})
+	if !errors.Is(err, ErrTooManyTransfers) {
+		t.Fatalf("error mismatch, got: %v, want: %v", err, ErrTooManyTransfers)
+	}
+	other := net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 9999}
+	if err := ht.HandleRead("snp.efi", &fakeReaderFrom{addr: other, content: make([]byte, 1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ht.HandleRead("snp.efi", &fakeReaderFrom{addr: client, content: make([]byte, 1)}); err != nil {
+		t.Fatalf("expected transfer to be allowed after others finished, got: %v", err)
+	}
+}
+
+// tricklingReaderFrom is a fakeReaderFrom that reads one block at a time, advancing the clock between blocks like a slow client.
+type tricklingReaderFrom struct {
+	fakeReaderFrom
+	clock *clock.Fake
+	pause time.Duration
+}
+
+func (f *tricklingReaderFrom) ReadFrom(r io.Reader) (int64, error) {
+	var n int64
+	buf := make([]byte, 512)
+	for {
+		c, err := r.Read(buf)
+		n += int64(c)
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		f.clock.Advance(f.pause)
+	}
+}
+
+func TestHandleReadMaxTransferDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		max     time.Duration
+		wantErr error
+	}{
+		{name: "deadline exceeded", max: 5 * time.Second, wantErr: ErrTransferDeadline},
+		{name: "no limit", max: 0, wantErr: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := clock.NewFake(time.Unix(0, 0))
+			ht := &Handler{Log: logr.Discard(), MaxTransferDuration: tt.max, Clock: fake}
+			rf := &tricklingReaderFrom{
+				fakeReaderFrom: fakeReaderFrom{addr: net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}},
+				clock:          fake,
+				pause:          100 * time.Millisecond,
+			}
+			err := ht.HandleRead("undionly.kpxe", rf)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error mismatch, got: %v, want: %v", err, tt.wantErr)
+			}
+			if elapsed := fake.Now().Sub(time.Unix(0, 0)); tt.wantErr != nil && elapsed > tt.max+rf.pause {
+				t.Fatalf("expected the transfer to be aborted after %v, took: %v", tt.max, elapsed)
+			}
+		})
+	}
+}
+
+func TestHandleReadUpstream(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/custom.efi":
+			_, _ = w.Write([]byte("custom content"))
+		case "/broken.efi":
+			http.Error(w, "boom", http.StatusBadGateway)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer up.Close()
+	cache, err := upstream.New(up.URL, time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		fileName string
+		want     []byte
+		wantErr  error
+	}{
+		{name: "upstream file", fileName: "custom.efi", want: []byte("custom content")},
+		{name: "not found upstream", fileName: "missing.efi", wantErr: os.ErrNotExist},
+		{name: "upstream error", fileName: "broken.efi", wantErr: upstream.ErrUpstream},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ht := &Handler{Log: logr.Discard(), Resolver: resolve.Resolver{Upstream: cache}}
+			rf := &fakeReaderFrom{
+				addr:    net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999},
+				content: make([]byte, len(tt.want)),
+			}
+			err := ht.HandleRead(tt.fileName, rf)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error mismatch, got: %v, want: %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(rf.content, tt.want); tt.wantErr == nil && diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestHandleReadTransferSize(t *testing.T) {
+	tests := []struct {
+		name string
+		h    *Handler
+	}{
+		{name: "default", h: &Handler{Log: logr.Discard()}},
+		{name: "with max transfer duration", h: &Handler{Log: logr.Discard(), MaxTransferDuration: time.Minute}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := tftp.NewServer(tt.h.HandleRead, tt.h.HandleWrite)
+			srv.SetTimeout(time.Second)
+			hook := newServingHook(tt.h)
+			srv.SetHook(hook)
+			go func() { _ = Serve(context.Background(), conn, srv) }()
+			// Shutdown races with Serve populating its conn until the serve loop runs.
+			hook.wait(t, conn.LocalAddr())
+			defer srv.Shutdown()
+
+			client, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			// RRQ: opcode 1, filename, mode and the tsize option with a value of 0.
+			rrq := append([]byte{0, 1}, []byte("undionly.kpxe\x00octet\x00tsize\x000\x00")...)
+			if _, err := client.WriteTo(rrq, conn.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 1024)
+			_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, from, err := client.ReadFrom(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// abort the transfer with an ERROR packet, the OACK is all that is needed.
+			_, _ = client.WriteTo(append([]byte{0, 5, 0, 0}, []byte("preflight only\x00")...), from)
+
+			// OACK: opcode 6 followed by the acknowledged options.
+			want := append([]byte{0, 6}, []byte(fmt.Sprintf("tsize\x00%v\x00", len(binary.Files["undionly.kpxe"])))...)
+			if diff := cmp.Diff(buf[:n], want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestHandleReadPanic(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler Handler
+	}{
+		{name: "resolver", handler: Handler{Resolver: resolve.Resolver{Info: func() []byte { panic("resolver bug") }}}},
+		{name: "served hook", handler: Handler{OnServed: func(netaddr.IP, net.HardwareAddr, string, int64) { panic("hook bug") }}},
+		{name: "progress hook", handler: Handler{OnProgress: func(netaddr.IP, string, int64, int64) { panic("hook bug") }}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged []string
+			tt.handler.Log = funcr.New(func(_, args string) { logged = append(logged, args) }, funcr.Options{})
+			rf := &fakeReaderFrom{
+				addr:    net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999},
+				content: make([]byte, len(binary.Files["snp.efi"])),
+			}
+			name := "snp.efi"
+			if tt.handler.Info != nil {
+				name = resolve.InfoFileName
+			}
+			if err := tt.handler.HandleRead(name, rf); !errors.Is(err, ErrPanic) {
+				t.Fatalf("expected %v, got: %v", ErrPanic, err)
+			}
+			last := logged[len(logged)-1]
+			if !strings.Contains(last, "recovered from panic in handler") || !strings.Contains(last, "bug") || !strings.Contains(last, "goroutine") {
+				t.Fatalf("expected the panic to be logged with its stack trace, got: %v", last)
+			}
+			// the handler keeps serving.
+			if err := (Handler{Log: logr.Discard()}).HandleRead("snp.efi", rf); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// readAllReaderFrom reads everything from the reader passed to ReadFrom.
+type readAllReaderFrom struct {
+	fakeReaderFrom
+	got []byte
+}
+
+func (f *readAllReaderFrom) ReadFrom(r io.Reader) (int64, error) {
+	b, err := io.ReadAll(r)
+	f.got = b
+	return int64(len(b)), err
+}
+
+func TestHandleReadBandwidth(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), 10000)
+	ht := &Handler{
+		Log:       logr.Discard(),
+		Resolver:  resolve.Resolver{Files: map[string][]byte{"custom.efi": content}},
+		Bandwidth: bandwidth.New(bandwidth.Config{PerTransfer: 20000}),
+	}
+	rf := &readAllReaderFrom{fakeReaderFrom: fakeReaderFrom{addr: net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}}}
+	start := time.Now()
+	if err := ht.HandleRead("custom.efi", rf); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed, min := time.Since(start), 450*time.Millisecond; elapsed < min {
+		t.Fatalf("expected the transfer to take at least %v, took: %v", min, elapsed)
+	}
+	if !bytes.Equal(rf.got, content) {
+		t.Fatal("content mismatch")
+	}
+}
+
+// sizeReaderFrom records the transfer size set by the handler.
+type sizeReaderFrom struct {
+	readAllReaderFrom
+	size int64
+}
+
+func (f *sizeReaderFrom) SetSize(n int64) { f.size = n }
+
+func TestHandleReadCompressed(t *testing.T) {
+	compressed := []byte("compressed ipxe.efi")
+	tests := []struct {
+		name     string
+		filename string
+		files    map[string][]byte
+		want     []byte
+		wantErr  error
+	}{
+		{name: "compressed", filename: "ipxe.efi.gz", files: map[string][]byte{"ipxe.efi.gz": compressed}, want: compressed},
+		{name: "compressed with mac", filename: "0a:00:27:00:00:02/ipxe.efi.gz", files: map[string][]byte{"ipxe.efi.gz": compressed}, want: compressed},
+		{name: "uncompressed", filename: "ipxe.efi", files: map[string][]byte{"ipxe.efi.gz": compressed}, want: binary.IpxeEFI},
+		{name: "fallback to uncompressed", filename: "snp.efi.gz", files: map[string][]byte{"ipxe.efi.gz": compressed}, want: binary.SNP},
+		{name: "unknown", filename: "custom.efi.gz", wantErr: os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var served string
+			ht := &Handler{
+				Log:      logr.Discard(),
+				Resolver: resolve.Resolver{Files: tt.files},
+				OnServed: func(_ netaddr.IP, _ net.HardwareAddr, filename string, _ int64) { served = filename },
+			}
+			rf := &sizeReaderFrom{readAllReaderFrom: readAllReaderFrom{fakeReaderFrom: fakeReaderFrom{addr: net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}}}}
+			err := ht.HandleRead(tt.filename, rf)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error mismatch, got: %v, want: %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if !bytes.Equal(rf.got, tt.want) {
+				t.Fatalf("unexpected content served: %.20q", rf.got)
+			}
+			if rf.size != int64(len(tt.want)) {
+				t.Fatalf("expected a transfer size of %v, got: %v", len(tt.want), rf.size)
+			}
+			if want := strings.TrimSuffix(path.Base(tt.filename), CompressedSuffix); !bytes.Equal(tt.want, compressed) && served != want {
+				t.Fatalf("expected %v to be served, got: %v", want, served)
+			}
+		})
+	}
+}
+
+func TestHandleReadErrorContext(t *testing.T) {
+	tests := []struct {
+		name     string
+		h        *Handler
+		filename string
+		wantErr  error
+	}{
+		{name: "unknown file", h: &Handler{Log: logr.Discard()}, filename: "0a:00:27:00:00:02/custom.efi", wantErr: os.ErrNotExist},
+		{name: "maintenance", h: &Handler{Log: logr.Discard(), Maintenance: func() bool { return true }}, filename: "snp.efi", wantErr: ErrMaintenance},
+		{name: "panic", h: &Handler{Log: logr.Discard(), Maintenance: func() bool { panic("boom") }}, filename: "snp.efi", wantErr: ErrPanic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rf := &fakeReaderFrom{addr: net.UDPAddr{IP: net.IPv4(192, 168, 2, 10), Port: 68}}
+			err := tt.h.HandleRead(tt.filename, rf)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error mismatch, got: %v, want: %v", err, tt.wantErr)
+			}
+			if want := fmt.Sprintf("get %v for 192.168.2.10:68 failed: ", tt.filename); !strings.HasPrefix(err.Error(), want) {
+				t.Fatalf("expected the error to start with %q, got: %v", want, err)
+			}
+		})
+	}
+	err := (&Handler{Log: logr.Discard()}).HandleWrite("snp.efi", &fakeReaderFrom{addr: net.UDPAddr{IP: net.IPv4(192, 168, 2, 10), Port: 68}})
+	if want := "put snp.efi for 192.168.2.10:68 failed: "; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Fatalf("expected the error to start with %q, got: %v", want, err)
+	}
+}
+
+func TestTransferOptionsLogged(t *testing.T) {
+	var logged []string
+	h := Handler{Log: funcr.New(func(prefix, args string) { logged = append(logged, args) }, funcr.Options{Verbosity: 1})}
+	stats := tftp.TransferStats{
+		RemoteAddr: net.IPv4(192, 168, 2, 10),
+		Filename:   "snp.efi",
+		Mode:       "octet",
+		Opts:       map[string]string{"tsize": "241152", "blksize": "1468"},
+	}
+	h.OnSuccess(stats)
+	h.OnFailure(stats, errors.New("timeout"))
+	want := []string{
+		`"msg"="transfer options negotiated" "client"="192.168.2.10" "filename"="snp.efi" "mode"="octet" "options"="blksize=1468 tsize=241152"`,
+		`"msg"="transfer failed" "client"="192.168.2.10" "filename"="snp.efi" "mode"="octet" "options"="blksize=1468 tsize=241152" "error"="timeout"`,
+	}
+	if len(logged) != len(want) {
+		t.Fatalf("expected %v log lines, got: %v", len(want), logged)
+	}
+	for i, w := range want {
+		if !strings.Contains(logged[i], w) {
+			t.Errorf("expected %q in the log, got: %v", w, logged[i])
+		}
+	}
+	logged = nil
+	h.Log = funcr.New(func(prefix, args string) { logged = append(logged, args) }, funcr.Options{})
+	h.OnSuccess(stats)
+	if len(logged) != 0 {
+		t.Fatalf("expected the options to be logged at debug level only, got: %v", logged)
+	}
+}
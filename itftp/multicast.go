@@ -0,0 +1,656 @@
+package itftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/clock"
+	"github.com/tinkerbell/ipxedust/fault"
+	"inet.af/netaddr"
+)
+
+const (
+	// DefaultMulticastSessions is the number of files served over multicast at once when NewMulticast is passed zero.
+	DefaultMulticastSessions = 4
+	// MaxMulticastBlockSize is the largest block size of a multicast transfer, the size iPXE requests, which fits
+	// an Ethernet frame with its headers. Larger requested block sizes are lowered to it.
+	MaxMulticastBlockSize = 1432
+	// defaultMulticastTimeout is the time waited for the master client to acknowledge a block when the Handler has
+	// no Timeout, the default of github.com/pin/tftp.
+	defaultMulticastTimeout = 5 * time.Second
+	// multicastRetries is the number of times a block is sent again to a master client before it is dropped.
+	multicastRetries = 5
+	// maxMulticastBlocks is the number of blocks TFTP block numbers, 16 bits, count without rolling over.
+	maxMulticastBlocks = 1<<16 - 1
+)
+
+// TFTP opcodes and error codes, RFC 1350 and RFC 2347.
+const (
+	opRRQ   = 1
+	opDATA  = 3
+	opACK   = 4
+	opERROR = 5
+	opOACK  = 6
+
+	errCodeUndefined    = 0
+	errCodeNotFound     = 1
+	errCodeBadOptionAck = 8
+)
+
+var (
+	// ErrMulticastUnavailable is returned when a client can't join a multicast transfer because all the sessions
+	// are serving other files.
+	ErrMulticastUnavailable = errors.New("no multicast session available")
+	// ErrMulticastTooLarge is returned when a file has more blocks than TFTP block numbers count, at the block size
+	// requested.
+	ErrMulticastTooLarge = errors.New("file too large for a multicast transfer")
+)
+
+// Multicast serves the read requests with the multicast option of RFC 2090, like the requests of iPXE for tftm://
+// URLs, to many clients at once: the blocks of a file are sent once to a multicast group, where every client
+// downloading it receives them. One client at a time, the master client, acknowledges the blocks. When it has the
+// whole file, the next client becomes the master client and acknowledges the blocks it missed, until every client
+// has the whole file.
+//
+// The other packets, including the requests without the multicast option, are passed to the TFTP server and served
+// over unicast, as are the requests the Handler rejects, so that it answers them with the usual errors, and the
+// requests arriving when all the sessions serve other files. Each file served at once has a session, which sends
+// from an ephemeral port to its own port of the group. Only octet mode is served over multicast.
+//
+// A client joining a multicast transfer goes through the Limiter of the Handler, and holds one of its Transfers and
+// one of its Workers until it has the whole file or leaves. A client that is rate limited, or that would wait for a
+// worker or exceed its transfers, is left to the TFTP server, which answers it like any request. Faults injected with
+// an error fail the request, truncations are not injected. MaxTransferDuration and Bandwidth don't apply: the blocks
+// are sent once for all the clients, at the pace of the master client.
+//
+// A nil *Multicast passes all the packets to the TFTP server. It is safe for concurrent use.
+type Multicast struct {
+	h       Handler
+	group   netaddr.IPPort
+	timeout time.Duration
+	// clock tells the time of the retransmissions to the master clients, see Handler.Clock.
+	clock clock.Clock
+
+	// wg counts the sessions and the requests being joined to one.
+	wg sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+	// joined holds the addresses of the clients holding a transfer and a worker, see acquire.
+	joined map[string]struct{}
+	// sessions holds the sessions in progress by their index, the port of the group they are sent to.
+	sessions []*session
+}
+
+// NewMulticast returns a Multicast serving the files of h to the multicast group, with up to sessions files at
+// once, sent to the port of group and the following ones. A sessions of zero means DefaultMulticastSessions.
+// It returns nil when group is the zero value, multicast disabled.
+func NewMulticast(h Handler, group netaddr.IPPort, sessions int) *Multicast {
+	if group.IsZero() {
+		return nil
+	}
+	if sessions <= 0 {
+		sessions = DefaultMulticastSessions
+	}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultMulticastTimeout
+	}
+	return &Multicast{h: h, group: group, timeout: timeout, clock: clock.Or(h.Clock), joined: make(map[string]struct{}), sessions: make([]*session, sessions)}
+}
+
+// Conn returns conn, the request conn of the TFTP server, with the multicast requests read from it served by m.
+// github.com/pin/tftp reads the returned conn like any conn that is not a *net.UDPConn, without the local address of
+// the requests, and grants blocks of at most 512 bytes, so give the multicast requests a conn of their own.
+func (m *Multicast) Conn(conn net.PacketConn) net.PacketConn {
+	if m == nil {
+		return conn
+	}
+	return multicastConn{PacketConn: conn, m: m}
+}
+
+// Close stops joining clients to multicast transfers, and waits for the transfers in progress to complete.
+func (m *Multicast) Close() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+	m.wg.Wait()
+}
+
+// multicastConn is a net.PacketConn that hands the multicast requests it reads to a Multicast.
+type multicastConn struct {
+	net.PacketConn
+	m *Multicast
+}
+
+// ReadFrom reads the next packet that is not a multicast request served by the Multicast.
+func (c multicastConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil || !c.m.handle(p[:n], addr) {
+			return n, addr, err
+		}
+	}
+}
+
+// multicastRequest is a read request with the multicast option.
+type multicastRequest struct {
+	// filename is the requested file name.
+	filename string
+	// blksize is the block size of the transfer, the requested one, if any, at most MaxMulticastBlockSize.
+	blksize int
+	// ackBlksize and ackTsize report whether the blksize and tsize options were requested.
+	ackBlksize, ackTsize bool
+}
+
+// parseMulticastRequest parses p as a read request, in octet mode, with the multicast option.
+// It reports whether p is one.
+func parseMulticastRequest(p []byte) (multicastRequest, bool) {
+	if len(p) < 4 || binary.BigEndian.Uint16(p) != opRRQ || p[len(p)-1] != 0 {
+		return multicastRequest{}, false
+	}
+	// the file name, the mode, and the names and values of the options, each followed by a NUL.
+	fields := strings.Split(string(p[2:len(p)-1]), "\x00")
+	if len(fields) < 2 || len(fields)%2 != 0 || !strings.EqualFold(fields[1], "octet") {
+		return multicastRequest{}, false
+	}
+	rq := multicastRequest{filename: fields[0], blksize: 512}
+	multicast := false
+	for i := 2; i < len(fields); i += 2 {
+		switch strings.ToLower(fields[i]) {
+		case "multicast":
+			multicast = true
+		case "blksize":
+			if n, err := strconv.Atoi(fields[i+1]); err == nil && n >= 8 {
+				if n > MaxMulticastBlockSize {
+					n = MaxMulticastBlockSize
+				}
+				rq.blksize, rq.ackBlksize = n, true
+			}
+		case "tsize":
+			rq.ackTsize = true
+		}
+	}
+	return rq, multicast
+}
+
+// handle joins the client of p to a multicast transfer when p is a multicast request that can be served over
+// multicast. It reports whether it did, otherwise p is left to the TFTP server. The file is opened in the background,
+// so that a slow source of files doesn't hold up the requests of other clients.
+func (m *Multicast) handle(p []byte, addr net.Addr) bool {
+	ua, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	rq, ok := parseMulticastRequest(p)
+	if !ok {
+		return false
+	}
+	rq.ackTsize = rq.ackTsize && !m.h.OptionDisabled("tsize")
+	client, _ := netaddr.FromStdAddr(ua.IP, ua.Port, ua.Zone)
+	h := m.h
+	if h.Maintenance != nil && h.Maintenance() || h.Paused != nil && h.Paused() || !h.Allowed.Allow(client.IP()) || h.Filenames.Check(rq.filename) != nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed || !m.available(rq) {
+		return false
+	}
+	// a client that already joined sent its request again, because the option acknowledgement was lost.
+	_, joined := m.joined[ua.String()]
+	if !joined && !m.acquire(client.IP()) {
+		return false
+	}
+	m.joined[ua.String()] = struct{}{}
+	m.wg.Add(1)
+	go m.join(rq, ua, client, !joined)
+	return true
+}
+
+// acquire reports whether the client ip can join a multicast transfer: it holds one of the Transfers of the Handler,
+// a worker that is free, without waiting in the queue, and isn't rate limited. m.mu must be held.
+func (m *Multicast) acquire(ip netaddr.IP) bool {
+	if !m.h.Transfers.Acquire(ip) {
+		return false
+	}
+	if !m.h.Workers.TryAcquire() {
+		m.h.Transfers.Release(ip)
+		return false
+	}
+	if ok, _ := m.h.Limiter.Allow(ip); !ok {
+		m.h.Workers.Release()
+		m.h.Transfers.Release(ip)
+		return false
+	}
+	return true
+}
+
+// release frees the transfer and the worker of mb, when it holds them. m.mu must be held.
+func (m *Multicast) release(mb *member) {
+	if !mb.held {
+		return
+	}
+	mb.held = false
+	delete(m.joined, mb.addr.String())
+	m.h.Workers.Release()
+	m.h.Transfers.Release(mb.ip)
+}
+
+// available reports whether rq can join a session, one serving the same file at the same block size, or a new one.
+// m.mu must be held.
+func (m *Multicast) available(rq multicastRequest) bool {
+	for _, s := range m.sessions {
+		if s == nil || s.filename == rq.filename && s.blksize == rq.blksize {
+			return true
+		}
+	}
+	return false
+}
+
+// join opens the file of rq and joins the client at addr to the session serving the same content, or to a new one.
+// The clients of different content, like the variants of a file served by Resolver.DynamicBinary, are never mixed.
+func (m *Multicast) join(rq multicastRequest, addr *net.UDPAddr, client netaddr.IPPort, held bool) {
+	defer m.wg.Done()
+	filename := path.Base(rq.filename)
+	log := m.h.Log.WithValues("event", "get", "filename", filename, "uri", rq.filename, "client", addr, "multicast", true)
+	mac, _ := net.ParseMAC(path.Dir(rq.filename))
+	mb := &member{addr: addr, ip: client.IP(), mac: mac, filename: filename, ackBlksize: rq.ackBlksize, ackTsize: rq.ackTsize, held: held, log: log}
+	content, err := m.open(client, filename, rq.blksize)
+	if err == nil {
+		err = fault.Latency(context.Background(), m.clock, m.h.ArtificialLatency)
+	}
+	if err == nil {
+		if f := m.h.Inject(fault.Request{Protocol: "tftp", Client: client.IP(), Filename: filename}); f.Err != nil {
+			log.Info("injected fault", "error", f.Err)
+			err = f.Err
+		}
+	}
+	if err != nil {
+		m.mu.Lock()
+		m.release(mb)
+		m.mu.Unlock()
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			log.Error(err, "file unknown")
+			sendError(addr, errCodeNotFound, "file not found")
+		case errors.Is(err, ErrMulticastTooLarge):
+			log.Info("file too large for multicast, the client must request it without the multicast option", "blksize", rq.blksize)
+			sendError(addr, errCodeBadOptionAck, err.Error())
+		default:
+			log.Error(err, "resolving requested file failed")
+			sendError(addr, errCodeUndefined, err.Error())
+		}
+		return
+	}
+	digest := sha256.Sum256(content)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	free := -1
+	for i, s := range m.sessions {
+		switch {
+		case s == nil:
+			if free < 0 {
+				free = i
+			}
+		case s.filename == rq.filename && s.blksize == rq.blksize && s.digest == digest:
+			s.enqueue(mb)
+			return
+		}
+	}
+	if free < 0 {
+		log.Info("request rejected, all multicast sessions busy")
+		m.release(mb)
+		sendError(addr, errCodeUndefined, ErrMulticastUnavailable.Error())
+		return
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		log.Error(err, "opening the multicast session failed")
+		m.release(mb)
+		sendError(addr, errCodeUndefined, err.Error())
+		return
+	}
+	group := m.group.UDPAddr()
+	group.Port += free
+	s := &session{
+		m:        m,
+		index:    free,
+		filename: rq.filename,
+		blksize:  rq.blksize,
+		digest:   digest,
+		content:  content,
+		group:    group,
+		conn:     conn,
+		notify:   make(chan struct{}, 1),
+	}
+	m.sessions[free] = s
+	s.enqueue(mb)
+	m.wg.Add(1)
+	go s.run()
+}
+
+// open reads the content of filename for client. It fails with ErrMulticastTooLarge when it has more blocks of
+// blksize than TFTP block numbers count.
+func (m *Multicast) open(client netaddr.IPPort, filename string, blksize int) ([]byte, error) {
+	f, _, err := m.h.Open(context.Background(), client, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	max := int64(maxMulticastBlocks*blksize - 1)
+	content, err := io.ReadAll(io.LimitReader(f, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > max {
+		return nil, ErrMulticastTooLarge
+	}
+	return content, nil
+}
+
+// sendError sends a TFTP error to addr, from an ephemeral port, like the TFTP server does.
+func sendError(addr *net.UDPAddr, code uint16, msg string) {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	p := make([]byte, 4, 5+len(msg))
+	binary.BigEndian.PutUint16(p, opERROR)
+	binary.BigEndian.PutUint16(p[2:], code)
+	_, _ = conn.WriteToUDP(append(append(p, msg...), 0), addr)
+}
+
+// member is a client of a multicast transfer.
+type member struct {
+	addr     *net.UDPAddr
+	ip       netaddr.IP
+	mac      net.HardwareAddr
+	filename string
+	// ackBlksize and ackTsize report whether the client requested the blksize and tsize options.
+	ackBlksize, ackTsize bool
+	// held reports whether the client holds a transfer and a worker, see Multicast.acquire. Guarded by m.mu.
+	held bool
+	log  logr.Logger
+}
+
+// session is the multicast transfer of a file, to all the clients downloading it.
+type session struct {
+	m *Multicast
+	// index is the index of the session in the sessions of m, and the offset of its port from the port of the group.
+	index    int
+	filename string
+	blksize  int
+	digest   [sha256.Size]byte
+	content  []byte
+	group    *net.UDPAddr
+	// conn is the conn the blocks are sent from, and the master client sends its acknowledgements to.
+	conn *net.UDPConn
+
+	// pending holds the clients joining the session, guarded by m.mu. notify is signaled when one is added.
+	pending []*member
+	notify  chan struct{}
+
+	// The following fields are only used by run.
+	members []*member
+	// master is the master client, the one acknowledging the blocks, nil when there is no client left.
+	master *member
+	// packet is the last packet sent, to dest, that the master client is expected to acknowledge, tries is the number
+	// of times it was sent again, and deadline the time it is sent again at.
+	packet   []byte
+	dest     *net.UDPAddr
+	tries    int
+	deadline time.Time
+	// sent is the last block sent, and promoted reports whether the master client is yet to acknowledge a packet
+	// since it became the master client, see promote.
+	sent     int
+	promoted bool
+}
+
+// packet is a packet received by a session.
+type packet struct {
+	b    []byte
+	addr *net.UDPAddr
+}
+
+// enqueue adds mb to the clients joining s. m.mu must be held.
+func (s *session) enqueue(mb *member) {
+	s.pending = append(s.pending, mb)
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// run serves the clients of s until there are none left.
+func (s *session) run() {
+	defer s.m.wg.Done()
+	done := make(chan struct{})
+	defer close(done)
+	defer s.conn.Close()
+	packets := make(chan packet)
+	go s.read(packets, done)
+	for {
+		var expired <-chan time.Time
+		stop := func() {}
+		if s.packet != nil {
+			expired, stop = clock.After(s.m.clock, s.deadline.Sub(s.m.clock.Now()))
+		}
+		select {
+		case <-s.notify:
+			s.m.mu.Lock()
+			pending := s.pending
+			s.pending = nil
+			s.m.mu.Unlock()
+			for _, mb := range pending {
+				s.add(mb)
+			}
+		case p := <-packets:
+			s.receive(p)
+		case <-expired:
+			s.expire()
+		}
+		stop()
+		if len(s.members) == 0 && s.end() {
+			return
+		}
+	}
+}
+
+// read passes the packets received on the conn of s to packets, until the conn is closed.
+func (s *session) read(packets chan<- packet, done <-chan struct{}) {
+	buf := make([]byte, 4+MaxMulticastBlockSize)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		select {
+		case packets <- packet{b: append([]byte(nil), buf[:n]...), addr: addr}:
+		case <-done:
+			return
+		}
+	}
+}
+
+// end removes s from its Multicast, unless a client is joining it. It reports whether it did.
+func (s *session) end() bool {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if len(s.pending) > 0 {
+		return false
+	}
+	s.m.sessions[s.index] = nil
+	return true
+}
+
+// blocks returns the number of blocks of the content of s, the last one shorter than the block size, maybe empty.
+func (s *session) blocks() int {
+	return len(s.content)/s.blksize + 1
+}
+
+// add adds mb to the clients of s, as the master client when there is none. A client that is already one sent its
+// request again, because the option acknowledgement was lost, it is sent again.
+func (s *session) add(mb *member) {
+	for _, c := range s.members {
+		if c.addr.String() != mb.addr.String() {
+			continue
+		}
+		if c == s.master {
+			s.promote(c)
+		} else {
+			_, _ = s.conn.WriteToUDP(s.oack(c, false), c.addr)
+		}
+		return
+	}
+	s.members = append(s.members, mb)
+	mb.log.V(1).Info("joined multicast transfer", "group", s.group.String(), "master", s.master == nil, "clients", len(s.members))
+	if s.master == nil {
+		s.promote(mb)
+		return
+	}
+	_, _ = s.conn.WriteToUDP(s.oack(mb, false), mb.addr)
+}
+
+// promote makes mb the master client, which then acknowledges the last block it has before the first it misses.
+func (s *session) promote(mb *member) {
+	s.master, s.promoted = mb, true
+	s.transmit(s.oack(mb, true), mb.addr)
+}
+
+// receive handles a packet sent by a client to the conn of s.
+func (s *session) receive(p packet) {
+	var mb *member
+	for _, c := range s.members {
+		if c.addr.String() == p.addr.String() {
+			mb = c
+		}
+	}
+	if mb == nil || len(p.b) < 4 {
+		return
+	}
+	switch binary.BigEndian.Uint16(p.b) {
+	case opERROR:
+		mb.log.Info("multicast transfer aborted by the client", "error", string(bytes.TrimRight(p.b[4:], "\x00")))
+		s.remove(mb)
+	case opACK:
+		block := int(binary.BigEndian.Uint16(p.b[2:]))
+		switch {
+		case block == s.blocks():
+			s.served(mb)
+			s.remove(mb)
+		case mb == s.master && block < s.blocks() && (block == s.sent || s.promoted):
+			// a master client that was just promoted may have missed blocks before the last one sent, the transfer
+			// goes back to the first one it misses. The other acknowledgements are duplicates, for example of a
+			// block sent again: answering them would send every block that follows twice, see RFC 1123 4.2.3.1.
+			s.promoted = false
+			s.send(block + 1)
+		}
+	}
+}
+
+// expire sends the last packet to the master client again, or drops it once it was sent multicastRetries times.
+func (s *session) expire() {
+	if s.tries >= multicastRetries {
+		s.master.log.Info("multicast client stopped acknowledging, dropping it", "timeout", s.m.timeout, "retries", s.tries)
+		s.remove(s.master)
+		return
+	}
+	s.tries++
+	s.deadline = s.m.clock.Now().Add(s.m.timeout)
+	_, _ = s.conn.WriteToUDP(s.packet, s.dest)
+}
+
+// send sends block to the group.
+func (s *session) send(block int) {
+	s.sent = block
+	s.transmit(s.data(block), s.group)
+}
+
+// transmit sends p to dest and waits for the master client to acknowledge it.
+func (s *session) transmit(p []byte, dest *net.UDPAddr) {
+	s.packet, s.dest, s.tries = p, dest, 0
+	s.deadline = s.m.clock.Now().Add(s.m.timeout)
+	_, _ = s.conn.WriteToUDP(p, dest)
+}
+
+// remove removes mb from the clients of s. When it was the master client, the next client becomes the master client.
+func (s *session) remove(mb *member) {
+	for i, c := range s.members {
+		if c == mb {
+			s.members = append(s.members[:i], s.members[i+1:]...)
+			break
+		}
+	}
+	s.m.mu.Lock()
+	s.m.release(mb)
+	s.m.mu.Unlock()
+	if mb != s.master {
+		return
+	}
+	s.master, s.packet = nil, nil
+	if len(s.members) > 0 {
+		s.promote(s.members[0])
+	}
+}
+
+// served logs that mb received the whole file, and calls OnServed.
+func (s *session) served(mb *member) {
+	size := int64(len(s.content))
+	mb.log.V(s.m.h.ServedVerbosity).Info("file served", "bytesSent", size, "contentSize", size, "group", s.group.String())
+	if s.m.h.OnServed != nil {
+		s.m.h.OnServed(mb.ip, mb.mac, mb.filename, size)
+	}
+}
+
+// oack returns the option acknowledgement of the request of mb, with the multicast option telling whether it is the
+// master client.
+func (s *session) oack(mb *member, master bool) []byte {
+	p := []byte{0, opOACK}
+	opt := func(name, value string) {
+		p = append(append(append(append(p, name...), 0), value...), 0)
+	}
+	mc := 0
+	if master {
+		mc = 1
+	}
+	opt("multicast", fmt.Sprintf("%v,%v,%v", s.group.IP, s.group.Port, mc))
+	if mb.ackBlksize {
+		opt("blksize", strconv.Itoa(s.blksize))
+	}
+	if mb.ackTsize {
+		opt("tsize", strconv.Itoa(len(s.content)))
+	}
+	return p
+}
+
+// data returns the DATA packet of block, numbered from 1.
+func (s *session) data(block int) []byte {
+	start := (block - 1) * s.blksize
+	end := start + s.blksize
+	if end > len(s.content) {
+		end = len(s.content)
+	}
+	p := make([]byte, 4, 4+end-start)
+	binary.BigEndian.PutUint16(p, opDATA)
+	binary.BigEndian.PutUint16(p[2:], uint16(block))
+	return append(p, s.content[start:end]...)
+}
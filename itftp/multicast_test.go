@@ -0,0 +1,482 @@
+package itftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/allowlist"
+	"github.com/tinkerbell/ipxedust/clock"
+	"github.com/tinkerbell/ipxedust/ratelimit"
+	"github.com/tinkerbell/ipxedust/resolve"
+	"inet.af/netaddr"
+)
+
+// rrq returns a TFTP read request of filename in mode, with the given option names and values.
+func rrq(filename, mode string, opts ...string) []byte {
+	p := []byte{0, opRRQ}
+	for _, f := range append([]string{filename, mode}, opts...) {
+		p = append(append(p, f...), 0)
+	}
+	return p
+}
+
+// ack returns the acknowledgement of block.
+func ack(block int) []byte {
+	p := []byte{0, opACK, 0, 0}
+	binary.BigEndian.PutUint16(p[2:], uint16(block))
+	return p
+}
+
+// oackOptions returns the options of the option acknowledgement p, or nil when p isn't one.
+func oackOptions(p []byte) map[string]string {
+	if len(p) < 2 || binary.BigEndian.Uint16(p) != opOACK {
+		return nil
+	}
+	opts := map[string]string{}
+	fields := strings.Split(strings.TrimSuffix(string(p[2:]), "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		opts[fields[i]] = fields[i+1]
+	}
+	return opts
+}
+
+// udpClient is a TFTP client socket of the tests.
+type udpClient struct {
+	t    *testing.T
+	conn *net.UDPConn
+}
+
+func newUDPClient(t *testing.T) udpClient {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return udpClient{t: t, conn: conn}
+}
+
+func (c udpClient) send(p []byte, to net.Addr) {
+	c.t.Helper()
+	if _, err := c.conn.WriteTo(p, to); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c udpClient) read() ([]byte, *net.UDPAddr) {
+	c.t.Helper()
+	if err := c.conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		c.t.Fatal(err)
+	}
+	buf := make([]byte, 2048)
+	n, addr, err := c.conn.ReadFromUDP(buf)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return buf[:n], addr
+}
+
+// serveMulticast serves h with multicast to a group on a loopback port, which the returned conn receives the
+// blocks sent to the group on, and returns the address of the server.
+func serveMulticast(t *testing.T, h Handler, sessions int) (*Multicast, udpClient, net.Addr) {
+	t.Helper()
+	group := newUDPClient(t)
+	port := group.conn.LocalAddr().(*net.UDPAddr).Port
+	m := NewMulticast(h, netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), uint16(port)), sessions)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := h.NewServer()
+	hook := newServingHook(h)
+	s.SetHook(hook)
+	go func() { _ = s.Serve(m.Conn(conn)) }()
+	// Shutdown races with Serve populating its conn until the serve loop runs.
+	hook.wait(t, conn.LocalAddr())
+	t.Cleanup(func() {
+		s.Shutdown()
+		m.Close()
+	})
+	return m, group, conn.LocalAddr()
+}
+
+func TestMulticast(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 400)
+	var mu sync.Mutex
+	var served []string
+	h := Handler{
+		Log:      logr.Discard(),
+		Resolver: resolve.Resolver{Files: map[string][]byte{"custom.efi": content}},
+		Timeout:  time.Second,
+		OnServed: func(client netaddr.IP, _ net.HardwareAddr, filename string, bytesSent int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			if bytesSent == int64(len(content)) {
+				served = append(served, filename)
+			}
+		},
+	}
+	_, group, server := serveMulticast(t, h, 1)
+	a, b := newUDPClient(t), newUDPClient(t)
+	groupValue := func(mc int) string {
+		addr := group.conn.LocalAddr().(*net.UDPAddr)
+		return fmt.Sprintf("%v,%v,%v", addr.IP, addr.Port, mc)
+	}
+	blockOf := func(block int) []byte {
+		p := content[(block-1)*512:]
+		if len(p) > 512 {
+			p = p[:512]
+		}
+		return p
+	}
+
+	// a joins first and is the master client.
+	a.send(rrq("custom.efi", "octet", "multicast", "", "tsize", "0"), server)
+	p, session := a.read()
+	if diff := cmp.Diff(map[string]string{"multicast": groupValue(1), "tsize": strconv.Itoa(len(content))}, oackOptions(p)); diff != "" {
+		t.Fatalf("unexpected option acknowledgement: %v", diff)
+	}
+	blocks := len(content)/512 + 1
+	gotA, gotB := map[int][]byte{}, map[int][]byte{}
+	sent := map[int]int{}
+	readBlock := func() int {
+		p, _ := group.read()
+		if binary.BigEndian.Uint16(p) != opDATA {
+			t.Fatalf("expected a DATA packet, got: %q", p)
+		}
+		block := int(binary.BigEndian.Uint16(p[2:]))
+		sent[block]++
+		return block
+	}
+	a.send(ack(0), session)
+	for len(gotA) < blocks {
+		block := readBlock()
+		gotA[block] = blockOf(block)
+		if block == 3 {
+			// b joins while the file is sent, it misses the first blocks.
+			b.send(rrq("custom.efi", "octet", "multicast", ""), server)
+			p, _ := b.read()
+			if diff := cmp.Diff(map[string]string{"multicast": groupValue(0)}, oackOptions(p)); diff != "" {
+				t.Fatalf("unexpected option acknowledgement: %v", diff)
+			}
+		}
+		if block > 3 {
+			gotB[block] = blockOf(block)
+		}
+		a.send(ack(block), session)
+	}
+
+	// b becomes the master client once a has the whole file, and acknowledges the blocks it has before the first
+	// it misses.
+	p, _ = b.read()
+	if diff := cmp.Diff(map[string]string{"multicast": groupValue(1)}, oackOptions(p)); diff != "" {
+		t.Fatalf("unexpected option acknowledgement: %v", diff)
+	}
+	b.send(ack(0), session)
+	for len(gotB) < blocks {
+		block := readBlock()
+		gotB[block] = blockOf(block)
+		last := 0
+		for gotB[last+1] != nil {
+			last++
+		}
+		b.send(ack(last), session)
+	}
+
+	want := map[int]int{1: 2, 2: 2, 3: 2}
+	for i := 4; i <= blocks; i++ {
+		want[i] = 1
+	}
+	if diff := cmp.Diff(want, sent); diff != "" {
+		t.Fatalf("unexpected blocks sent to the group: %v", diff)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(served)
+		mu.Unlock()
+		if n == 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff([]string{"custom.efi", "custom.efi"}, served); diff != "" {
+		t.Fatalf("expected both clients to be served: %v", diff)
+	}
+}
+
+func TestMulticastMasterTimeout(t *testing.T) {
+	var mu sync.Mutex
+	var logged []string
+	h := Handler{
+		Log: funcr.New(func(_, args string) {
+			mu.Lock()
+			defer mu.Unlock()
+			logged = append(logged, args)
+		}, funcr.Options{}),
+		Resolver: resolve.Resolver{Files: map[string][]byte{"custom.efi": make([]byte, 1000)}},
+		Timeout:  time.Second,
+		Clock:    clock.NewFake(time.Unix(0, 0)),
+	}
+	fake := h.Clock.(*clock.Fake)
+	_, _, server := serveMulticast(t, h, 1)
+	a, b := newUDPClient(t), newUDPClient(t)
+
+	a.send(rrq("custom.efi", "octet", "multicast", ""), server)
+	_, session := a.read()
+	b.send(rrq("custom.efi", "octet", "multicast", ""), server)
+	if p, _ := b.read(); !strings.HasSuffix(oackOptions(p)["multicast"], ",0") {
+		t.Fatalf("expected b not to be the master client, got: %q", p)
+	}
+	// a never acknowledges the option acknowledgement, it is sent again each timeout, then a is dropped and b is the
+	// master.
+	expire := func() {
+		for fake.Sleepers() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		fake.Advance(h.Timeout)
+	}
+	for i := 0; i < multicastRetries; i++ {
+		expire()
+		if p, _ := a.read(); !strings.HasSuffix(oackOptions(p)["multicast"], ",1") {
+			t.Fatalf("expected the option acknowledgement to be sent again, got: %q", p)
+		}
+	}
+	expire()
+	if p, _ := b.read(); !strings.HasSuffix(oackOptions(p)["multicast"], ",1") {
+		t.Fatalf("expected b to become the master client, got: %q", p)
+	}
+	b.send([]byte("\x00\x05\x00\x00aborted\x00"), session)
+
+	mu.Lock()
+	defer mu.Unlock()
+	found := false
+	for _, l := range logged {
+		found = found || strings.Contains(l, "multicast client stopped acknowledging")
+	}
+	if !found {
+		t.Fatalf("expected the dropped client to be logged, got: %v", logged)
+	}
+}
+
+func TestMulticastDuplicateAck(t *testing.T) {
+	h := Handler{
+		Log:      logr.Discard(),
+		Resolver: resolve.Resolver{Files: map[string][]byte{"custom.efi": make([]byte, 2000)}},
+		Timeout:  time.Second,
+		Clock:    clock.NewFake(time.Unix(0, 0)),
+	}
+	fake := h.Clock.(*clock.Fake)
+	_, group, server := serveMulticast(t, h, 1)
+	a, b := newUDPClient(t), newUDPClient(t)
+	ack := func(c udpClient, block uint16, to net.Addr) {
+		c.send([]byte{0, opACK, byte(block >> 8), byte(block)}, to)
+	}
+	next := func() uint16 {
+		p, _ := group.read()
+		if binary.BigEndian.Uint16(p) != opDATA {
+			t.Fatalf("expected a DATA packet, got: %q", p)
+		}
+		return binary.BigEndian.Uint16(p[2:])
+	}
+
+	a.send(rrq("custom.efi", "octet", "multicast", ""), server)
+	_, session := a.read()
+	b.send(rrq("custom.efi", "octet", "multicast", ""), server)
+	b.read()
+	ack(a, 0, session)
+	got := []uint16{next()}
+	// block 1 is sent again, a acknowledges both: the duplicate acknowledgement doesn't send block 2 twice.
+	for fake.Sleepers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	fake.Advance(h.Timeout)
+	got = append(got, next())
+	ack(a, 1, session)
+	ack(a, 1, session)
+	got = append(got, next())
+	ack(a, 2, session)
+	got = append(got, next())
+	// b becomes the master client and goes back to the first block it misses.
+	a.send([]byte("\x00\x05\x00\x00aborted\x00"), session)
+	b.read()
+	ack(b, 1, session)
+	got = append(got, next())
+	b.send([]byte("\x00\x05\x00\x00aborted\x00"), session)
+	if diff := cmp.Diff(got, []uint16{1, 1, 2, 3, 2}); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestMulticastFallback(t *testing.T) {
+	local := netaddr.IPv4(127, 0, 0, 1)
+	tests := []struct {
+		name    string
+		request []byte
+		allowed []netaddr.IP
+		busy    bool
+		// setup sets the limits of h, already reached by the client, and returns the function undoing them, if any.
+		setup    func(h *Handler) func()
+		rejected bool
+	}{
+		{name: "no multicast option", request: rrq("custom.efi", "octet", "tsize", "0")},
+		{name: "netascii", request: rrq("custom.efi", "netascii", "multicast", "")},
+		{name: "client not allowed", request: rrq("custom.efi", "octet", "multicast", ""), allowed: []netaddr.IP{netaddr.IPv4(192, 0, 2, 1)}, rejected: true},
+		{name: "sessions busy", request: rrq("custom.efi", "octet", "multicast", ""), busy: true},
+		{name: "rate limited", request: rrq("custom.efi", "octet", "multicast", ""), rejected: true, setup: func(h *Handler) func() {
+			h.Limiter = ratelimit.New(ratelimit.Config{PerClientRequestsPerSecond: 0.001})
+			h.Limiter.Allow(local)
+			return nil
+		}},
+		{name: "too many transfers", request: rrq("custom.efi", "octet", "multicast", ""), rejected: true, setup: func(h *Handler) func() {
+			h.Transfers = NewTransfers(1)
+			h.Transfers.Acquire(local)
+			return nil
+		}},
+		{name: "workers busy", request: rrq("custom.efi", "octet", "multicast", ""), setup: func(h *Handler) func() {
+			h.Workers = NewWorkers(1, 1)
+			h.Workers.Acquire()
+			return h.Workers.Release
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Handler{
+				Log:      logr.Discard(),
+				Resolver: resolve.Resolver{Files: map[string][]byte{"custom.efi": make([]byte, 1000), "other.efi": make([]byte, 1000)}},
+				Allowed:  &allowlist.Set{},
+				Timeout:  100 * time.Millisecond,
+			}
+			if tt.allowed != nil {
+				h.Allowed.Replace(tt.allowed)
+			}
+			var undo func()
+			if tt.setup != nil {
+				undo = tt.setup(&h)
+			}
+			_, _, server := serveMulticast(t, h, 1)
+			if tt.busy {
+				other := newUDPClient(t)
+				other.send(rrq("other.efi", "octet", "multicast", ""), server)
+				if p, _ := other.read(); oackOptions(p)["multicast"] == "" {
+					t.Fatalf("expected other.efi to be served over multicast, got: %q", p)
+				}
+				defer func() {
+					_, session := other.read()
+					other.send([]byte("\x00\x05\x00\x00aborted\x00"), session)
+				}()
+			}
+			c := newUDPClient(t)
+			c.send(tt.request, server)
+			if undo != nil {
+				// the request waits for the worker over unicast.
+				time.Sleep(50 * time.Millisecond)
+				undo()
+			}
+			p, transfer := c.read()
+			if _, ok := oackOptions(p)["multicast"]; ok {
+				t.Fatalf("expected the request to be served over unicast, got: %q", p)
+			}
+			if binary.BigEndian.Uint16(p) == opERROR {
+				if !tt.rejected {
+					t.Fatalf("expected the request to be served, got: %q", p)
+				}
+				return
+			}
+			if tt.rejected {
+				t.Fatalf("expected the request to be rejected, got: %q", p)
+			}
+			c.send([]byte("\x00\x05\x00\x00aborted\x00"), transfer)
+		})
+	}
+}
+
+func TestMulticastReleases(t *testing.T) {
+	local := netaddr.IPv4(127, 0, 0, 1)
+	h := Handler{
+		Log:       logr.Discard(),
+		Resolver:  resolve.Resolver{Files: map[string][]byte{"custom.efi": make([]byte, 1000)}},
+		Timeout:   time.Second,
+		Transfers: NewTransfers(1),
+		Workers:   NewWorkers(1, 0),
+	}
+	_, _, server := serveMulticast(t, h, 1)
+	c := newUDPClient(t)
+	c.send(rrq("custom.efi", "octet", "multicast", ""), server)
+	_, session := c.read()
+	// the request is sent again, as if the option acknowledgement was lost: it doesn't count as another transfer.
+	c.send(rrq("custom.efi", "octet", "multicast", ""), server)
+	if p, _ := c.read(); oackOptions(p)["multicast"] == "" {
+		t.Fatalf("expected the option acknowledgement to be sent again, got: %q", p)
+	}
+	if got := []int{h.Transfers.Count(local), h.Workers.Busy()}; !cmp.Equal(got, []int{1, 1}) {
+		t.Fatalf("expected the client to hold a transfer and a worker, got: %v", got)
+	}
+	c.send([]byte("\x00\x05\x00\x00aborted\x00"), session)
+	deadline := time.Now().Add(5 * time.Second)
+	for h.Transfers.Count(local) != 0 || h.Workers.Busy() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the transfer and the worker to be released, got: %v, %v", h.Transfers.Count(local), h.Workers.Busy())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMulticastDisabled(t *testing.T) {
+	if m := NewMulticast(Handler{}, netaddr.IPPort{}, 4); m != nil {
+		t.Fatalf("expected multicast to be disabled, got: %v", m)
+	}
+	var m *Multicast
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := m.Conn(conn); got != conn {
+		t.Fatalf("expected the conn to be served as is, got: %v", got)
+	}
+	m.Close()
+}
+
+func TestParseMulticastRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		p         []byte
+		want      multicastRequest
+		multicast bool
+	}{
+		{name: "multicast", p: rrq("ipxe.efi", "octet", "multicast", ""), want: multicastRequest{filename: "ipxe.efi", blksize: 512}, multicast: true},
+		{name: "case insensitive", p: rrq("ipxe.efi", "OCTET", "MULTICAST", "", "TSize", "0"), want: multicastRequest{filename: "ipxe.efi", blksize: 512, ackTsize: true}, multicast: true},
+		{name: "block size", p: rrq("ipxe.efi", "octet", "blksize", "1024", "multicast", ""), want: multicastRequest{filename: "ipxe.efi", blksize: 1024, ackBlksize: true}, multicast: true},
+		{name: "block size too large", p: rrq("ipxe.efi", "octet", "multicast", "", "blksize", "65464"), want: multicastRequest{filename: "ipxe.efi", blksize: MaxMulticastBlockSize, ackBlksize: true}, multicast: true},
+		{name: "invalid block size", p: rrq("ipxe.efi", "octet", "multicast", "", "blksize", "four"), want: multicastRequest{filename: "ipxe.efi", blksize: 512}, multicast: true},
+		{name: "unicast", p: rrq("ipxe.efi", "octet", "blksize", "1024"), want: multicastRequest{filename: "ipxe.efi", blksize: 1024, ackBlksize: true}},
+		{name: "write request", p: append([]byte{0, 2}, rrq("ipxe.efi", "octet", "multicast", "")[2:]...)},
+		{name: "missing value", p: rrq("ipxe.efi", "octet", "multicast")},
+		{name: "not terminated", p: []byte("\x00\x01ipxe.efi\x00octet")},
+		{name: "short", p: []byte{0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, multicast := parseMulticastRequest(tt.p)
+			if multicast != tt.multicast {
+				t.Fatalf("expected multicast %v, got: %v", tt.multicast, multicast)
+			}
+			if diff := cmp.Diff(tt.want, got, cmp.AllowUnexported(multicastRequest{})); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package itftp
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/pin/tftp"
+	"github.com/tinkerbell/ipxedust/capture"
+	"inet.af/netaddr"
+)
+
+// OnSuccess logs, at debug level, the options negotiated for a transfer that completed: the options of the request
+// acknowledged by the server (OACK), like blksize and tsize, with their granted values. With OnFailure, it implements
+// tftp.Hook, see tftp.Server.SetHook. github.com/pin/tftp only acknowledges blksize and tsize, options like windowsize
+// and timeout are ignored, and it doesn't make the options as requested by the client available.
+func (t Handler) OnSuccess(stats tftp.TransferStats) {
+	t.captureStats(stats)
+	t.Log.V(1).Info("transfer options negotiated", "client", stats.RemoteAddr.String(), "filename", stats.Filename,
+		"mode", stats.Mode, "options", formatOptions(stats.Opts), "duration", stats.Duration,
+		"datagramsSent", stats.DatagramsSent, "datagramsAcked", stats.DatagramsAcked)
+}
+
+// OnFailure logs, at debug level, the options negotiated for a transfer that failed with err, see OnSuccess.
+// The failures are logged by the handlers, errors of the server itself, like reading a request, are only logged here.
+// Stray packets, that are not requests, received on the request port are logged at info level, at most once every
+// StrayLogInterval with Stray set, see StrayPackets.
+func (t Handler) OnFailure(stats tftp.TransferStats, err error) {
+	if isStray(stats, err) {
+		if ok, suppressed := t.Stray.log(); ok {
+			t.Log.Info("ignored a stray packet on the TFTP request port, a client may be sending to the request port instead of the port of its transfer",
+				"error", err.Error(), "suppressed", suppressed)
+		}
+		return
+	}
+	t.captureStats(stats)
+	t.Log.V(1).Info("transfer failed", "client", stats.RemoteAddr.String(), "filename", stats.Filename,
+		"mode", stats.Mode, "options", formatOptions(stats.Opts), "error", err.Error())
+}
+
+// captureStats adds stats to the capture of the transfer, see Capture.
+func (t Handler) captureStats(stats tftp.TransferStats) {
+	if t.Capture == nil {
+		return
+	}
+	ip, _ := netaddr.FromStdIP(stats.RemoteAddr)
+	t.Capture.Negotiated(ip, stats.Filename, capture.Stats{
+		Mode:           stats.Mode,
+		Options:        stats.Opts,
+		DatagramsSent:  stats.DatagramsSent,
+		DatagramsAcked: stats.DatagramsAcked,
+	})
+}
+
+// formatOptions formats TFTP options as space separated name=value pairs, sorted by name, for example "blksize=1468 tsize=1018880".
+func formatOptions(opts map[string]string) string {
+	pairs := make([]string, 0, len(opts))
+	for name, value := range opts {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// OptionDisabled reports whether the TFTP option name is one of DisabledOptions, in any case.
+func (t Handler) OptionDisabled(name string) bool {
+	for _, o := range t.DisabledOptions {
+		if strings.EqualFold(o, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// OptionsConn returns conn, removing the options named by disabled from the read requests read from it, in any case,
+// so that they are not acknowledged, see Handler.DisabledOptions. It returns conn unchanged when disabled has neither
+// blksize nor multicast, the options acknowledged before the Handler is called: github.com/pin/tftp doesn't
+// acknowledge the others, and reads requests through the returned conn like it does through any conn that is not a
+// *net.UDPConn: its transfers are sent from the address picked by routing, in blocks of at most 512 bytes.
+func OptionsConn(conn net.PacketConn, disabled []string) net.PacketConn {
+	h := Handler{DisabledOptions: disabled}
+	if !h.OptionDisabled("blksize") && !h.OptionDisabled("multicast") {
+		return conn
+	}
+	return optionsConn{PacketConn: conn, h: h}
+}
+
+// optionsConn removes the disabled options of h from the read requests read from the PacketConn.
+type optionsConn struct {
+	net.PacketConn
+	h Handler
+}
+
+// ReadFrom reads a packet into p, removing the disabled options when it is a read request.
+func (c optionsConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	if err == nil {
+		n = c.h.stripOptions(p[:n])
+	}
+	return n, addr, err
+}
+
+// stripOptions removes the disabled options from the read request p, in place, and returns its new length.
+// Other packets, and malformed requests, are left unchanged.
+func (t Handler) stripOptions(p []byte) int {
+	if len(p) < 4 || binary.BigEndian.Uint16(p) != opRRQ || p[len(p)-1] != 0 {
+		return len(p)
+	}
+	// the file name, the mode, and the names and values of the options, each followed by a NUL.
+	fields := strings.Split(string(p[2:len(p)-1]), "\x00")
+	if len(fields) < 2 || len(fields)%2 != 0 {
+		return len(p)
+	}
+	n := 2
+	for i, f := range fields {
+		if i >= 2 && t.OptionDisabled(fields[i-i%2]) {
+			continue
+		}
+		n += copy(p[n:], f)
+		p[n] = 0
+		n++
+	}
+	return n
+}
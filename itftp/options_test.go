@@ -0,0 +1,78 @@
+package itftp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStripOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		disabled []string
+		packet   string
+		want     string
+	}{
+		{name: "nothing disabled", packet: "\x00\x01ipxe.efi\x00octet\x00blksize\x001468\x00tsize\x000\x00", want: "\x00\x01ipxe.efi\x00octet\x00blksize\x001468\x00tsize\x000\x00"},
+		{name: "blksize", disabled: []string{"blksize"}, packet: "\x00\x01ipxe.efi\x00octet\x00blksize\x001468\x00tsize\x000\x00", want: "\x00\x01ipxe.efi\x00octet\x00tsize\x000\x00"},
+		{name: "any case", disabled: []string{"BLKSIZE", "tsize"}, packet: "\x00\x01ipxe.efi\x00octet\x00BlkSize\x001468\x00TSIZE\x000\x00", want: "\x00\x01ipxe.efi\x00octet\x00"},
+		{name: "not requested", disabled: []string{"multicast"}, packet: "\x00\x01ipxe.efi\x00octet\x00blksize\x001468\x00", want: "\x00\x01ipxe.efi\x00octet\x00blksize\x001468\x00"},
+		{name: "not a read request", disabled: []string{"blksize"}, packet: "\x00\x04\x00\x01", want: "\x00\x04\x00\x01"},
+		{name: "malformed", disabled: []string{"blksize"}, packet: "\x00\x01ipxe.efi\x00octet\x00blksize\x00", want: "\x00\x01ipxe.efi\x00octet\x00blksize\x00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := []byte(tt.packet)
+			n := Handler{DisabledOptions: tt.disabled}.stripOptions(p)
+			if diff := cmp.Diff(tt.want, string(p[:n])); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestOptionsConn(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if conn := OptionsConn(server, []string{"tsize", "windowsize"}); conn != server {
+		t.Fatal("expected the conn to be returned unchanged without blksize nor multicast disabled")
+	}
+	conn := OptionsConn(server, []string{"blksize"})
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.WriteTo([]byte("\x00\x01ipxe.efi\x00octet\x00blksize\x001468\x00"), server.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 512)
+	n, _, err := conn.ReadFrom(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("\x00\x01ipxe.efi\x00octet\x00", string(p[:n])); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestHandleReadTsizeDisabled(t *testing.T) {
+	for _, disabled := range []bool{false, true} {
+		h := Handler{Log: logr.Discard()}
+		if disabled {
+			h.DisabledOptions = []string{"tsize"}
+		}
+		rf := &sizeReaderFrom{readAllReaderFrom: readAllReaderFrom{fakeReaderFrom: fakeReaderFrom{addr: net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}}}}
+		if err := h.HandleRead("snp.efi", rf); err != nil {
+			t.Fatal(err)
+		}
+		if (rf.size == 0) != disabled {
+			t.Fatalf("expected the transfer size to be set: %v, got: %v", !disabled, rf.size)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package itftp
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ResumeSeparator separates a requested file name from the offset to resume its transfer at, see Handler.Resume.
+// For example "ipxe.efi@65536" is served ipxe.efi from its 65537th byte.
+const ResumeSeparator = "@"
+
+// ErrInvalidOffset is returned for a request to resume a transfer past the end of the file.
+var ErrInvalidOffset = errors.New("resume offset past the end of the file")
+
+// splitResumeOffset splits a requested file name into the name of the file and the offset to resume its transfer at.
+// ok is false when filename doesn't end with ResumeSeparator and a decimal offset.
+func splitResumeOffset(filename string) (name string, offset int64, ok bool) {
+	i := strings.LastIndex(filename, ResumeSeparator)
+	if i <= 0 {
+		return filename, 0, false
+	}
+	offset, err := strconv.ParseInt(filename[i+len(ResumeSeparator):], 10, 64)
+	if err != nil || offset < 0 {
+		return filename, 0, false
+	}
+	return filename[:i], offset, true
+}
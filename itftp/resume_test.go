@@ -0,0 +1,60 @@
+package itftp
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/binary"
+	"inet.af/netaddr"
+)
+
+func TestHandleReadResume(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		disabled bool
+		want     []byte
+		wantErr  error
+	}{
+		{name: "resume", filename: "snp.efi@1024", want: binary.SNP[1024:]},
+		{name: "resume with mac", filename: "0a:00:27:00:00:02/snp.efi@1024", want: binary.SNP[1024:]},
+		{name: "resume at start", filename: "snp.efi@0", want: binary.SNP},
+		{name: "resume at end", filename: "snp.efi@" + strconv.Itoa(len(binary.SNP)), want: []byte{}},
+		{name: "full transfer", filename: "snp.efi", want: binary.SNP},
+		{name: "past the end", filename: "snp.efi@" + strconv.Itoa(len(binary.SNP)+1), wantErr: ErrInvalidOffset},
+		{name: "not an offset", filename: "snp.efi@abc", wantErr: os.ErrNotExist},
+		{name: "disabled", filename: "snp.efi@1024", disabled: true, wantErr: os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var served string
+			ht := &Handler{
+				Log:      logr.Discard(),
+				Resume:   !tt.disabled,
+				OnServed: func(_ netaddr.IP, _ net.HardwareAddr, filename string, _ int64) { served = filename },
+			}
+			rf := &sizeReaderFrom{readAllReaderFrom: readAllReaderFrom{fakeReaderFrom: fakeReaderFrom{addr: net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}}}}
+			err := ht.HandleRead(tt.filename, rf)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error mismatch, got: %v, want: %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if !bytes.Equal(rf.got, tt.want) {
+				t.Fatalf("unexpected content served: %.20q", rf.got)
+			}
+			if rf.size != int64(len(tt.want)) {
+				t.Fatalf("expected a transfer size of %v, got: %v", len(tt.want), rf.size)
+			}
+			if served != "snp.efi" {
+				t.Fatalf("expected snp.efi to be served, got: %v", served)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package itftp
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pin/tftp"
+)
+
+// servingHook is a tftp.Hook telling when the serve loop of a github.com/pin/tftp server runs: its first failure is
+// the empty datagram sent by wait, which the loop fails to parse. It is not passed on.
+type servingHook struct {
+	tftp.Hook
+	once    sync.Once
+	serving chan struct{}
+}
+
+// newServingHook returns a servingHook passing the transfer hooks on to h.
+func newServingHook(h tftp.Hook) *servingHook {
+	return &servingHook{Hook: h, serving: make(chan struct{})}
+}
+
+// OnFailure implements tftp.Hook.
+func (s *servingHook) OnFailure(stats tftp.TransferStats, err error) {
+	first := false
+	s.once.Do(func() {
+		close(s.serving)
+		first = true
+	})
+	if !first {
+		s.Hook.OnFailure(stats, err)
+	}
+}
+
+// wait sends an empty datagram to the server listening on addr and waits for its serve loop to read it.
+func (s *servingHook) wait(t *testing.T, addr net.Addr) {
+	t.Helper()
+	c, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Write(nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-s.serving:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the server to serve")
+	}
+}
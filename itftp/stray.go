@@ -0,0 +1,57 @@
+package itftp
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pin/tftp"
+	"github.com/tinkerbell/ipxedust/clock"
+)
+
+// StrayLogInterval is the minimum time between two logs of stray packets, see StrayPackets.
+const StrayLogInterval = 10 * time.Second
+
+// StrayPackets rate limits the logs of the stray packets received on the request port of a github.com/pin/tftp
+// server: packets that are not requests, like the acknowledgement of a block, sent by a client to the request port
+// instead of the port of its transfer. The server ignores them, the transfers in flight are not affected.
+// A nil *StrayPackets doesn't rate limit. It is safe for concurrent use.
+type StrayPackets struct {
+	clock clock.Clock
+
+	mu sync.Mutex
+	// last is the time the last stray packet was logged.
+	last time.Time
+	// suppressed is the number of stray packets not logged since then.
+	suppressed int
+}
+
+// NewStrayPackets returns a StrayPackets logging at most once every StrayLogInterval, on c, or the real clock when
+// c is nil.
+func NewStrayPackets(c clock.Clock) *StrayPackets {
+	return &StrayPackets{clock: clock.Or(c)}
+}
+
+// log records a stray packet and reports whether it should be logged, with the number of stray packets that were
+// not logged since the last one that was.
+func (s *StrayPackets) log() (bool, int) {
+	if s == nil {
+		return true, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	if !s.last.IsZero() && now.Sub(s.last) < StrayLogInterval {
+		s.suppressed++
+		return false, 0
+	}
+	suppressed := s.suppressed
+	s.last, s.suppressed = now, 0
+	return true, suppressed
+}
+
+// isStray reports whether the failure of a github.com/pin/tftp server, passed to its tftp.Hook with stats and err,
+// is a stray packet. The server reports them, without a transfer, with an error like "unexpected tftp.pACK".
+func isStray(stats tftp.TransferStats, err error) bool {
+	return stats.Filename == "" && strings.HasPrefix(err.Error(), "unexpected ")
+}
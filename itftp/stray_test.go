@@ -0,0 +1,118 @@
+package itftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/pin/tftp"
+	"github.com/tinkerbell/ipxedust/clock"
+	"github.com/tinkerbell/ipxedust/resolve"
+)
+
+func TestStrayPacket(t *testing.T) {
+	var mu sync.Mutex
+	var logged []string
+	content := bytes.Repeat([]byte("0123456789abcdef"), 256)
+	h := Handler{
+		Log: funcr.New(func(_, args string) {
+			mu.Lock()
+			defer mu.Unlock()
+			logged = append(logged, args)
+		}, funcr.Options{}),
+		Resolver: resolve.Resolver{Files: map[string][]byte{"custom.efi": content}},
+		Stray:    NewStrayPackets(clock.NewFake(time.Unix(0, 0))),
+	}
+	s := h.NewServer()
+	hook := newServingHook(h)
+	s.SetHook(hook)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.Serve(conn) }()
+	// Shutdown races with Serve populating its conn until the serve loop runs. The probe of wait isn't passed on to
+	// h, so it isn't counted as a stray packet.
+	hook.wait(t, conn.LocalAddr())
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.WriteTo([]byte("\x00\x01custom.efi\x00octet\x00"), conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	// acknowledge each block to the request port too, like a client that sends to the wrong port.
+	var got []byte
+	buf := make([]byte, 1024)
+	for {
+		if err := client.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		n, addr, err := client.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n < 4 || binary.BigEndian.Uint16(buf) != 3 {
+			t.Fatalf("expected a DATA packet, got: %q", buf[:n])
+		}
+		got = append(got, buf[4:n]...)
+		ack := []byte{0, 4, buf[2], buf[3]}
+		if _, err := client.WriteTo(ack, conn.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.WriteTo(ack, addr); err != nil {
+			t.Fatal(err)
+		}
+		if n < 516 {
+			break
+		}
+	}
+	s.Shutdown()
+	if !bytes.Equal(got, content) {
+		t.Fatal("expected the transfer to complete unaffected by the stray packets")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	strays := 0
+	for _, l := range logged {
+		if strings.Contains(l, "stray packet") {
+			strays++
+		}
+	}
+	if strays != 1 {
+		t.Fatalf("expected a single stray packet log, the others suppressed, got: %v", strays)
+	}
+}
+
+func TestOnFailureStrayRateLimit(t *testing.T) {
+	var logged []string
+	c := clock.NewFake(time.Unix(0, 0))
+	h := Handler{
+		Log:   funcr.New(func(_, args string) { logged = append(logged, args) }, funcr.Options{}),
+		Stray: NewStrayPackets(c),
+	}
+	stray := errors.New("unexpected tftp.pACK")
+	h.OnFailure(tftp.TransferStats{}, stray)
+	h.OnFailure(tftp.TransferStats{}, stray)
+	h.OnFailure(tftp.TransferStats{}, stray)
+	c.Advance(StrayLogInterval)
+	h.OnFailure(tftp.TransferStats{}, stray)
+	// not a stray packet, logged at debug level only.
+	h.OnFailure(tftp.TransferStats{Filename: "ipxe.efi"}, errors.New("unexpected EOF"))
+
+	if len(logged) != 2 {
+		t.Fatalf("expected 2 stray packet logs, got: %q", logged)
+	}
+	if !strings.Contains(logged[0], `"suppressed"=0`) || !strings.Contains(logged[1], `"suppressed"=2`) {
+		t.Fatalf("expected the suppressed stray packets to be counted, got: %q", logged)
+	}
+}
@@ -0,0 +1,75 @@
+package itftp
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/resolve"
+	"inet.af/netaddr"
+)
+
+func TestHandleReadTimeout(t *testing.T) {
+	tests := []time.Duration{100 * time.Millisecond, 400 * time.Millisecond}
+	for _, timeout := range tests {
+		t.Run(timeout.String(), func(t *testing.T) {
+			// the handler runs once the server is serving, it can then be shut down.
+			serving := make(chan struct{})
+			h := Handler{
+				Log: logr.Discard(),
+				Resolver: resolve.Resolver{
+					Files: map[string][]byte{"big.efi": make([]byte, 4096)},
+					Dynamic: func(context.Context, netaddr.IPPort, string) ([]byte, bool, error) {
+						close(serving)
+						return nil, false, nil
+					},
+				},
+				Timeout: timeout,
+			}
+			s := h.NewServer()
+			// a single retry, without backoff, so the transfer is aborted after twice the timeout.
+			s.SetRetries(1)
+			s.SetBackoff(func(int) time.Duration { return 0 })
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go func() { _ = s.Serve(conn) }()
+
+			client, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			if _, err := client.WriteTo([]byte("\x00\x01big.efi\x00octet\x00"), conn.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+			<-serving
+			defer s.Shutdown()
+			// never acknowledge a block, like a client that went away.
+			var start time.Time
+			buf := make([]byte, 1024)
+			for {
+				if err := client.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+					t.Fatal(err)
+				}
+				n, _, err := client.ReadFrom(buf)
+				if err != nil {
+					t.Fatal("expected the transfer to be aborted:", err)
+				}
+				if start.IsZero() {
+					start = time.Now()
+				}
+				if n >= 2 && binary.BigEndian.Uint16(buf) == 5 {
+					break
+				}
+			}
+			if got := time.Since(start); got < timeout || got > 2*timeout+time.Second {
+				t.Fatalf("expected the transfer to be aborted after %v, got: %v", 2*timeout, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package itftp
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/tinkerbell/ipxedust/bandwidth"
+	"github.com/tinkerbell/ipxedust/resolve"
+	"github.com/tinkerbell/ipxedust/timing"
+	"inet.af/netaddr"
+)
+
+func TestHandleReadTimings(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), 4000)
+	var logged []string
+	var got []timing.Phases
+	h := Handler{
+		Log: funcr.New(func(prefix, args string) { logged = append(logged, args) }, funcr.Options{}),
+		Resolver: resolve.Resolver{Dynamic: func(context.Context, netaddr.IPPort, string) ([]byte, bool, error) {
+			time.Sleep(50 * time.Millisecond)
+			return content, true, nil
+		}},
+		Bandwidth: bandwidth.New(bandwidth.Config{PerTransfer: 20000}),
+		OnTimings: func(_ netaddr.IP, _ string, phases timing.Phases) { got = append(got, phases) },
+	}
+	rf := &readAllReaderFrom{fakeReaderFrom: fakeReaderFrom{addr: net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}}}
+	if err := h.HandleRead("custom.efi", rf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rf.got, content) {
+		t.Fatal("content mismatch")
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected OnTimings to be called once, got: %v", len(got))
+	}
+	p := got[0]
+	if p.Queue < 0 || p.Queue >= p.Resolve {
+		t.Errorf("expected a short queue phase, got: %+v", p)
+	}
+	if p.Resolve < 50*time.Millisecond || p.Resolve >= p.Transfer {
+		t.Errorf("expected a resolve phase of at least 50ms, shorter than the transfer, got: %+v", p)
+	}
+	if p.Transfer < 150*time.Millisecond {
+		t.Errorf("expected a transfer phase of at least 150ms, got: %+v", p)
+	}
+	if p.Total < p.Queue+p.Resolve+p.Transfer {
+		t.Errorf("expected the total to be at least the sum of the phases, got: %+v", p)
+	}
+
+	var served string
+	for _, l := range logged {
+		if strings.Contains(l, `"msg"="file served"`) {
+			served = l
+		}
+	}
+	for _, key := range []string{timing.LogKeyQueue, timing.LogKeyResolve, timing.LogKeyTransfer, timing.LogKeyTotal} {
+		if !strings.Contains(served, `"`+key+`"=`) {
+			t.Errorf("expected %v to be logged with the file served, got: %v", key, served)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package itftp
+
+import (
+	"errors"
+	"sync"
+
+	"inet.af/netaddr"
+)
+
+// ErrTooManyTransfers is returned when a client has reached its concurrent transfer limit.
+var ErrTooManyTransfers = errors.New("too many concurrent transfers")
+
+// Transfers counts in-progress transfers per client IP and caps how many a single client can have at once.
+// A nil *Transfers counts nothing and allows every transfer.
+type Transfers struct {
+	max    int
+	mu     sync.Mutex
+	active map[netaddr.IP]int
+}
+
+// NewTransfers returns a Transfers that allows at most max concurrent transfers per client IP.
+// A max less than 1 means unlimited, for which nil is returned.
+func NewTransfers(max int) *Transfers {
+	if max < 1 {
+		return nil
+	}
+	return &Transfers{max: max, active: make(map[netaddr.IP]int)}
+}
+
+// Acquire starts a transfer for ip. It returns false, without starting a transfer,
+// when ip already has the maximum number of transfers in progress.
+// Every successful Acquire must be followed by a Release.
+func (t *Transfers) Acquire(ip netaddr.IP) bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active[ip] >= t.max {
+		return false
+	}
+	t.active[ip]++
+	return true
+}
+
+// Release finishes a transfer for ip that was started with Acquire.
+func (t *Transfers) Release(ip netaddr.IP) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active[ip] <= 1 {
+		delete(t.active, ip)
+		return
+	}
+	t.active[ip]--
+}
+
+// Count returns the number of transfers in progress for ip.
+func (t *Transfers) Count(ip netaddr.IP) int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active[ip]
+}
@@ -0,0 +1,46 @@
+package itftp
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"inet.af/netaddr"
+)
+
+func TestTransfers(t *testing.T) {
+	a := netaddr.IPv4(192, 168, 2, 1)
+	b := netaddr.IPv4(192, 168, 2, 2)
+	tr := NewTransfers(2)
+
+	got := []bool{tr.Acquire(a), tr.Acquire(a), tr.Acquire(a), tr.Acquire(b)}
+	if diff := cmp.Diff(got, []bool{true, true, false, true}); diff != "" {
+		t.Fatal(diff)
+	}
+	tr.Release(a)
+	if diff := cmp.Diff(tr.Count(a), 1); diff != "" {
+		t.Fatal(diff)
+	}
+	if !tr.Acquire(a) {
+		t.Fatal("expected transfer to be allowed after release")
+	}
+	tr.Release(a)
+	tr.Release(a)
+	tr.Release(b)
+	if diff := cmp.Diff(len(tr.active), 0); diff != "" {
+		t.Fatalf("expected finished clients to be removed: %v", diff)
+	}
+}
+
+func TestTransfersUnlimited(t *testing.T) {
+	tr := NewTransfers(0)
+	ip := netaddr.IPv4(192, 168, 2, 1)
+	for i := 0; i < 100; i++ {
+		if !tr.Acquire(ip) {
+			t.Fatalf("transfer %v: expected unlimited transfers", i)
+		}
+	}
+	tr.Release(ip)
+	if diff := cmp.Diff(tr.Count(ip), 0); diff != "" {
+		t.Fatal(diff)
+	}
+}
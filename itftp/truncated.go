@@ -0,0 +1,40 @@
+package itftp
+
+import "io"
+
+// Reasons a transfer is truncated, see Handler.OnTruncated.
+const (
+	// TruncatedClientGone is a client that aborted the transfer, with an error packet, or that stopped acknowledging
+	// the blocks sent, for example a node that was reset while booting.
+	TruncatedClientGone = "client gone"
+	// TruncatedShortContent is content that ended before its size, for example a file truncated while it was sent.
+	TruncatedShortContent = "short content"
+)
+
+// readErrReader records the error reading the content of a transfer, to tell it apart from an error sending it:
+// github.com/pin/tftp returns both.
+type readErrReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *readErrReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// truncation returns why a transfer of content read by r, that ended with err after sending sent bytes of size, is
+// truncated, or an empty string when it isn't: it sent the whole file, or it failed on the server side, reading the
+// content or exceeding Handler.MaxTransferDuration.
+func truncation(r *readErrReader, err error, sent, size int64) string {
+	switch {
+	case sent >= size, r.err != nil:
+		return ""
+	case err != nil:
+		return TruncatedClientGone
+	}
+	return TruncatedShortContent
+}
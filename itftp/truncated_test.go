@@ -0,0 +1,104 @@
+package itftp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"testing/iotest"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/binary"
+	"inet.af/netaddr"
+)
+
+// truncated is a call to Handler.OnTruncated.
+type truncated struct {
+	Filename   string
+	Sent, Size int64
+	Reason     string
+}
+
+// abortingReaderFrom reads blocks of 512 bytes and fails with err once it read n of them, like a client that aborts
+// the transfer.
+type abortingReaderFrom struct {
+	fakeReaderFrom
+	n int
+}
+
+func (a *abortingReaderFrom) ReadFrom(r io.Reader) (int64, error) {
+	var sent int64
+	buf := make([]byte, 512)
+	for i := 0; i < a.n; i++ {
+		n, err := io.ReadFull(r, buf)
+		sent += int64(n)
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, a.err
+}
+
+func TestHandleReadTruncated(t *testing.T) {
+	errAborted := errors.New("sending block 3: code=0, error: aborted by the user")
+	size := int64(len(binary.Files["ipxe.efi"]))
+	tests := []struct {
+		name   string
+		blocks int
+		want   []truncated
+	}{
+		{name: "client aborted", blocks: 2, want: []truncated{{Filename: "ipxe.efi", Sent: 1024, Size: size, Reason: TruncatedClientGone}}},
+		{name: "zero bytes", want: []truncated{{Filename: "ipxe.efi", Size: size, Reason: TruncatedClientGone}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []truncated
+			h := Handler{
+				Log: logr.Discard(),
+				OnServed: func(netaddr.IP, net.HardwareAddr, string, int64) {
+					t.Error("expected a truncated transfer not to be served")
+				},
+				OnTruncated: func(_ netaddr.IP, filename string, sent, size int64, reason string) {
+					got = append(got, truncated{Filename: filename, Sent: sent, Size: size, Reason: reason})
+				},
+			}
+			rf := &abortingReaderFrom{fakeReaderFrom: fakeReaderFrom{addr: net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}, err: errAborted}, n: tt.blocks}
+			if err := h.HandleRead("ipxe.efi", rf); !errors.Is(err, errAborted) {
+				t.Fatalf("error mismatch, got: %v, want: %v", err, errAborted)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestTruncation(t *testing.T) {
+	errRead := errors.New("read failed")
+	tests := []struct {
+		name       string
+		readErr    error
+		err        error
+		sent, size int64
+		want       string
+	}{
+		{name: "served", sent: 10, size: 10},
+		{name: "empty file", sent: 0, size: 0},
+		{name: "client gone", err: errors.New("sending block 2: timeout"), sent: 4, size: 10, want: TruncatedClientGone},
+		{name: "short content", sent: 4, size: 10, want: TruncatedShortContent},
+		{name: "read error", readErr: errRead, err: errRead, sent: 4, size: 10},
+		{name: "deadline", readErr: ErrTransferDeadline, err: ErrTransferDeadline, sent: 4, size: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &readErrReader{r: iotest.ErrReader(tt.readErr)}
+			if tt.readErr != nil {
+				_, _ = r.Read(make([]byte, 1))
+			}
+			if got := truncation(r, tt.err, tt.sent, tt.size); got != tt.want {
+				t.Fatalf("got: %q, want: %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package itftp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/tinkerbell/ipxedust/resolve"
+	"inet.af/netaddr"
+)
+
+func TestServedVerbosity(t *testing.T) {
+	dynamic := func(_ context.Context, _ netaddr.IPPort, name string) ([]byte, bool, error) {
+		if name == "broken.efi" {
+			return nil, false, errors.New("generation failed")
+		}
+		return nil, false, nil
+	}
+	tests := []struct {
+		name       string
+		verbosity  int
+		filename   string
+		want       string
+		wantLogged bool
+	}{
+		{name: "served at info", filename: "ipxe.efi", want: `"level"=0 "msg"="file served"`, wantLogged: true},
+		{name: "served at verbosity", verbosity: 2, filename: "ipxe.efi", want: `"level"=2 "msg"="file served"`, wantLogged: true},
+		{name: "served suppressed", verbosity: 3, filename: "ipxe.efi", want: `"msg"="file served"`},
+		{name: "error", verbosity: 3, filename: "broken.efi", want: `"msg"="resolving requested file failed"`, wantLogged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged []string
+			h := Handler{
+				Log:             funcr.New(func(_, args string) { logged = append(logged, args) }, funcr.Options{Verbosity: 2}),
+				ServedVerbosity: tt.verbosity,
+				Resolver:        resolve.Resolver{Dynamic: dynamic},
+			}
+			rf := &readAllReaderFrom{fakeReaderFrom: fakeReaderFrom{addr: net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}}}
+			_ = h.HandleRead(tt.filename, rf)
+			found := false
+			for _, l := range logged {
+				if strings.Contains(l, tt.want) {
+					found = true
+				}
+			}
+			if found != tt.wantLogged {
+				t.Fatalf("expected %v logged: %v, got: %v", tt.want, tt.wantLogged, logged)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package itftp
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrWorkersBusy is returned when all the workers are busy and the queue of transfers waiting for one is full.
+var ErrWorkersBusy = errors.New("all workers busy")
+
+// Workers caps the transfers in progress at a number of workers, with a bounded queue of transfers waiting for a
+// worker. Transfers arriving when the queue is full are rejected at once. github.com/pin/tftp starts a goroutine for
+// every request before the Handler sees it, so Workers doesn't bound the goroutines started: it bounds how many of
+// them transfer and wait, the goroutines of the rejected requests end as soon as the client is sent the error.
+// A nil *Workers allows every transfer at once.
+type Workers struct {
+	// workers holds a token per transfer in progress.
+	workers chan struct{}
+	// queue holds a token per transfer waiting for a worker.
+	queue chan struct{}
+	// rejected counts the transfers rejected since the Workers were created.
+	rejected int64
+}
+
+// NewWorkers returns Workers running at most workers transfers at once, with at most queue transfers waiting for a
+// worker. A workers less than 1 means unlimited, for which nil is returned. A negative queue is an empty queue.
+func NewWorkers(workers, queue int) *Workers {
+	if workers < 1 {
+		return nil
+	}
+	if queue < 0 {
+		queue = 0
+	}
+	return &Workers{workers: make(chan struct{}, workers), queue: make(chan struct{}, queue)}
+}
+
+// Acquire waits for a worker to run a transfer, in the queue when all the workers are busy. It returns false,
+// without waiting, when the queue is full. Every successful Acquire must be followed by a Release.
+func (w *Workers) Acquire() bool {
+	if w == nil {
+		return true
+	}
+	select {
+	case w.workers <- struct{}{}:
+		return true
+	default:
+	}
+	select {
+	case w.queue <- struct{}{}:
+	default:
+		atomic.AddInt64(&w.rejected, 1)
+		return false
+	}
+	w.workers <- struct{}{}
+	<-w.queue
+	return true
+}
+
+// TryAcquire takes a worker to run a transfer when one is free. It returns false, without waiting in the queue and
+// without counting a rejection, when all the workers are busy. Every successful TryAcquire must be followed by a
+// Release.
+func (w *Workers) TryAcquire() bool {
+	if w == nil {
+		return true
+	}
+	select {
+	case w.workers <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release frees the worker of a transfer started with Acquire.
+func (w *Workers) Release() {
+	if w == nil {
+		return
+	}
+	<-w.workers
+}
+
+// Busy returns the number of workers running a transfer.
+func (w *Workers) Busy() int {
+	if w == nil {
+		return 0
+	}
+	return len(w.workers)
+}
+
+// Queued returns the number of transfers waiting for a worker.
+func (w *Workers) Queued() int {
+	if w == nil {
+		return 0
+	}
+	return len(w.queue)
+}
+
+// Rejected returns the number of transfers rejected because the queue was full.
+func (w *Workers) Rejected() int64 {
+	if w == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&w.rejected)
+}
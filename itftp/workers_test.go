@@ -0,0 +1,171 @@
+package itftp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+func TestWorkers(t *testing.T) {
+	w := NewWorkers(1, 1)
+	if !w.Acquire() {
+		t.Fatal("expected a worker to be free")
+	}
+	queued := make(chan bool)
+	go func() { queued <- w.Acquire() }()
+	for w.Queued() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	// the worker is busy and the queue is full.
+	if w.Acquire() {
+		t.Fatal("expected the transfer to be rejected")
+	}
+	w.Release()
+	if !<-queued {
+		t.Fatal("expected the queued transfer to get a worker")
+	}
+	got := []int{w.Busy(), w.Queued(), int(w.Rejected())}
+	if diff := cmp.Diff(got, []int{1, 0, 1}); diff != "" {
+		t.Fatal(diff)
+	}
+	w.Release()
+}
+
+func TestWorkersTryAcquire(t *testing.T) {
+	w := NewWorkers(1, 1)
+	if !w.TryAcquire() {
+		t.Fatal("expected a worker to be free")
+	}
+	// the queue isn't full, but TryAcquire doesn't wait in it.
+	if w.TryAcquire() {
+		t.Fatal("expected no worker to be free")
+	}
+	got := []int{w.Busy(), w.Queued(), int(w.Rejected())}
+	if diff := cmp.Diff(got, []int{1, 0, 0}); diff != "" {
+		t.Fatal(diff)
+	}
+	w.Release()
+	var unlimited *Workers
+	if !unlimited.TryAcquire() {
+		t.Fatal("expected nil Workers to allow every transfer")
+	}
+}
+
+func TestWorkersUnlimited(t *testing.T) {
+	var w *Workers
+	if NewWorkers(0, 10) != w {
+		t.Fatal("expected no workers to mean unlimited")
+	}
+	for i := 0; i < 100; i++ {
+		if !w.Acquire() {
+			t.Fatalf("transfer %v: expected unlimited transfers", i)
+		}
+	}
+	w.Release()
+	if diff := cmp.Diff([]int{w.Busy(), w.Queued(), int(w.Rejected())}, []int{0, 0, 0}); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestHandleReadWorkers(t *testing.T) {
+	ht := &Handler{Log: logr.Discard(), Workers: NewWorkers(1, 0)}
+	client := net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}
+	rf := &blockingReaderFrom{
+		fakeReaderFrom: fakeReaderFrom{addr: client, content: make([]byte, len(binary.Files["snp.efi"]))},
+		started:        make(chan struct{}),
+		release:        make(chan struct{}),
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- ht.HandleRead("snp.efi", rf) }()
+	<-rf.started
+
+	// another client is rejected, the only worker is busy and there is no queue.
+	other := net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 9999}
+	err := ht.HandleRead("snp.efi", &fakeReaderFrom{addr: other, content: make([]byte, 1)})
+	if !errors.Is(err, ErrWorkersBusy) {
+		t.Fatalf("error mismatch, got: %v, want: %v", err, ErrWorkersBusy)
+	}
+
+	close(rf.release)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if err := ht.HandleRead("snp.efi", &fakeReaderFrom{addr: other, content: make([]byte, 1)}); err != nil {
+		t.Fatalf("expected transfer to be allowed after the worker was freed, got: %v", err)
+	}
+}
+
+// slowReaderFrom is a fakeReaderFrom that takes delay to transfer, like a client acknowledging blocks over a slow link.
+type slowReaderFrom struct {
+	fakeReaderFrom
+	delay time.Duration
+}
+
+func (s *slowReaderFrom) ReadFrom(r io.Reader) (int64, error) {
+	time.Sleep(s.delay)
+	return s.fakeReaderFrom.ReadFrom(r)
+}
+
+// BenchmarkWorkers compares a burst of TFTP requests all transferring at once, as they do without Workers, to the same
+// burst served by a bounded number of workers. Both start a goroutine per request, like github.com/pin/tftp does: the
+// workers don't bound the goroutines started, they bound how many of them are transferring or waiting for a worker at
+// once, the rejected ones return at once. The requests arrive in batches, faster than the transfers finish, so the
+// transfers of the first model grow with the burst, while the workers hold them at the workers and their queue.
+// It reports the peak number of requests being handled at once, and the transfers served and
+// rejected per burst.
+func BenchmarkWorkers(b *testing.B) {
+	const (
+		batches = 20
+		batch   = 25
+		arrival = time.Millisecond
+		delay   = 20 * time.Millisecond
+	)
+	client := net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}
+	for _, bb := range []struct {
+		name           string
+		workers, queue int
+	}{{name: "unbounded transfers"}, {name: "workers", workers: 16, queue: 64}} {
+		b.Run(bb.name, func(b *testing.B) {
+			var served, rejected, inFlight, peak int64
+			for i := 0; i < b.N; i++ {
+				ht := &Handler{Log: logr.Discard(), Workers: NewWorkers(bb.workers, bb.queue)}
+				var wg sync.WaitGroup
+				for r := 0; r < batches*batch; r++ {
+					if r%batch == 0 {
+						time.Sleep(arrival)
+					}
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						n := atomic.AddInt64(&inFlight, 1)
+						defer atomic.AddInt64(&inFlight, -1)
+						for {
+							p := atomic.LoadInt64(&peak)
+							if n <= p || atomic.CompareAndSwapInt64(&peak, p, n) {
+								break
+							}
+						}
+						rf := &slowReaderFrom{fakeReaderFrom: fakeReaderFrom{addr: client, content: make([]byte, 1)}, delay: delay}
+						if err := ht.HandleRead("snp.efi", rf); err != nil {
+							atomic.AddInt64(&rejected, 1)
+							return
+						}
+						atomic.AddInt64(&served, 1)
+					}()
+				}
+				wg.Wait()
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&peak)), "peak-in-flight")
+			b.ReportMetric(float64(served)/float64(b.N), "served/op")
+			b.ReportMetric(float64(rejected)/float64(b.N), "rejected/op")
+		})
+	}
+}
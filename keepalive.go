@@ -0,0 +1,29 @@
+package ipxedust
+
+import (
+	"net"
+	"time"
+)
+
+// keepAliveListener sets the TCP keep-alive period of the connections it accepts, see ServerSpec.KeepAlive.
+type keepAliveListener struct {
+	net.Listener
+	// period of the keep-alive probes, a negative period disables them.
+	period time.Duration
+}
+
+func (l keepAliveListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		if l.period < 0 {
+			_ = tc.SetKeepAlive(false)
+		} else {
+			_ = tc.SetKeepAlive(true)
+			_ = tc.SetKeepAlivePeriod(l.period)
+		}
+	}
+	return conn, nil
+}
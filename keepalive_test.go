@@ -0,0 +1,69 @@
+package ipxedust
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestHTTPIdleTimeout(t *testing.T) {
+	ready := make(chan net.Addr, 1)
+	c := &Server{
+		Log:  logr.Discard(),
+		TFTP: ServerSpec{Disabled: true},
+		HTTP: ServerSpec{IdleTimeout: 200 * time.Millisecond, KeepAlive: time.Minute},
+		OnReady: func(_ Protocol, addr net.Addr) {
+			ready <- addr
+		},
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Serve(ctx, l, nil) }()
+	defer func() {
+		cancel()
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}()
+	addr := <-ready
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprintf(conn, "GET /snp.efi HTTP/1.1\r\nHost: ipxe\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// the connection is kept alive, and closed by the server once it was idle for IdleTimeout.
+	start := time.Now()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := br.ReadByte(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected the server to close the idle connection, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("expected the connection to be closed after about %v, took: %v", c.HTTP.IdleTimeout, elapsed)
+	}
+}
@@ -0,0 +1,127 @@
+package ipxedust
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pin/tftp"
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+// TestArtificialLatency shows how the timeouts of the clients are tested against a slow server: a client giving up
+// sooner than the latency fails, one waiting longer is served.
+func TestArtificialLatency(t *testing.T) {
+	const latency = 200 * time.Millisecond
+	ready := make(chan struct{}, 2)
+	addrs := make(map[Protocol]net.Addr)
+	var mu sync.Mutex
+	c := &Server{
+		Log:               logr.Discard(),
+		ArtificialLatency: latency,
+		OnReady: func(p Protocol, addr net.Addr) {
+			mu.Lock()
+			addrs[p] = addr
+			mu.Unlock()
+			ready <- struct{}{}
+		},
+	}
+	// the transfers of the TFTP requests the client gave up on are retried by github.com/pin/tftp after a random
+	// backoff of up to a second, which delays the shutdown: they are given up soon after the latency instead.
+	c.TFTP.Timeout = latency / 2
+	c.NewTFTPServer = func(read func(string, io.ReaderFrom) error, write func(string, io.WriterTo) error) TFTPServer {
+		s := tftp.NewServer(read, write)
+		s.SetRetries(1)
+		s.SetBackoff(func(int) time.Duration { return 0 })
+		return s
+	}
+	conn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Serve(ctx, conn, uconn) }()
+	defer func() {
+		cancel()
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}()
+	<-ready
+	<-ready
+	mu.Lock()
+	httpAddr, tftpAddr := addrs[ProtocolHTTP].String(), addrs[ProtocolTFTP].String()
+	mu.Unlock()
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		wantErr bool
+	}{
+		{name: "shorter client timeout", timeout: latency / 4, wantErr: true},
+		{name: "longer client timeout", timeout: 10 * latency},
+	}
+	for _, tt := range tests {
+		t.Run("http "+tt.name, func(t *testing.T) {
+			hc := &http.Client{Timeout: tt.timeout}
+			start := time.Now()
+			resp, err := hc.Get(fmt.Sprintf("http://%v/snp.efi", httpAddr))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected an error: %v, got: %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+			n, err := io.Copy(io.Discard, resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int64(len(binary.Files["snp.efi"])) {
+				t.Fatalf("expected %v bytes, got: %v", len(binary.Files["snp.efi"]), n)
+			}
+			if took := time.Since(start); took < latency {
+				t.Fatalf("expected the request to be delayed by %v, took: %v", latency, took)
+			}
+		})
+		t.Run("tftp "+tt.name, func(t *testing.T) {
+			tc, err := tftp.NewClient(tftpAddr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the client sends its request twice, giving up after two timeouts.
+			tc.SetTimeout(tt.timeout)
+			tc.SetRetries(1)
+			tc.SetBackoff(func(int) time.Duration { return 0 })
+			start := time.Now()
+			var n int64
+			wt, err := tc.Receive("snp.efi", "octet")
+			if err == nil {
+				n, err = wt.WriteTo(io.Discard)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected an error: %v, got: %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if n != int64(len(binary.Files["snp.efi"])) {
+				t.Fatalf("expected %v bytes, got: %v", len(binary.Files["snp.efi"]), n)
+			}
+			if took := time.Since(start); took < latency {
+				t.Fatalf("expected the transfer to be delayed by %v, took: %v", latency, took)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package ipxedust
+
+import (
+	"context"
+	"path"
+
+	"github.com/tinkerbell/ipxedust/resolve"
+	"inet.af/netaddr"
+)
+
+// Locate returns where the file name would be served from, over both protocols, without serving it: the name is
+// checked against Filenames and then looked up like requests are, with the aliases, the registered files, FileSystems,
+// the embedded binaries and upstream. Only its base name is looked up, like requests, so directories, like a MAC
+// address, and ".." elements don't reach outside of the files served.
+// The returned error wraps filename.ErrInvalid for a name that is rejected, and os.ErrNotExist for a name that is not
+// found. Files served to some clients only, like canary binaries, and over HTTP only, like templates, are not located.
+// DynamicBinary is called and upstream is fetched, as what they serve is only known once generated or fetched.
+// The first boot script of FirstBoot is located without recording a boot.
+// It is meant for the running server, for readiness checks and tests: before serving starts, the aliases, the files
+// pulled from OCIReference and upstream are not known yet.
+func (c *Server) Locate(name string) (resolve.Location, error) {
+	if err := c.Filenames.Check(name); err != nil {
+		return resolve.Location{}, err
+	}
+	base := path.Base(name)
+	if c.FirstBoot != nil && base == c.FirstBoot.Name {
+		return resolve.Location{Name: base, Size: int64(len(c.FirstBoot.First)), Source: resolve.SourceDynamic}, nil
+	}
+	return c.resolver().Locate(context.Background(), netaddr.IPPort{}, name)
+}
+
+// CanServe reports whether the file name would be served, see Locate.
+func (c *Server) CanServe(name string) bool {
+	_, err := c.Locate(name)
+	return err == nil
+}
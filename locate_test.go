@@ -0,0 +1,61 @@
+package ipxedust
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/filename"
+	"github.com/tinkerbell/ipxedust/firstboot"
+	"github.com/tinkerbell/ipxedust/resolve"
+	"inet.af/netaddr"
+)
+
+func TestLocate(t *testing.T) {
+	c := &Server{
+		Aliases:     map[string]string{"bootx64.efi": "ipxe.efi"},
+		FileSystems: []fs.FS{fstest.MapFS{"custom.efi": {Data: []byte("custom")}}},
+		FirstBoot:   &firstboot.Tracker{Name: "first.ipxe", First: []byte("#!ipxe\n")},
+	}
+	if err := c.start(context.Background(), Server{Log: logr.Discard()}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		want    resolve.Location
+		wantErr error
+	}{
+		{name: "snp.efi", want: resolve.Location{Name: "snp.efi", Size: int64(len(binary.Files["snp.efi"])), Source: resolve.SourceEmbedded}},
+		{name: "bootx64.efi", want: resolve.Location{Name: "ipxe.efi", Size: int64(len(binary.Files["ipxe.efi"])), Source: resolve.SourceEmbedded}},
+		{name: "custom.efi", want: resolve.Location{Name: "custom.efi", Size: 6, Source: resolve.SourceFS}},
+		{name: "first.ipxe", want: resolve.Location{Name: "first.ipxe", Size: 7, Source: resolve.SourceDynamic}},
+		{name: "unknown.efi", wantErr: os.ErrNotExist},
+		{name: "../../../etc/passwd", wantErr: os.ErrNotExist},
+		{name: "..\\..\\windows\\win.ini", wantErr: filename.ErrInvalid},
+		{name: "", wantErr: filename.ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.Locate(tt.name)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got: %v", tt.wantErr, err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+			if c.CanServe(tt.name) != (tt.wantErr == nil) {
+				t.Fatalf("expected CanServe to be %v", tt.wantErr == nil)
+			}
+		})
+	}
+	// locating the first boot script doesn't record a boot.
+	if got, _, err := c.FirstBoot.Dynamic(context.Background(), netaddr.IPPort{}, "first.ipxe"); err != nil || string(got) != "#!ipxe\n" {
+		t.Fatalf("expected the first boot script, got: %q, %v", got, err)
+	}
+}
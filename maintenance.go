@@ -0,0 +1,39 @@
+package ipxedust
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// SetMaintenance turns maintenance mode on or off. In maintenance mode new requests are rejected,
+// with a 503 over HTTP and an error over TFTP, while in-flight transfers complete.
+// The readiness endpoint, when ReadyPath is set, reports 503 as well so load balancers stop sending traffic.
+// It is safe to call concurrently with serving.
+func (c *Server) SetMaintenance(on bool) {
+	var v uint32
+	if on {
+		v = 1
+	}
+	atomic.StoreUint32(&c.maintenance, v)
+	if c.Log.GetSink() != nil {
+		c.log().Info("maintenance mode changed", "maintenance", on)
+	}
+}
+
+// InMaintenance reports whether the server is in maintenance mode.
+func (c *Server) InMaintenance() bool {
+	return atomic.LoadUint32(&c.maintenance) == 1
+}
+
+// serveReady is the readiness endpoint. It responds 200 when serving and 503 in maintenance mode or while paused.
+func (c *Server) serveReady(w http.ResponseWriter, _ *http.Request) {
+	switch {
+	case c.InMaintenance():
+		http.Error(w, "maintenance", http.StatusServiceUnavailable)
+		return
+	case c.Paused():
+		http.Error(w, "paused", http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,130 @@
+package ipxedust
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/pin/tftp"
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+func TestSetMaintenance(t *testing.T) {
+	c := &Server{}
+	if c.InMaintenance() {
+		t.Fatal("expected the server not to start in maintenance mode")
+	}
+	c.SetMaintenance(true)
+	if !c.InMaintenance() {
+		t.Fatal("expected the server to be in maintenance mode")
+	}
+	c.SetMaintenance(false)
+	if c.InMaintenance() {
+		t.Fatal("expected the server to be out of maintenance mode")
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	ready := make(chan struct{}, 2)
+	addrs := make(map[Protocol]net.Addr)
+	var mu sync.Mutex
+	c := &Server{
+		Log:       logr.Discard(),
+		ReadyPath: "/ready",
+		OnReady: func(p Protocol, addr net.Addr) {
+			mu.Lock()
+			addrs[p] = addr
+			mu.Unlock()
+			ready <- struct{}{}
+		},
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Serve(ctx, smallBufferListener{l}, uconn) }()
+	defer func() {
+		cancel()
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}()
+	<-ready
+	<-ready
+	mu.Lock()
+	httpAddr, tftpAddr := addrs[ProtocolHTTP].String(), addrs[ProtocolTFTP].String()
+	mu.Unlock()
+
+	status := func(path string) int {
+		t.Helper()
+		resp, err := http.Get(fmt.Sprintf("http://%v%v", httpAddr, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode
+	}
+	if got := status("/ready"); got != http.StatusOK {
+		t.Fatalf("expected ready status %v, got: %v", http.StatusOK, got)
+	}
+
+	// start a transfer over each protocol before entering maintenance mode.
+	resp, err := http.Get(fmt.Sprintf("http://%v/ipxe.efi", httpAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	tc, err := tftp.NewClient(tftpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := tc.Receive("undionly.kpxe", "octet")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.SetMaintenance(true)
+	if got := status("/ready"); got != http.StatusServiceUnavailable {
+		t.Fatalf("expected ready status %v, got: %v", http.StatusServiceUnavailable, got)
+	}
+	if got := status("/snp.efi"); got != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %v for a new HTTP request, got: %v", http.StatusServiceUnavailable, got)
+	}
+	if _, err := tc.Receive("snp.efi", "octet"); err == nil {
+		t.Fatal("expected a new TFTP request to be rejected")
+	}
+
+	// the transfers in flight complete.
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, binary.Files["ipxe.efi"]) {
+		t.Fatalf("expected the in-flight HTTP transfer to complete, got %v of %v bytes", len(b), len(binary.Files["ipxe.efi"]))
+	}
+	var buf bytes.Buffer
+	if _, err := wt.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), binary.Files["undionly.kpxe"]) {
+		t.Fatalf("expected the in-flight TFTP transfer to complete, got %v of %v bytes", buf.Len(), len(binary.Files["undionly.kpxe"]))
+	}
+
+	c.SetMaintenance(false)
+	if got := status("/snp.efi"); got != http.StatusOK {
+		t.Fatalf("expected status %v after leaving maintenance mode, got: %v", http.StatusOK, got)
+	}
+}
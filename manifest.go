@@ -0,0 +1,22 @@
+package ipxedust
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// serveManifest serves the files served as JSON, see ManifestPath.
+func (c *Server) serveManifest(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	m, err := c.resolver().Manifest()
+	if err != nil {
+		c.log().Error(err, "listing the files served failed")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(m)
+}
@@ -0,0 +1,43 @@
+package ipxedust
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/resolve"
+)
+
+func TestServeManifest(t *testing.T) {
+	c := &Server{Log: logr.Discard(), EnableInfoFile: true}
+	w := httptest.NewRecorder()
+	c.serveManifest(w, httptest.NewRequest(http.MethodGet, "/manifest", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %v, got: %v", http.StatusOK, w.Code)
+	}
+	var m resolve.Manifest
+	if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]resolve.Entry)
+	for _, e := range m.Files {
+		got[e.Name] = e
+	}
+	for name, content := range binary.Files {
+		if e := got[name]; e.Size != int64(len(content)) || e.Source != resolve.SourceEmbedded || e.ETag == "" {
+			t.Errorf("%v: unexpected entry: %+v", name, e)
+		}
+	}
+	if e := got[resolve.InfoFileName]; e.Source != resolve.SourceInfo {
+		t.Errorf("expected the info file to be listed, got: %+v", e)
+	}
+
+	w = httptest.NewRecorder()
+	c.serveManifest(w, httptest.NewRequest(http.MethodPost, "/manifest", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status %v, got: %v", http.StatusMethodNotAllowed, w.Code)
+	}
+}
@@ -0,0 +1,108 @@
+package oci
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Auth holds the credentials for a registry.
+type Auth struct {
+	// Username and Password are used for basic authentication, and to request bearer tokens.
+	Username string
+	Password string
+	// Token, when not empty, is sent as a bearer token as is, instead of requesting one.
+	Token string
+}
+
+// dockerConfig is the part of a Docker config file, like ~/.docker/config.json, that holds credentials.
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		RegistryToken string `json:"registrytoken"`
+	} `json:"auths"`
+}
+
+// LoadDockerConfig reads the registry credentials in the Docker config file at path, keyed by registry host.
+// Credential helpers and stores are not supported.
+func LoadDockerConfig(path string) (map[string]Auth, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading docker config: %w", err)
+	}
+	var cfg dockerConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing docker config %v: %w", path, err)
+	}
+	auths := make(map[string]Auth, len(cfg.Auths))
+	for host, a := range cfg.Auths {
+		auth := Auth{Username: a.Username, Password: a.Password, Token: a.RegistryToken}
+		if a.Auth != "" {
+			d, err := base64.StdEncoding.DecodeString(a.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("parsing docker config %v: invalid auth for %v: %w", path, host, err)
+			}
+			parts := strings.SplitN(string(d), ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("parsing docker config %v: invalid auth for %v", path, host)
+			}
+			auth.Username, auth.Password = parts[0], parts[1]
+		}
+		auths[registryHost(host)] = auth
+	}
+	return auths, nil
+}
+
+// registryHost returns the host of a Docker config auths key, which may be a URL like "https://index.docker.io/v1/".
+func registryHost(key string) string {
+	if u, err := url.Parse(key); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return strings.TrimSuffix(key, "/")
+}
+
+// challenge is a parsed WWW-Authenticate header.
+type challenge struct {
+	scheme string
+	params map[string]string
+}
+
+// parseChallenge parses a WWW-Authenticate header like `Bearer realm="https://auth.example.com/token",service="registry"`.
+func parseChallenge(h string) challenge {
+	scheme, rest := h, ""
+	if i := strings.Index(h, " "); i >= 0 {
+		scheme, rest = h[:i], h[i+1:]
+	}
+	c := challenge{scheme: strings.ToLower(scheme), params: make(map[string]string)}
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		i := strings.Index(rest, "=")
+		if i < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:i]))
+		rest = rest[i+1:]
+		var val string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				val, rest = rest[1:], ""
+			} else {
+				val, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.Index(rest, ",")
+			if end < 0 {
+				end = len(rest)
+			}
+			val, rest = rest[:end], rest[end:]
+		}
+		c.params[key] = val
+	}
+	return c
+}
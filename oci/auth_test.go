@@ -0,0 +1,74 @@
+package oci
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadDockerConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    map[string]Auth
+		wantErr bool
+	}{
+		{
+			name: "auths",
+			config: `{"auths": {
+				"https://index.docker.io/v1/": {"auth": "dXNlcjpwYXNz"},
+				"ghcr.io": {"username": "octo", "password": "cat"},
+				"registry.example.com:5000": {"registrytoken": "t0ken"}
+			}}`,
+			want: map[string]Auth{
+				"index.docker.io":           {Username: "user", Password: "pass"},
+				"ghcr.io":                   {Username: "octo", Password: "cat"},
+				"registry.example.com:5000": {Token: "t0ken"},
+			},
+		},
+		{name: "no auths", config: `{"credsStore": "desktop"}`, want: map[string]Auth{}},
+		{name: "invalid json", config: `{`, wantErr: true},
+		{name: "invalid auth", config: `{"auths": {"ghcr.io": {"auth": "!!"}}}`, wantErr: true},
+		{name: "auth without password", config: `{"auths": {"ghcr.io": {"auth": "dXNlcg=="}}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(p, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadDockerConfig(p)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error: %v, got: %v", tt.wantErr, err)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		in   string
+		want challenge
+	}{
+		{
+			in:   `Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:ipxe:pull"`,
+			want: challenge{scheme: "bearer", params: map[string]string{"realm": "https://auth.example.com/token", "service": "registry.example.com", "scope": "repository:ipxe:pull"}},
+		},
+		{in: `Basic realm="registry"`, want: challenge{scheme: "basic", params: map[string]string{"realm": "registry"}}},
+		{in: `Bearer realm=unquoted, service="svc"`, want: challenge{scheme: "bearer", params: map[string]string{"realm": "unquoted", "service": "svc"}}},
+		{in: ``, want: challenge{scheme: "", params: map[string]string{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got := parseChallenge(tt.in)
+			if diff := cmp.Diff(got, tt.want, cmp.AllowUnexported(challenge{})); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
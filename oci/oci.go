@@ -0,0 +1,303 @@
+// Package oci pulls iPXE binaries packaged as an OCI artifact from a container registry.
+//
+// Each layer of the artifact with an "org.opencontainers.image.title" annotation is a file,
+// named after the annotation. Artifacts pushed with `oras push registry/repo:tag snp.efi ipxe.efi` have this layout.
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrPull is returned when pulling an artifact fails.
+	ErrPull = errors.New("oci pull failed")
+	// ErrUnauthorized is returned when the registry rejects the credentials, or requires credentials and there are none.
+	ErrUnauthorized = errors.New("registry authentication failed")
+)
+
+// titleAnnotation is the layer annotation that names the file in the layer.
+const titleAnnotation = "org.opencontainers.image.title"
+
+// manifestMediaTypes are the manifest media types accepted from registries.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// maxManifestSize caps the size of manifests read from registries.
+const maxManifestSize = 4 * 1024 * 1024
+
+// manifest is the part of an OCI image manifest needed to pull files.
+type manifest struct {
+	Layers []descriptor `json:"layers"`
+}
+
+// descriptor describes a blob.
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// Puller pulls files from OCI artifacts. The zero value pulls anonymously over HTTPS without a local cache.
+type Puller struct {
+	// Client makes the registry requests. Defaults to http.DefaultClient.
+	Client *http.Client
+	// Auth holds credentials keyed by registry host. See LoadDockerConfig.
+	Auth map[string]Auth
+	// CacheDir, when not empty, is a directory blobs are cached in by digest, so that they are only downloaded once.
+	CacheDir string
+	// PlainHTTP talks to registries over HTTP instead of HTTPS, for local registries.
+	PlainHTTP bool
+}
+
+// Pull returns the files in the artifact ref, keyed by name.
+// The returned error wraps ErrUnauthorized when authentication fails, and ErrPull for any other failure.
+func (p Puller) Pull(ctx context.Context, ref string) (map[string][]byte, error) {
+	r, err := ParseReference(ref)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPull, err)
+	}
+	s := &session{Puller: p, ref: r}
+	if s.Client == nil {
+		s.Client = http.DefaultClient
+	}
+	s.auth, s.hasAuth = p.Auth[r.Registry]
+	if !s.hasAuth && r.Registry == dockerHub {
+		s.auth, s.hasAuth = p.Auth[dockerHubAuth]
+	}
+
+	b, err := s.get(ctx, "manifests/"+r.Reference, strings.Join(manifestMediaTypes, ", "), maxManifestSize)
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%w: %v: parsing manifest: %v", ErrPull, r, err)
+	}
+	files := make(map[string][]byte)
+	for _, l := range m.Layers {
+		name := path.Base(l.Annotations[titleAnnotation])
+		if name == "." || name == "/" {
+			continue
+		}
+		content, err := s.blob(ctx, l)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = content
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: %v: no files in artifact, layers must have the %v annotation", ErrPull, r, titleAnnotation)
+	}
+	return files, nil
+}
+
+// session pulls a single reference. It keeps the bearer token obtained for the repository.
+type session struct {
+	Puller
+	ref     Reference
+	auth    Auth
+	hasAuth bool
+	token   string
+}
+
+// blob returns the content of the blob d, from the cache when it is there, and verifies its digest.
+func (s *session) blob(ctx context.Context, d descriptor) ([]byte, error) {
+	algo, hexDigest, ok := splitDigest(d.Digest)
+	if !ok || algo != "sha256" {
+		return nil, fmt.Errorf("%w: %v: unsupported digest %q", ErrPull, s.ref, d.Digest)
+	}
+	cached := ""
+	if s.CacheDir != "" {
+		cached = filepath.Join(s.CacheDir, algo, hexDigest)
+		if b, err := os.ReadFile(cached); err == nil && verify(b, hexDigest) {
+			return b, nil
+		}
+	}
+	b, err := s.get(ctx, "blobs/"+d.Digest, "", d.Size)
+	if err != nil {
+		return nil, err
+	}
+	if !verify(b, hexDigest) {
+		return nil, fmt.Errorf("%w: %v: blob %v does not match its digest", ErrPull, s.ref, d.Digest)
+	}
+	if cached != "" {
+		if err := writeFile(cached, b); err != nil {
+			return nil, fmt.Errorf("%w: %v: caching blob %v: %v", ErrPull, s.ref, d.Digest, err)
+		}
+	}
+	return b, nil
+}
+
+// get returns the body of the registry API endpoint "/v2/<repository>/<p>", of at most maxSize bytes.
+// It authenticates when the registry asks for it.
+func (s *session) get(ctx context.Context, p, accept string, maxSize int64) ([]byte, error) {
+	scheme := "https"
+	if s.PlainHTTP {
+		scheme = "http"
+	}
+	u := fmt.Sprintf("%v://%v/v2/%v/%v", scheme, s.ref.Registry, s.ref.Repository, p)
+	resp, err := s.do(ctx, u, accept)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && s.token == "" {
+		c := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+		resp.Body.Close()
+		if err := s.authenticate(ctx, c); err != nil {
+			return nil, err
+		}
+		if resp, err = s.do(ctx, u, accept); err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, fmt.Errorf("%w: %v: %v", ErrUnauthorized, s.ref, resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%w: %v: GET %v: %v", ErrPull, s.ref, p, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v: GET %v: %v", ErrPull, s.ref, p, err)
+	}
+	if int64(len(b)) > maxSize {
+		return nil, fmt.Errorf("%w: %v: GET %v: larger than %v bytes", ErrPull, s.ref, p, maxSize)
+	}
+	return b, nil
+}
+
+// do sends a GET request to u with the credentials of the session.
+func (s *session) do(ctx context.Context, u, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v: %v", ErrPull, s.ref, err)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	switch {
+	case s.token != "":
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	case s.hasAuth && s.auth.Token != "":
+		req.Header.Set("Authorization", "Bearer "+s.auth.Token)
+	case s.hasAuth && s.auth.Username != "":
+		req.SetBasicAuth(s.auth.Username, s.auth.Password)
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v: %v", ErrPull, s.ref, err)
+	}
+	return resp, nil
+}
+
+// authenticate answers the challenge c of the registry. Basic challenges are answered with the
+// credentials of the session. Bearer challenges are answered by requesting a pull token from the realm.
+func (s *session) authenticate(ctx context.Context, c challenge) error {
+	switch c.scheme {
+	case "basic":
+		if !s.hasAuth || s.auth.Username == "" {
+			return fmt.Errorf("%w: %v: registry requires credentials", ErrUnauthorized, s.ref)
+		}
+		// the credentials were already sent, retrying would fail the same way.
+		return fmt.Errorf("%w: %v: credentials rejected", ErrUnauthorized, s.ref)
+	case "bearer":
+	default:
+		return fmt.Errorf("%w: %v: unsupported authentication scheme %q", ErrUnauthorized, s.ref, c.scheme)
+	}
+	realm, err := url.Parse(c.params["realm"])
+	if err != nil || realm.Host == "" {
+		return fmt.Errorf("%w: %v: invalid token realm %q", ErrUnauthorized, s.ref, c.params["realm"])
+	}
+	q := realm.Query()
+	if svc := c.params["service"]; svc != "" {
+		q.Set("service", svc)
+	}
+	scope := c.params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%v:pull", s.ref.Repository)
+	}
+	q.Set("scope", scope)
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v: %v", ErrUnauthorized, s.ref, err)
+	}
+	if s.hasAuth && s.auth.Username != "" {
+		req.SetBasicAuth(s.auth.Username, s.auth.Password)
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v: requesting token: %v", ErrUnauthorized, s.ref, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %v: requesting token: %v", ErrUnauthorized, s.ref, resp.Status)
+	}
+	var t struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&t); err != nil {
+		return fmt.Errorf("%w: %v: parsing token: %v", ErrUnauthorized, s.ref, err)
+	}
+	s.token = t.Token
+	if s.token == "" {
+		s.token = t.AccessToken
+	}
+	if s.token == "" {
+		return fmt.Errorf("%w: %v: empty token", ErrUnauthorized, s.ref)
+	}
+	return nil
+}
+
+// splitDigest splits a digest like "sha256:abc..." into its algorithm and hex encoded value.
+func splitDigest(d string) (string, string, bool) {
+	parts := strings.SplitN(d, ":", 2)
+	if len(parts) != 2 || parts[1] == "" || strings.ContainsAny(parts[1], `/\.`) {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// verify reports whether the sha256 digest of b is hexDigest.
+func verify(b []byte, hexDigest string) bool {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]) == hexDigest
+}
+
+// writeFile writes b to name atomically, creating its directory.
+func writeFile(name string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), ".blob-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
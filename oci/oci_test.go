@@ -0,0 +1,185 @@
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// registry is a local registry fixture serving a single artifact.
+type registry struct {
+	// auth is "", "basic" or "bearer".
+	auth     string
+	username string
+	password string
+	files    map[string][]byte
+	// corrupt serves blobs that don't match their digest.
+	corrupt bool
+
+	mu         sync.Mutex
+	blobGets   int
+	tokenCalls int
+}
+
+func digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func (r *registry) server(t *testing.T) *httptest.Server {
+	t.Helper()
+	blobs := make(map[string][]byte)
+	m := manifest{}
+	for name, content := range r.files {
+		d := digest(content)
+		blobs[d] = content
+		m.Layers = append(m.Layers, descriptor{
+			MediaType:   "application/octet-stream",
+			Digest:      d,
+			Size:        int64(len(content)),
+			Annotations: map[string]string{titleAnnotation: name},
+		})
+	}
+	// a layer without a title is not a file.
+	m.Layers = append(m.Layers, descriptor{MediaType: "application/octet-stream", Digest: digest(nil)})
+	mb, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			r.mu.Lock()
+			r.tokenCalls++
+			r.mu.Unlock()
+			if u, p, _ := req.BasicAuth(); u != r.username || p != r.password {
+				http.Error(w, "denied", http.StatusUnauthorized)
+				return
+			}
+			if req.URL.Query().Get("scope") != "repository:tinkerbell/ipxe:pull" || req.URL.Query().Get("service") != "fixture" {
+				http.Error(w, "bad scope", http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"token":"t0ken"}`))
+			return
+		}
+		switch r.auth {
+		case "basic":
+			if u, p, _ := req.BasicAuth(); u != r.username || p != r.password {
+				w.Header().Set("WWW-Authenticate", `Basic realm="fixture"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		case "bearer":
+			if req.Header.Get("Authorization") != "Bearer t0ken" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%v/token",service="fixture",scope="repository:tinkerbell/ipxe:pull"`, srv.URL))
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		switch {
+		case req.URL.Path == "/v2/tinkerbell/ipxe/manifests/v1":
+			if !strings.Contains(req.Header.Get("Accept"), "application/vnd.oci.image.manifest.v1+json") {
+				http.Error(w, "not acceptable", http.StatusNotAcceptable)
+				return
+			}
+			w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+			_, _ = w.Write(mb)
+		case strings.HasPrefix(req.URL.Path, "/v2/tinkerbell/ipxe/blobs/"):
+			b, ok := blobs[strings.TrimPrefix(req.URL.Path, "/v2/tinkerbell/ipxe/blobs/")]
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+			r.mu.Lock()
+			r.blobGets++
+			r.mu.Unlock()
+			if r.corrupt {
+				b = append([]byte("x"), b[1:]...)
+			}
+			_, _ = w.Write(b)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestPull(t *testing.T) {
+	files := map[string][]byte{"snp.efi": []byte("snp content"), "undionly.kpxe": []byte("undionly content")}
+	tests := []struct {
+		name    string
+		reg     *registry
+		auth    *Auth
+		ref     string
+		wantErr error
+	}{
+		{name: "anonymous", reg: &registry{files: files}, ref: "tinkerbell/ipxe:v1"},
+		{name: "basic auth", reg: &registry{auth: "basic", username: "user", password: "pass", files: files}, auth: &Auth{Username: "user", Password: "pass"}, ref: "tinkerbell/ipxe:v1"},
+		{name: "bearer auth", reg: &registry{auth: "bearer", username: "user", password: "pass", files: files}, auth: &Auth{Username: "user", Password: "pass"}, ref: "tinkerbell/ipxe:v1"},
+		{name: "static token", reg: &registry{auth: "bearer", files: files}, auth: &Auth{Token: "t0ken"}, ref: "tinkerbell/ipxe:v1"},
+		{name: "missing credentials", reg: &registry{auth: "basic", username: "user", password: "pass", files: files}, ref: "tinkerbell/ipxe:v1", wantErr: ErrUnauthorized},
+		{name: "wrong password", reg: &registry{auth: "basic", username: "user", password: "pass", files: files}, auth: &Auth{Username: "user", Password: "nope"}, ref: "tinkerbell/ipxe:v1", wantErr: ErrUnauthorized},
+		{name: "token denied", reg: &registry{auth: "bearer", username: "user", password: "pass", files: files}, auth: &Auth{Username: "user", Password: "nope"}, ref: "tinkerbell/ipxe:v1", wantErr: ErrUnauthorized},
+		{name: "unknown tag", reg: &registry{files: files}, ref: "tinkerbell/ipxe:v2", wantErr: ErrPull},
+		{name: "corrupt blob", reg: &registry{files: files, corrupt: true}, ref: "tinkerbell/ipxe:v1", wantErr: ErrPull},
+		{name: "no files", reg: &registry{}, ref: "tinkerbell/ipxe:v1", wantErr: ErrPull},
+		{name: "invalid reference", reg: &registry{files: files}, ref: "Tinkerbell/ipxe:v1", wantErr: ErrPull},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := tt.reg.server(t)
+			host := strings.TrimPrefix(srv.URL, "http://")
+			p := Puller{PlainHTTP: true}
+			if tt.auth != nil {
+				p.Auth = map[string]Auth{host: *tt.auth}
+			}
+			got, err := p.Pull(context.Background(), host+"/"+tt.ref)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got: %v", tt.wantErr, err)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if diff := cmp.Diff(got, files); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestPullCacheDir(t *testing.T) {
+	files := map[string][]byte{"snp.efi": []byte("snp content"), "undionly.kpxe": []byte("undionly content")}
+	reg := &registry{auth: "bearer", username: "user", password: "pass", files: files}
+	srv := reg.server(t)
+	host := strings.TrimPrefix(srv.URL, "http://")
+	p := Puller{PlainHTTP: true, CacheDir: t.TempDir(), Auth: map[string]Auth{host: {Username: "user", Password: "pass"}}}
+	for i := 0; i < 2; i++ {
+		got, err := p.Pull(context.Background(), host+"/tinkerbell/ipxe:v1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, files); diff != "" {
+			t.Fatal(diff)
+		}
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.blobGets != len(files) {
+		t.Fatalf("expected %v blob downloads, got: %v", len(files), reg.blobGets)
+	}
+	if reg.tokenCalls != 2 {
+		t.Fatalf("expected a token request per pull, got: %v", reg.tokenCalls)
+	}
+}
@@ -0,0 +1,66 @@
+package oci
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// dockerHub is the registry of references without a registry host, like "tinkerbell/ipxe:latest".
+	dockerHub = "registry-1.docker.io"
+	// dockerHubAuth is the key of Docker Hub credentials in a Docker config file.
+	dockerHubAuth = "index.docker.io"
+)
+
+var repositoryRe = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+
+// Reference is a parsed reference to an artifact in a registry, like "ghcr.io/tinkerbell/ipxe:v1.0.0".
+type Reference struct {
+	// Registry is the registry host and optional port.
+	Registry string
+	// Repository is the repository in the registry, like "tinkerbell/ipxe".
+	Repository string
+	// Reference is the tag or the digest ("sha256:...") of the artifact.
+	Reference string
+}
+
+// ParseReference parses s as "[registry/]repository[:tag|@digest]".
+// The tag defaults to "latest". References without a registry host are Docker Hub references.
+func ParseReference(s string) (Reference, error) {
+	r := Reference{Registry: dockerHub, Reference: "latest"}
+	rest := s
+	if i := strings.Index(rest, "/"); i > 0 {
+		if host := rest[:i]; strings.ContainsAny(host, ".:") || host == "localhost" {
+			r.Registry = host
+			rest = rest[i+1:]
+		}
+	}
+	if i := strings.Index(rest, "@"); i >= 0 {
+		r.Reference = rest[i+1:]
+		rest = rest[:i]
+		if !strings.HasPrefix(r.Reference, "sha256:") {
+			return Reference{}, fmt.Errorf("invalid reference %q: unsupported digest %q", s, r.Reference)
+		}
+	} else if i := strings.LastIndex(rest, ":"); i >= 0 {
+		r.Reference = rest[i+1:]
+		rest = rest[:i]
+	}
+	if r.Registry == dockerHub && !strings.Contains(rest, "/") {
+		rest = "library/" + rest
+	}
+	if r.Reference == "" || !repositoryRe.MatchString(rest) {
+		return Reference{}, fmt.Errorf("invalid reference %q", s)
+	}
+	r.Repository = rest
+	return r, nil
+}
+
+// String returns the reference in the form ParseReference parses.
+func (r Reference) String() string {
+	sep := ":"
+	if strings.HasPrefix(r.Reference, "sha256:") {
+		sep = "@"
+	}
+	return r.Registry + "/" + r.Repository + sep + r.Reference
+}
@@ -0,0 +1,45 @@
+package oci
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Reference
+		wantErr bool
+	}{
+		{in: "ghcr.io/tinkerbell/ipxe:v1.0.0", want: Reference{Registry: "ghcr.io", Repository: "tinkerbell/ipxe", Reference: "v1.0.0"}},
+		{in: "localhost:5000/ipxe", want: Reference{Registry: "localhost:5000", Repository: "ipxe", Reference: "latest"}},
+		{in: "localhost/ipxe", want: Reference{Registry: "localhost", Repository: "ipxe", Reference: "latest"}},
+		{in: "tinkerbell/ipxe", want: Reference{Registry: dockerHub, Repository: "tinkerbell/ipxe", Reference: "latest"}},
+		{in: "ipxe:v1", want: Reference{Registry: dockerHub, Repository: "library/ipxe", Reference: "v1"}},
+		{
+			in:   "registry.example.com:5000/ipxe/binaries@sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+			want: Reference{Registry: "registry.example.com:5000", Repository: "ipxe/binaries", Reference: "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"},
+		},
+		{in: "ghcr.io/tinkerbell/ipxe@md5:abc", wantErr: true},
+		{in: "ghcr.io/Tinkerbell/ipxe", wantErr: true},
+		{in: "ghcr.io/tinkerbell/ipxe:", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseReference(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error: %v, got: %v", tt.wantErr, err)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+			if err == nil && tt.want.Registry != dockerHub {
+				if s := got.String(); s != tt.in && s != tt.in+":latest" {
+					t.Fatalf("expected String() to round trip %q, got: %q", tt.in, s)
+				}
+			}
+		})
+	}
+}
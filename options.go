@@ -0,0 +1,206 @@
+package ipxedust
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"text/template"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/ihttp"
+	"inet.af/netaddr"
+)
+
+// Option configures a Server created with New or NewE.
+type Option func(*Server) error
+
+// New returns a Server configured with opts. It panics if an option is invalid, use NewE to handle the error instead.
+// See NewE for details.
+func New(opts ...Option) *Server {
+	s, err := NewE(opts...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// NewE returns a Server configured with opts, or an error if an option is invalid.
+// The Server starts with the same values ListenAndServe defaults to and has NoDefaults set,
+// so the values set by opts are used as is and no default merging happens when serving.
+// Options are applied in order, a later option overrides an earlier one.
+func NewE(opts ...Option) (*Server, error) {
+	s := &Server{
+		TFTP:       ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 69), Timeout: 5 * time.Second},
+		HTTP:       ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 8080), Timeout: 5 * time.Second},
+		Log:        logr.Discard(),
+		NoDefaults: true,
+	}
+	for _, opt := range opts {
+		if opt == nil {
+			return nil, errors.New("option must not be nil")
+		}
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// WithTFTPAddr sets the TFTP listen address, for example "0.0.0.0:69".
+func WithTFTPAddr(addr string) Option {
+	return func(s *Server) error {
+		a, err := netaddr.ParseIPPort(addr)
+		if err != nil {
+			return fmt.Errorf("invalid TFTP address: %w", err)
+		}
+		s.TFTP.Addr = a
+		return nil
+	}
+}
+
+// WithHTTPAddr sets the HTTP listen address, for example "0.0.0.0:8080",
+// or the path of a Unix domain socket prefixed with "unix:", for example "unix:/run/ipxe.sock". See ServerSpec.UnixSocket.
+// It replaces both the TCP address and the Unix domain socket, so the last WithHTTPAddr wins.
+func WithHTTPAddr(addr string) Option {
+	return func(s *Server) error {
+		if p, ok := unixSocketPath(addr); ok {
+			if p == "" {
+				return errors.New("invalid HTTP address: empty Unix domain socket path")
+			}
+			s.HTTP.Addr = netaddr.IPPort{}
+			s.HTTP.UnixSocket = p
+			return nil
+		}
+		a, err := netaddr.ParseIPPort(addr)
+		if err != nil {
+			return fmt.Errorf("invalid HTTP address: %w", err)
+		}
+		s.HTTP.Addr = a
+		s.HTTP.UnixSocket = ""
+		return nil
+	}
+}
+
+// WithPort sets the port of both the TFTP and HTTP listen addresses, keeping their IP addresses, for deployments
+// that expose a single port number, for example 69. TFTP binds it over UDP and HTTP over TCP, so they don't conflict.
+// The port must not be zero, which binds a different ephemeral port for each protocol, and HTTP must not be served
+// over a Unix domain socket. A later WithTFTPAddr or WithHTTPAddr overrides it.
+func WithPort(port uint16) Option {
+	return func(s *Server) error {
+		return s.setPort(port)
+	}
+}
+
+// setPort sets the port of both the TFTP and HTTP listen addresses, see WithPort.
+func (c *Server) setPort(port uint16) error {
+	if port == 0 {
+		return errors.New("port must not be zero, it binds a different ephemeral port for each protocol")
+	}
+	if c.HTTP.UnixSocket != "" {
+		return errors.New("port can't be set when HTTP is served over a Unix domain socket")
+	}
+	c.TFTP.Addr = c.TFTP.Addr.WithPort(port)
+	c.HTTP.Addr = c.HTTP.Addr.WithPort(port)
+	return nil
+}
+
+// WithTFTPTimeout sets the timeout for serving individual TFTP requests. It must be greater than zero.
+func WithTFTPTimeout(d time.Duration) Option {
+	return func(s *Server) error {
+		if d <= 0 {
+			return fmt.Errorf("TFTP timeout must be greater than zero, got: %v", d)
+		}
+		s.TFTP.Timeout = d
+		return nil
+	}
+}
+
+// WithHTTPTimeout sets the timeout for serving individual HTTP requests. It must be greater than zero.
+func WithHTTPTimeout(d time.Duration) Option {
+	return func(s *Server) error {
+		if d <= 0 {
+			return fmt.Errorf("HTTP timeout must be greater than zero, got: %v", d)
+		}
+		s.HTTP.Timeout = d
+		return nil
+	}
+}
+
+// WithLogger sets the logger. It must not be the zero value logr.Logger.
+func WithLogger(l logr.Logger) Option {
+	return func(s *Server) error {
+		if l.GetSink() == nil {
+			return errors.New("logger must not be the zero value")
+		}
+		s.Log = l
+		return nil
+	}
+}
+
+// WithSinglePort enables TFTP single port mode. See Server.EnableTFTPSinglePort.
+func WithSinglePort() Option {
+	return func(s *Server) error {
+		s.EnableTFTPSinglePort = true
+		return nil
+	}
+}
+
+// WithOnReady sets the callback invoked when each protocol is ready. See Server.OnReady.
+func WithOnReady(fn func(p Protocol, addr net.Addr)) Option {
+	return func(s *Server) error {
+		if fn == nil {
+			return errors.New("OnReady callback must not be nil")
+		}
+		s.OnReady = fn
+		return nil
+	}
+}
+
+// WithTemplate registers the template text to be rendered for requests of the file name over HTTP. See Server.Templates.
+// The template is parsed and rendered once with empty data, so that syntax errors and references to fields
+// that don't exist in ihttp.TemplateData are returned here, instead of failing requests.
+// required are the query parameters identifying the node, of ihttp.NodeParams, its requests must have,
+// see Server.TemplateParams.
+func WithTemplate(name, text string, required ...string) Option {
+	return func(s *Server) error {
+		t, err := template.New(name).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return fmt.Errorf("invalid template %q: %w", name, err)
+		}
+		if err := t.Execute(io.Discard, ihttp.TemplateData{}); err != nil {
+			return fmt.Errorf("invalid template %q: %w", name, err)
+		}
+		if err := ihttp.CheckNodeParams(required); err != nil {
+			return fmt.Errorf("invalid template %q: %w", name, err)
+		}
+		if s.Templates == nil {
+			s.Templates = make(map[string]*template.Template)
+		}
+		s.Templates[name] = t
+		if len(required) > 0 {
+			if s.TemplateParams == nil {
+				s.TemplateParams = make(map[string][]string)
+			}
+			s.TemplateParams[name] = required
+		}
+		return nil
+	}
+}
+
+// DisableTFTP disables the TFTP server.
+func DisableTFTP() Option {
+	return func(s *Server) error {
+		s.TFTP.Disabled = true
+		return nil
+	}
+}
+
+// DisableHTTP disables the HTTP server.
+func DisableHTTP() Option {
+	return func(s *Server) error {
+		s.HTTP.Disabled = true
+		return nil
+	}
+}
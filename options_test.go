@@ -0,0 +1,230 @@
+package ipxedust
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/tinkerbell/ipxedust/ihttp"
+	"inet.af/netaddr"
+)
+
+func TestNewE(t *testing.T) {
+	defaults := Server{
+		TFTP:       ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 69), Timeout: 5 * time.Second},
+		HTTP:       ServerSpec{Addr: netaddr.IPPortFrom(netaddr.IPv4(0, 0, 0, 0), 8080), Timeout: 5 * time.Second},
+		NoDefaults: true,
+	}
+	tests := []struct {
+		name    string
+		opts    []Option
+		want    func(s Server) Server
+		wantErr bool
+	}{
+		{name: "defaults", want: func(s Server) Server { return s }},
+		{
+			name: "composed",
+			opts: []Option{
+				WithTFTPAddr("127.0.0.1:6969"),
+				WithHTTPAddr("[::1]:9090"),
+				WithTFTPTimeout(time.Second),
+				WithHTTPTimeout(2 * time.Second),
+				WithSinglePort(),
+				DisableHTTP(),
+			},
+			want: func(s Server) Server {
+				s.TFTP = ServerSpec{Addr: netaddr.MustParseIPPort("127.0.0.1:6969"), Timeout: time.Second}
+				s.HTTP = ServerSpec{Addr: netaddr.MustParseIPPort("[::1]:9090"), Timeout: 2 * time.Second, Disabled: true}
+				s.EnableTFTPSinglePort = true
+				return s
+			},
+		},
+		{
+			name: "later option overrides earlier",
+			opts: []Option{WithTFTPAddr("127.0.0.1:6969"), WithTFTPAddr("127.0.0.1:7070"), DisableTFTP()},
+			want: func(s Server) Server {
+				s.TFTP.Addr = netaddr.MustParseIPPort("127.0.0.1:7070")
+				s.TFTP.Disabled = true
+				return s
+			},
+		},
+		{
+			name: "HTTP address replaces Unix domain socket",
+			opts: []Option{WithHTTPAddr("unix:/run/ipxe.sock"), WithHTTPAddr("127.0.0.1:9090")},
+			want: func(s Server) Server {
+				s.HTTP.Addr = netaddr.MustParseIPPort("127.0.0.1:9090")
+				return s
+			},
+		},
+		{
+			name: "Unix domain socket replaces HTTP address",
+			opts: []Option{WithHTTPAddr("127.0.0.1:9090"), WithHTTPAddr("unix:/run/ipxe.sock")},
+			want: func(s Server) Server {
+				s.HTTP.Addr = netaddr.IPPort{}
+				s.HTTP.UnixSocket = "/run/ipxe.sock"
+				return s
+			},
+		},
+		{name: "invalid TFTP address", opts: []Option{WithTFTPAddr("127.0.0.1")}, wantErr: true},
+		{name: "invalid HTTP address", opts: []Option{WithHTTPAddr("localhost:8080")}, wantErr: true},
+		{name: "zero TFTP timeout", opts: []Option{WithTFTPTimeout(0)}, wantErr: true},
+		{name: "negative HTTP timeout", opts: []Option{WithHTTPTimeout(-time.Second)}, wantErr: true},
+		{name: "zero logger", opts: []Option{WithLogger(logr.Logger{})}, wantErr: true},
+		{name: "nil OnReady", opts: []Option{WithOnReady(nil)}, wantErr: true},
+		{name: "nil option", opts: []Option{nil}, wantErr: true},
+		{name: "template syntax error", opts: []Option{WithTemplate("boot.ipxe", "{{ .Client ")}, wantErr: true},
+		{name: "template unknown field", opts: []Option{WithTemplate("boot.ipxe", "{{ .Hostname }}")}, wantErr: true},
+	}
+	// netaddr.IPPort has unexported fields.
+	ippComparer := cmp.Comparer(func(a, b netaddr.IPPort) bool { return a == b })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewE(tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("wantErr: %v, got: %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Log.GetSink() == nil {
+				t.Fatal("expected a usable logger")
+			}
+			if diff := cmp.Diff(*got, tt.want(defaults), ippComparer, cmpopts.IgnoreUnexported(Server{}), cmpopts.IgnoreFields(Server{}, "Log"), cmpopts.IgnoreFields(ServerSpec{}, "Log")); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestNewPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected New to panic on an invalid option")
+		}
+	}()
+	New(WithHTTPTimeout(0))
+}
+
+func TestNewServes(t *testing.T) {
+	ready := make(chan net.Addr, 1)
+	s := New(
+		WithHTTPAddr("127.0.0.1:0"),
+		DisableTFTP(),
+		WithLogger(logr.Discard()),
+		WithOnReady(func(_ Protocol, addr net.Addr) { ready <- addr }),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- s.ListenAndServe(ctx) }()
+	select {
+	case addr := <-ready:
+		httpGet(t, addr.String(), "snp.efi")
+	case err := <-errChan:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnReady")
+	}
+	cancel()
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithPort(t *testing.T) {
+	port := freeTCPAndUDPPort(t)
+	ready := make(chan net.Addr, 2)
+	s := New(
+		WithTFTPAddr("127.0.0.1:69"),
+		WithHTTPAddr("127.0.0.1:8080"),
+		WithPort(port),
+		WithOnReady(func(_ Protocol, addr net.Addr) { ready <- addr }),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- s.ListenAndServe(ctx) }()
+	var udp, tcp int
+	for i := 0; i < 2; i++ {
+		select {
+		case addr := <-ready:
+			switch a := addr.(type) {
+			case *net.UDPAddr:
+				udp = a.Port
+			case *net.TCPAddr:
+				tcp = a.Port
+			}
+		case err := <-errChan:
+			t.Fatal(err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for OnReady")
+		}
+	}
+	if udp != int(port) || tcp != int(port) {
+		t.Fatalf("expected TFTP (UDP) and HTTP (TCP) to bind port %v, got: %v and %v", port, udp, tcp)
+	}
+	cancel()
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewE(WithPort(0)); err == nil {
+		t.Fatal("expected an error for port zero")
+	}
+	if _, err := NewE(WithHTTPAddr("unix:/run/ipxe.sock"), WithPort(6969)); err == nil {
+		t.Fatal("expected an error for an HTTP Unix domain socket")
+	}
+}
+
+// freeTCPAndUDPPort returns a port that is free over both TCP and UDP on the loopback interface.
+func freeTCPAndUDPPort(t *testing.T) uint16 {
+	t.Helper()
+	for i := 0; i < 10; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		l.Close()
+		c, err := net.ListenPacket("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			continue
+		}
+		c.Close()
+		return uint16(port)
+	}
+	t.Fatal("no port free over both TCP and UDP")
+	return 0
+}
+
+func TestWithTemplate(t *testing.T) {
+	s, err := NewE(WithTemplate("boot.ipxe", `chain http://{{ .Client }}/{{ .Query.Get "arch" }}.ipxe`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	data := ihttp.TemplateData{Client: "192.168.2.10", Query: url.Values{"arch": []string{"arm64"}}}
+	if err := s.Templates["boot.ipxe"].Execute(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(buf.String(), "chain http://192.168.2.10/arm64.ipxe"); diff != "" {
+		t.Fatal(diff)
+	}
+
+	s, err = NewE(WithTemplate("node.ipxe", `chain http://boot.example.com/{{ .Node.MAC }}`, ihttp.ParamMAC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(s.TemplateParams, map[string][]string{"node.ipxe": {ihttp.ParamMAC}}); diff != "" {
+		t.Fatal(diff)
+	}
+	if _, err := NewE(WithTemplate("node.ipxe", `chain http://boot.example.com/`, "uuid")); !errors.Is(err, ihttp.ErrInvalidParam) {
+		t.Fatalf("expected %v, got: %v", ihttp.ErrInvalidParam, err)
+	}
+}
@@ -0,0 +1,60 @@
+package ipxedust
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// ErrSuspiciousPorts is returned by Command.Validate for port configurations that can't serve clients.
+var ErrSuspiciousPorts = errors.New("suspicious port configuration")
+
+// tftpPort is the port TFTP clients send requests to, unless configured otherwise.
+const tftpPort = 69
+
+// httpPorts are the ports HTTP servers usually listen on.
+var httpPorts = map[int]bool{80: true, 443: true, 8080: true, 8443: true}
+
+// checkPorts returns the warnings about the ports of c that are likely mistakes, for example a TFTP address given
+// as the HTTP address, and an error wrapping ErrSuspiciousPorts for the ports that can't work. The ports of the
+// protocols are not compared when Port sets both: TFTP is served over UDP and HTTP over TCP, the same port number
+// doesn't collide, and is what single port containers need.
+func (c *Command) checkPorts() ([]string, error) {
+	tport, tok := addrPort(c.TFTPAddr)
+	hport, hok := addrPort(c.HTTPAddr)
+	if c.Port != 0 {
+		tport, hport = int(c.Port), int(c.Port)
+	}
+	tok = tok && !c.TFTPDisabled
+	if _, unix := unixSocketPath(c.HTTPAddr); unix || c.HTTPDisabled {
+		hok = false
+	}
+	if tok && tport == 0 && c.EnableTFTPSinglePort {
+		return nil, fmt.Errorf("%w: TFTP single port mode on port 0, a random port clients can't send requests to", ErrSuspiciousPorts)
+	}
+	if c.Port != 0 {
+		return nil, nil
+	}
+	if tok && hok && hport == tftpPort && httpPorts[tport] {
+		return nil, fmt.Errorf("%w: TFTP on port %v and HTTP on port %v, the TFTP and HTTP addresses look swapped", ErrSuspiciousPorts, tport, hport)
+	}
+	var warnings []string
+	if hok && hport == tftpPort {
+		warnings = append(warnings, fmt.Sprintf("HTTP on port %v, the TFTP port: clients fetch files over HTTP on port 80, or the port of the URL they are given", tftpPort))
+	}
+	if tok && httpPorts[tport] {
+		warnings = append(warnings, fmt.Sprintf("TFTP on port %v, an HTTP port: clients send TFTP requests to port %v, unless configured otherwise", tport, tftpPort))
+	}
+	return warnings, nil
+}
+
+// addrPort returns the port of the host:port address addr.
+func addrPort(addr string) (int, bool) {
+	_, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, false
+	}
+	port, err := strconv.Atoi(p)
+	return port, err == nil
+}
@@ -0,0 +1,73 @@
+package ipxedust
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCheckPorts(t *testing.T) {
+	tests := []struct {
+		name         string
+		cmd          Command
+		wantWarnings []string
+		wantErr      error
+	}{
+		{name: "defaults", cmd: Command{TFTPAddr: "0.0.0.0:69", HTTPAddr: "0.0.0.0:8080"}},
+		{name: "port 80", cmd: Command{TFTPAddr: "0.0.0.0:69", HTTPAddr: "0.0.0.0:80"}},
+		{name: "single port", cmd: Command{TFTPAddr: "0.0.0.0:69", HTTPAddr: "0.0.0.0:8080", EnableTFTPSinglePort: true}},
+		{name: "same port", cmd: Command{TFTPAddr: "0.0.0.0:69", HTTPAddr: "0.0.0.0:8080", Port: 6969}},
+		{name: "same port 69", cmd: Command{TFTPAddr: "0.0.0.0:69", HTTPAddr: "0.0.0.0:8080", Port: 69}},
+		{name: "unix domain socket", cmd: Command{TFTPAddr: "0.0.0.0:69", HTTPAddr: "unix:/run/ipxe.sock"}},
+		{
+			name:         "HTTP on the TFTP port",
+			cmd:          Command{TFTPAddr: "0.0.0.0:69", HTTPAddr: "0.0.0.0:69"},
+			wantWarnings: []string{"HTTP on port 69, the TFTP port: clients fetch files over HTTP on port 80, or the port of the URL they are given"},
+		},
+		{
+			name:         "TFTP on an HTTP port",
+			cmd:          Command{TFTPAddr: "0.0.0.0:8080", HTTPAddr: "0.0.0.0:8080"},
+			wantWarnings: []string{"TFTP on port 8080, an HTTP port: clients send TFTP requests to port 69, unless configured otherwise"},
+		},
+		{name: "TFTP disabled", cmd: Command{TFTPAddr: "0.0.0.0:80", HTTPAddr: "0.0.0.0:8080", TFTPDisabled: true}},
+		{name: "HTTP disabled", cmd: Command{TFTPAddr: "0.0.0.0:69", HTTPAddr: "0.0.0.0:69", HTTPDisabled: true}},
+		{name: "swapped", cmd: Command{TFTPAddr: "0.0.0.0:8080", HTTPAddr: "0.0.0.0:69"}, wantErr: ErrSuspiciousPorts},
+		{name: "single port on port 0", cmd: Command{TFTPAddr: "0.0.0.0:0", HTTPAddr: "0.0.0.0:8080", EnableTFTPSinglePort: true}, wantErr: ErrSuspiciousPorts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings, err := tt.cmd.checkPorts()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error mismatch, got: %v, want: %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.wantWarnings, warnings); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestValidatePorts(t *testing.T) {
+	var logged []string
+	c := &Command{
+		TFTPAddr:    "0.0.0.0:8080",
+		TFTPTimeout: 5 * time.Second,
+		HTTPAddr:    "0.0.0.0:69",
+		HTTPTimeout: 5 * time.Second,
+		Log:         funcr.New(func(_, args string) { logged = append(logged, args) }, funcr.Options{}),
+	}
+	if err := c.Validate(); !errors.Is(err, ErrSuspiciousPorts) {
+		t.Fatalf("expected %v, got: %v", ErrSuspiciousPorts, err)
+	}
+	c.TFTPAddr = "0.0.0.0:69"
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(logged) != 1 || !strings.Contains(logged[0], `"msg"="warning: HTTP on port 69`) {
+		t.Fatalf("expected a warning about HTTP on port 69, got: %v", logged)
+	}
+}
@@ -0,0 +1,55 @@
+package ipxedust
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// registerPprof registers the net/http/pprof handlers under /debug/pprof/ on mux.
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// listenAndServePprof serves the pprof handlers on c.PprofAddr until ctx is canceled.
+func (c *Server) listenAndServePprof(ctx context.Context) error {
+	l, err := net.Listen("tcp", c.PprofAddr.String())
+	if err != nil {
+		return err
+	}
+	return c.servePprof(ctx, l)
+}
+
+// servePprof serves the pprof handlers on l until ctx is canceled.
+func (c *Server) servePprof(ctx context.Context, l net.Listener) error {
+	router := http.NewServeMux()
+	registerPprof(router)
+	hs := &http.Server{
+		Handler:     router,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	c.log().Info("serving pprof", "addr", l.Addr().String())
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return hs.Serve(l)
+	})
+
+	<-ctx.Done()
+	err := hs.Shutdown(ctx)
+	if err != nil {
+		return err
+	}
+	err = g.Wait()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package ipxedust
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"inet.af/netaddr"
+)
+
+func TestPprof(t *testing.T) {
+	tests := []struct {
+		name        string
+		enablePprof bool
+		separate    bool
+		wantHTTP    int
+		wantPprof   int
+	}{
+		{name: "disabled", wantHTTP: http.StatusNotFound},
+		{name: "served by the HTTP server", enablePprof: true, wantHTTP: http.StatusOK},
+		{name: "separate address", enablePprof: true, separate: true, wantHTTP: http.StatusNotFound, wantPprof: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Server{Log: logr.Discard(), EnablePprof: tt.enablePprof}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			hl, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var pl net.Listener
+			if tt.separate {
+				if pl, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+					t.Fatal(err)
+				}
+				c.PprofAddr = netaddr.MustParseIPPort(pl.Addr().String())
+				go c.servePprof(ctx, pl)
+			}
+			go c.serveHTTP(ctx, hl)
+
+			if diff := cmp.Diff(getStatus(t, hl.Addr(), "/debug/pprof/"), tt.wantHTTP); diff != "" {
+				t.Fatal(diff)
+			}
+			if pl != nil {
+				if diff := cmp.Diff(getStatus(t, pl.Addr(), "/debug/pprof/"), tt.wantPprof); diff != "" {
+					t.Fatal(diff)
+				}
+			}
+		})
+	}
+}
+
+// getStatus returns the status code of a GET request for path to addr.
+func getStatus(t *testing.T, addr net.Addr, path string) int {
+	t.Helper()
+	resp, err := http.Get(fmt.Sprintf("http://%v%v", addr, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
@@ -0,0 +1,82 @@
+// Package progress reports the progress of transfers, throttled to keep the overhead low.
+package progress
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/tinkerbell/ipxedust/clock"
+)
+
+const (
+	// DefaultBytes is the number of bytes between reports when Throttle.Bytes is not set.
+	DefaultBytes = 64 * 1024
+	// DefaultInterval is the time between reports when Throttle.Interval is not set.
+	DefaultInterval = time.Second
+)
+
+// Func is called with the number of bytes sent so far and the total number of bytes, or -1 when the total is unknown.
+type Func func(sent, total int64)
+
+// Throttle limits how often progress is reported. Progress is reported once at least Bytes bytes
+// were sent or at least Interval passed since the last report, and always when the transfer completes.
+type Throttle struct {
+	// Bytes defaults to DefaultBytes.
+	Bytes int64
+	// Interval defaults to DefaultInterval.
+	Interval time.Duration
+	// Clock measures Interval. Defaults to the real clock.
+	Clock clock.Clock
+}
+
+// reader is an io.Reader that reports the bytes read from it as sent.
+type reader struct {
+	r        io.Reader
+	fn       Func
+	total    int64
+	bytes    int64
+	interval time.Duration
+	clock    clock.Clock
+
+	sent         int64
+	reportedSent int64
+	reportedAt   time.Time
+	done         bool
+}
+
+// NewReader returns an io.Reader that reads from r and reports the bytes read, as the bytes sent, to fn.
+// total is the size of r, or -1 when unknown. The completed transfer is reported when r returns io.EOF.
+// When fn is nil, r is returned as is.
+func NewReader(r io.Reader, total int64, t Throttle, fn Func) io.Reader {
+	if fn == nil {
+		return r
+	}
+	if t.Bytes <= 0 {
+		t.Bytes = DefaultBytes
+	}
+	if t.Interval <= 0 {
+		t.Interval = DefaultInterval
+	}
+	c := clock.Or(t.Clock)
+	return &reader{r: r, fn: fn, total: total, bytes: t.Bytes, interval: t.Interval, clock: c, reportedAt: c.Now()}
+}
+
+func (p *reader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.sent += int64(n)
+	switch {
+	case errors.Is(err, io.EOF) && !p.done:
+		p.done = true
+		p.report()
+	case n > 0 && (p.sent-p.reportedSent >= p.bytes || p.clock.Now().Sub(p.reportedAt) >= p.interval):
+		p.report()
+	}
+	return n, err
+}
+
+func (p *reader) report() {
+	p.reportedSent = p.sent
+	p.reportedAt = p.clock.Now()
+	p.fn(p.sent, p.total)
+}
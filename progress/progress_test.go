@@ -0,0 +1,96 @@
+package progress
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/clock"
+)
+
+// chunkReader returns at most size bytes per Read, like the block by block reads of a TFTP transfer.
+type chunkReader struct {
+	r    io.Reader
+	size int
+}
+
+func (c chunkReader) Read(b []byte) (int, error) {
+	if len(b) > c.size {
+		b = b[:c.size]
+	}
+	return c.r.Read(b)
+}
+
+func TestNewReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		chunk    int
+		total    int64
+		throttle Throttle
+		want     [][2]int64
+	}{
+		{
+			name: "throttled by bytes", size: 2048, chunk: 512, total: 2048, throttle: Throttle{Bytes: 1024, Interval: time.Hour},
+			want: [][2]int64{{1024, 2048}, {2048, 2048}, {2048, 2048}},
+		},
+		{
+			name: "every read", size: 1536, chunk: 512, total: 1536, throttle: Throttle{Bytes: 1, Interval: time.Hour},
+			want: [][2]int64{{512, 1536}, {1024, 1536}, {1536, 1536}, {1536, 1536}},
+		},
+		{
+			name: "unknown total", size: 1000, chunk: 512, total: -1, throttle: Throttle{Bytes: 4096, Interval: time.Hour},
+			want: [][2]int64{{1000, -1}},
+		},
+		{
+			name: "defaults", size: 200 * 1024, chunk: 32 * 1024, total: 200 * 1024,
+			want: [][2]int64{{64 * 1024, 200 * 1024}, {128 * 1024, 200 * 1024}, {192 * 1024, 200 * 1024}, {200 * 1024, 200 * 1024}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][2]int64
+			r := NewReader(chunkReader{r: bytes.NewReader(make([]byte, tt.size)), size: tt.chunk}, tt.total, tt.throttle, func(sent, total int64) {
+				got = append(got, [2]int64{sent, total})
+			})
+			n, err := io.Copy(io.Discard, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int64(tt.size) {
+				t.Fatalf("expected %v bytes, got: %v", tt.size, n)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestNewReaderInterval(t *testing.T) {
+	var got []int64
+	fake := clock.NewFake(time.Unix(0, 0))
+	r := NewReader(chunkReader{r: bytes.NewReader(make([]byte, 4)), size: 1}, 4, Throttle{Bytes: 1024, Interval: time.Second, Clock: fake}, func(sent, _ int64) {
+		got = append(got, sent)
+	})
+	b := make([]byte, 1)
+	// the second read is less than an interval after the first report.
+	for _, advance := range []time.Duration{time.Second, 500 * time.Millisecond, 500 * time.Millisecond, time.Second, 0} {
+		fake.Advance(advance)
+		if _, err := r.Read(b); err != nil {
+			break
+		}
+	}
+	if diff := cmp.Diff(got, []int64{1, 3, 4, 4}); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestNewReaderNilFunc(t *testing.T) {
+	r := bytes.NewReader(nil)
+	if got := NewReader(r, 0, Throttle{}, nil); got != io.Reader(r) {
+		t.Fatal("expected the reader to be returned as is")
+	}
+}
@@ -0,0 +1,292 @@
+package ipxedust
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrNotServing is returned by DisableProtocol, EnableProtocol and Listener when the Server is not serving.
+var ErrNotServing = errors.New("not serving")
+
+// ErrProtocolDisabled is returned by Listener for a protocol that is not served, see DisableProtocol.
+var ErrProtocolDisabled = errors.New("protocol disabled")
+
+// errProtocolSkipped is returned by the bindFunc of an optional protocol that failed to bind, see
+// Server.TFTPOptional. The protocol is then left disabled, instead of failing serving.
+var errProtocolSkipped = errors.New("protocol skipped")
+
+// BoundListener describes the listener a protocol is served on, for embedders coordinating their shutdown with the
+// Server. It doesn't give access to the listener itself, which only the Server closes.
+type BoundListener struct {
+	// Addr is the address the listener is bound to. It is nil until the listener is bound, see OnReady.
+	Addr net.Addr
+	// Done is closed when serving the listener stops, because serving stops or the protocol is disabled, once the
+	// listener is closed and the transfers in flight completed.
+	Done <-chan struct{}
+}
+
+// Listener returns the listener protocol p is served on. It returns ErrNotServing when the Server is not serving,
+// and ErrProtocolDisabled when p is not served. A protocol enabled again, see EnableProtocol, is served on a new
+// listener. It is safe to call concurrently with serving.
+func (c *Server) Listener(p Protocol) (BoundListener, error) {
+	r, _ := c.running.Load().(*protocolRunners)
+	if r == nil {
+		return BoundListener{}, ErrNotServing
+	}
+	return r.listener(p)
+}
+
+// DisableProtocol stops serving protocol p, closing its listener, while the other protocols keep serving.
+// It returns once the transfers in flight over p complete. TFTP transfers in single port mode share
+// the closed listener and are interrupted. Disabling a protocol that is already disabled does nothing.
+// It is safe to call concurrently with serving.
+func (c *Server) DisableProtocol(p Protocol) error {
+	r, _ := c.running.Load().(*protocolRunners)
+	if r == nil {
+		return ErrNotServing
+	}
+	stopped, err := r.disable(p)
+	if err != nil {
+		return err
+	}
+	if stopped {
+		c.log().Info("protocol disabled", LogKeyProtocol, p)
+	}
+	return nil
+}
+
+// EnableProtocol starts serving protocol p again after DisableProtocol, binding a new listener at the address
+// it was bound to before, or at the configured address when it was disabled from the start (ListenAndServe only).
+// The bind error, for example when the address was taken in the meantime, is returned and the Server keeps serving.
+// OnReady is called again once serving begins. Enabling a protocol that is already enabled does nothing.
+// It is safe to call concurrently with serving.
+func (c *Server) EnableProtocol(p Protocol) error {
+	r, _ := c.running.Load().(*protocolRunners)
+	if r == nil {
+		return ErrNotServing
+	}
+	started, err := r.enable(p)
+	if err != nil {
+		return err
+	}
+	if started {
+		c.log().Info("protocol enabled", LogKeyProtocol, p)
+	}
+	return nil
+}
+
+// serveFunc serves a bound listener until ctx is done or stop is closed.
+type serveFunc func(ctx context.Context, stop <-chan struct{}) error
+
+// bindFunc binds the listener of a protocol. It returns the function serving it and the address it is bound to,
+// nil when it is already known.
+type bindFunc func() (serveFunc, net.Addr, error)
+
+// protocolRunner serves a single protocol.
+type protocolRunner struct {
+	// rebind binds the listener of the protocol again, at addr, to enable it.
+	rebind func(addr net.Addr) bindFunc
+	// addr is the address the protocol is bound to, or was last bound to. It is nil when it is unknown.
+	addr net.Addr
+	// stop is closed to disable the protocol. It is nil while the protocol is disabled.
+	stop chan struct{}
+	// done is closed when serving the protocol returns.
+	done chan struct{}
+}
+
+// protocolRunners serve the protocols of a Server, so they can be disabled and enabled independently.
+// Serving errors are recorded in errs and returned to g, which stops serving all the protocols.
+type protocolRunners struct {
+	ctx  context.Context
+	g    *errgroup.Group
+	errs *errorList
+
+	mu sync.Mutex
+	// closed is set once serving stops, afterwards no protocol can be enabled.
+	closed  bool
+	runners map[Protocol]*protocolRunner
+}
+
+// runProtocols returns the runners of the protocols served by c with g until ctx is done.
+func (c *Server) runProtocols(ctx context.Context, g *errgroup.Group, errs *errorList) *protocolRunners {
+	r := &protocolRunners{
+		ctx:  ctx,
+		g:    g,
+		errs: errs,
+		runners: map[Protocol]*protocolRunner{
+			ProtocolTFTP: {rebind: c.rebindTFTP},
+			ProtocolHTTP: {rebind: c.rebindHTTP},
+		},
+	}
+	c.running.Store(r)
+	return r
+}
+
+// setAddr sets the address protocol p is bound to.
+func (r *protocolRunners) setAddr(p Protocol, addr net.Addr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runners[p].addr = addr
+}
+
+// start serves protocol p, with the listener bound by bind, in the background.
+func (r *protocolRunners) start(p Protocol, bind bindFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.startLocked(r.runners[p], bind)
+}
+
+// startLocked starts pr. r.mu must be held.
+func (r *protocolRunners) startLocked(pr *protocolRunner, bind bindFunc) {
+	stop, done := make(chan struct{}), make(chan struct{})
+	pr.stop, pr.done = stop, done
+	r.g.Go(func() error {
+		defer close(done)
+		serve, addr, err := bind()
+		if errors.Is(err, errProtocolSkipped) {
+			r.mu.Lock()
+			if pr.stop == stop {
+				pr.stop = nil
+			}
+			r.mu.Unlock()
+			return nil
+		}
+		if err != nil {
+			return r.errs.add(err)
+		}
+		if addr != nil {
+			r.mu.Lock()
+			pr.addr = addr
+			r.mu.Unlock()
+		}
+		return r.errs.add(serve(r.ctx, stop))
+	})
+}
+
+// disable stops serving protocol p and waits for the transfers in flight to complete.
+// It reports whether p was stopped, false when it was already disabled.
+func (r *protocolRunners) disable(p Protocol) (bool, error) {
+	r.mu.Lock()
+	pr, err := r.runnerLocked(p)
+	if err != nil {
+		r.mu.Unlock()
+		return false, err
+	}
+	if pr.stop == nil {
+		r.mu.Unlock()
+		return false, nil
+	}
+	close(pr.stop)
+	pr.stop = nil
+	done := pr.done
+	r.mu.Unlock()
+	<-done
+	return true, nil
+}
+
+// listener returns the listener protocol p is served on.
+func (r *protocolRunners) listener(p Protocol) (BoundListener, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pr, err := r.runnerLocked(p)
+	if err != nil {
+		return BoundListener{}, err
+	}
+	if pr.stop == nil {
+		return BoundListener{}, fmt.Errorf("%v: %w", p, ErrProtocolDisabled)
+	}
+	return BoundListener{Addr: pr.addr, Done: pr.done}, nil
+}
+
+// enable binds the listener of protocol p again and serves it in the background.
+// It reports whether p was started, false when it was already enabled.
+func (r *protocolRunners) enable(p Protocol) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pr, err := r.runnerLocked(p)
+	if err != nil {
+		return false, err
+	}
+	if pr.stop != nil {
+		return false, nil
+	}
+	if pr.addr == nil {
+		return false, fmt.Errorf("%v has no address to bind", p)
+	}
+	serve, addr, err := pr.rebind(pr.addr)()
+	if err != nil {
+		return false, err
+	}
+	r.startLocked(pr, func() (serveFunc, net.Addr, error) {
+		return serve, addr, nil
+	})
+	return true, nil
+}
+
+// runnerLocked returns the runner of protocol p. r.mu must be held.
+func (r *protocolRunners) runnerLocked(p Protocol) (*protocolRunner, error) {
+	if r.closed {
+		return nil, ErrNotServing
+	}
+	pr, ok := r.runners[p]
+	if !ok {
+		return nil, fmt.Errorf("unknown protocol %q", p)
+	}
+	return pr, nil
+}
+
+// close marks serving as stopped. It must be called before waiting for the protocols to return.
+func (r *protocolRunners) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+}
+
+// httpServe returns the function serving HTTP on l.
+func (c *Server) httpServe(l net.Listener) serveFunc {
+	return func(ctx context.Context, stop <-chan struct{}) error {
+		return c.serveHTTPUntil(ctx, stop, l)
+	}
+}
+
+// tftpServe returns the function serving TFTP on conn.
+func (c *Server) tftpServe(conn net.PacketConn) serveFunc {
+	return func(ctx context.Context, stop <-chan struct{}) error {
+		return c.serveTFTPUntil(ctx, stop, conn)
+	}
+}
+
+// rebindHTTP returns the function binding a new HTTP listener at addr, a TCP address or a Unix domain socket.
+func (c *Server) rebindHTTP(addr net.Addr) bindFunc {
+	return func() (serveFunc, net.Addr, error) {
+		a := addr.String()
+		if addr.Network() == "unix" {
+			a = unixAddrPrefix + a
+		}
+		l, err := listenHTTP(a)
+		if err != nil {
+			return nil, nil, err
+		}
+		return c.httpServe(l), l.Addr(), nil
+	}
+}
+
+// rebindTFTP returns the function binding a new TFTP conn at addr.
+func (c *Server) rebindTFTP(addr net.Addr) bindFunc {
+	return func() (serveFunc, net.Addr, error) {
+		a, err := resolveUDPAddr(addr.Network(), addr.String())
+		if err != nil {
+			return nil, nil, err
+		}
+		conn, err := listenUDP(addr.Network(), a)
+		if err != nil {
+			return nil, nil, bindError(err)
+		}
+		return c.tftpServe(conn), conn.LocalAddr(), nil
+	}
+}
@@ -0,0 +1,187 @@
+package ipxedust
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pin/tftp"
+	"github.com/tinkerbell/ipxedust/bandwidth"
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+func TestDisableProtocol(t *testing.T) {
+	ready := make(chan net.Addr, 3)
+	c := &Server{
+		Log: logr.Discard(),
+		// Slow down transfers so one is in flight while TFTP is disabled.
+		Bandwidth: bandwidth.Config{PerTransfer: 128 * 1024},
+		OnReady: func(p Protocol, addr net.Addr) {
+			if p == ProtocolTFTP {
+				ready <- addr
+			}
+		},
+	}
+	if err := c.DisableProtocol(ProtocolTFTP); !errors.Is(err, ErrNotServing) {
+		t.Fatalf("expected %v before serving, got: %v", ErrNotServing, err)
+	}
+	conn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpAddr, tftpAddr := conn.Addr().String(), uconn.LocalAddr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Serve(ctx, conn, uconn) }()
+	<-ready
+
+	inFlight := make(chan []byte, 1)
+	go func() {
+		b, err := tftpReceive(tftpAddr, "snp.efi")
+		if err != nil {
+			t.Error(err)
+		}
+		inFlight <- b
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := c.DisableProtocol(ProtocolTFTP); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case b := <-inFlight:
+		if !bytes.Equal(b, binary.SNP) {
+			t.Fatal("expected the transfer in flight to complete")
+		}
+	default:
+		t.Fatal("expected the transfer in flight to complete before TFTP is disabled")
+	}
+	if err := c.DisableProtocol(ProtocolTFTP); err != nil {
+		t.Fatal("disabling twice:", err)
+	}
+	if _, err := tftpReceive(tftpAddr, "undionly.kpxe"); err == nil {
+		t.Fatal("expected TFTP to be disabled")
+	}
+	if !bytes.Equal(httpGet(t, httpAddr, "undionly.kpxe"), binary.Undionly) {
+		t.Fatal("expected HTTP to keep serving")
+	}
+
+	if err := c.EnableProtocol(ProtocolTFTP); err != nil {
+		t.Fatal(err)
+	}
+	if addr := <-ready; addr.String() != tftpAddr {
+		t.Fatalf("expected TFTP to be bound at %v again, got: %v", tftpAddr, addr)
+	}
+	if err := c.EnableProtocol(ProtocolTFTP); err != nil {
+		t.Fatal("enabling twice:", err)
+	}
+	if !bytes.Equal(tftpGet(t, tftpAddr, "undionly.kpxe"), binary.Undionly) {
+		t.Fatal("expected TFTP to serve again")
+	}
+	if !bytes.Equal(httpGet(t, httpAddr, "undionly.kpxe"), binary.Undionly) {
+		t.Fatal("expected HTTP to keep serving")
+	}
+	if err := c.EnableProtocol("ftp"); err == nil {
+		t.Fatal("expected an error enabling an unknown protocol")
+	}
+
+	cancel()
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	if err := c.EnableProtocol(ProtocolTFTP); !errors.Is(err, ErrNotServing) {
+		t.Fatalf("expected %v after serving, got: %v", ErrNotServing, err)
+	}
+}
+
+func TestListener(t *testing.T) {
+	ready := make(chan struct{}, 2)
+	c := &Server{Log: logr.Discard(), OnReady: func(Protocol, net.Addr) { ready <- struct{}{} }}
+	if _, err := c.Listener(ProtocolHTTP); !errors.Is(err, ErrNotServing) {
+		t.Fatalf("expected %v before serving, got: %v", ErrNotServing, err)
+	}
+	conn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Serve(ctx, conn, uconn) }()
+	<-ready
+	<-ready
+
+	want := map[Protocol]net.Addr{ProtocolHTTP: conn.Addr(), ProtocolTFTP: uconn.LocalAddr()}
+	bound := make(map[Protocol]BoundListener)
+	for p, addr := range want {
+		l, err := c.Listener(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l.Addr == nil || l.Addr.String() != addr.String() || l.Done == nil {
+			t.Fatalf("%v: expected a listener bound at %v, got: %+v", p, addr, l)
+		}
+		bound[p] = l
+	}
+
+	if err := c.DisableProtocol(ProtocolTFTP); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-bound[ProtocolTFTP].Done:
+	default:
+		t.Fatal("expected the TFTP listener to be done once TFTP is disabled")
+	}
+	if _, err := c.Listener(ProtocolTFTP); !errors.Is(err, ErrProtocolDisabled) {
+		t.Fatalf("expected %v, got: %v", ErrProtocolDisabled, err)
+	}
+	select {
+	case <-bound[ProtocolHTTP].Done:
+		t.Fatal("expected the HTTP listener to keep serving")
+	default:
+	}
+
+	cancel()
+	select {
+	case <-bound[ProtocolHTTP].Done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the HTTP listener to be done")
+	}
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Listener(ProtocolHTTP); !errors.Is(err, ErrNotServing) {
+		t.Fatalf("expected %v after serving, got: %v", ErrNotServing, err)
+	}
+}
+
+// tftpReceive downloads filename from the TFTP server listening on addr, giving up quickly when it doesn't answer.
+func tftpReceive(addr, filename string) ([]byte, error) {
+	c, err := tftp.NewClient(addr)
+	if err != nil {
+		return nil, err
+	}
+	c.SetTimeout(200 * time.Millisecond)
+	c.SetRetries(1)
+	wt, err := c.Receive(filename, "octet")
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := wt.WriteTo(&buf); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,166 @@
+// Package ratelimit implements token bucket rate limiting of iPXE binary requests.
+package ratelimit
+
+import (
+	"container/list"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/tinkerbell/ipxedust/clock"
+	"golang.org/x/time/rate"
+	"inet.af/netaddr"
+)
+
+// ErrLimited is returned when a request is rejected because a rate limit was exceeded.
+var ErrLimited = errors.New("rate limit exceeded")
+
+// DefaultMaxClients is the number of per client limiters tracked when Config.MaxClients is not set.
+const DefaultMaxClients = 4096
+
+// Config holds the rate limiting settings. The zero value means unlimited.
+type Config struct {
+	// RequestsPerSecond is the global request rate across all clients. Zero means unlimited.
+	RequestsPerSecond float64
+	// Burst is the maximum number of requests allowed to exceed RequestsPerSecond at once.
+	// Defaults to RequestsPerSecond rounded up.
+	Burst int
+	// PerClientRequestsPerSecond is the request rate of a single client IP. Zero means unlimited.
+	PerClientRequestsPerSecond float64
+	// PerClientBurst is the maximum number of requests a single client IP is allowed to exceed
+	// PerClientRequestsPerSecond by at once. Defaults to PerClientRequestsPerSecond rounded up.
+	PerClientBurst int
+	// MaxClients bounds the number of client IPs tracked. The least recently seen client is
+	// forgotten when the bound is reached. Defaults to DefaultMaxClients.
+	MaxClients int
+	// Clock tells the time of requests. Defaults to the real clock.
+	Clock clock.Clock
+}
+
+// Limiter enforces a global and a per client IP request rate.
+// A nil *Limiter allows all requests.
+type Limiter struct {
+	global     *rate.Limiter
+	perClient  rate.Limit
+	burst      int
+	maxClients int
+	clock      clock.Clock
+
+	mu      sync.Mutex
+	order   *list.List
+	clients map[netaddr.IP]*list.Element
+}
+
+// client is an entry in the Limiter LRU.
+type client struct {
+	ip  netaddr.IP
+	lim *rate.Limiter
+}
+
+// New returns a Limiter for the given Config.
+// It returns nil, which allows all requests, when no limit is configured.
+func New(c Config) *Limiter {
+	if c.RequestsPerSecond <= 0 && c.PerClientRequestsPerSecond <= 0 {
+		return nil
+	}
+	l := &Limiter{
+		perClient:  rate.Limit(c.PerClientRequestsPerSecond),
+		burst:      burst(c.PerClientRequestsPerSecond, c.PerClientBurst),
+		maxClients: c.MaxClients,
+		clock:      clock.Or(c.Clock),
+		order:      list.New(),
+		clients:    make(map[netaddr.IP]*list.Element),
+	}
+	if l.maxClients <= 0 {
+		l.maxClients = DefaultMaxClients
+	}
+	if c.RequestsPerSecond > 0 {
+		l.global = rate.NewLimiter(rate.Limit(c.RequestsPerSecond), burst(c.RequestsPerSecond, c.Burst))
+	}
+	return l
+}
+
+// Allow reports whether a request from ip may proceed.
+// When it may not, the returned duration is how long the client should wait before retrying.
+func (l *Limiter) Allow(ip netaddr.IP) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	now := l.clock.Now()
+	var cr *rate.Reservation
+	if l.perClient > 0 {
+		cr = l.client(ip).ReserveN(now, 1)
+		if d := cr.DelayFrom(now); d > 0 {
+			cr.CancelAt(now)
+			return false, d
+		}
+	}
+	if l.global != nil {
+		gr := l.global.ReserveN(now, 1)
+		if d := gr.DelayFrom(now); d > 0 {
+			gr.CancelAt(now)
+			if cr != nil {
+				cr.CancelAt(now)
+			}
+			return false, d
+		}
+	}
+	return true, 0
+}
+
+// Forget drops the rate limiting state of ip.
+func (l *Limiter) Forget(ip netaddr.IP) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.clients[ip]; ok {
+		l.order.Remove(e)
+		delete(l.clients, ip)
+	}
+}
+
+// Reset drops the rate limiting state of all clients. The global rate is not reset.
+func (l *Limiter) Reset() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.order.Init()
+	l.clients = make(map[netaddr.IP]*list.Element)
+}
+
+// client returns the limiter for ip, creating it and evicting the least recently seen client if needed.
+func (l *Limiter) client(ip netaddr.IP) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.clients[ip]; ok {
+		l.order.MoveToFront(e)
+		return entry(e).lim
+	}
+	if l.order.Len() >= l.maxClients {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.clients, entry(oldest).ip)
+	}
+	c := &client{ip: ip, lim: rate.NewLimiter(l.perClient, l.burst)}
+	l.clients[ip] = l.order.PushFront(c)
+	return c.lim
+}
+
+// entry returns the client held by e.
+func entry(e *list.Element) *client {
+	c, _ := e.Value.(*client)
+	return c
+}
+
+// burst returns b, or r rounded up when b is not set.
+func burst(r float64, b int) int {
+	if b > 0 {
+		return b
+	}
+	return int(math.Ceil(r))
+}
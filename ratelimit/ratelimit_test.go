@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/clock"
+	"inet.af/netaddr"
+)
+
+func TestAllow(t *testing.T) {
+	ip1 := netaddr.IPv4(192, 168, 2, 1)
+	ip2 := netaddr.IPv4(192, 168, 2, 2)
+	tests := []struct {
+		name     string
+		cfg      Config
+		requests []netaddr.IP
+		want     []bool
+	}{
+		{
+			name:     "unlimited",
+			requests: []netaddr.IP{ip1, ip1, ip1},
+			want:     []bool{true, true, true},
+		},
+		{
+			name:     "global limit",
+			cfg:      Config{RequestsPerSecond: 0.001, Burst: 2},
+			requests: []netaddr.IP{ip1, ip2, ip1},
+			want:     []bool{true, true, false},
+		},
+		{
+			name:     "per client limit",
+			cfg:      Config{PerClientRequestsPerSecond: 0.001},
+			requests: []netaddr.IP{ip1, ip1, ip2},
+			want:     []bool{true, false, true},
+		},
+		{
+			name:     "per client rejection does not consume global tokens",
+			cfg:      Config{RequestsPerSecond: 0.001, Burst: 2, PerClientRequestsPerSecond: 0.001},
+			requests: []netaddr.IP{ip1, ip1, ip1, ip2, ip2},
+			want:     []bool{true, false, false, true, false},
+		},
+		{
+			name:     "least recently seen client is forgotten",
+			cfg:      Config{PerClientRequestsPerSecond: 0.001, MaxClients: 1},
+			requests: []netaddr.IP{ip1, ip1, ip2, ip1},
+			want:     []bool{true, false, true, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.cfg)
+			var got []bool
+			for _, ip := range tt.requests {
+				ok, wait := l.Allow(ip)
+				if !ok && wait <= 0 {
+					t.Errorf("expected a positive retry after duration, got: %v", wait)
+				}
+				got = append(got, ok)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestForget(t *testing.T) {
+	ip := netaddr.IPv4(192, 168, 2, 1)
+	l := New(Config{PerClientRequestsPerSecond: 0.001})
+	if ok, _ := l.Allow(ip); !ok {
+		t.Fatal("expected first request to be allowed")
+	}
+	if ok, _ := l.Allow(ip); ok {
+		t.Fatal("expected second request to be rejected")
+	}
+	l.Forget(ip)
+	if ok, _ := l.Allow(ip); !ok {
+		t.Fatal("expected request after Forget to be allowed")
+	}
+}
+
+func TestAllowRefill(t *testing.T) {
+	ip := netaddr.IPv4(192, 168, 2, 1)
+	fake := clock.NewFake(time.Unix(0, 0))
+	l := New(Config{PerClientRequestsPerSecond: 1, Clock: fake})
+	if ok, _ := l.Allow(ip); !ok {
+		t.Fatal("expected the first request to be allowed")
+	}
+	ok, wait := l.Allow(ip)
+	if ok {
+		t.Fatal("expected the second request to be limited")
+	}
+	if wait != time.Second {
+		t.Fatalf("expected a retry after %v, got: %v", time.Second, wait)
+	}
+	fake.Advance(wait)
+	if ok, _ := l.Allow(ip); !ok {
+		t.Fatal("expected a request to be allowed once the bucket refilled")
+	}
+}
+
+func TestReset(t *testing.T) {
+	ips := []netaddr.IP{netaddr.IPv4(192, 168, 2, 1), netaddr.IPv4(192, 168, 2, 2)}
+	l := New(Config{PerClientRequestsPerSecond: 0.001})
+	for _, ip := range ips {
+		if ok, _ := l.Allow(ip); !ok {
+			t.Fatal("expected first request to be allowed")
+		}
+	}
+	l.Reset()
+	for _, ip := range ips {
+		if ok, _ := l.Allow(ip); !ok {
+			t.Fatal("expected request after Reset to be allowed")
+		}
+	}
+}
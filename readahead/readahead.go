@@ -0,0 +1,111 @@
+// Package readahead reads from a slow source, like a network file system, ahead of its consumer, so that reading
+// the source and sending what was read, for example to a client, overlap instead of taking turns.
+package readahead
+
+import (
+	"io"
+	"sync"
+)
+
+// DefaultSize is the size of the buffers of a Reader created with a size that isn't positive.
+const DefaultSize = 64 * 1024
+
+// buffers is the number of buffers of a Reader: one is read from the source while the other is read by the consumer.
+const buffers = 2
+
+// chunk is a buffer read from the source, along with the error the read returned.
+type chunk struct {
+	buf []byte
+	err error
+}
+
+// Reader reads from its source in a background goroutine, into buffers of a fixed size. It must be closed.
+type Reader struct {
+	src    io.Reader
+	free   chan []byte
+	filled chan chunk
+	done   chan struct{}
+	// exited is closed when the goroutine reading src returns.
+	exited chan struct{}
+	once   sync.Once
+
+	// cur is the part of the current chunk not read by the consumer yet.
+	cur  []byte
+	last chunk
+	err  error
+}
+
+// NewReader returns a Reader that reads ahead of its consumer from src, size bytes at a time, or DefaultSize bytes
+// when size isn't positive. Closing the Reader closes src when it is an io.Closer.
+func NewReader(src io.Reader, size int) *Reader {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	r := &Reader{
+		src:    src,
+		free:   make(chan []byte, buffers),
+		filled: make(chan chunk, buffers),
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	for i := 0; i < buffers; i++ {
+		r.free <- make([]byte, size)
+	}
+	go r.fill()
+	return r
+}
+
+// fill reads src into the free buffers until it fails, at the end of src, or the Reader is closed.
+func (r *Reader) fill() {
+	defer close(r.exited)
+	for {
+		var buf []byte
+		select {
+		case buf = <-r.free:
+		case <-r.done:
+			return
+		}
+		n, err := io.ReadFull(r.src, buf)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		select {
+		case r.filled <- chunk{buf: buf[:n], err: err}:
+		case <-r.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read implements io.Reader. It returns the error reading the source failed with, after the bytes read before it.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.cur) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.last.buf != nil {
+			r.free <- r.last.buf[:cap(r.last.buf)]
+		}
+		r.last = <-r.filled
+		r.cur, r.err = r.last.buf, r.last.err
+	}
+	n := copy(p, r.cur)
+	r.cur = r.cur[n:]
+	return n, nil
+}
+
+// Close stops reading ahead and closes the source when it is an io.Closer, once the read in progress, if any, returns.
+func (r *Reader) Close() error {
+	err := error(nil)
+	r.once.Do(func() {
+		close(r.done)
+		<-r.exited
+		if c, ok := r.src.(io.Closer); ok {
+			err = c.Close()
+		}
+	})
+	return err
+}
@@ -0,0 +1,124 @@
+package readahead
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestReader(t *testing.T) {
+	data := make([]byte, 100*1024+7)
+	rand.New(rand.NewSource(1)).Read(data)
+	errRead := errors.New("read failed")
+	tests := []struct {
+		name    string
+		src     io.Reader
+		size    int
+		want    []byte
+		wantErr error
+	}{
+		{name: "larger than the buffers", src: bytes.NewReader(data), size: 4096, want: data},
+		{name: "smaller than the buffers", src: bytes.NewReader(data), size: 1 << 20, want: data},
+		{name: "default size", src: bytes.NewReader(data), want: data},
+		{name: "one byte reads", src: iotest.OneByteReader(bytes.NewReader(data[:10000])), size: 1000, want: data[:10000]},
+		{name: "empty", src: bytes.NewReader(nil), size: 1024, want: []byte{}},
+		{name: "error", src: io.MultiReader(bytes.NewReader(data[:5000]), iotest.ErrReader(errRead)), size: 1024, want: data[:5000], wantErr: errRead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReader(tt.src, tt.size)
+			defer r.Close()
+			var got bytes.Buffer
+			_, err := io.Copy(&got, iotest.HalfReader(r))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error mismatch, got: %v, want: %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got.Bytes(), tt.want) {
+				t.Fatalf("unexpected content read: %v bytes, want: %v bytes", got.Len(), len(tt.want))
+			}
+		})
+	}
+}
+
+// closeRecorder records whether it was closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestReaderClose(t *testing.T) {
+	src := &closeRecorder{Reader: bytes.NewReader(make([]byte, 1<<20))}
+	r := NewReader(src, 1024)
+	if _, err := r.Read(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !src.closed {
+		t.Fatal("expected the source to be closed")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal("closing twice:", err)
+	}
+}
+
+// slowReader reads from r, sleeping for delay before each read, like a network file system.
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (s slowReader) Read(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	return s.r.Read(p)
+}
+
+// slowWriter discards what is written to it, sleeping for delay before each write, like a client on a slow link.
+type slowWriter struct {
+	delay time.Duration
+}
+
+func (s slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	return len(p), nil
+}
+
+// BenchmarkReader compares sending a file from a slow source to a slow client with and without reading ahead.
+// Reading ahead overlaps the reads and the writes, so it approaches the time of the slower of the two, instead of their sum.
+func BenchmarkReader(b *testing.B) {
+	const (
+		size  = 1 << 20
+		chunk = 64 * 1024
+		delay = 200 * time.Microsecond
+	)
+	data := make([]byte, size)
+	for _, bb := range []struct {
+		name      string
+		readAhead bool
+	}{{name: "unbuffered"}, {name: "buffered", readAhead: true}} {
+		b.Run(bb.name, func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				var src io.ReadCloser = io.NopCloser(slowReader{r: bytes.NewReader(data), delay: delay})
+				if bb.readAhead {
+					src = NewReader(src, chunk)
+				}
+				buf := make([]byte, chunk)
+				if _, err := io.CopyBuffer(slowWriter{delay: delay}, struct{ io.Reader }{src}, buf); err != nil {
+					b.Fatal(err)
+				}
+				src.Close()
+			}
+		})
+	}
+}
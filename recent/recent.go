@@ -0,0 +1,144 @@
+// Package recent keeps a bounded, in-memory view of the iPXE binaries recently served to clients.
+package recent
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSize is the number of boots kept when no size is given.
+const DefaultSize = 256
+
+// Boot is a file served to a client.
+type Boot struct {
+	// Client is the IP address of the client.
+	Client string `json:"client"`
+	// MAC is the MAC address from the requested path, if there was one.
+	MAC string `json:"mac,omitempty"`
+	// Filename is the name of the file served.
+	Filename string `json:"filename"`
+	// Protocol is the protocol the file was served over.
+	Protocol string `json:"protocol"`
+	// Time is when the file finished being served.
+	Time time.Time `json:"time"`
+}
+
+// Boots is a ring buffer of the most recently served files. When it is full, the oldest boot is overwritten.
+// A nil *Boots records nothing.
+type Boots struct {
+	mu   sync.Mutex
+	buf  []Boot
+	next int
+	full bool
+}
+
+// New returns a Boots that keeps the last size boots, or DefaultSize when size is not greater than zero.
+func New(size int) *Boots {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &Boots{buf: make([]Boot, size)}
+}
+
+// Add records a boot.
+func (b *Boots) Add(boot Boot) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.add(boot)
+}
+
+// add records a boot. b.mu must be held.
+func (b *Boots) add(boot Boot) {
+	b.buf[b.next] = boot
+	b.next = (b.next + 1) % len(b.buf)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// Forget drops the recorded boots of the client IP address.
+func (b *Boots) Forget(client string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	kept := make([]Boot, 0, len(b.buf))
+	for _, boot := range b.list() {
+		if boot.Client != client {
+			kept = append(kept, boot)
+		}
+	}
+	b.reset()
+	for i := len(kept) - 1; i >= 0; i-- {
+		b.add(kept[i])
+	}
+}
+
+// Reset drops all the recorded boots.
+func (b *Boots) Reset() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reset()
+}
+
+// reset drops all the recorded boots. b.mu must be held.
+func (b *Boots) reset() {
+	b.buf = make([]Boot, len(b.buf))
+	b.next, b.full = 0, false
+}
+
+// List returns the recorded boots, most recent first.
+func (b *Boots) List() []Boot {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.list()
+}
+
+// list returns the recorded boots, most recent first. b.mu must be held.
+func (b *Boots) list() []Boot {
+	n := b.next
+	if b.full {
+		n = len(b.buf)
+	}
+	l := make([]Boot, 0, n)
+	for i := 1; i <= n; i++ {
+		l = append(l, b.buf[(b.next-i+len(b.buf))%len(b.buf)])
+	}
+	return l
+}
+
+// ServeHTTP writes the recorded boots as a JSON array, most recent first.
+// The optional "client" and "mac" query parameters only return the boots of that client IP or MAC address.
+func (b *Boots) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	client := req.URL.Query().Get("client")
+	mac := req.URL.Query().Get("mac")
+	l := []Boot{}
+	for _, boot := range b.List() {
+		if client != "" && boot.Client != client {
+			continue
+		}
+		if mac != "" && !strings.EqualFold(boot.MAC, mac) {
+			continue
+		}
+		l = append(l, boot)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(l)
+}
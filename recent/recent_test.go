@@ -0,0 +1,113 @@
+package recent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestList(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		add  []string
+		want []string
+	}{
+		{name: "empty", size: 3, want: []string{}},
+		{name: "not full", size: 3, add: []string{"a", "b"}, want: []string{"b", "a"}},
+		{name: "full", size: 3, add: []string{"a", "b", "c"}, want: []string{"c", "b", "a"}},
+		{name: "wrapped", size: 3, add: []string{"a", "b", "c", "d", "e"}, want: []string{"e", "d", "c"}},
+		{name: "default size", add: []string{"a"}, want: []string{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New(tt.size)
+			for _, f := range tt.add {
+				b.Add(Boot{Filename: f})
+			}
+			got := []string{}
+			for _, boot := range b.List() {
+				got = append(got, boot.Filename)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	now := time.Date(2022, 1, 6, 12, 0, 0, 0, time.UTC)
+	b := New(10)
+	b.Add(Boot{Client: "192.168.2.10", MAC: "0a:00:27:00:00:02", Filename: "undionly.kpxe", Protocol: "tftp", Time: now})
+	b.Add(Boot{Client: "192.168.2.11", Filename: "snp.efi", Protocol: "tftp", Time: now.Add(time.Second)})
+	b.Add(Boot{Client: "192.168.2.10", MAC: "0a:00:27:00:00:02", Filename: "auto.ipxe", Protocol: "http", Time: now.Add(2 * time.Second)})
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{name: "all", want: []string{"auto.ipxe", "snp.efi", "undionly.kpxe"}},
+		{name: "by client", query: "?client=192.168.2.11", want: []string{"snp.efi"}},
+		{name: "by mac", query: "?mac=0A:00:27:00:00:02", want: []string{"auto.ipxe", "undionly.kpxe"}},
+		{name: "no match", query: "?client=192.168.2.99", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			b.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/recent-boots"+tt.query, nil))
+			if diff := cmp.Diff(w.Code, http.StatusOK); diff != "" {
+				t.Fatal(diff)
+			}
+			var boots []Boot
+			if err := json.NewDecoder(w.Body).Decode(&boots); err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, boot := range boots {
+				got = append(got, boot.Filename)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestNilBoots(t *testing.T) {
+	var b *Boots
+	b.Add(Boot{Filename: "snp.efi"})
+	if got := b.List(); got != nil {
+		t.Fatalf("expected nil, got: %v", got)
+	}
+}
+
+func TestForget(t *testing.T) {
+	b := New(3)
+	for _, boot := range []Boot{
+		{Client: "192.168.2.10", Filename: "a"},
+		{Client: "192.168.2.11", Filename: "b"},
+		{Client: "192.168.2.10", Filename: "c"},
+		{Client: "192.168.2.12", Filename: "d"},
+	} {
+		b.Add(boot)
+	}
+	b.Forget("192.168.2.10")
+	b.Add(Boot{Client: "192.168.2.13", Filename: "e"})
+	got := []string{}
+	for _, boot := range b.List() {
+		got = append(got, boot.Filename)
+	}
+	if diff := cmp.Diff(got, []string{"e", "d", "b"}); diff != "" {
+		t.Fatal(diff)
+	}
+	b.Reset()
+	if diff := cmp.Diff(b.List(), []Boot{}); diff != "" {
+		t.Fatal(diff)
+	}
+}
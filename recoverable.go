@@ -0,0 +1,91 @@
+package ipxedust
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// minRecoverWait and maxRecoverWait bound the wait before accepting or reading again after a recoverable error,
+// see Server.RecoverableErr. The wait doubles with every consecutive error, like net/http does for temporary errors.
+const (
+	minRecoverWait = 5 * time.Millisecond
+	maxRecoverWait = time.Second
+)
+
+// recoverer retries the operations of a listener or conn that fail with a recoverable error.
+type recoverer struct {
+	recoverable func(error) bool
+	protocol    Protocol
+	log         logr.Logger
+}
+
+// retry reports whether err is recoverable, waiting before the operation is retried when it is. wait is the previous
+// wait, zero for the first consecutive error. An error of a closed listener or conn is never recoverable, it is how
+// serving stops.
+func (r recoverer) retry(err error, wait *time.Duration) bool {
+	if errors.Is(err, net.ErrClosed) || !r.recoverable(err) {
+		return false
+	}
+	*wait *= 2
+	if *wait < minRecoverWait {
+		*wait = minRecoverWait
+	}
+	if *wait > maxRecoverWait {
+		*wait = maxRecoverWait
+	}
+	r.log.Info("recoverable error, retrying", LogKeyProtocol, r.protocol, "error", err.Error(), "wait", *wait)
+	time.Sleep(*wait)
+	return true
+}
+
+// recoverableListener accepts again when accepting fails with a recoverable error, instead of failing, which stops
+// serving HTTP.
+type recoverableListener struct {
+	net.Listener
+	recoverer
+}
+
+func (l recoverableListener) Accept() (net.Conn, error) {
+	var wait time.Duration
+	for {
+		conn, err := l.Listener.Accept()
+		if err == nil || !l.retry(err, &wait) {
+			return conn, err
+		}
+	}
+}
+
+// recoverableConn reads again when reading fails with a recoverable error, instead of failing, which
+// github.com/pin/tftp reports as a failed request before reading again, without waiting.
+type recoverableConn struct {
+	net.PacketConn
+	recoverer
+}
+
+func (c recoverableConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	var wait time.Duration
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err == nil || !c.retry(err, &wait) {
+			return n, addr, err
+		}
+	}
+}
+
+// recoverable returns l and conn retrying on the errors RecoverableErr reports as recoverable, unchanged when it is
+// nil. A *net.UDPConn is not wrapped: github.com/pin/tftp reads the local address of requests from it directly.
+func (c *Server) recoverable(l net.Listener, conn net.PacketConn) (net.Listener, net.PacketConn) {
+	if c.RecoverableErr == nil {
+		return l, conn
+	}
+	if !isNil(l) {
+		l = recoverableListener{Listener: l, recoverer: recoverer{recoverable: c.RecoverableErr, protocol: ProtocolHTTP, log: c.protocolLog(ProtocolHTTP)}}
+	}
+	if _, ok := conn.(*net.UDPConn); !ok && !isNil(conn) {
+		conn = recoverableConn{PacketConn: conn, recoverer: recoverer{recoverable: c.RecoverableErr, protocol: ProtocolTFTP, log: c.protocolLog(ProtocolTFTP)}}
+	}
+	return l, conn
+}
@@ -0,0 +1,158 @@
+package ipxedust
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+var (
+	errTransient = errors.New("transient")
+	errFatal     = errors.New("fatal")
+)
+
+// failingListener fails to accept with errs, in order, before accepting with its listener.
+type failingListener struct {
+	net.Listener
+	mu   sync.Mutex
+	errs []error
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if len(l.errs) > 0 {
+		err := l.errs[0]
+		l.errs = l.errs[1:]
+		l.mu.Unlock()
+		return nil, err
+	}
+	l.mu.Unlock()
+	return l.Listener.Accept()
+}
+
+// failingConn fails to read with errs, in order, before reading from its conn.
+type failingConn struct {
+	net.PacketConn
+	errs []error
+}
+
+func (c *failingConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return 0, nil, err
+	}
+	return c.PacketConn.ReadFrom(p)
+}
+
+func isTransient(err error) bool { return errors.Is(err, errTransient) }
+
+func TestRecoverableErr(t *testing.T) {
+	tests := []struct {
+		name        string
+		recoverable func(error) bool
+		errs        []error
+		wantErr     error
+	}{
+		{name: "recovered", recoverable: isTransient, errs: []error{errTransient, errTransient}},
+		{name: "fatal", recoverable: isTransient, errs: []error{errTransient, errFatal}, wantErr: errFatal},
+		{name: "not set", errs: []error{errTransient}, wantErr: errTransient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr := l.Addr().String()
+			ready := make(chan struct{}, 1)
+			c := &Server{
+				Log:            logr.Discard(),
+				TFTP:           ServerSpec{Disabled: true},
+				RecoverableErr: tt.recoverable,
+				OnReady:        func(Protocol, net.Addr) { ready <- struct{}{} },
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			errChan := make(chan error, 1)
+			go func() { errChan <- c.Serve(ctx, &failingListener{Listener: l, errs: tt.errs}, nil) }()
+			<-ready
+			if tt.wantErr != nil {
+				if err := <-errChan; !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error mismatch, got: %v, want: %v", err, tt.wantErr)
+				}
+				return
+			}
+			resp, err := http.Get(fmt.Sprintf("http://%v/undionly.kpxe", addr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(binary.Files["undionly.kpxe"]) {
+				t.Fatalf("expected %v bytes, got: %v", len(binary.Files["undionly.kpxe"]), len(got))
+			}
+			cancel()
+			if err := <-errChan; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestRecoverableConn(t *testing.T) {
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uconn.Close()
+	c := &Server{Log: logr.Discard(), RecoverableErr: isTransient}
+	_, conn := c.recoverable(nil, &failingConn{PacketConn: uconn, errs: []error{errTransient, errTransient, errFatal}})
+	if _, ok := conn.(recoverableConn); !ok {
+		t.Fatalf("expected the conn to be wrapped, got: %T", conn)
+	}
+	buf := make([]byte, 16)
+	if _, _, err := conn.ReadFrom(buf); !errors.Is(err, errFatal) {
+		t.Fatalf("error mismatch, got: %v, want: %v", err, errFatal)
+	}
+
+	sender, err := net.Dial("udp", uconn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	if _, err := sender.Write([]byte("probe")); err != nil {
+		t.Fatal(err)
+	}
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "probe" {
+		t.Fatalf("expected probe, got: %q", buf[:n])
+	}
+
+	// a closed conn is how serving stops, it is never recovered from.
+	c.RecoverableErr = func(error) bool { return true }
+	_, conn = c.recoverable(nil, &failingConn{PacketConn: uconn})
+	uconn.Close()
+	if _, _, err := conn.ReadFrom(buf); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("error mismatch, got: %v, want: %v", err, net.ErrClosed)
+	}
+
+	// a *net.UDPConn is read by github.com/pin/tftp directly.
+	if _, conn := c.recoverable(nil, uconn); conn != uconn {
+		t.Fatalf("expected a *net.UDPConn not to be wrapped, got: %T", conn)
+	}
+}
@@ -0,0 +1,341 @@
+package ipxedust
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/go-logr/logr"
+)
+
+// serveReloadable listens on the addresses of c and serves until ctx is done.
+// Each value received on reload calls load for a new configuration. The settings that can be applied
+// without rebinding (timeouts, limits and log level) are applied by serving the same listeners with a new Server
+// right away, while the running Server stops gracefully, letting its in-flight transfers finish.
+// Changes to any other setting are logged as requiring a restart. The served file set, the embedded binaries, is
+// fixed by the build: serving other binaries requires a restart with a new build.
+// When TFTPOptional is set, a failure to bind the TFTP address is logged as a warning and only HTTP is served.
+// If load fails, the error is logged and the running Server is left untouched.
+func (c *Command) serveReloadable(ctx context.Context, reload <-chan os.Signal, load func() (*Command, error)) error {
+	var tcp net.Listener
+	var udp *net.UDPConn
+	if !c.HTTPDisabled {
+		l, err := listenHTTP(c.HTTPAddr)
+		if err != nil {
+			return err
+		}
+		tcp = l
+		defer tcp.Close()
+	}
+	if !c.TFTPDisabled {
+		a, err := net.ResolveUDPAddr("udp", c.TFTPAddr)
+		if err != nil {
+			return err
+		}
+		switch udp, err = net.ListenUDP("udp", a); {
+		case err == nil:
+			defer udp.Close()
+		case c.TFTPOptional:
+			c.Log.Info("warning: TFTP failed to bind, serving HTTP only", "error", bindError(err).Error(), LogKeyAddr, c.TFTPAddr)
+			udp = nil
+		default:
+			return bindError(err)
+		}
+	}
+
+	var tcpShared *sharedListener
+	if tcp != nil {
+		tcpShared = newSharedListener(tcp)
+		defer tcpShared.stop()
+	}
+	var udpShared *sharedConn
+	if udp != nil {
+		udpShared = newSharedConn(udp)
+		defer udpShared.stop()
+	}
+	// the previous generations drain before the listeners are closed.
+	var draining sync.WaitGroup
+	defer draining.Wait()
+
+	for {
+		srv, err := c.server()
+		if err != nil {
+			return err
+		}
+		if udp == nil {
+			srv.TFTP.Disabled = true
+		}
+		sctx, cancel := context.WithCancel(ctx)
+		errCh := make(chan error, 1)
+		tg, ug := tcpShared.generation(), udpShared.generation()
+		go func() {
+			errCh <- srv.Serve(sctx, tg, ug)
+		}()
+
+		next, err := c.waitReload(ctx, reload, load, errCh)
+		if next == nil {
+			cancel()
+			return err
+		}
+		// the next generation serves the new requests right away, while the transfers in flight drain.
+		if tg != nil {
+			tg.Close()
+		}
+		if ug != nil {
+			ug.Close()
+		}
+		cancel()
+		draining.Add(1)
+		go func(log logr.Logger) {
+			defer draining.Done()
+			if err := <-errCh; err != nil {
+				log.Error(err, "serving the previous configuration failed")
+			}
+		}(c.Log)
+		c = next
+		c.Log.Info("configuration reloaded", "tftpTimeout", c.TFTPTimeout, "httpTimeout", c.HTTPTimeout, "logLevel", c.LogLevel, "binaries", AvailableBinaries())
+	}
+}
+
+// waitReload waits for the running Server to stop, ctx to be done, or a reload.
+// It returns the Command to serve next after a successful reload, otherwise nil and the error to return.
+func (c *Command) waitReload(ctx context.Context, reload <-chan os.Signal, load func() (*Command, error), errCh <-chan error) (*Command, error) {
+	for {
+		select {
+		case err := <-errCh:
+			return nil, err
+		case <-ctx.Done():
+			return nil, <-errCh
+		case <-reload:
+			c.Log.Info("reloading configuration")
+			n, err := load()
+			if err != nil {
+				c.Log.Error(err, "reloading configuration failed, keeping the current configuration")
+				continue
+			}
+			return c.reloaded(n), nil
+		}
+	}
+}
+
+// reloaded returns a copy of c with the settings of n that can be applied without rebinding.
+// Changes to other settings are logged as requiring a restart.
+func (c *Command) reloaded(n *Command) *Command {
+	var restart []string
+	if n.TFTPAddr != c.TFTPAddr {
+		restart = append(restart, "tftp-addr")
+	}
+	if n.HTTPAddr != c.HTTPAddr {
+		restart = append(restart, "http-addr")
+	}
+	if n.Port != c.Port {
+		restart = append(restart, "port")
+	}
+	if n.EnableTFTPSinglePort != c.EnableTFTPSinglePort {
+		restart = append(restart, "tftp-single-port")
+	}
+	if n.TFTPDisabled != c.TFTPDisabled {
+		restart = append(restart, "tftp-disabled")
+	}
+	if n.TFTPOptional != c.TFTPOptional {
+		restart = append(restart, "tftp-optional")
+	}
+	if n.HTTPDisabled != c.HTTPDisabled {
+		restart = append(restart, "http-disabled")
+	}
+	if len(restart) > 0 {
+		n.Log.Info("restart required", "settings", restart)
+	}
+	r := *c
+	r.TFTPTimeout = n.TFTPTimeout
+	r.HTTPTimeout = n.HTTPTimeout
+	r.LogLevel = n.LogLevel
+	r.Log = n.Log
+	r.RateLimit, r.RateLimitBurst = n.RateLimit, n.RateLimitBurst
+	r.ClientRateLimit, r.ClientRateLimitBurst = n.ClientRateLimit, n.ClientRateLimitBurst
+	r.MaxTFTPTransfersPerClient = n.MaxTFTPTransfersPerClient
+	r.TFTPMaxTransferDuration = n.TFTPMaxTransferDuration
+	r.HTTPMaxTransferDuration = n.HTTPMaxTransferDuration
+	return &r
+}
+
+// listenHTTP listens on the HTTP address addr, a TCP address or a Unix domain socket prefixed with "unix:".
+func listenHTTP(addr string) (net.Listener, error) {
+	if p, ok := unixSocketPath(addr); ok {
+		l, err := listenUnix(p)
+		if err != nil {
+			return nil, bindError(err)
+		}
+		return l, nil
+	}
+	a, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.ListenTCP("tcp", a)
+	if err != nil {
+		return nil, bindError(err)
+	}
+	return l, nil
+}
+
+// sharedListener accepts the connections of a listener shared by the successive Servers serving it, and hands each
+// one to the generation accepting, see generation.
+type sharedListener struct {
+	net.Listener
+	conns chan net.Conn
+	// stopped is closed to stop accepting, done when accepting stopped, with the error of the listener, err.
+	stopped chan struct{}
+	done    chan struct{}
+	err     error
+}
+
+// newSharedListener starts accepting the connections of l, until stop is called.
+func newSharedListener(l net.Listener) *sharedListener {
+	s := &sharedListener{Listener: l, conns: make(chan net.Conn), stopped: make(chan struct{}), done: make(chan struct{})}
+	go s.accept()
+	return s
+}
+
+func (s *sharedListener) accept() {
+	defer close(s.done)
+	for {
+		c, err := s.Listener.Accept()
+		if err != nil {
+			s.err = err
+			return
+		}
+		select {
+		case s.conns <- c:
+		case <-s.stopped:
+			c.Close()
+			return
+		}
+	}
+}
+
+// stop stops handing connections to the generations. The listener is closed by its owner.
+func (s *sharedListener) stop() {
+	close(s.stopped)
+}
+
+// generation returns the view of s given to a single Server, or nil when s is nil.
+// Closing it stops its Accept without closing s, so the next generation can use it.
+func (s *sharedListener) generation() net.Listener {
+	if s == nil {
+		return nil
+	}
+	return &listenerGeneration{sharedListener: s, closed: make(chan struct{})}
+}
+
+type listenerGeneration struct {
+	*sharedListener
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (g *listenerGeneration) Accept() (net.Conn, error) {
+	select {
+	case <-g.closed:
+		return nil, net.ErrClosed
+	default:
+	}
+	select {
+	case c := <-g.conns:
+		return c, nil
+	case <-g.closed:
+		return nil, net.ErrClosed
+	case <-g.done:
+		return nil, g.err
+	}
+}
+
+func (g *listenerGeneration) Close() error {
+	g.once.Do(func() { close(g.closed) })
+	return nil
+}
+
+// sharedConn reads the packets of a UDP conn shared by the successive Servers serving it, and hands each one to the
+// generation reading, see generation. Only the requests are read from it: transfers use their own conn, except in
+// single port mode, where the packets of the transfers in flight during a reload reach the next generation and the
+// transfers time out.
+type sharedConn struct {
+	*net.UDPConn
+	packets chan packet
+	// stopped is closed to stop reading, done when reading stopped, with the error of the conn, err.
+	stopped chan struct{}
+	done    chan struct{}
+	err     error
+}
+
+// packet is a packet read by a sharedConn.
+type packet struct {
+	b    []byte
+	addr net.Addr
+}
+
+// newSharedConn starts reading the packets of c, until stop is called.
+func newSharedConn(c *net.UDPConn) *sharedConn {
+	s := &sharedConn{UDPConn: c, packets: make(chan packet), stopped: make(chan struct{}), done: make(chan struct{})}
+	go s.read()
+	return s
+}
+
+func (s *sharedConn) read() {
+	defer close(s.done)
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := s.UDPConn.ReadFrom(buf)
+		if err != nil {
+			s.err = err
+			return
+		}
+		select {
+		case s.packets <- packet{b: append([]byte(nil), buf[:n]...), addr: addr}:
+		case <-s.stopped:
+			return
+		}
+	}
+}
+
+// stop stops handing packets to the generations. The conn is closed by its owner.
+func (s *sharedConn) stop() {
+	close(s.stopped)
+}
+
+// generation returns the view of s given to a single Server, or nil when s is nil.
+// Closing it stops its ReadFrom without closing s, so the next generation can use it.
+func (s *sharedConn) generation() net.PacketConn {
+	if s == nil {
+		return nil
+	}
+	return &connGeneration{sharedConn: s, closed: make(chan struct{})}
+}
+
+type connGeneration struct {
+	*sharedConn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (g *connGeneration) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case <-g.closed:
+		return 0, nil, net.ErrClosed
+	default:
+	}
+	select {
+	case p := <-g.packets:
+		return copy(b, p.b), p.addr, nil
+	case <-g.closed:
+		return 0, nil, net.ErrClosed
+	case <-g.done:
+		return 0, nil, g.err
+	}
+}
+
+func (g *connGeneration) Close() error {
+	g.once.Do(func() { close(g.closed) })
+	return nil
+}
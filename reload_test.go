@@ -0,0 +1,160 @@
+package ipxedust
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+func TestServeReloadable(t *testing.T) {
+	log, lines := newTestLogger()
+	httpAddr := fmt.Sprintf("127.0.0.1:%v", getPort())
+	tftpAddr := fmt.Sprintf("127.0.0.1:%v", getPort())
+	c := &Command{TFTPAddr: tftpAddr, TFTPTimeout: 5 * time.Second, HTTPAddr: httpAddr, HTTPTimeout: 5 * time.Second, LogLevel: "info", Log: log}
+
+	loads := []func() (*Command, error){
+		func() (*Command, error) {
+			n := *c
+			n.HTTPTimeout = 7 * time.Second
+			n.HTTPAddr = fmt.Sprintf("127.0.0.1:%v", getPort())
+			return &n, nil
+		},
+		func() (*Command, error) { return nil, errors.New("invalid configuration") },
+	}
+	load := func() (*Command, error) {
+		l := loads[0]
+		loads = loads[1:]
+		return l()
+	}
+	reload := make(chan os.Signal)
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- c.serveReloadable(ctx, reload, load) }()
+
+	waitServing(t, httpAddr)
+	for i, want := range []string{`"msg"="configuration reloaded" "tftpTimeout"="5s" "httpTimeout"="7s"`, "reloading configuration failed"} {
+		reload <- os.Interrupt
+		waitLog(t, lines, want)
+		// the listeners are not rebound, so the original addresses keep serving.
+		waitServing(t, httpAddr)
+		if diff := cmp.Diff(tftpGet(t, tftpAddr, "undionly.kpxe"), binary.Files["undionly.kpxe"]); diff != "" {
+			t.Fatalf("reload %v: %v", i, diff)
+		}
+	}
+	waitLog(t, lines, `"msg"="restart required" "settings"=["http-addr"]`)
+
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServeReloadableOverlap(t *testing.T) {
+	log, lines := newTestLogger()
+	httpAddr := fmt.Sprintf("127.0.0.1:%v", getPort())
+	tftpAddr := fmt.Sprintf("127.0.0.1:%v", getPort())
+	c := &Command{TFTPAddr: tftpAddr, TFTPTimeout: 10 * time.Second, HTTPAddr: httpAddr, HTTPTimeout: 5 * time.Second, LogLevel: "info", Log: log}
+	load := func() (*Command, error) {
+		n := *c
+		n.HTTPTimeout = 7 * time.Second
+		return &n, nil
+	}
+	reload := make(chan os.Signal)
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- c.serveReloadable(ctx, reload, load) }()
+	waitServing(t, httpAddr)
+
+	// a TFTP transfer in flight, waiting for the first block to be acknowledged, keeps the first generation draining.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	addr, err := net.ResolveUDPAddr("udp", tftpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.WriteTo([]byte("\x00\x01undionly.kpxe\x00octet\x00"), addr); err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	buf := make([]byte, 516)
+	n, from, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, buf[4:n]...)
+
+	reload <- os.Interrupt
+	// the next generation serves without waiting for the transfer.
+	waitLog(t, lines, `"msg"="configuration reloaded" "tftpTimeout"="10s" "httpTimeout"="7s"`)
+	if diff := cmp.Diff(tftpGet(t, tftpAddr, "snp.efi"), binary.Files["snp.efi"]); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// the transfer completes.
+	for n == 516 {
+		if _, err := conn.WriteTo([]byte{0, 4, buf[2], buf[3]}, from); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		if n, _, err = conn.ReadFrom(buf); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, buf[4:n]...)
+	}
+	if _, err := conn.WriteTo([]byte{0, 4, buf[2], buf[3]}, from); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, binary.Files["undionly.kpxe"]); diff != "" {
+		t.Fatal(diff)
+	}
+
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitServing waits for the HTTP server on addr to serve a file.
+func waitServing(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get(fmt.Sprintf("http://%v/snp.efi", addr))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v to serve: %v", addr, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// waitLog waits for a log line containing want.
+func waitLog(t *testing.T, lines func() []string, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(strings.Join(lines(), "\n"), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for log %q, got: %v", want, lines())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
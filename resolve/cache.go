@@ -0,0 +1,192 @@
+package resolve
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tinkerbell/ipxedust/clock"
+	"golang.org/x/sync/singleflight"
+	"inet.af/netaddr"
+)
+
+// CacheConfig bounds a Cache. The zero value disables caching.
+type CacheConfig struct {
+	// MaxEntries is the number of entries kept. Zero disables caching.
+	MaxEntries int
+	// MaxBytes bounds the total size of the cached content. Zero means no bound.
+	// Content larger than MaxBytes is not cached.
+	MaxBytes int64
+	// TTL is how long an entry is served from the cache. Zero means until it is evicted.
+	TTL time.Duration
+	// Clock tells the time entries expire. Defaults to the real clock.
+	Clock clock.Clock
+}
+
+// Cache is a least recently used cache of content, bounded by number of entries, total size and age.
+// Concurrent lookups of a missing key load the content once. It is safe for concurrent use.
+// A nil *Cache caches nothing.
+type Cache struct {
+	cfg   CacheConfig
+	clock clock.Clock
+	group singleflight.Group
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	bytes   int64
+	// generation is incremented by Purge and ForgetClient so that loads started before it are not cached.
+	generation uint64
+}
+
+// cacheEntry is an entry in the Cache LRU.
+type cacheEntry struct {
+	key     string
+	content []byte
+	expires time.Time
+}
+
+// NewCache returns a Cache bounded by cfg. It returns nil, which caches nothing, when cfg.MaxEntries is not set.
+func NewCache(cfg CacheConfig) *Cache {
+	if cfg.MaxEntries <= 0 {
+		return nil
+	}
+	return &Cache{cfg: cfg, clock: clock.Or(cfg.Clock), order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Get returns the content for key, from the cache or by calling load. Only content for which load returns
+// true and no error is cached. Concurrent calls for a key that is not cached share a single call of load.
+func (c *Cache) Get(key string, load func() ([]byte, bool, error)) ([]byte, bool, error) {
+	if c == nil {
+		return load()
+	}
+	if content, ok := c.get(key); ok {
+		return content, true, nil
+	}
+	type result struct {
+		content []byte
+		ok      bool
+	}
+	v, err, _ := c.group.Do(key, func() (interface{}, error) {
+		if content, ok := c.get(key); ok {
+			return result{content, true}, nil
+		}
+		gen := c.currentGeneration()
+		content, ok, err := load()
+		if err == nil && ok {
+			c.add(key, content, gen)
+		}
+		return result{content, ok}, err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	r, _ := v.(result)
+	return r.content, r.ok, nil
+}
+
+// Purge drops all entries, for example after the source of the content changed.
+// Loads in progress are not cached.
+func (c *Cache) Purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.bytes = 0
+	c.generation++
+}
+
+// ForgetClient drops the entries generated for the client IP address, whatever its client ID.
+// Like with Purge, loads in progress are not cached.
+func (c *Cache) ForgetClient(ip netaddr.IP) {
+	if c == nil {
+		return
+	}
+	prefix := clientPrefix(ip)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(e)
+		}
+	}
+	c.generation++
+}
+
+// Len returns the number of cached entries, including expired ones that were not evicted yet.
+func (c *Cache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// get returns the content cached for key, dropping it when it has expired.
+func (c *Cache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	ce := cached(e)
+	if !ce.expires.IsZero() && !c.clock.Now().Before(ce.expires) {
+		c.remove(e)
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return ce.content, true
+}
+
+// currentGeneration returns the generation of the cache, see Purge.
+func (c *Cache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// add caches content for key, when the cache wasn't purged since gen, evicting the least recently used
+// entries to stay within the bounds.
+func (c *Cache) add(key string, content []byte, gen uint64) {
+	size := int64(len(content))
+	if c.cfg.MaxBytes > 0 && size > c.cfg.MaxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.generation {
+		return
+	}
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	ce := &cacheEntry{key: key, content: content}
+	if c.cfg.TTL > 0 {
+		ce.expires = c.clock.Now().Add(c.cfg.TTL)
+	}
+	c.entries[key] = c.order.PushFront(ce)
+	c.bytes += size
+	for c.order.Len() > c.cfg.MaxEntries || (c.cfg.MaxBytes > 0 && c.bytes > c.cfg.MaxBytes) {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove drops the entry e. c.mu must be held.
+func (c *Cache) remove(e *list.Element) {
+	ce := cached(e)
+	c.order.Remove(e)
+	delete(c.entries, ce.key)
+	c.bytes -= int64(len(ce.content))
+}
+
+// cached returns the cacheEntry of e.
+func cached(e *list.Element) *cacheEntry {
+	ce, _ := e.Value.(*cacheEntry)
+	return ce
+}
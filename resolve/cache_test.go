@@ -0,0 +1,225 @@
+package resolve
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/clock"
+	"inet.af/netaddr"
+)
+
+// loader returns content for a key and counts the calls.
+type loader struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (l *loader) load(key string, content []byte) func() ([]byte, bool, error) {
+	return func() ([]byte, bool, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.calls == nil {
+			l.calls = make(map[string]int)
+		}
+		l.calls[key]++
+		return content, true, nil
+	}
+}
+
+func TestCache(t *testing.T) {
+	type get struct {
+		key     string
+		advance time.Duration
+		purge   bool
+	}
+	tests := []struct {
+		name      string
+		cfg       CacheConfig
+		gets      []get
+		wantCalls map[string]int
+		wantLen   int
+	}{
+		{
+			name:      "hit",
+			cfg:       CacheConfig{MaxEntries: 2},
+			gets:      []get{{key: "a"}, {key: "a"}, {key: "b"}, {key: "a"}},
+			wantCalls: map[string]int{"a": 1, "b": 1},
+			wantLen:   2,
+		},
+		{
+			name:      "evicts least recently used",
+			cfg:       CacheConfig{MaxEntries: 2},
+			gets:      []get{{key: "a"}, {key: "b"}, {key: "a"}, {key: "c"}, {key: "a"}, {key: "b"}},
+			wantCalls: map[string]int{"a": 1, "b": 2, "c": 1},
+			wantLen:   2,
+		},
+		{
+			name:      "evicts over max bytes",
+			cfg:       CacheConfig{MaxEntries: 10, MaxBytes: 20},
+			gets:      []get{{key: "a"}, {key: "b"}, {key: "c"}, {key: "a"}},
+			wantCalls: map[string]int{"a": 2, "b": 1, "c": 1},
+			wantLen:   2,
+		},
+		{
+			name:      "larger than max bytes",
+			cfg:       CacheConfig{MaxEntries: 10, MaxBytes: 3},
+			gets:      []get{{key: "a"}, {key: "a"}},
+			wantCalls: map[string]int{"a": 2},
+		},
+		{
+			name:      "expires",
+			cfg:       CacheConfig{MaxEntries: 2, TTL: time.Minute},
+			gets:      []get{{key: "a"}, {key: "a", advance: 59 * time.Second}, {key: "a", advance: time.Second}, {key: "a"}},
+			wantCalls: map[string]int{"a": 2},
+			wantLen:   1,
+		},
+		{
+			name:      "purge",
+			cfg:       CacheConfig{MaxEntries: 2},
+			gets:      []get{{key: "a"}, {key: "b"}, {key: "a", purge: true}},
+			wantCalls: map[string]int{"a": 2, "b": 1},
+			wantLen:   1,
+		},
+		{
+			name:      "disabled",
+			gets:      []get{{key: "a"}, {key: "a"}},
+			wantCalls: map[string]int{"a": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Unix(0, 0))
+			tt.cfg.Clock = clk
+			c := NewCache(tt.cfg)
+			l := &loader{}
+			for _, g := range tt.gets {
+				clk.Advance(g.advance)
+				if g.purge {
+					c.Purge()
+				}
+				want := []byte(g.key + "-content")
+				got, ok, err := c.Get(g.key, l.load(g.key, want))
+				if err != nil || !ok {
+					t.Fatalf("unexpected result for %v: %v, %v", g.key, ok, err)
+				}
+				if diff := cmp.Diff(got, want); diff != "" {
+					t.Fatal(diff)
+				}
+			}
+			if diff := cmp.Diff(l.calls, tt.wantCalls); diff != "" {
+				t.Fatal(diff)
+			}
+			if got := c.Len(); got != tt.wantLen {
+				t.Fatalf("expected %v entries, got: %v", tt.wantLen, got)
+			}
+		})
+	}
+}
+
+func TestCacheNotCached(t *testing.T) {
+	c := NewCache(CacheConfig{MaxEntries: 2})
+	errLoad := errors.New("boom")
+	if _, _, err := c.Get("a", func() ([]byte, bool, error) { return nil, false, errLoad }); !errors.Is(err, errLoad) {
+		t.Fatalf("expected %v, got: %v", errLoad, err)
+	}
+	if _, ok, err := c.Get("b", func() ([]byte, bool, error) { return nil, false, nil }); ok || err != nil {
+		t.Fatalf("unexpected result: %v, %v", ok, err)
+	}
+	if got := c.Len(); got != 0 {
+		t.Fatalf("expected no entries, got: %v", got)
+	}
+}
+
+func TestCacheConcurrentMiss(t *testing.T) {
+	c := NewCache(CacheConfig{MaxEntries: 2})
+	l := &loader{}
+	release := make(chan struct{})
+	load := l.load("a", []byte("content"))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, _ = c.Get("a", func() ([]byte, bool, error) {
+				<-release
+				return load()
+			})
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if got := l.calls["a"]; got != 1 {
+		t.Fatalf("expected a single load, got: %v", got)
+	}
+}
+
+func TestResolveCache(t *testing.T) {
+	var calls int
+	r := Resolver{
+		Dynamic: func(_ context.Context, client netaddr.IPPort, name string) ([]byte, bool, error) {
+			calls++
+			return []byte(client.IP().String() + " " + name), true, nil
+		},
+		Cache: NewCache(CacheConfig{MaxEntries: 10}),
+	}
+	ip := netaddr.IPv4(192, 168, 2, 1)
+	requests := []struct {
+		client netaddr.IPPort
+		id     string
+		name   string
+	}{
+		{client: netaddr.IPPortFrom(ip, 1000), name: "snp.efi"},
+		{client: netaddr.IPPortFrom(ip, 1001), name: "0a:00:27:00:00:02/snp.efi"},
+		{client: netaddr.IPPortFrom(ip, 1002), name: "ipxe.efi"},
+		{client: netaddr.IPPortFrom(netaddr.IPv4(192, 168, 2, 2), 1000), name: "snp.efi"},
+		{client: netaddr.IPPortFrom(ip, 1000), id: "machine-1", name: "snp.efi"},
+	}
+	for _, req := range requests {
+		ctx := context.Background()
+		if req.id != "" {
+			ctx = WithClientID(ctx, req.id)
+		}
+		if _, err := r.Resolve(ctx, req.client, req.name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the client port and the directory of the file name are not part of the key.
+	if calls != 4 {
+		t.Fatalf("expected 4 calls, got: %v", calls)
+	}
+}
+
+func TestCacheForgetClient(t *testing.T) {
+	c := NewCache(CacheConfig{MaxEntries: 10})
+	ctx := context.Background()
+	keys := []string{
+		cacheKey(ctx, netaddr.MustParseIPPort("192.168.2.10:1234"), "auto.ipxe"),
+		cacheKey(WithClientID(ctx, "0a:00:27:00:00:02"), netaddr.MustParseIPPort("192.168.2.10:1234"), "auto.ipxe"),
+		cacheKey(ctx, netaddr.MustParseIPPort("192.168.2.100:1234"), "auto.ipxe"),
+	}
+	l := &loader{}
+	for _, k := range keys {
+		if _, _, err := c.Get(k, l.load(k, []byte("content"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.ForgetClient(netaddr.MustParseIP("192.168.2.10"))
+	if got := c.Len(); got != 1 {
+		t.Fatalf("expected 1 entry, got: %v", got)
+	}
+	for _, k := range keys {
+		if _, _, err := c.Get(k, l.load(k, []byte("content"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if diff := cmp.Diff(l.calls, map[string]int{keys[0]: 2, keys[1]: 2, keys[2]: 1}); diff != "" {
+		t.Fatal(diff)
+	}
+	var nilCache *Cache
+	nilCache.ForgetClient(netaddr.MustParseIP("192.168.2.10"))
+}
@@ -0,0 +1,72 @@
+package resolve
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"inet.af/netaddr"
+)
+
+// ChecksumExtension is appended to a file name to request the SHA-256 of its content, see Resolver.Checksums.
+const ChecksumExtension = ".sha256"
+
+// IsChecksum reports whether the file name is the name of a checksum file, ending with ChecksumExtension in any case.
+func IsChecksum(filename string) bool {
+	return strings.EqualFold(path.Ext(filename), ChecksumExtension)
+}
+
+// Checksums caches the checksums of the content that doesn't change while serving: the registered files and the
+// embedded iPXE binaries. It is safe for concurrent use.
+type Checksums struct {
+	mu   sync.Mutex
+	sums map[string]string
+}
+
+// NewChecksums returns an empty Checksums.
+func NewChecksums() *Checksums {
+	return &Checksums{sums: make(map[string]string)}
+}
+
+// get returns the cached checksum for key, or computes it with sum and caches it when cache is true.
+func (c *Checksums) get(key string, cache bool, sum func() string) string {
+	if !cache {
+		return sum()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.sums[key]
+	if !ok {
+		s = sum()
+		c.sums[key] = s
+	}
+	return s
+}
+
+// checksum returns the checksum file of filename requested by client, ending with ChecksumExtension: the SHA-256 of
+// the content served for the file name without it, in the format of sha256sum, so that `sha256sum -c` verifies a
+// downloaded file. The content is resolved like any request, it is only read when its checksum is not cached.
+// Stateful is not consulted: it records the requests of the clients, and its content changes between requests.
+func (r Resolver) checksum(ctx context.Context, client netaddr.IPPort, filename string) (found, error) {
+	r.Stateful = nil
+	name := path.Base(filename)
+	served := name[:len(name)-len(ChecksumExtension)]
+	if served == "" {
+		return found{}, fmt.Errorf("file [%v] unknown: %w", name, os.ErrNotExist)
+	}
+	c, err := r.resolve(ctx, client, path.Join(path.Dir(filename), served), false)
+	if err != nil {
+		return found{}, err
+	}
+	cache := c.source == SourceEmbedded || c.source == SourceFiles
+	sum := r.Checksums.get(c.source+"\x00"+c.name, cache, func() string {
+		s := sha256.Sum256(c.content)
+		return hex.EncodeToString(s[:])
+	})
+	return found{content: []byte(sum + "  " + served + "\n"), name: name, source: SourceChecksum}, nil
+}
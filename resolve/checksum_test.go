@@ -0,0 +1,105 @@
+package resolve
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/tinkerbell/ipxedust/binary"
+	"inet.af/netaddr"
+)
+
+func TestChecksum(t *testing.T) {
+	sum := func(content []byte, name string) string {
+		s := sha256.Sum256(content)
+		return hex.EncodeToString(s[:]) + "  " + name + "\n"
+	}
+	custom := fstest.MapFS{
+		"custom.efi":          {Data: []byte("custom")},
+		"provided.efi":        {Data: []byte("provided")},
+		"provided.efi.sha256": {Data: []byte("provided checksum\n")},
+	}
+	r := Resolver{
+		FS:        []fs.FS{custom},
+		Aliases:   map[string]string{"bootx64.efi": "ipxe.efi"},
+		Checksums: NewChecksums(),
+	}
+	tests := []struct {
+		filename string
+		want     string
+		wantErr  error
+	}{
+		{filename: "ipxe.efi.sha256", want: sum(binary.Files["ipxe.efi"], "ipxe.efi")},
+		{filename: "0a:00:27:00:00:02/snp.efi.SHA256", want: sum(binary.Files["snp.efi"], "snp.efi")},
+		{filename: "bootx64.efi.sha256", want: sum(binary.Files["ipxe.efi"], "bootx64.efi")},
+		{filename: "custom.efi.sha256", want: sum([]byte("custom"), "custom.efi")},
+		{filename: "provided.efi.sha256", want: "provided checksum\n"},
+		{filename: "unknown.efi.sha256", wantErr: os.ErrNotExist},
+		{filename: ".sha256", wantErr: os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			got, err := r.Resolve(context.Background(), netaddr.IPPort{}, tt.filename)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got: %v", tt.wantErr, err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("expected %q, got: %q", tt.want, got)
+			}
+		})
+	}
+
+	// the files of FS may change, their checksums are not cached.
+	custom["custom.efi"] = &fstest.MapFile{Data: []byte("changed")}
+	if got, err := r.Resolve(context.Background(), netaddr.IPPort{}, "custom.efi.sha256"); err != nil || string(got) != sum([]byte("changed"), "custom.efi") {
+		t.Fatalf("expected the checksum of the changed file, got: %q, %v", got, err)
+	}
+
+	r.Checksums = nil
+	if _, err := r.Resolve(context.Background(), netaddr.IPPort{}, "ipxe.efi.sha256"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no checksum files without Checksums, got: %v", err)
+	}
+}
+
+func TestChecksumStateful(t *testing.T) {
+	calls := 0
+	r := Resolver{
+		Stateful: func(_ context.Context, _ netaddr.IPPort, name string) ([]byte, bool, error) {
+			if name != "boot.ipxe" {
+				return nil, false, nil
+			}
+			calls++
+			return []byte("first"), true, nil
+		},
+		Checksums: NewChecksums(),
+	}
+	if _, err := r.Resolve(context.Background(), netaddr.IPPort{}, "boot.ipxe.sha256"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no checksum of stateful content, got: %v", err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected Stateful not to be called, got: %v calls", calls)
+	}
+}
+
+func TestChecksumsCache(t *testing.T) {
+	c := NewChecksums()
+	calls := 0
+	sum := func() string {
+		calls++
+		return "sum"
+	}
+	for i := 0; i < 3; i++ {
+		if got := c.get("key", true, sum); got != "sum" {
+			t.Fatalf("expected sum, got: %v", got)
+		}
+	}
+	c.get("key", false, sum)
+	if calls != 2 {
+		t.Fatalf("expected the checksum to be computed twice, once cached and once not, got: %v", calls)
+	}
+}
@@ -0,0 +1,33 @@
+package resolve
+
+import "context"
+
+// clientIDKey is the context key of the client identifier.
+type clientIDKey struct{}
+
+// WithClientID returns a copy of ctx that carries the identifier of the client, for example the machine ID
+// a cooperating DHCP server passes on. Hooks called with the context, like Resolver.Dynamic, get it with ClientID.
+func WithClientID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, clientIDKey{}, id)
+}
+
+// ClientID returns the identifier of the client carried by ctx, see WithClientID.
+func ClientID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(clientIDKey{}).(string)
+	return id, ok
+}
+
+// verifiedClientKey is the context key of the verified client certificate subject.
+type verifiedClientKey struct{}
+
+// WithVerifiedClient returns a copy of ctx that carries the subject of the verified client certificate of a mutual
+// TLS request. Hooks called with the context, like Resolver.Dynamic, get it with VerifiedClient.
+func WithVerifiedClient(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, verifiedClientKey{}, subject)
+}
+
+// VerifiedClient returns the subject of the verified client certificate carried by ctx, see WithVerifiedClient.
+func VerifiedClient(ctx context.Context) (string, bool) {
+	subject, ok := ctx.Value(verifiedClientKey{}).(string)
+	return subject, ok
+}
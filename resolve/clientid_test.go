@@ -0,0 +1,16 @@
+package resolve
+
+import (
+	"context"
+	"testing"
+)
+
+func TestClientID(t *testing.T) {
+	if id, ok := ClientID(context.Background()); ok || id != "" {
+		t.Fatalf("expected no client ID, got: %q", id)
+	}
+	id, ok := ClientID(WithClientID(context.Background(), "machine-1"))
+	if !ok || id != "machine-1" {
+		t.Fatalf("expected client ID %q, got: %q", "machine-1", id)
+	}
+}
@@ -0,0 +1,151 @@
+package resolve
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+// Sources of the files of a Manifest, in the order they are looked up.
+const (
+	// SourceInfo is the virtual InfoFileName, see Resolver.Info.
+	SourceInfo = "info"
+	// SourceOverlay are the files of the file system of Resolver.Overlay.
+	SourceOverlay = "overlay"
+	// SourceFiles are the files of Resolver.Files, registered when serving starts, like the binaries of an OCI artifact.
+	SourceFiles = "files"
+	// SourceFS are the files of the file systems of Resolver.FS.
+	SourceFS = "fs"
+	// SourceEmbedded are the embedded iPXE binaries, see binary.Files.
+	SourceEmbedded = "embedded"
+)
+
+// Sources of content that is not listed in a Manifest, see Resolver.Locate.
+const (
+	// SourceDynamic is the content generated by Resolver.Dynamic or Resolver.Stateful.
+	SourceDynamic = "dynamic"
+	// SourceUpstream are the files fetched by Resolver.Upstream.
+	SourceUpstream = "upstream"
+	// SourceMissingScript is Resolver.MissingScript, served in place of a script that is not found.
+	SourceMissingScript = "missing-script"
+	// SourceChecksum is the checksum file of another file, see Resolver.Checksums.
+	SourceChecksum = "checksum"
+)
+
+// Manifest lists the files a Resolver serves, see Resolver.Manifest.
+type Manifest struct {
+	// Files are the files served, in order of name.
+	Files []Entry `json:"files"`
+	// Dynamic is set when files that are not listed may be generated, see Resolver.Dynamic and Resolver.Stateful.
+	// Their names are not known until they are requested, and they may be served in place of the files listed.
+	Dynamic bool `json:"dynamic"`
+	// Upstream is set when files that are not listed may be fetched upstream, see Resolver.Upstream.
+	Upstream bool `json:"upstream"`
+}
+
+// Entry is a file listed in a Manifest.
+type Entry struct {
+	// Name is the name the file is requested with.
+	Name string `json:"name"`
+	// Size is the size of the file, in bytes.
+	Size int64 `json:"size"`
+	// ETag identifies the content of the file: its SHA-256, quoted like an HTTP entity tag. It is empty for the
+	// InfoFileName, whose content changes with every request.
+	ETag string `json:"etag,omitempty"`
+	// Source is where the file is served from, one of the Source constants.
+	Source string `json:"source"`
+	// FS is the index, in Resolver.FS, of the file system the file is served from, for SourceFS.
+	FS int `json:"fs,omitempty"`
+	// Aliases are the names of Resolver.Aliases that are served this file, in order.
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// Manifest returns the files r serves: InfoFileName, when Info is set, the files of Overlay, of Files, of the top level directory
+// of the file systems of FS and the embedded iPXE binaries, with the aliases that are served them. A name is listed
+// once, with the source it is served from, the first in this order. The names of Aliases are not listed on their own,
+// the files served in place of files that are not found, MissingScript and DefaultEFI, aren't either.
+// What Stateful and Dynamic generate and Upstream fetches can't be listed, only whether they are set is reported.
+// Every file is read to compute its ETag, so a Manifest is costly to build with large file systems.
+func (r Resolver) Manifest() (Manifest, error) {
+	m := Manifest{Dynamic: r.Dynamic != nil || r.Stateful != nil, Upstream: r.Upstream != nil}
+	entries := make(map[string]*Entry)
+	add := func(e Entry) {
+		if _, ok := entries[e.Name]; ok {
+			return
+		}
+		// a name that is an alias is served the file it is an alias of.
+		if _, ok := r.Aliases[e.Name]; ok && e.Source != SourceInfo {
+			return
+		}
+		entries[e.Name] = &e
+	}
+	if r.Info != nil {
+		add(Entry{Name: InfoFileName, Size: int64(len(r.Info())), Source: SourceInfo})
+	}
+	if r.Overlay != nil {
+		if err := addFS(r.Overlay, "the overlay", Entry{Source: SourceOverlay}, add); err != nil {
+			return Manifest{}, err
+		}
+	}
+	for name, content := range r.Files {
+		add(Entry{Name: name, Size: int64(len(content)), ETag: etag(content), Source: SourceFiles})
+	}
+	for i, fsys := range r.FS {
+		if err := addFS(fsys, fmt.Sprintf("file system %v", i), Entry{Source: SourceFS, FS: i}, add); err != nil {
+			return Manifest{}, err
+		}
+	}
+	for _, name := range binary.Available() {
+		content := binary.Files[name]
+		add(Entry{Name: name, Size: int64(len(content)), ETag: etag(content), Source: SourceEmbedded})
+	}
+	for alias, name := range r.Aliases {
+		if e, ok := entries[name]; ok && alias != InfoFileName {
+			e.Aliases = append(e.Aliases, alias)
+		}
+	}
+	m.Files = make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		sort.Strings(e.Aliases)
+		m.Files = append(m.Files, *e)
+	}
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Name < m.Files[j].Name })
+	return m, nil
+}
+
+// addFS adds the regular files of the top level directory of fsys, described by desc in errors, with add, as source.
+// Lookups only use the base name of the requested files, files in sub directories are never served.
+func addFS(fsys fs.FS, desc string, source Entry, add func(Entry)) error {
+	dir, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return fmt.Errorf("listing %v failed: %w", desc, err)
+	}
+	for _, d := range dir {
+		f, size, err := openFile(fsys, d.Name())
+		if err != nil {
+			// like a directory, or a dangling link.
+			continue
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("reading file [%v] from %v failed: %w", d.Name(), desc, err)
+		}
+		e := source
+		e.Name, e.Size, e.ETag = d.Name(), size, `"`+hex.EncodeToString(h.Sum(nil))+`"`
+		add(e)
+	}
+	return nil
+}
+
+// etag returns the entity tag of content, its quoted SHA-256.
+func etag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
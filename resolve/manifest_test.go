@@ -0,0 +1,116 @@
+package resolve
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sort"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/binary"
+	"inet.af/netaddr"
+)
+
+func TestManifestEmbedded(t *testing.T) {
+	m, err := Resolver{}.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []Entry
+	for name, content := range binary.Files {
+		want = append(want, Entry{Name: name, Size: int64(len(content)), ETag: etag(content), Source: SourceEmbedded})
+	}
+	sort.Slice(want, func(i, j int) bool { return want[i].Name < want[j].Name })
+	if diff := cmp.Diff(Manifest{Files: want}, m); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	override := fstest.MapFS{
+		"snp.efi":   {Data: []byte("override")},
+		"boot":      {Mode: fs.ModeDir},
+		"boot/a.sh": {Data: []byte("nested")},
+	}
+	shared := fstest.MapFS{
+		"snp.efi":    {Data: []byte("shared")},
+		"custom.efi": {Data: []byte("shared custom")},
+		// an alias is served the file it is an alias of.
+		"bootx64.efi": {Data: []byte("shadowed by its alias")},
+	}
+	r := Resolver{
+		Dynamic: func(context.Context, netaddr.IPPort, string) ([]byte, bool, error) { return nil, false, nil },
+		Files:   map[string][]byte{"ipxe.efi": []byte("registered")},
+		FS:      []fs.FS{override, shared},
+		Info:    func() []byte { return []byte("info") },
+		Aliases: map[string]string{"bootx64.efi": "ipxe.efi", "boot.efi": "ipxe.efi", "gone.efi": "missing.efi"},
+	}
+	m, err := r.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Dynamic || m.Upstream {
+		t.Fatalf("expected dynamic and no upstream, got: %+v", m)
+	}
+	got := make(map[string]Entry)
+	for _, e := range m.Files {
+		got[e.Name] = e
+	}
+	want := map[string]Entry{
+		InfoFileName: {Name: InfoFileName, Size: 4, Source: SourceInfo},
+		"ipxe.efi":   {Name: "ipxe.efi", Size: 10, ETag: etag([]byte("registered")), Source: SourceFiles, Aliases: []string{"boot.efi", "bootx64.efi"}},
+		"snp.efi":    {Name: "snp.efi", Size: 8, ETag: etag([]byte("override")), Source: SourceFS},
+		"custom.efi": {Name: "custom.efi", Size: 13, ETag: etag([]byte("shared custom")), Source: SourceFS, FS: 1},
+		"undionly.kpxe": {
+			Name:   "undionly.kpxe",
+			Size:   int64(len(binary.Files["undionly.kpxe"])),
+			ETag:   etag(binary.Files["undionly.kpxe"]),
+			Source: SourceEmbedded,
+		},
+	}
+	for name, w := range want {
+		if diff := cmp.Diff(w, got[name]); diff != "" {
+			t.Errorf("%v: %v", name, diff)
+		}
+	}
+	for _, name := range []string{"bootx64.efi", "boot.efi", "gone.efi", "boot", "boot/a.sh"} {
+		if _, ok := got[name]; ok {
+			t.Errorf("expected %v not to be listed", name)
+		}
+	}
+	if !sort.SliceIsSorted(m.Files, func(i, j int) bool { return m.Files[i].Name < m.Files[j].Name }) {
+		t.Error("expected the files to be sorted by name")
+	}
+}
+
+func TestManifestFSError(t *testing.T) {
+	errFS := errors.New("volume unavailable")
+	_, err := Resolver{FS: []fs.FS{errorFS{errFS}}}.Manifest()
+	if !errors.Is(err, errFS) {
+		t.Fatalf("error mismatch, got: %v, want: %v", err, errFS)
+	}
+}
+
+func TestManifestOverlay(t *testing.T) {
+	r := Resolver{
+		Overlay: fstest.MapFS{"ipxe.efi": {Data: []byte("canary")}},
+		Files:   map[string][]byte{"ipxe.efi": []byte("registered")},
+	}
+	m, err := r.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range m.Files {
+		if e.Name != "ipxe.efi" {
+			continue
+		}
+		want := Entry{Name: "ipxe.efi", Size: 6, ETag: etag([]byte("canary")), Source: SourceOverlay}
+		if diff := cmp.Diff(want, e); diff != "" {
+			t.Fatal(diff)
+		}
+		return
+	}
+	t.Fatal("expected ipxe.efi to be listed")
+}
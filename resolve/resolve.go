@@ -0,0 +1,330 @@
+// Package resolve maps requested file names to the content served by both the HTTP and TFTP handlers.
+// Features that change what is served for a file name are implemented here once, so both protocols behave the same.
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/readahead"
+	"github.com/tinkerbell/ipxedust/upstream"
+	"inet.af/netaddr"
+)
+
+// InfoFileName is the name of the virtual file that describes the running server, see Resolver.Info.
+// It can't be confused with an iPXE binary, which are never text files.
+const InfoFileName = "ipxedust.txt"
+
+// ScriptExtension is the file name extension of iPXE scripts, see Resolver.MissingScript.
+const ScriptExtension = ".ipxe"
+
+// EFIExtension is the file name extension of EFI binaries, see Resolver.DefaultEFI.
+const EFIExtension = ".efi"
+
+// Resolver resolves file names to content.
+// The zero value only resolves the embedded iPXE binaries.
+type Resolver struct {
+	// Dynamic, when not nil, is consulted first, with the client and the requested file name. When it returns true
+	// its content is served, for example a binary generated for the machine. Nothing it returns is cached, unless Cache is set.
+	Dynamic func(ctx context.Context, client netaddr.IPPort, name string) ([]byte, bool, error)
+	// Stateful, when not nil, is consulted before Dynamic, like it, for content that changes between requests of the
+	// same client, like the first boot script of firstboot.Tracker. What it returns is never cached.
+	Stateful func(ctx context.Context, client netaddr.IPPort, name string) ([]byte, bool, error)
+	// Peek, when not nil, answers in place of Stateful the requests that must not change what it records, like HTTP
+	// HEAD requests: it returns what Stateful would without recording the request, see firstboot.Tracker.Peek.
+	// Without it, those requests don't consult Stateful.
+	Peek func(ctx context.Context, client netaddr.IPPort, name string) ([]byte, bool, error)
+	// Cache, when not nil, caches the content returned by Dynamic, keyed by the client IP, the client ID
+	// (see ClientID) and the file name. The client port is not part of the key, as it changes between requests.
+	Cache *Cache
+	// Files are served in place of the embedded iPXE binaries of the same name, and in addition to them.
+	// For example the binaries pulled from an OCI artifact, see oci.Puller.
+	Files map[string][]byte
+	// FS are file systems consulted in order, after Files and before the embedded iPXE binaries, for example a local
+	// override directory (os.DirFS) and then a shared volume. The first one holding the file wins.
+	// Include binary.FS to consult the embedded binaries before the file systems that follow it.
+	FS []fs.FS
+	// Overlay, when not nil, is a file system consulted before Files, for example the canary binaries served to some
+	// clients, see canary.Rule. Unlike FS, it takes precedence over the registered files.
+	Overlay fs.FS
+	// Upstream serves files that are not embedded, fetching them from an upstream server. A nil Upstream disables this.
+	Upstream *upstream.Cache
+	// Info, when not nil, generates the content of InfoFileName on every request.
+	Info func() []byte
+	// Aliases maps requested file names to the names they are served as, for example "bootx64.efi" to "ipxe.efi".
+	// Dynamic is called with the requested name, the other lookups use the name it is an alias of.
+	Aliases map[string]string
+	// ReadAhead, when positive, makes Open stream the files of FS in buffers of this many bytes, read ahead of the
+	// transfer, instead of reading them whole before the transfer starts. Reading a slow file system, like a network
+	// volume, then overlaps with sending the file. See the readahead package. Resolve is not affected.
+	ReadAhead int
+	// MissingScript, when not nil, is served in place of the iPXE scripts that are not found, see IsScript, for
+	// example a script that prints a message on the console of the client and retries. Other files that are not
+	// found are not affected: serving a script in place of a binary would fail the boot in a more confusing way.
+	MissingScript []byte
+	// DefaultEFI, when not empty, is the name of the binary served in place of the EFI binaries that are not found,
+	// see IsEFI, for example "ipxe.efi" for firmware requesting a boot loader name that is not known. It is looked
+	// up like a requested name. Other files that are not found are not affected.
+	DefaultEFI string
+	// Checksums, when not nil, serves the SHA-256 of the content of a file name under its name with ChecksumExtension
+	// appended, like "ipxe.efi.sha256", in the format of sha256sum, so that clients on lossy links can verify a
+	// download. A file of that name that is found is served in its place. The checksums of Files and of the embedded
+	// iPXE binaries are cached in it, the others are computed for every request, as their content may change.
+	Checksums *Checksums
+	// EmbeddedModTime, when not zero, is the modification time of the embedded iPXE binaries, for example
+	// binary.BuildTime. The readers returned by Open for them implement ModTimer. Other content has no known
+	// modification time: it may change at any time, like a binary with a replaced script in Files.
+	EmbeddedModTime time.Time
+}
+
+// IsScript reports whether the file name is the name of an iPXE script, ending with ScriptExtension in any case.
+func IsScript(filename string) bool {
+	return strings.EqualFold(path.Ext(filename), ScriptExtension)
+}
+
+// IsEFI reports whether the file name is the name of an EFI binary, ending with EFIExtension in any case.
+func IsEFI(filename string) bool {
+	return strings.EqualFold(path.Ext(filename), EFIExtension)
+}
+
+// Resolve returns the content for filename requested by client. Only the base name of filename is used.
+// Stateful and Dynamic are consulted first, when they are set, then InfoFileName is resolved, when Info is set,
+// then Aliases are applied and Files, FS, embedded iPXE binaries and then Upstream are looked up.
+// MissingScript is returned for a script that is not found, when it is set, DefaultEFI is looked up in place of
+// an EFI binary that is not found, when it is set, and the checksum of a file is returned for its checksum file that
+// is not found, when Checksums is set.
+// The returned error wraps os.ErrNotExist when no content is found. Other errors mean a source
+// of content failed, for example upstream.ErrUpstream.
+func (r Resolver) Resolve(ctx context.Context, client netaddr.IPPort, filename string) ([]byte, error) {
+	c, err := r.resolve(ctx, client, filename, false)
+	return c.content, err
+}
+
+// Open returns the content for filename requested by client, like Resolve, as a reader along with its size.
+// When ReadAhead is positive, files of FS are streamed through a readahead.Reader, the others are read from memory.
+// An error reading a streamed file is returned by the reader. The reader must be closed.
+// The reader of an embedded iPXE binary implements ModTimer when EmbeddedModTime is set.
+func (r Resolver) Open(ctx context.Context, client netaddr.IPPort, filename string) (io.ReadCloser, int64, error) {
+	c, err := r.resolve(ctx, client, filename, r.ReadAhead > 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.file != nil {
+		return readahead.NewReader(c.file, r.ReadAhead), c.size, nil
+	}
+	rc := io.NopCloser(bytes.NewReader(c.content))
+	if c.embedded && !r.EmbeddedModTime.IsZero() {
+		rc = modTimeReader{ReadCloser: rc, modTime: r.EmbeddedModTime}
+	}
+	return rc, int64(len(c.content)), nil
+}
+
+// ModTimer is implemented by the readers returned by Resolver.Open for content whose modification time is known.
+type ModTimer interface {
+	// ModTime returns the modification time of the content.
+	ModTime() time.Time
+}
+
+// modTimeReader is a reader of content whose modification time is known.
+type modTimeReader struct {
+	io.ReadCloser
+	modTime time.Time
+}
+
+// ModTime implements ModTimer.
+func (m modTimeReader) ModTime() time.Time {
+	return m.modTime
+}
+
+// found is the content found for a file name: in memory, or a file of FS, opened, along with its size.
+type found struct {
+	content []byte
+	file    fs.File
+	size    int64
+	// embedded is set for the embedded iPXE binaries.
+	embedded bool
+	// name is the name the content was found under, source and fs where, see Location.
+	name   string
+	source string
+	fs     int
+}
+
+// Location is where the content served for a requested file name is found, see Resolver.Locate.
+type Location struct {
+	// Name is the name the content is found under: the requested base name, or the name it is an alias of, or
+	// DefaultEFI when it is served in its place.
+	Name string
+	// Size is the size of the content, in bytes.
+	Size int64
+	// Source is where the content is found, one of the Source constants.
+	Source string
+	// FS is the index, in Resolver.FS, of the file system the content is found in, for SourceFS.
+	FS int
+}
+
+// Locate returns where the content for filename requested by client is found, like Resolve, without reading the files
+// of Overlay and FS. Stateful is not consulted, as it records the requests of the clients. Dynamic is called and
+// Upstream is fetched, when they are set, as what they serve is only known once generated or fetched.
+// The returned error wraps os.ErrNotExist when no content is found, like Resolve.
+func (r Resolver) Locate(ctx context.Context, client netaddr.IPPort, filename string) (Location, error) {
+	r.Stateful = nil
+	c, err := r.resolve(ctx, client, filename, true)
+	if err != nil {
+		return Location{}, err
+	}
+	size := int64(len(c.content))
+	if c.file != nil {
+		c.file.Close()
+		size = c.size
+	}
+	return Location{Name: c.name, Size: size, Source: c.source, FS: c.fs}, nil
+}
+
+// resolve returns the content for filename requested by client, see Resolve. When open is true, a file of FS
+// is returned opened, along with its size, instead of being read.
+func (r Resolver) resolve(ctx context.Context, client netaddr.IPPort, filename string, open bool) (found, error) {
+	c, err := r.lookup(ctx, client, filename, open)
+	if errors.Is(err, os.ErrNotExist) && r.MissingScript != nil && IsScript(filename) {
+		return found{content: r.MissingScript, name: path.Base(filename), source: SourceMissingScript}, nil
+	}
+	if errors.Is(err, os.ErrNotExist) && r.DefaultEFI != "" && IsEFI(filename) {
+		return r.lookup(ctx, client, r.DefaultEFI, open)
+	}
+	if errors.Is(err, os.ErrNotExist) && r.Checksums != nil && IsChecksum(filename) {
+		return r.checksum(ctx, client, filename)
+	}
+	return c, err
+}
+
+// lookup returns the content for filename requested by client from the sources of content, see resolve.
+func (r Resolver) lookup(ctx context.Context, client netaddr.IPPort, filename string, open bool) (found, error) {
+	name := path.Base(filename)
+	if r.Stateful != nil {
+		content, ok, err := r.Stateful(ctx, client, name)
+		if err != nil {
+			return found{}, fmt.Errorf("generating file [%v] for %v failed: %w", name, client, err)
+		}
+		if ok {
+			return found{content: content, name: name, source: SourceDynamic}, nil
+		}
+	}
+	if r.Dynamic != nil {
+		content, ok, err := r.Cache.Get(cacheKey(ctx, client, name), func() ([]byte, bool, error) {
+			return r.Dynamic(ctx, client, name)
+		})
+		if err != nil {
+			return found{}, fmt.Errorf("generating file [%v] for %v failed: %w", name, client, err)
+		}
+		if ok {
+			return found{content: content, name: name, source: SourceDynamic}, nil
+		}
+	}
+	if name == InfoFileName && r.Info != nil {
+		return found{content: r.Info(), name: name, source: SourceInfo}, nil
+	}
+	if alias, ok := r.Aliases[name]; ok {
+		name = alias
+	}
+	if r.Overlay != nil {
+		c, err := lookupFS(r.Overlay, name, open)
+		if err == nil {
+			c.name, c.source = name, SourceOverlay
+			return c, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return found{}, fmt.Errorf("reading file [%v] from the overlay failed: %w", name, err)
+		}
+	}
+	if content, ok := r.Files[name]; ok {
+		return found{content: content, name: name, source: SourceFiles}, nil
+	}
+	for i, fsys := range r.FS {
+		c, err := lookupFS(fsys, name, open)
+		if err == nil {
+			c.name, c.source, c.fs = name, SourceFS, i
+			return c, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return found{}, fmt.Errorf("reading file [%v] from file system %v failed: %w", name, i, err)
+		}
+	}
+	if content, ok := binary.Files[name]; ok && binary.Embedded(name) {
+		return found{content: content, embedded: true, name: name, source: SourceEmbedded}, nil
+	}
+	if r.Upstream != nil {
+		content, err := r.Upstream.Get(ctx, name)
+		return found{content: content, name: name, source: SourceUpstream}, err
+	}
+	if _, ok := binary.Files[name]; ok {
+		return found{}, fmt.Errorf("file [%v]: %w", name, binary.ErrNotEmbedded)
+	}
+	return found{}, fmt.Errorf("file [%v] unknown: %w", name, os.ErrNotExist)
+}
+
+// lookupFS returns the file name of fsys, opened when open is true, read otherwise.
+func lookupFS(fsys fs.FS, name string, open bool) (found, error) {
+	var c found
+	var err error
+	if open {
+		c.file, c.size, err = openFile(fsys, name)
+	} else {
+		c.content, err = readFile(fsys, name)
+	}
+	return c, err
+}
+
+// readFile reads the regular file name from fsys. Other names, like directories, don't exist.
+func readFile(fsys fs.FS, name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, fs.ErrNotExist
+	}
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fs.ErrNotExist
+	}
+	return fs.ReadFile(fsys, name)
+}
+
+// openFile opens the regular file name of fsys and returns its size. Other names, like directories, don't exist.
+func openFile(fsys fs.FS, name string) (fs.File, int64, error) {
+	if !fs.ValidPath(name) {
+		return nil, 0, fs.ErrNotExist
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, 0, fs.ErrNotExist
+	}
+	return f, info.Size(), nil
+}
+
+// cacheKey returns the key of the content generated by Resolver.Dynamic for name requested by client.
+func cacheKey(ctx context.Context, client netaddr.IPPort, name string) string {
+	id, _ := ClientID(ctx)
+	return clientPrefix(client.IP()) + id + "\x00" + name
+}
+
+// clientPrefix returns the prefix of the keys of the content generated by Resolver.Dynamic for the client IP.
+func clientPrefix(ip netaddr.IP) string {
+	return ip.String() + "\x00"
+}
@@ -0,0 +1,283 @@
+package resolve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/upstream"
+	"inet.af/netaddr"
+)
+
+func TestResolve(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/custom.efi", "/snp.efi":
+			_, _ = w.Write([]byte("upstream content"))
+		case "/broken.efi":
+			http.Error(w, "boom", http.StatusBadGateway)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer up.Close()
+	cache, err := upstream.New(up.URL, time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errDynamic := errors.New("generation failed")
+	dynamic := func(_ context.Context, client netaddr.IPPort, name string) ([]byte, bool, error) {
+		switch name {
+		case "snp.efi":
+			return []byte(fmt.Sprintf("%v for %v", name, client)), true, nil
+		case "broken.efi":
+			return nil, false, errDynamic
+		}
+		return nil, false, nil
+	}
+	first := func(context.Context, netaddr.IPPort, string) ([]byte, bool, error) {
+		return []byte("first"), true, nil
+	}
+	tests := []struct {
+		name     string
+		resolver Resolver
+		filename string
+		want     []byte
+		wantErr  error
+	}{
+		{name: "embedded", filename: "snp.efi", want: binary.Files["snp.efi"]},
+		{name: "embedded with directory", filename: "0a:00:27:00:00:02/snp.efi", want: binary.Files["snp.efi"]},
+		{name: "unknown", filename: "custom.efi", wantErr: os.ErrNotExist},
+		{name: "embedded before upstream", resolver: Resolver{Upstream: cache}, filename: "snp.efi", want: binary.Files["snp.efi"]},
+		{name: "upstream", resolver: Resolver{Upstream: cache}, filename: "custom.efi", want: []byte("upstream content")},
+		{name: "not found upstream", resolver: Resolver{Upstream: cache}, filename: "missing.efi", wantErr: os.ErrNotExist},
+		{name: "info file", resolver: Resolver{Info: func() []byte { return []byte("info") }}, filename: InfoFileName, want: []byte("info")},
+		{name: "info file disabled", filename: InfoFileName, wantErr: os.ErrNotExist},
+		{name: "files before embedded", resolver: Resolver{Files: map[string][]byte{"snp.efi": []byte("pulled")}}, filename: "snp.efi", want: []byte("pulled")},
+		{name: "files in addition to embedded", resolver: Resolver{Files: map[string][]byte{"custom.efi": []byte("pulled")}}, filename: "ipxe.efi", want: binary.Files["ipxe.efi"]},
+		{name: "files before upstream", resolver: Resolver{Files: map[string][]byte{"custom.efi": []byte("pulled")}, Upstream: cache}, filename: "custom.efi", want: []byte("pulled")},
+		{name: "dynamic", resolver: Resolver{Dynamic: dynamic}, filename: "snp.efi", want: []byte("snp.efi for 192.168.2.1:68")},
+		{name: "dynamic declined", resolver: Resolver{Dynamic: dynamic}, filename: "ipxe.efi", want: binary.Files["ipxe.efi"]},
+		{name: "dynamic before files", resolver: Resolver{Dynamic: dynamic, Files: map[string][]byte{"snp.efi": []byte("pulled")}}, filename: "snp.efi", want: []byte("snp.efi for 192.168.2.1:68")},
+		{name: "dynamic error", resolver: Resolver{Dynamic: dynamic}, filename: "broken.efi", wantErr: errDynamic},
+		{name: "stateful before dynamic", resolver: Resolver{Stateful: first, Dynamic: dynamic}, filename: "snp.efi", want: []byte("first")},
+		{name: "stateful declined", resolver: Resolver{Stateful: dynamic, Files: map[string][]byte{"ipxe.efi": []byte("pulled")}}, filename: "ipxe.efi", want: []byte("pulled")},
+		{name: "stateful error", resolver: Resolver{Stateful: dynamic}, filename: "broken.efi", wantErr: errDynamic},
+		{name: "alias", resolver: Resolver{Aliases: map[string]string{"bootx64.efi": "ipxe.efi"}}, filename: "bootx64.efi", want: binary.Files["ipxe.efi"]},
+		{name: "alias with directory", resolver: Resolver{Aliases: map[string]string{"bootx64.efi": "ipxe.efi"}}, filename: "0a:00:27:00:00:02/bootx64.efi", want: binary.Files["ipxe.efi"]},
+		{name: "alias to files", resolver: Resolver{Aliases: map[string]string{"bootx64.efi": "custom.efi"}, Files: map[string][]byte{"custom.efi": []byte("pulled")}}, filename: "bootx64.efi", want: []byte("pulled")},
+		{name: "dynamic before alias", resolver: Resolver{Dynamic: dynamic, Aliases: map[string]string{"snp.efi": "ipxe.efi"}}, filename: "snp.efi", want: []byte("snp.efi for 192.168.2.1:68")},
+		{name: "alias to unknown", resolver: Resolver{Aliases: map[string]string{"bootx64.efi": "missing.efi"}}, filename: "bootx64.efi", wantErr: os.ErrNotExist},
+		{name: "upstream error", resolver: Resolver{Upstream: cache}, filename: "broken.efi", wantErr: upstream.ErrUpstream},
+		{name: "missing script", resolver: Resolver{MissingScript: []byte("#!ipxe\nreboot\n")}, filename: "boot.ipxe", want: []byte("#!ipxe\nreboot\n")},
+		{name: "missing script with directory", resolver: Resolver{MissingScript: []byte("#!ipxe\nreboot\n")}, filename: "0a:00:27:00:00:02/BOOT.IPXE", want: []byte("#!ipxe\nreboot\n")},
+		{name: "missing script not found upstream", resolver: Resolver{MissingScript: []byte("#!ipxe\nreboot\n"), Upstream: cache}, filename: "boot.ipxe", want: []byte("#!ipxe\nreboot\n")},
+		{name: "missing script disabled", filename: "boot.ipxe", wantErr: os.ErrNotExist},
+		{name: "missing script found", resolver: Resolver{MissingScript: []byte("#!ipxe\nreboot\n"), Files: map[string][]byte{"boot.ipxe": []byte("#!ipxe\nautoboot\n")}}, filename: "boot.ipxe", want: []byte("#!ipxe\nautoboot\n")},
+		{name: "missing script not a script", resolver: Resolver{MissingScript: []byte("#!ipxe\nreboot\n")}, filename: "custom.efi", wantErr: os.ErrNotExist},
+		{name: "default EFI", resolver: Resolver{DefaultEFI: "ipxe.efi"}, filename: "0a:00:27:00:00:02/grubx64.EFI", want: binary.Files["ipxe.efi"]},
+		{name: "default EFI known name", resolver: Resolver{DefaultEFI: "ipxe.efi"}, filename: "snp.efi", want: binary.Files["snp.efi"]},
+		{name: "default EFI from files", resolver: Resolver{DefaultEFI: "custom.efi", Files: map[string][]byte{"custom.efi": []byte("pulled")}}, filename: "shimx64.efi", want: []byte("pulled")},
+		{name: "default EFI not an EFI binary", resolver: Resolver{DefaultEFI: "ipxe.efi"}, filename: "boot.ipxe", wantErr: os.ErrNotExist},
+		{name: "default EFI not found", resolver: Resolver{DefaultEFI: "missing.efi"}, filename: "grubx64.efi", wantErr: os.ErrNotExist},
+		{name: "default EFI upstream error", resolver: Resolver{DefaultEFI: "ipxe.efi", Upstream: cache}, filename: "broken.efi", wantErr: upstream.ErrUpstream},
+		{name: "overlay before files", resolver: Resolver{Overlay: fstest.MapFS{"snp.efi": {Data: []byte("canary")}}, Files: map[string][]byte{"snp.efi": []byte("pulled")}}, filename: "snp.efi", want: []byte("canary")},
+		{name: "overlay in addition to files", resolver: Resolver{Overlay: fstest.MapFS{"snp.efi": {Data: []byte("canary")}}, Files: map[string][]byte{"custom.efi": []byte("pulled")}}, filename: "custom.efi", want: []byte("pulled")},
+		{name: "alias to overlay", resolver: Resolver{Overlay: fstest.MapFS{"ipxe.efi": {Data: []byte("canary")}}, Aliases: map[string]string{"bootx64.efi": "ipxe.efi"}}, filename: "bootx64.efi", want: []byte("canary")},
+		{name: "missing script dynamic error", resolver: Resolver{MissingScript: []byte("#!ipxe\nreboot\n"), Dynamic: func(context.Context, netaddr.IPPort, string) ([]byte, bool, error) { return nil, false, errDynamic }}, filename: "boot.ipxe", wantErr: errDynamic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.resolver.Resolve(context.Background(), netaddr.IPPortFrom(netaddr.IPv4(192, 168, 2, 1), 68), tt.filename)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error mismatch, got: %v, want: %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestResolveFS(t *testing.T) {
+	override := fstest.MapFS{
+		"snp.efi":   {Data: []byte("override")},
+		"boot":      {Mode: fs.ModeDir},
+		"boot/a.sh": {Data: []byte("nested")},
+	}
+	shared := fstest.MapFS{
+		"snp.efi":    {Data: []byte("shared")},
+		"custom.efi": {Data: []byte("shared custom")},
+	}
+	errFS := errors.New("volume unavailable")
+	tests := []struct {
+		name     string
+		fs       []fs.FS
+		filename string
+		want     []byte
+		wantErr  error
+	}{
+		{name: "first layer wins", fs: []fs.FS{override, shared}, filename: "snp.efi", want: []byte("override")},
+		{name: "second layer", fs: []fs.FS{override, shared}, filename: "custom.efi", want: []byte("shared custom")},
+		{name: "embedded last", fs: []fs.FS{override, shared}, filename: "ipxe.efi", want: binary.Files["ipxe.efi"]},
+		{name: "embedded included", fs: []fs.FS{binary.FS, shared}, filename: "snp.efi", want: binary.Files["snp.efi"]},
+		{name: "after embedded included", fs: []fs.FS{binary.FS, shared}, filename: "custom.efi", want: []byte("shared custom")},
+		{name: "only base name", fs: []fs.FS{override}, filename: "boot/a.sh", wantErr: os.ErrNotExist},
+		{name: "directory", fs: []fs.FS{override}, filename: "boot", wantErr: os.ErrNotExist},
+		{name: "not found", fs: []fs.FS{override, shared}, filename: "missing.efi", wantErr: os.ErrNotExist},
+		{name: "error", fs: []fs.FS{errorFS{errFS}, shared}, filename: "snp.efi", wantErr: errFS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Resolver{FS: tt.fs}
+			got, err := r.Resolve(context.Background(), netaddr.IPPortFrom(netaddr.IPv4(192, 168, 2, 1), 68), tt.filename)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error mismatch, got: %v, want: %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+			for _, readAhead := range []int{0, 4} {
+				r.ReadAhead = readAhead
+				f, size, err := r.Open(context.Background(), netaddr.IPPortFrom(netaddr.IPv4(192, 168, 2, 1), 68), tt.filename)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("read ahead %v: error mismatch, got: %v, want: %v", readAhead, err, tt.wantErr)
+				}
+				if err != nil {
+					continue
+				}
+				got, err := io.ReadAll(f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := f.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if size != int64(len(tt.want)) {
+					t.Fatalf("read ahead %v: expected a size of %v, got: %v", readAhead, len(tt.want), size)
+				}
+				if diff := cmp.Diff(got, tt.want); diff != "" {
+					t.Fatalf("read ahead %v: %v", readAhead, diff)
+				}
+			}
+		})
+	}
+}
+
+// errorFS is a file system that fails to open any file with err.
+type errorFS struct {
+	err error
+}
+
+func (f errorFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: f.err}
+}
+
+func TestOpenModTime(t *testing.T) {
+	built := time.Date(2023, time.November, 14, 22, 13, 20, 0, time.UTC)
+	r := Resolver{
+		Files:           map[string][]byte{"ipxe.efi": []byte("replaced")},
+		FS:              []fs.FS{fstest.MapFS{"custom.efi": {Data: []byte("custom"), ModTime: built}}},
+		EmbeddedModTime: built,
+	}
+	tests := map[string]bool{"snp.efi": true, "ipxe.efi": false, "custom.efi": false}
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			f, _, err := r.Open(context.Background(), netaddr.IPPortFrom(netaddr.IPv4(192, 168, 2, 1), 68), name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			mt, ok := f.(ModTimer)
+			if ok != want {
+				t.Fatalf("expected a modification time: %v, got: %v", want, ok)
+			}
+			if ok && !mt.ModTime().Equal(built) {
+				t.Fatalf("expected %v, got: %v", built, mt.ModTime())
+			}
+		})
+	}
+	r.EmbeddedModTime = time.Time{}
+	f, _, err := r.Open(context.Background(), netaddr.IPPortFrom(netaddr.IPv4(192, 168, 2, 1), 68), "snp.efi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, ok := f.(ModTimer); ok {
+		t.Fatal("expected no modification time without EmbeddedModTime")
+	}
+}
+
+func TestLocate(t *testing.T) {
+	stateful := 0
+	r := Resolver{
+		Stateful: func(_ context.Context, _ netaddr.IPPort, name string) ([]byte, bool, error) {
+			stateful++
+			return []byte("first boot"), true, nil
+		},
+		Dynamic: func(_ context.Context, _ netaddr.IPPort, name string) ([]byte, bool, error) {
+			return []byte("generated"), name == "generated.efi", nil
+		},
+		Files:         map[string][]byte{"ipxe.efi": []byte("replaced")},
+		FS:            []fs.FS{fstest.MapFS{}, fstest.MapFS{"custom.efi": {Data: []byte("custom")}, "dir": {Mode: fs.ModeDir}}},
+		Aliases:       map[string]string{"bootx64.efi": "custom.efi"},
+		MissingScript: []byte("#!ipxe\nexit\n"),
+		DefaultEFI:    "snp.efi",
+	}
+	tests := []struct {
+		filename string
+		want     Location
+		wantErr  error
+	}{
+		{filename: "generated.efi", want: Location{Name: "generated.efi", Size: 9, Source: SourceDynamic}},
+		{filename: "ipxe.efi", want: Location{Name: "ipxe.efi", Size: 8, Source: SourceFiles}},
+		{filename: "0a:00:27:00:00:02/custom.efi", want: Location{Name: "custom.efi", Size: 6, Source: SourceFS, FS: 1}},
+		{filename: "bootx64.efi", want: Location{Name: "custom.efi", Size: 6, Source: SourceFS, FS: 1}},
+		{filename: "undionly.kpxe", want: Location{Name: "undionly.kpxe", Size: int64(len(binary.Files["undionly.kpxe"])), Source: SourceEmbedded}},
+		{filename: "unknown.efi", want: Location{Name: "snp.efi", Size: int64(len(binary.Files["snp.efi"])), Source: SourceEmbedded}},
+		{filename: "auto.ipxe", want: Location{Name: "auto.ipxe", Size: 12, Source: SourceMissingScript}},
+		{filename: "dir", wantErr: os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			got, err := r.Locate(context.Background(), netaddr.IPPort{}, tt.filename)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got: %v", tt.wantErr, err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+	if stateful != 0 {
+		t.Fatalf("expected Stateful not to be consulted, got %v calls", stateful)
+	}
+}
+
+func TestResolveNotEmbedded(t *testing.T) {
+	snp := binary.Files["snp.efi"]
+	binary.Files["snp.efi"] = []byte{}
+	defer func() { binary.Files["snp.efi"] = snp }()
+	var r Resolver
+	if _, err := r.Resolve(context.Background(), netaddr.IPPort{}, "snp.efi"); !errors.Is(err, binary.ErrNotEmbedded) || !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected %v, got: %v", binary.ErrNotEmbedded, err)
+	}
+	if _, err := r.Resolve(context.Background(), netaddr.IPPort{}, "unknown.efi"); errors.Is(err, binary.ErrNotEmbedded) || !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected %v, got: %v", os.ErrNotExist, err)
+	}
+	// another source serves the binary in its place.
+	r.FS = []fs.FS{fstest.MapFS{"snp.efi": {Data: []byte("arm")}}}
+	if got, err := r.Resolve(context.Background(), netaddr.IPPort{}, "snp.efi"); err != nil || string(got) != "arm" {
+		t.Fatalf("expected the binary of the file system, got: %q, %v", got, err)
+	}
+}
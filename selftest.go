@@ -0,0 +1,94 @@
+package ipxedust
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pin/tftp"
+	"inet.af/netaddr"
+)
+
+// selfTestFile is the file downloaded by the TFTP self-test. It is the smallest embedded binary.
+const selfTestFile = "undionly.kpxe"
+
+// selfTestRetries is the number of attempts the TFTP self-test client makes to transmit a packet.
+const selfTestRetries = 2
+
+// errSelfTestSkipped is returned by Server.selfTestContent when the self-test can't run in the configuration.
+var errSelfTestSkipped = errors.New("TFTP self-test skipped")
+
+// newSelfTestName returns the name requested by the TFTP self-test: selfTestFile in a random directory, so that the
+// handler tells it apart from the requests of clients, see itftp.Handler.SelfTestName.
+func newSelfTestName() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return "selftest" + hex.EncodeToString(b) + "/" + selfTestFile
+}
+
+// selfTestIP returns the IP address the TFTP self-test reaches the server listening on addr at: the loopback address
+// for an unspecified listening IP (0.0.0.0 or ::).
+func selfTestIP(addr *net.UDPAddr) net.IP {
+	switch ip := addr.IP; {
+	case ip == nil || ip.To4() != nil && ip.IsUnspecified():
+		return net.IPv4(127, 0, 0, 1)
+	case ip.IsUnspecified():
+		return net.IPv6loopback
+	default:
+		return ip
+	}
+}
+
+// selfTestContent returns the content the TFTP self-test is expected to receive from the server at ip, the one
+// served to the self-test client. It fails with errSelfTestSkipped when the request would be rejected, because the
+// server is paused or the client is not allowed, or the file is not served.
+func (c *Server) selfTestContent(ip net.IP, name string) ([]byte, error) {
+	client, _ := netaddr.FromStdIP(ip)
+	switch {
+	case name == "":
+		return nil, fmt.Errorf("%w: no random file name", errSelfTestSkipped)
+	case c.Paused() || c.InMaintenance():
+		return nil, fmt.Errorf("%w: requests are rejected", errSelfTestSkipped)
+	case !c.allowed.Allow(client):
+		return nil, fmt.Errorf("%w: client %v not allowed", errSelfTestSkipped, client)
+	}
+	if err := c.Filenames.Check(name); err != nil {
+		return nil, fmt.Errorf("%w: %v", errSelfTestSkipped, err)
+	}
+	content, err := c.resolver().Resolve(context.Background(), netaddr.IPPortFrom(client, 0), selfTestFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %v not served", errSelfTestSkipped, selfTestFile)
+	}
+	return content, err
+}
+
+// runTFTPSelfTest downloads name from the TFTP server at addr and verifies it receives want.
+func runTFTPSelfTest(addr *net.UDPAddr, timeout time.Duration, name string, want []byte) error {
+	c, err := tftp.NewClient(net.JoinHostPort(addr.IP.String(), strconv.Itoa(addr.Port)))
+	if err != nil {
+		return err
+	}
+	c.SetTimeout(timeout)
+	c.SetRetries(selfTestRetries)
+	wt, err := c.Receive(name, "octet")
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if _, err := wt.WriteTo(&buf); err != nil {
+		return err
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		return fmt.Errorf("received %v bytes of %v that do not match the %v bytes served", buf.Len(), selfTestFile, len(want))
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package ipxedust
+
+import (
+	"context"
+	"io/fs"
+	"net"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/tinkerbell/ipxedust/ratelimit"
+	"inet.af/netaddr"
+)
+
+// dropConn is a net.PacketConn that drops every packet it receives.
+type dropConn struct {
+	net.PacketConn
+}
+
+func (d dropConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		if n, addr, err := d.PacketConn.ReadFrom(p); err != nil {
+			return n, addr, err
+		}
+	}
+}
+
+func TestSelfTestTFTP(t *testing.T) {
+	tests := []struct {
+		name     string
+		drop     bool
+		failFast bool
+		// configure sets up c before serving.
+		configure func(c *Server)
+		wantLog   string
+		wantErr   string
+	}{
+		{name: "pass", failFast: true, wantLog: "TFTP self-test passed"},
+		{name: "fail", drop: true, wantLog: "TFTP self-test failed"},
+		{name: "fail fast", drop: true, failFast: true, wantLog: "TFTP self-test failed", wantErr: "TFTP self-test failed"},
+		{name: "binary overridden", failFast: true, wantLog: "TFTP self-test passed", configure: func(c *Server) {
+			c.FileSystems = []fs.FS{fstest.MapFS{selfTestFile: {Data: []byte("custom")}}}
+		}},
+		{name: "rate limited", failFast: true, wantLog: "TFTP self-test passed", configure: func(c *Server) {
+			c.RateLimit = ratelimit.Config{RequestsPerSecond: 0.001, Burst: 1}
+		}},
+		{name: "paused", failFast: true, wantLog: "TFTP self-test skipped: requests are rejected", configure: func(c *Server) {
+			c.StartPaused = true
+		}},
+		{name: "client not allowed", failFast: true, wantLog: "TFTP self-test skipped: client 127.0.0.1 not allowed", configure: func(c *Server) {
+			c.SetAllowedClients([]netaddr.IP{netaddr.IPv4(192, 168, 2, 10)})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, lines := newTestLogger()
+			c := &Server{
+				Log:                log,
+				TFTP:               ServerSpec{Timeout: 100 * time.Millisecond},
+				HTTP:               ServerSpec{Disabled: true},
+				EnableTFTPSelfTest: true,
+				FailFastSelfTest:   tt.failFast,
+				OnFirstServe:       func(Protocol) { t.Error("expected the self-test not to be reported as served") },
+			}
+			if tt.configure != nil {
+				tt.configure(c)
+			}
+			uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var conn net.PacketConn = uconn
+			if tt.drop {
+				conn = dropConn{PacketConn: uconn}
+			}
+			tcp, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			err = c.Serve(ctx, tcp, conn)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("expected nil error, got: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+			if tt.wantErr != "" && ctx.Err() != nil {
+				t.Fatal("expected Serve to return before the context was canceled")
+			}
+			if !strings.Contains(strings.Join(lines(), "\n"), tt.wantLog) {
+				t.Fatalf("expected log to contain %q, got: %v", tt.wantLog, lines())
+			}
+			for name, n := range c.ServedCounts() {
+				if n != 0 {
+					t.Fatalf("expected the self-test not to be counted, got: %v served %v times", name, n)
+				}
+			}
+			// the self-test doesn't use up the rate limit of the clients.
+			if ok, _ := c.limiter.Allow(netaddr.IPv4(192, 168, 2, 10)); !ok {
+				t.Fatal("expected the self-test not to be rate limited")
+			}
+		})
+	}
+}
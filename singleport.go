@@ -0,0 +1,25 @@
+package ipxedust
+
+import "net"
+
+// singlePortMaxPort is the highest port single port mode is expected on. TFTP clients send requests to port 69,
+// single port mode on a high port is usually a mistake, like a container port mapping to the wrong port.
+const singlePortMaxPort = 1024
+
+// logSinglePort confirms that single port mode is active on addr, and warns when addr is not a port
+// TFTP clients send requests to by default.
+func (c *Server) logSinglePort(addr net.Addr) {
+	c.protocolLog(ProtocolTFTP).Info("TFTP single port mode active, requests and transfers share a single port", LogKeyAddr, addr.String())
+	if w := singlePortWarning(addr); w != "" {
+		c.protocolLog(ProtocolTFTP).Info("warning: "+w, LogKeyAddr, addr.String())
+	}
+}
+
+// singlePortWarning returns a warning about single port mode on addr, or an empty string when there is none.
+func singlePortWarning(addr net.Addr) string {
+	ua, ok := addr.(*net.UDPAddr)
+	if !ok || ua.Port <= singlePortMaxPort {
+		return ""
+	}
+	return "TFTP single port mode is enabled on a port above 1024, clients send requests to port 69 unless configured otherwise"
+}
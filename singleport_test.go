@@ -0,0 +1,79 @@
+package ipxedust
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+)
+
+func TestSinglePortWarning(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    net.Addr
+		warning bool
+	}{
+		{name: "port 69", addr: &net.UDPAddr{IP: net.IPv4zero, Port: 69}},
+		{name: "port 1024", addr: &net.UDPAddr{IP: net.IPv4zero, Port: 1024}},
+		{name: "high port", addr: &net.UDPAddr{IP: net.IPv4zero, Port: 6969}, warning: true},
+		{name: "not udp", addr: &net.TCPAddr{IP: net.IPv4zero, Port: 6969}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := singlePortWarning(tt.addr); (got != "") != tt.warning {
+				t.Fatalf("expected a warning: %v, got: %q", tt.warning, got)
+			}
+		})
+	}
+}
+
+func TestServeTFTPSinglePortLog(t *testing.T) {
+	tests := []struct {
+		name        string
+		singlePort  bool
+		wantActive  bool
+		wantWarning bool
+	}{
+		{name: "single port on a high port", singlePort: true, wantActive: true, wantWarning: true},
+		{name: "single port disabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var mu sync.Mutex
+			var logged []string
+			ready := make(chan struct{})
+			c := &Server{
+				Log: funcr.New(func(_, args string) {
+					mu.Lock()
+					logged = append(logged, args)
+					mu.Unlock()
+				}, funcr.Options{}),
+				EnableTFTPSinglePort: tt.singlePort,
+				OnReady:              func(Protocol, net.Addr) { close(ready) },
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			errChan := make(chan error, 1)
+			go func() { errChan <- c.serveTFTP(ctx, conn) }()
+			<-ready
+			cancel()
+			<-errChan
+
+			mu.Lock()
+			all := strings.Join(logged, "\n")
+			mu.Unlock()
+			if got := strings.Contains(all, "single port mode active") && strings.Contains(all, conn.LocalAddr().String()); got != tt.wantActive {
+				t.Fatalf("expected single port mode active to be logged with the address: %v, got: %v", tt.wantActive, all)
+			}
+			if got := strings.Contains(all, "warning: TFTP single port mode"); got != tt.wantWarning {
+				t.Fatalf("expected a warning: %v, got: %v", tt.wantWarning, all)
+			}
+		})
+	}
+}
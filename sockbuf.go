@@ -0,0 +1,24 @@
+package ipxedust
+
+import "net"
+
+// setReadBuffer sets the receive buffer of conn to c.TFTP.ReadBufferSize, when conn supports it,
+// like a *net.UDPConn does, and logs the size the OS actually applied, which may be clamped.
+func (c *Server) setReadBuffer(conn net.PacketConn) {
+	size := c.TFTP.ReadBufferSize
+	rb, ok := conn.(interface{ SetReadBuffer(bytes int) error })
+	if !ok {
+		c.protocolLog(ProtocolTFTP).Info("TFTP conn does not support setting the read buffer size, ignoring it", "requested", size)
+		return
+	}
+	if err := rb.SetReadBuffer(size); err != nil {
+		c.protocolLog(ProtocolTFTP).Error(err, "setting TFTP read buffer size failed", "requested", size)
+		return
+	}
+	actual, err := readBufferSize(conn)
+	if err != nil {
+		c.protocolLog(ProtocolTFTP).Info("set TFTP read buffer size", "requested", size)
+		return
+	}
+	c.protocolLog(ProtocolTFTP).Info("set TFTP read buffer size", "requested", size, "actual", actual)
+}
@@ -0,0 +1,29 @@
+package ipxedust
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// readBufferSize returns the receive buffer size of conn as reported by the kernel.
+// Linux reports twice the size that was set, the extra half is for its bookkeeping, and
+// clamps the size that was set to net.core.rmem_max.
+func readBufferSize(conn net.PacketConn) (int, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0, errors.New("conn does not expose its socket")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var size int
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		size, serr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+	}); err != nil {
+		return 0, err
+	}
+	return size, serr
+}
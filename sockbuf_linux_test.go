@@ -0,0 +1,92 @@
+package ipxedust
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+)
+
+// packetConn hides the methods of the net.PacketConn it embeds other than those of net.PacketConn.
+type packetConn struct {
+	net.PacketConn
+}
+
+func TestSetReadBuffer(t *testing.T) {
+	const size = 64 * 1024
+	tests := []struct {
+		name       string
+		wrap       func(net.PacketConn) net.PacketConn
+		wantLog    string
+		wantActual bool
+	}{
+		{name: "udp conn", wrap: func(c net.PacketConn) net.PacketConn { return c }, wantLog: "set TFTP read buffer size", wantActual: true},
+		{name: "unsupported conn", wrap: func(c net.PacketConn) net.PacketConn { return packetConn{c} }, wantLog: "does not support setting the read buffer size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			before, err := readBufferSize(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var logged []string
+			c := &Server{Log: funcr.New(func(_, args string) { logged = append(logged, args) }, funcr.Options{}), TFTP: ServerSpec{ReadBufferSize: size}}
+			c.setReadBuffer(tt.wrap(conn))
+			if len(logged) != 1 || !strings.Contains(logged[0], tt.wantLog) {
+				t.Fatalf("expected a log containing %q, got: %v", tt.wantLog, logged)
+			}
+			got, err := readBufferSize(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantActual {
+				if got != before {
+					t.Fatalf("expected the read buffer size to be unchanged, %v, got: %v", before, got)
+				}
+				return
+			}
+			// Linux reports twice the size that was set.
+			if got != 2*size {
+				t.Fatalf("expected a read buffer size of %v, got: %v", 2*size, got)
+			}
+			if !strings.Contains(logged[0], `"actual"=131072`) {
+				t.Fatalf("expected the actual size to be logged, got: %v", logged[0])
+			}
+		})
+	}
+}
+
+func TestServeTFTPReadBufferSize(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ready := make(chan struct{})
+	var once sync.Once
+	c := &Server{
+		Log:     funcr.New(func(_, _ string) {}, funcr.Options{}),
+		TFTP:    ServerSpec{ReadBufferSize: 32 * 1024},
+		OnReady: func(Protocol, net.Addr) { once.Do(func() { close(ready) }) },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.serveTFTP(ctx, conn) }()
+	<-ready
+	got, err := readBufferSize(conn)
+	cancel()
+	<-errChan
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 64*1024 {
+		t.Fatalf("expected a read buffer size of %v, got: %v", 64*1024, got)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package ipxedust
+
+import "net"
+
+// readBufferSize is only supported on Linux.
+func readBufferSize(net.PacketConn) (int, error) {
+	return 0, errNotLinux
+}
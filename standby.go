@@ -0,0 +1,28 @@
+package ipxedust
+
+import "sync/atomic"
+
+// Pause stops answering requests without closing the listeners, for a warm standby. New requests are rejected,
+// with a 503 over HTTP and an error over TFTP, until Resume is called. Transfers in flight complete.
+// The readiness endpoint, when ReadyPath is set, reports 503 while paused.
+// It is safe to call concurrently with serving.
+func (c *Server) Pause() {
+	atomic.StoreUint32(&c.paused, 1)
+	if c.Log.GetSink() != nil {
+		c.log().Info("serving paused")
+	}
+}
+
+// Resume starts answering requests again after Pause, or after starting with StartPaused.
+// Serving begins immediately, the listeners are already bound.
+func (c *Server) Resume() {
+	atomic.StoreUint32(&c.paused, 0)
+	if c.Log.GetSink() != nil {
+		c.log().Info("serving resumed")
+	}
+}
+
+// Paused reports whether the server is paused.
+func (c *Server) Paused() bool {
+	return atomic.LoadUint32(&c.paused) == 1
+}
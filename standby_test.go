@@ -0,0 +1,111 @@
+package ipxedust
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pin/tftp"
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+func TestStartPaused(t *testing.T) {
+	ready := make(chan struct{}, 2)
+	addrs := make(map[Protocol]net.Addr)
+	var mu sync.Mutex
+	c := &Server{
+		Log:         logr.Discard(),
+		StartPaused: true,
+		ReadyPath:   "/ready",
+		OnReady: func(p Protocol, addr net.Addr) {
+			mu.Lock()
+			addrs[p] = addr
+			mu.Unlock()
+			ready <- struct{}{}
+		},
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Serve(ctx, l, uconn) }()
+	defer func() {
+		cancel()
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}()
+	<-ready
+	<-ready
+	mu.Lock()
+	httpAddr, tftpAddr := addrs[ProtocolHTTP].String(), addrs[ProtocolTFTP].String()
+	mu.Unlock()
+
+	status := func(path string) int {
+		t.Helper()
+		resp, err := http.Get(fmt.Sprintf("http://%v%v", httpAddr, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode
+	}
+	tftpFails := func() bool {
+		t.Helper()
+		tc, err := tftp.NewClient(tftpAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tc.Receive("undionly.kpxe", "octet")
+		return err != nil
+	}
+
+	steps := []struct {
+		name       string
+		action     func()
+		wantPaused bool
+	}{
+		{name: "started paused", action: func() {}, wantPaused: true},
+		{name: "resumed", action: c.Resume},
+		{name: "paused", action: c.Pause, wantPaused: true},
+		{name: "resumed again", action: c.Resume},
+	}
+	for _, s := range steps {
+		t.Run(s.name, func(t *testing.T) {
+			s.action()
+			if c.Paused() != s.wantPaused {
+				t.Fatalf("expected paused: %v", s.wantPaused)
+			}
+			wantStatus := http.StatusOK
+			if s.wantPaused {
+				wantStatus = http.StatusServiceUnavailable
+			}
+			got := map[string]int{"ready": status("/ready"), "file": status("/snp.efi")}
+			if diff := cmp.Diff(got, map[string]int{"ready": wantStatus, "file": wantStatus}); diff != "" {
+				t.Fatal(diff)
+			}
+			if s.wantPaused {
+				if !tftpFails() {
+					t.Fatal("expected the TFTP request to be rejected")
+				}
+				return
+			}
+			if diff := cmp.Diff(tftpGet(t, tftpAddr, "undionly.kpxe"), binary.Files["undionly.kpxe"]); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package ipxedust
+
+import (
+	"sort"
+
+	"github.com/tinkerbell/ipxedust/resolve"
+)
+
+// tenantResolvers returns the resolvers of the Tenants, or nil when there are none.
+// Each resolves the files of its tenant like resolver does, without the Server wide files and file systems.
+func (c *Server) tenantResolvers() map[string]resolve.Resolver {
+	if c.Tenants == nil {
+		return nil
+	}
+	resolvers := make(map[string]resolve.Resolver, len(c.Tenants))
+	for tenant, fsys := range c.Tenants {
+		r := c.resolver()
+		r.Files = nil
+		r.FS = fsys
+		resolvers[tenant] = r
+	}
+	return resolvers
+}
+
+// tenantNames returns the keys of the Tenants in order.
+func (c *Server) tenantNames() []string {
+	names := make([]string, 0, len(c.Tenants))
+	for tenant := range c.Tenants {
+		names = append(names, tenant)
+	}
+	sort.Strings(names)
+	return names
+}
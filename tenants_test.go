@@ -0,0 +1,70 @@
+package ipxedust
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTenants(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Server{
+		Log:         logr.Discard(),
+		Aliases:     map[string]string{"bootx64.efi": "ipxe.efi"},
+		FileSystems: []fs.FS{fstest.MapFS{"ipxe.efi": {Data: []byte("default")}}},
+		Tenants: map[string][]fs.FS{
+			"tenant-a": {fstest.MapFS{"ipxe.efi": {Data: []byte("tenant-a")}}},
+			"tenant-b": {fstest.MapFS{"ipxe.efi": {Data: []byte("tenant-b")}}},
+		},
+	}
+	if err := c.start(context.Background(), Server{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.serveHTTP(ctx, l) }()
+	defer func() {
+		cancel()
+		<-errChan
+	}()
+
+	type response struct {
+		Status int
+		Body   string
+	}
+	want := map[string]response{
+		"/tenant-a/ipxe.efi":                   {Status: http.StatusOK, Body: "tenant-a"},
+		"/tenant-b/ipxe.efi":                   {Status: http.StatusOK, Body: "tenant-b"},
+		"/tenant-b/0a:00:27:00:00:02/ipxe.efi": {Status: http.StatusOK, Body: "tenant-b"},
+		"/tenant-a/bootx64.efi":                {Status: http.StatusOK, Body: "tenant-a"},
+		"/tenant-c/ipxe.efi":                   {Status: http.StatusNotFound, Body: "404 page not found\n"},
+		"/ipxe.efi":                            {Status: http.StatusNotFound, Body: "404 page not found\n"},
+	}
+	got := make(map[string]response)
+	for p := range want {
+		resp, err := http.Get(fmt.Sprintf("http://%v%v", l.Addr(), p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[p] = response{Status: resp.StatusCode, Body: string(b)}
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatal(diff)
+	}
+}
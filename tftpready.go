@@ -0,0 +1,118 @@
+package ipxedust
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pin/tftp"
+)
+
+// maxTFTPReadyWait bounds the wait for github.com/pin/tftp to be ready to shut down, see waitTFTPReady.
+// It is the duration of the fixed sleep the wait replaces.
+const maxTFTPReadyWait = time.Second
+
+// readyHook is the tftp.Hook of a github.com/pin/tftp server that detects when its serve loop runs.
+// The hooks are only called from the serve loop, or from transfers it started, so the first call means the server
+// populated its conn and can be shut down. The first failure is the probe sent by waitTFTPReady, it is not passed on.
+type readyHook struct {
+	tftp.Hook
+	once  sync.Once
+	ready chan struct{}
+}
+
+// newReadyHook returns a readyHook passing the transfer hooks on to h.
+func newReadyHook(h tftp.Hook) *readyHook {
+	return &readyHook{Hook: h, ready: make(chan struct{})}
+}
+
+// signal marks the server as ready. It reports whether it was the first call.
+func (r *readyHook) signal() bool {
+	first := false
+	r.once.Do(func() {
+		close(r.ready)
+		first = true
+	})
+	return first
+}
+
+// OnSuccess implements tftp.Hook.
+func (r *readyHook) OnSuccess(stats tftp.TransferStats) {
+	r.signal()
+	r.Hook.OnSuccess(stats)
+}
+
+// OnFailure implements tftp.Hook.
+func (r *readyHook) OnFailure(stats tftp.TransferStats, err error) {
+	if r.signal() {
+		return
+	}
+	r.Hook.OnFailure(stats, err)
+}
+
+// waitTFTPReady waits, at most maxTFTPReadyWait, for the github.com/pin/tftp server serving conn to be ready to shut
+// down, which it is not until its Serve call populated its conn. It sends an empty datagram to conn, which the serve
+// loop fails to parse and reports to r once it runs. The datagram is queued on conn when the loop isn't reading yet.
+// It returns how long the server took to be ready, and false when the wait timed out.
+func waitTFTPReady(conn net.PacketConn, r *readyHook, start time.Time) (time.Duration, bool) {
+	timeout := time.NewTimer(maxTFTPReadyWait - time.Since(start))
+	defer timeout.Stop()
+	if err := probeTFTP(conn.LocalAddr()); err != nil {
+		<-timeout.C
+		return time.Since(start), false
+	}
+	select {
+	case <-r.ready:
+		return time.Since(start), true
+	case <-timeout.C:
+		return time.Since(start), false
+	}
+}
+
+// probeTFTP sends an empty datagram to the TFTP server listening on addr, over the loopback interface when it
+// listens on all addresses.
+func probeTFTP(addr net.Addr) error {
+	a, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return &net.AddrError{Err: "not a UDP address", Addr: addr.String()}
+	}
+	targets := []net.IP{a.IP}
+	if a.IP.IsUnspecified() {
+		targets = []net.IP{net.IPv4(127, 0, 0, 1)}
+		if a.IP.To4() == nil {
+			targets = []net.IP{net.IPv6loopback, net.IPv4(127, 0, 0, 1)}
+		}
+	}
+	var err error
+	for _, ip := range targets {
+		var c *net.UDPConn
+		c, err = net.DialUDP("udp", nil, &net.UDPAddr{IP: ip, Port: a.Port, Zone: a.Zone})
+		if err != nil {
+			continue
+		}
+		_, err = c.Write(nil)
+		c.Close()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// awaitTFTPReady waits for the github.com/pin/tftp server serving conn since start to be ready to shut down, see
+// waitTFTPReady, and records how long it took. Without r, it sleeps for maxTFTPReadyWait.
+func (c *Server) awaitTFTPReady(conn net.PacketConn, r *readyHook, start time.Time) {
+	if r == nil {
+		time.Sleep(maxTFTPReadyWait)
+		return
+	}
+	d, ok := waitTFTPReady(conn, r, start)
+	if !ok {
+		c.protocolLog(ProtocolTFTP).Info("warning: timed out waiting for the TFTP server to be ready to shut down", "waited", d)
+	} else {
+		c.protocolLog(ProtocolTFTP).V(1).Info("TFTP server ready to shut down", "duration", d)
+	}
+	if c.OnTFTPStartup != nil {
+		c.OnTFTPStartup(d)
+	}
+}
@@ -0,0 +1,60 @@
+package ipxedust
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestTFTPStartup(t *testing.T) {
+	tests := []struct {
+		name       string
+		singlePort bool
+		canceled   bool
+	}{
+		{name: "serving"},
+		{name: "single port", singlePort: true},
+		{name: "canceled context", canceled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			startup := make(chan time.Duration, 1)
+			c := &Server{
+				Log:                  logr.Discard(),
+				EnableTFTPSinglePort: tt.singlePort,
+				OnTFTPStartup:        func(d time.Duration) { startup <- d },
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.canceled {
+				cancel()
+			}
+			defer cancel()
+			start := time.Now()
+			errChan := make(chan error, 1)
+			go func() { errChan <- c.serveTFTP(ctx, conn) }()
+			var d time.Duration
+			select {
+			case d = <-startup:
+			case <-time.After(5 * time.Second):
+				t.Fatal("expected the TFTP startup duration to be recorded")
+			}
+			if d <= 0 || d >= maxTFTPReadyWait/4 {
+				t.Fatalf("expected the TFTP server to be ready well under %v, took: %v", maxTFTPReadyWait, d)
+			}
+			cancel()
+			if err := <-errChan; err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(start); elapsed >= maxTFTPReadyWait {
+				t.Fatalf("expected serving to stop well under %v, took: %v", maxTFTPReadyWait, elapsed)
+			}
+		})
+	}
+}
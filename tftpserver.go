@@ -0,0 +1,146 @@
+package ipxedust
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pin/tftp"
+	"github.com/tinkerbell/ipxedust/itftp"
+)
+
+// TFTPServer is a TFTP server implementation, see Server.NewTFTPServer.
+// *tftp.Server of github.com/pin/tftp, the default, implements it.
+type TFTPServer interface {
+	// Serve serves the requests received on conn until Shutdown is called.
+	Serve(conn net.PacketConn) error
+	// SetTimeout sets the timeout of the transfers, for each packet sent.
+	SetTimeout(t time.Duration)
+	// EnableSinglePort makes transfers use conn, instead of a new port for each transfer.
+	EnableSinglePort()
+	// Shutdown stops serving and waits for the transfers in flight to complete.
+	// It is called once, after Serve was called, possibly before Serve received a request.
+	Shutdown()
+}
+
+// newTFTPServer creates the TFTP server serving requests with read and write, with NewTFTPServer when it is set.
+func (c *Server) newTFTPServer(read func(filename string, rf io.ReaderFrom) error, write func(filename string, wt io.WriterTo) error) TFTPServer {
+	if c.NewTFTPServer != nil {
+		return c.NewTFTPServer(read, write)
+	}
+	return tftp.NewServer(read, write)
+}
+
+// ErrPortRangeUnsupported is returned when ServerSpec.DataPorts is set for a TFTP server that doesn't implement
+// TFTPPortRangeSetter, like github.com/pin/tftp, the default, outside of single port mode.
+var ErrPortRangeUnsupported = errors.New("TFTP server can't bind transfers to a port range")
+
+// TFTPPortRangeSetter is implemented by TFTPServer implementations that can bind the data connection of each transfer
+// to a port in a range, see ServerSpec.DataPorts.
+type TFTPPortRangeSetter interface {
+	// SetPortRange binds the data connections to ports from first to last, inclusive.
+	SetPortRange(first, last uint16)
+}
+
+// PortRange is an inclusive range of UDP ports, see ServerSpec.DataPorts. The zero value is no range.
+type PortRange struct {
+	First uint16
+	Last  uint16
+}
+
+// IsZero reports whether r is the zero value, no range.
+func (r PortRange) IsZero() bool {
+	return r == PortRange{}
+}
+
+// String returns r as "first-last", or an empty string when r is the zero value.
+func (r PortRange) String() string {
+	if r.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", r.First, r.Last)
+}
+
+// validate returns an error when r is not the zero value and is not a valid range.
+func (r PortRange) validate() error {
+	if r.IsZero() {
+		return nil
+	}
+	if r.First == 0 || r.First > r.Last {
+		return fmt.Errorf("invalid port range %v: the first port must be between 1 and the last port", r)
+	}
+	return nil
+}
+
+// TFTPOptions flags the TFTP options acknowledged by the server, see ServerSpec.DisabledOptions.
+// github.com/pin/tftp only acknowledges blksize and tsize, and Server.TFTPMulticast multicast.
+type TFTPOptions struct {
+	// Blksize is the block size option, RFC 2348.
+	Blksize bool
+	// Tsize is the transfer size option, RFC 2349.
+	Tsize bool
+	// Multicast is the multicast option, RFC 2090.
+	Multicast bool
+}
+
+// names returns the names of the options flagged in o.
+func (o TFTPOptions) names() []string {
+	var names []string
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{{"blksize", o.Blksize}, {"tsize", o.Tsize}, {"multicast", o.Multicast}} {
+		if f.set {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// String returns the names of the options flagged in o, separated by commas.
+func (o TFTPOptions) String() string {
+	return strings.Join(o.names(), ",")
+}
+
+// setDataPorts binds the data connections of the transfers of ts to the DataPorts of the TFTP ServerSpec, when it is set.
+// In single port mode, transfers use the listening port and the range is ignored.
+func (c *Server) setDataPorts(ts TFTPServer) error {
+	r := c.TFTP.DataPorts
+	if r.IsZero() || c.EnableTFTPSinglePort {
+		return nil
+	}
+	s, ok := ts.(TFTPPortRangeSetter)
+	if !ok {
+		return ErrPortRangeUnsupported
+	}
+	s.SetPortRange(r.First, r.Last)
+	return nil
+}
+
+// validateMulticast returns an error when TFTPMulticast is set but is not a multicast address, the ports of its
+// sessions don't fit, or TFTPMulticastAddr is not set.
+func (c *Server) validateMulticast() error {
+	if c.TFTPMulticast.IsZero() {
+		return nil
+	}
+	if !c.TFTPMulticast.IP().IsMulticast() {
+		return errors.New("not a multicast address")
+	}
+	if !c.TFTPMulticastAddr.IsValid() {
+		return errors.New("TFTPMulticastAddr must be set to receive the multicast requests")
+	}
+	if c.TFTPMulticastSessions < 0 {
+		return fmt.Errorf("%v sessions: must not be negative", c.TFTPMulticastSessions)
+	}
+	sessions := c.TFTPMulticastSessions
+	if sessions == 0 {
+		sessions = itftp.DefaultMulticastSessions
+	}
+	if c.TFTPMulticast.Port() == 0 || int(c.TFTPMulticast.Port())+sessions-1 > 65535 {
+		return fmt.Errorf("the ports of %v sessions must be from 1 to 65535", sessions)
+	}
+	return nil
+}
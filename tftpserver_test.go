@@ -0,0 +1,471 @@
+package ipxedust
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/clock"
+	"github.com/tinkerbell/ipxedust/itftp"
+	"inet.af/netaddr"
+)
+
+// fakeTFTPServer is a TFTPServer that serves requests made by the test with its read handler.
+type fakeTFTPServer struct {
+	read       func(filename string, rf io.ReaderFrom) error
+	timeout    time.Duration
+	singlePort bool
+	served     chan net.PacketConn
+	shutdown   chan struct{}
+}
+
+func (f *fakeTFTPServer) Serve(conn net.PacketConn) error {
+	f.served <- conn
+	<-f.shutdown
+	return nil
+}
+
+func (f *fakeTFTPServer) SetTimeout(t time.Duration) { f.timeout = t }
+func (f *fakeTFTPServer) EnableSinglePort()          { f.singlePort = true }
+func (f *fakeTFTPServer) Shutdown()                  { close(f.shutdown) }
+
+// fakeTransfer is the tftp.OutgoingTransfer of a request to fakeTFTPServer.
+type fakeTransfer struct {
+	addr net.UDPAddr
+	size int64
+	got  []byte
+}
+
+func (f *fakeTransfer) ReadFrom(r io.Reader) (int64, error) {
+	b, err := io.ReadAll(r)
+	f.got = b
+	return int64(len(b)), err
+}
+
+func (f *fakeTransfer) SetSize(n int64)         { f.size = n }
+func (f *fakeTransfer) RemoteAddr() net.UDPAddr { return f.addr }
+
+func TestNewTFTPServer(t *testing.T) {
+	fake := &fakeTFTPServer{served: make(chan net.PacketConn, 1), shutdown: make(chan struct{})}
+	c := &Server{
+		Log:                  logr.Discard(),
+		TFTP:                 ServerSpec{Timeout: 3 * time.Second},
+		HTTP:                 ServerSpec{Disabled: true},
+		EnableTFTPSinglePort: true,
+		NewTFTPServer: func(read func(string, io.ReaderFrom) error, _ func(string, io.WriterTo) error) TFTPServer {
+			fake.read = read
+			return fake
+		},
+	}
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Serve(ctx, nil, uconn) }()
+	if conn := <-fake.served; conn != uconn {
+		t.Fatal("expected the TFTP server to serve the conn passed to Serve")
+	}
+	if fake.timeout != 3*time.Second || !fake.singlePort {
+		t.Fatalf("expected the timeout and single port mode to be applied, got: %v, %v", fake.timeout, fake.singlePort)
+	}
+	rf := &fakeTransfer{addr: net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}}
+	if err := fake.read("snp.efi", rf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rf.got, binary.SNP) || rf.size != int64(len(binary.SNP)) {
+		t.Fatal("expected the read handler to serve snp.efi")
+	}
+
+	start := time.Now()
+	cancel()
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-fake.shutdown:
+	default:
+		t.Fatal("expected the TFTP server to be shut down")
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Fatalf("expected shutting down not to wait for github.com/pin/tftp, took: %v", elapsed)
+	}
+}
+
+// slowTransfer is a fakeTransfer reading one block at a time, advancing clock between blocks like a client that
+// acknowledges each block just within the per packet timeout.
+type slowTransfer struct {
+	fakeTransfer
+	clock *clock.Fake
+	pause time.Duration
+}
+
+func (s *slowTransfer) ReadFrom(r io.Reader) (int64, error) {
+	var n int64
+	buf := make([]byte, 512)
+	for {
+		c, err := r.Read(buf)
+		n += int64(c)
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		s.clock.Advance(s.pause)
+	}
+}
+
+func TestTFTPTimeouts(t *testing.T) {
+	tests := []struct {
+		name        string
+		timeout     time.Duration
+		max         time.Duration
+		wantTimeout time.Duration
+		wantErr     error
+	}{
+		// a short per packet timeout doesn't limit a long transfer that keeps making progress.
+		{name: "short timeout, no deadline", timeout: 200 * time.Millisecond, wantTimeout: 200 * time.Millisecond},
+		{name: "short timeout, long deadline", timeout: 200 * time.Millisecond, max: time.Hour, wantTimeout: 200 * time.Millisecond},
+		// a long per packet timeout doesn't extend the deadline of the whole transfer.
+		{name: "long timeout, short deadline", timeout: 30 * time.Second, max: 5 * time.Second, wantTimeout: 30 * time.Second, wantErr: itftp.ErrTransferDeadline},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeTFTPServer{served: make(chan net.PacketConn, 1), shutdown: make(chan struct{})}
+			fc := clock.NewFake(time.Unix(0, 0))
+			c := &Server{
+				Log:   logr.Discard(),
+				Clock: fc,
+				TFTP:  ServerSpec{Timeout: tt.timeout, MaxTransferDuration: tt.max},
+				HTTP:  ServerSpec{Disabled: true},
+				NewTFTPServer: func(read func(string, io.ReaderFrom) error, _ func(string, io.WriterTo) error) TFTPServer {
+					fake.read = read
+					return fake
+				},
+			}
+			uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			errChan := make(chan error, 1)
+			go func() { errChan <- c.Serve(ctx, nil, uconn) }()
+			<-fake.served
+			if fake.timeout != tt.wantTimeout {
+				t.Errorf("expected the per packet timeout %v, got: %v", tt.wantTimeout, fake.timeout)
+			}
+			// snp.efi is sent in hundreds of blocks, a second apart.
+			rf := &slowTransfer{fakeTransfer: fakeTransfer{addr: net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}}, clock: fc, pause: time.Second}
+			if err := fake.read("snp.efi", rf); !errors.Is(err, tt.wantErr) {
+				t.Errorf("error mismatch, got: %v, want: %v", err, tt.wantErr)
+			}
+			cancel()
+			if err := <-errChan; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestTFTPTimeoutsNegative(t *testing.T) {
+	for _, spec := range []ServerSpec{{Timeout: -time.Second}, {Timeout: time.Second, MaxTransferDuration: -time.Second}} {
+		c := &Server{Log: logr.Discard(), TFTP: spec, HTTP: ServerSpec{Disabled: true}}
+		uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.Serve(context.Background(), nil, uconn)
+		uconn.Close()
+		if err == nil || !strings.Contains(err.Error(), "must not be negative") {
+			t.Fatalf("expected %+v to be rejected, got: %v", spec, err)
+		}
+	}
+}
+
+// fakeRangeTFTPServer is a fakeTFTPServer that implements TFTPPortRangeSetter.
+type fakeRangeTFTPServer struct {
+	*fakeTFTPServer
+	first, last uint16
+}
+
+func (f *fakeRangeTFTPServer) SetPortRange(first, last uint16) { f.first, f.last = first, last }
+
+func TestDataPorts(t *testing.T) {
+	tests := []struct {
+		name       string
+		ports      PortRange
+		singlePort bool
+		rangeFake  bool
+		defaultSrv bool
+		wantErr    error
+		wantRange  PortRange
+	}{
+		{name: "set", ports: PortRange{First: 30000, Last: 30099}, rangeFake: true, wantRange: PortRange{First: 30000, Last: 30099}},
+		{name: "single port", ports: PortRange{First: 30000, Last: 30099}, singlePort: true, rangeFake: true},
+		{name: "unsupported", ports: PortRange{First: 30000, Last: 30099}, wantErr: ErrPortRangeUnsupported},
+		{name: "unsupported by default", ports: PortRange{First: 30000, Last: 30099}, defaultSrv: true, wantErr: ErrPortRangeUnsupported},
+		{name: "default in single port mode", ports: PortRange{First: 30000, Last: 30099}, defaultSrv: true, singlePort: true},
+		{name: "unset", rangeFake: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeTFTPServer{served: make(chan net.PacketConn, 1), shutdown: make(chan struct{})}
+			rangeFake := &fakeRangeTFTPServer{fakeTFTPServer: fake}
+			c := &Server{
+				Log:                  logr.Discard(),
+				TFTP:                 ServerSpec{DataPorts: tt.ports},
+				HTTP:                 ServerSpec{Disabled: true},
+				EnableTFTPSinglePort: tt.singlePort,
+				NewTFTPServer: func(read func(string, io.ReaderFrom) error, _ func(string, io.WriterTo) error) TFTPServer {
+					if tt.rangeFake {
+						return rangeFake
+					}
+					return fake
+				},
+			}
+			if tt.defaultSrv {
+				c.NewTFTPServer = nil
+			}
+			uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			errChan := make(chan error, 1)
+			go func() { errChan <- c.Serve(ctx, nil, uconn) }()
+			if tt.wantErr != nil {
+				if err := <-errChan; !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got: %v", tt.wantErr, err)
+				}
+				return
+			}
+			if !tt.defaultSrv {
+				<-fake.served
+			}
+			cancel()
+			if err := <-errChan; err != nil {
+				t.Fatal(err)
+			}
+			if got := (PortRange{First: rangeFake.first, Last: rangeFake.last}); got != tt.wantRange {
+				t.Fatalf("expected the data port range %q to be set, got: %q", tt.wantRange, got)
+			}
+		})
+	}
+}
+
+func TestPortRangeValidate(t *testing.T) {
+	tests := map[PortRange]bool{
+		{}:                          true,
+		{First: 30000, Last: 30099}: true,
+		{First: 30000, Last: 30000}: true,
+		{First: 30099, Last: 30000}: false,
+		{First: 0, Last: 30000}:     false,
+	}
+	for r, valid := range tests {
+		if err := r.validate(); (err == nil) != valid {
+			t.Errorf("%q: expected valid: %v, got: %v", r, valid, err)
+		}
+	}
+}
+
+func TestTFTPMulticast(t *testing.T) {
+	ready := make(chan struct{}, 1)
+	maddr := netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), uint16(getPort()))
+	c := &Server{
+		Log:               logr.Discard(),
+		HTTP:              ServerSpec{Disabled: true},
+		TFTP:              ServerSpec{Timeout: 100 * time.Millisecond},
+		TFTPMulticast:     netaddr.IPPortFrom(netaddr.IPv4(239, 255, 0, 1), 1758),
+		TFTPMulticastAddr: maddr,
+		OnReady:           func(Protocol, net.Addr) { ready <- struct{}{} },
+	}
+	uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Serve(ctx, nil, uconn) }()
+	defer func() {
+		cancel()
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}()
+	<-ready
+
+	// requests without the multicast option are served over unicast.
+	if got := tftpGet(t, uconn.LocalAddr().String(), "snp.efi"); !bytes.Equal(got, binary.Files["snp.efi"]) {
+		t.Fatal("unexpected content served over unicast")
+	}
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+
+	// the requests of TFTP.Addr are read from the *net.UDPConn, and granted blocks over 512 bytes, even with the
+	// multicast option.
+	if _, err := client.WriteTo([]byte("\x00\x01snp.efi\x00octet\x00blksize\x001024\x00multicast\x00\x00"), uconn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	n, transfer, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x00\x06blksize\x001024\x00"; string(buf[:n]) != want {
+		t.Fatalf("expected a unicast transfer of blocks of 1024 bytes, got: %q", buf[:n])
+	}
+	if _, err := client.WriteTo([]byte("\x00\x05\x00\x00aborted\x00"), transfer); err != nil {
+		t.Fatal(err)
+	}
+
+	// a client of its own, the aborted transfer may still answer the first one.
+	mclient, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mclient.Close()
+	if err := mclient.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mclient.WriteTo([]byte("\x00\x01snp.efi\x00octet\x00multicast\x00\x00"), maddr.UDPAddr()); err != nil {
+		t.Fatal(err)
+	}
+	n, session, err := mclient.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x00\x06multicast\x00239.255.0.1,1758,1\x00"; string(buf[:n]) != want {
+		t.Fatalf("expected the client to be the master client of the multicast group, got: %q", buf[:n])
+	}
+	if _, err := mclient.WriteTo([]byte("\x00\x05\x00\x00aborted\x00"), session); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateMulticast(t *testing.T) {
+	tests := []struct {
+		name     string
+		group    netaddr.IPPort
+		sessions int
+		// noAddr leaves TFTPMulticastAddr unset.
+		noAddr  bool
+		wantErr bool
+	}{
+		{name: "disabled"},
+		{name: "group", group: netaddr.IPPortFrom(netaddr.IPv4(239, 255, 0, 1), 1758)},
+		{name: "last ports", group: netaddr.IPPortFrom(netaddr.IPv4(239, 255, 0, 1), 65534), sessions: 2},
+		{name: "unicast address", group: netaddr.IPPortFrom(netaddr.IPv4(192, 168, 2, 1), 1758), wantErr: true},
+		{name: "no port", group: netaddr.IPPortFrom(netaddr.IPv4(239, 255, 0, 1), 0), wantErr: true},
+		{name: "ports overflow", group: netaddr.IPPortFrom(netaddr.IPv4(239, 255, 0, 1), 65535), wantErr: true},
+		{name: "negative sessions", group: netaddr.IPPortFrom(netaddr.IPv4(239, 255, 0, 1), 1758), sessions: -1, wantErr: true},
+		{name: "no multicast address", group: netaddr.IPPortFrom(netaddr.IPv4(239, 255, 0, 1), 1758), noAddr: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Server{TFTPMulticast: tt.group, TFTPMulticastSessions: tt.sessions}
+			if !tt.noAddr {
+				c.TFTPMulticastAddr = netaddr.IPPortFrom(netaddr.IPv4(192, 168, 2, 1), 1759)
+			}
+			if err := c.validateMulticast(); (err != nil) != tt.wantErr {
+				t.Fatalf("expected an error: %v, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestTFTPDisabledOptions(t *testing.T) {
+	size := strconv.Itoa(len(binary.Files["snp.efi"]))
+	tests := []struct {
+		name     string
+		disabled TFTPOptions
+		// multicast serves TFTPMulticast and requests the multicast option, without blksize, from TFTPMulticastAddr:
+		// its requests are read through a wrapper, and github.com/pin/tftp grants blocks of at most 512 bytes.
+		multicast bool
+		want      map[string]string
+	}{
+		{name: "none", want: map[string]string{"blksize": "1024", "tsize": size}},
+		{name: "tsize", disabled: TFTPOptions{Tsize: true}, want: map[string]string{"blksize": "1024"}},
+		{name: "blksize", disabled: TFTPOptions{Blksize: true}, want: map[string]string{"tsize": size}},
+		{name: "multicast", disabled: TFTPOptions{Multicast: true}, multicast: true, want: map[string]string{"tsize": size}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready := make(chan struct{}, 1)
+			c := &Server{
+				Log:     logr.Discard(),
+				HTTP:    ServerSpec{Disabled: true},
+				TFTP:    ServerSpec{Timeout: 100 * time.Millisecond, DisabledOptions: tt.disabled},
+				OnReady: func(Protocol, net.Addr) { ready <- struct{}{} },
+			}
+			rq := "\x00\x01snp.efi\x00octet\x00blksize\x001024\x00tsize\x000\x00"
+			uconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			to := uconn.LocalAddr()
+			if tt.multicast {
+				c.TFTPMulticast = netaddr.IPPortFrom(netaddr.IPv4(239, 255, 0, 1), 1758)
+				c.TFTPMulticastAddr = netaddr.IPPortFrom(netaddr.IPv4(127, 0, 0, 1), uint16(getPort()))
+				to = c.TFTPMulticastAddr.UDPAddr()
+				rq = "\x00\x01snp.efi\x00octet\x00tsize\x000\x00multicast\x00\x00"
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			errChan := make(chan error, 1)
+			go func() { errChan <- c.Serve(ctx, nil, uconn) }()
+			defer func() {
+				cancel()
+				if err := <-errChan; err != nil {
+					t.Fatal(err)
+				}
+			}()
+			client, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			// github.com/pin/tftp grants blocks of at most 512 bytes to requests read before its serve loop runs.
+			<-ready
+			if _, err := client.WriteTo([]byte(rq), to); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 2048)
+			n, transfer, err := client.ReadFrom(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _, _ = client.WriteTo([]byte("\x00\x05\x00\x00aborted\x00"), transfer) }()
+			if buf[1] != 6 {
+				t.Fatalf("expected an OACK, got: %q", buf[:n])
+			}
+			fields := strings.Split(string(buf[2:n-1]), "\x00")
+			got := make(map[string]string)
+			for i := 0; i+1 < len(fields); i += 2 {
+				got[fields[i]] = fields[i+1]
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+// Package timing breaks the time spent handling a request down into phases, to tell contention, a slow source of
+// content and a slow network apart.
+package timing
+
+import (
+	"time"
+
+	"github.com/tinkerbell/ipxedust/clock"
+)
+
+// Log keys of the phases, see Phases.KeysAndValues.
+const (
+	LogKeyQueue    = "queueDuration"
+	LogKeyResolve  = "resolveDuration"
+	LogKeyTransfer = "transferDuration"
+	LogKeyTotal    = "totalDuration"
+)
+
+// Phases are the durations of the phases of a request, in the order they happen.
+type Phases struct {
+	// Queue is the time the request took to be admitted: the maintenance, allowlist, rate limit and concurrent
+	// transfer checks.
+	Queue time.Duration
+	// Resolve is the time it took to find the content, including generating it or fetching it upstream.
+	Resolve time.Duration
+	// Transfer is the time it took to send the content, including the time it was throttled.
+	Transfer time.Duration
+	// Total is the time from the start of the request to when the phases were read, at least their sum.
+	Total time.Duration
+}
+
+// KeysAndValues returns the phases as logr key and value pairs, with the LogKey constants as keys. A phase that
+// was not reached, like the transfer of a request that failed to resolve, is zero.
+func (p Phases) KeysAndValues() []interface{} {
+	return []interface{}{LogKeyQueue, p.Queue, LogKeyResolve, p.Resolve, LogKeyTransfer, p.Transfer, LogKeyTotal, p.Total}
+}
+
+// Stopwatch measures the Phases of a request. Each phase is measured from the end of the previous one.
+type Stopwatch struct {
+	clock  clock.Clock
+	start  time.Time
+	last   time.Time
+	phases Phases
+}
+
+// Start returns a Stopwatch measuring a request starting now, on c, or the real clock when c is nil.
+func Start(c clock.Clock) *Stopwatch {
+	c = clock.Or(c)
+	now := c.Now()
+	return &Stopwatch{clock: c, start: now, last: now}
+}
+
+// Queued ends the queue phase, when the request is admitted.
+func (s *Stopwatch) Queued() {
+	s.phases.Queue = s.lap()
+}
+
+// Resolved ends the resolve phase, when the content is found, or failed to be.
+func (s *Stopwatch) Resolved() {
+	s.phases.Resolve = s.lap()
+}
+
+// Transferred ends the transfer phase, when the content is sent, or failed to be.
+func (s *Stopwatch) Transferred() {
+	s.phases.Transfer = s.lap()
+}
+
+// lap returns the time since the end of the previous phase, and ends the current one.
+func (s *Stopwatch) lap() time.Duration {
+	now := s.clock.Now()
+	d := now.Sub(s.last)
+	s.last = now
+	return d
+}
+
+// Phases returns the phases measured so far, with Total measured now.
+func (s *Stopwatch) Phases() Phases {
+	p := s.phases
+	p.Total = s.clock.Now().Sub(s.start)
+	return p
+}
@@ -0,0 +1,37 @@
+package timing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/clock"
+)
+
+func TestStopwatch(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	s := Start(c)
+	c.Advance(time.Millisecond)
+	s.Queued()
+	c.Advance(20 * time.Millisecond)
+	s.Resolved()
+	c.Advance(300 * time.Millisecond)
+	s.Transferred()
+	c.Advance(4 * time.Millisecond)
+	want := Phases{Queue: time.Millisecond, Resolve: 20 * time.Millisecond, Transfer: 300 * time.Millisecond, Total: 325 * time.Millisecond}
+	if diff := cmp.Diff(want, s.Phases()); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestStopwatchNotResolved(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	s := Start(c)
+	c.Advance(time.Millisecond)
+	s.Queued()
+	c.Advance(20 * time.Millisecond)
+	want := []interface{}{LogKeyQueue, time.Millisecond, LogKeyResolve, time.Duration(0), LogKeyTransfer, time.Duration(0), LogKeyTotal, 21 * time.Millisecond}
+	if diff := cmp.Diff(want, s.Phases().KeysAndValues()); diff != "" {
+		t.Fatal(diff)
+	}
+}
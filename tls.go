@@ -0,0 +1,39 @@
+package ipxedust
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"log"
+
+	"github.com/go-logr/logr"
+)
+
+// tlsConfig returns the TLS configuration of the HTTP server, or nil when HTTPS is not enabled.
+func (c *Server) tlsConfig() *tls.Config {
+	if len(c.TLSCertificates) == 0 {
+		return nil
+	}
+	return &tls.Config{
+		Certificates: c.TLSCertificates,
+		ClientCAs:    c.ClientCAs,
+		ClientAuth:   c.ClientAuth,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// errorLogWriter is an io.Writer that logs each write as an error.
+// It is used to send net/http server errors, like TLS handshake failures, to a logr.Logger.
+type errorLogWriter struct {
+	log logr.Logger
+}
+
+func (e errorLogWriter) Write(p []byte) (int, error) {
+	e.log.Error(errors.New(string(bytes.TrimSpace(p))), "HTTP server error")
+	return len(p), nil
+}
+
+// newErrorLog returns a *log.Logger, suitable for http.Server.ErrorLog, that writes to l.
+func newErrorLog(l logr.Logger) *log.Logger {
+	return log.New(errorLogWriter{log: l}, "", 0)
+}
@@ -0,0 +1,197 @@
+package ipxedust
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/resolve"
+	"inet.af/netaddr"
+)
+
+func TestServeHTTPMutualTLS(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	untrustedCA := newTestCert(t, "untrusted ca", nil)
+	serverCert := newTestCert(t, "server", ca)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	tests := []struct {
+		name       string
+		clientCert *tls.Certificate
+		wantErr    bool
+		wantLog    string
+	}{
+		{name: "valid client certificate", clientCert: newTestCert(t, "machine-1", ca), wantLog: `"clientCert"="CN=machine-1"`},
+		{name: "missing client certificate", wantErr: true, wantLog: "TLS handshake error"},
+		{name: "untrusted client certificate", clientCert: newTestCert(t, "machine-2", untrustedCA), wantErr: true, wantLog: "TLS handshake error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, lines := newTestLogger()
+			c := &Server{
+				Log:             log,
+				TLSCertificates: []tls.Certificate{*serverCert},
+				ClientCAs:       pool,
+				ClientAuth:      tls.RequireAndVerifyClientCert,
+			}
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			errChan := make(chan error, 1)
+			go func() {
+				errChan <- c.serveHTTP(ctx, l)
+			}()
+			defer func() {
+				cancel()
+				if err := <-errChan; err != nil {
+					t.Fatal(err)
+				}
+			}()
+
+			roots := x509.NewCertPool()
+			roots.AddCert(ca.Leaf)
+			cfg := &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+			if tt.clientCert != nil {
+				cfg.Certificates = []tls.Certificate{*tt.clientCert}
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}, Timeout: 5 * time.Second}
+			resp, err := client.Get(fmt.Sprintf("https://%v/undionly.kpxe", l.Addr()))
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("wantErr: %v, got: %v", tt.wantErr, err)
+			}
+			if err == nil && resp.StatusCode != http.StatusOK {
+				t.Fatalf("want status %v, got: %v", http.StatusOK, resp.StatusCode)
+			}
+
+			// The server logs handshake failures asynchronously.
+			deadline := time.Now().Add(2 * time.Second)
+			for !strings.Contains(strings.Join(lines(), "\n"), tt.wantLog) {
+				if time.Now().After(deadline) {
+					t.Fatalf("expected log containing %q, got: %v", tt.wantLog, lines())
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestServeHTTPClientCertPolicy(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	serverCert := newTestCert(t, "server", ca)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	c := &Server{
+		Log:             logr.Discard(),
+		TLSCertificates: []tls.Certificate{*serverCert},
+		ClientCAs:       pool,
+		ClientAuth:      tls.RequireAndVerifyClientCert,
+		AllowClientCert: func(subject string) bool { return subject != "CN=machine-2" },
+		DynamicBinary: func(ctx context.Context, _ netaddr.IPPort, name string) ([]byte, bool, error) {
+			subject, ok := resolve.VerifiedClient(ctx)
+			return []byte(subject), ok && name == "whoami.ipxe", nil
+		},
+	}
+	if err := c.start(context.Background(), Server{Log: logr.Discard()}); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- c.serveHTTP(ctx, l)
+	}()
+	defer func() {
+		cancel()
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}()
+	tests := []struct {
+		name     string
+		cn       string
+		wantCode int
+		wantBody string
+	}{
+		{name: "allowed", cn: "machine-1", wantCode: http.StatusOK, wantBody: "CN=machine-1"},
+		{name: "not allowed", cn: "machine-2", wantCode: http.StatusForbidden, wantBody: "Forbidden\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roots := x509.NewCertPool()
+			roots.AddCert(ca.Leaf)
+			cfg := &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{*newTestCert(t, tt.cn, ca)}}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}, Timeout: 5 * time.Second}
+			resp, err := client.Get(fmt.Sprintf("https://%v/whoami.ipxe", l.Addr()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff([]interface{}{resp.StatusCode, string(body)}, []interface{}{tt.wantCode, tt.wantBody}); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+// newTestCert returns a certificate with the common name cn, signed by parent.
+// When parent is nil, the certificate is a self-signed certificate authority.
+func newTestCert(t *testing.T, cn string, parent *tls.Certificate) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
@@ -0,0 +1,62 @@
+package ipxedust
+
+import (
+	"context"
+	"crypto/x509"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/ipxedust/resolve"
+	"inet.af/netaddr"
+)
+
+// checkTrustAnchors logs a warning for each EFI binary that doesn't trust all the IPXETrustAnchors, see untrustedBinaries.
+func (c *Server) checkTrustAnchors(ctx context.Context) {
+	untrusted := c.untrustedBinaries(ctx)
+	names := make([]string, 0, len(untrusted))
+	for name := range untrusted {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		subjects := make([]string, 0, len(untrusted[name]))
+		for _, cert := range untrusted[name] {
+			subjects = append(subjects, cert.Subject.String())
+		}
+		c.log().Info("warning: binary doesn't trust the iPXE trust anchors, chainloading over HTTPS will fail", "binary", name, "untrusted", subjects)
+	}
+}
+
+// untrustedBinaries returns the IPXETrustAnchors not trusted by each EFI binary served under the name of an embedded
+// binary, keyed by the file name, prefixed with the tenant for Tenants. Binaries that can't be read are skipped.
+func (c *Server) untrustedBinaries(ctx context.Context) map[string][]*x509.Certificate {
+	if len(c.IPXETrustAnchors) == 0 {
+		return nil
+	}
+	resolvers := map[string]resolve.Resolver{"": c.resolver()}
+	for tenant, r := range c.tenantResolvers() {
+		resolvers[tenant+"/"] = r
+	}
+	untrusted := make(map[string][]*x509.Certificate)
+	for prefix, r := range resolvers {
+		// only look up the binaries served to every client, without calling out.
+		r.Dynamic, r.Upstream, r.Info = nil, nil, nil
+		for name := range binary.Files {
+			if !strings.EqualFold(path.Ext(name), ".efi") {
+				continue
+			}
+			b, err := r.Resolve(ctx, netaddr.IPPort{}, name)
+			if err != nil {
+				continue
+			}
+			for _, cert := range c.IPXETrustAnchors {
+				if !binary.Trusts(b, cert) {
+					untrusted[prefix+name] = append(untrusted[prefix+name], cert)
+				}
+			}
+		}
+	}
+	return untrusted
+}
@@ -0,0 +1,86 @@
+package ipxedust
+
+import (
+	"context"
+	"crypto/x509"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+func TestIPXETrustAnchors(t *testing.T) {
+	ca := newTestCert(t, "private CA", nil).Leaf
+	other := newTestCert(t, "other CA", nil).Leaf
+	// trusting returns an EFI binary built to trust certs.
+	trusting := func(certs ...*x509.Certificate) []byte {
+		b := append([]byte{}, binary.SNP[:1024]...)
+		for _, cert := range certs {
+			fp := binary.Fingerprint(cert)
+			b = append(b, fp[:]...)
+		}
+		return b
+	}
+	tests := []struct {
+		name    string
+		anchors []*x509.Certificate
+		want    map[string][]string
+	}{
+		{name: "no trust anchors"},
+		{
+			name:    "one trust anchor",
+			anchors: []*x509.Certificate{ca},
+			want: map[string][]string{
+				"snp.efi":           {"CN=private CA"},
+				"tenant-a/ipxe.efi": {"CN=private CA"},
+			},
+		},
+		{
+			name:    "two trust anchors",
+			anchors: []*x509.Certificate{ca, other},
+			want: map[string][]string{
+				"snp.efi":           {"CN=private CA", "CN=other CA"},
+				"ipxe.efi":          {"CN=other CA"},
+				"tenant-a/ipxe.efi": {"CN=private CA", "CN=other CA"},
+				"tenant-a/snp.efi":  {"CN=other CA"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged []string
+			c := &Server{
+				Log:              funcr.New(func(_, args string) { logged = append(logged, args) }, funcr.Options{}),
+				IPXETrustAnchors: tt.anchors,
+				FileSystems:      []fs.FS{fstest.MapFS{"ipxe.efi": {Data: trusting(ca)}}},
+				Tenants:          map[string][]fs.FS{"tenant-a": {fstest.MapFS{"snp.efi": {Data: trusting(ca)}}}},
+			}
+			if err := c.start(context.Background(), Server{}); err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string][]string)
+			for name, certs := range c.untrustedBinaries(context.Background()) {
+				for _, cert := range certs {
+					got[name] = append(got[name], cert.Subject.String())
+				}
+			}
+			if diff := cmp.Diff(got, tt.want, cmpopts.EquateEmpty()); diff != "" {
+				t.Fatal(diff)
+			}
+			warnings := 0
+			for _, l := range logged {
+				if strings.Contains(l, "doesn't trust the iPXE trust anchors") {
+					warnings++
+				}
+			}
+			if warnings != len(tt.want) {
+				t.Fatalf("expected %v warnings, got: %v", len(tt.want), logged)
+			}
+		})
+	}
+}
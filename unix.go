@@ -0,0 +1,32 @@
+package ipxedust
+
+import (
+	"net"
+	"os"
+	"strings"
+)
+
+// unixAddrPrefix marks an HTTP address as the path of a Unix domain socket, for example "unix:/run/ipxe.sock".
+const unixAddrPrefix = "unix:"
+
+// unixSocketPath returns the path of the Unix domain socket addr refers to, and false when it is not a Unix address.
+func unixSocketPath(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, unixAddrPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(addr, unixAddrPrefix), true
+}
+
+// listenUnix listens on the Unix domain socket at path. A socket file left behind by a process that
+// didn't shut down cleanly is removed first, a socket another process listens on is left alone.
+// The socket file is removed when the listener is closed.
+func listenUnix(path string) (*net.UnixListener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+		} else {
+			_ = os.Remove(path)
+		}
+	}
+	return net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+}
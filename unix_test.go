@@ -0,0 +1,93 @@
+package ipxedust
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+func TestListenAndServeUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "ipxe.sock")
+	// a socket file left behind by a process that didn't shut down cleanly.
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: sock, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	ready := make(chan net.Addr, 1)
+	s := New(
+		WithHTTPAddr("unix:"+sock),
+		DisableTFTP(),
+		WithLogger(logr.Discard()),
+		WithOnReady(func(_ Protocol, addr net.Addr) { ready <- addr }),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- s.ListenAndServe(ctx) }()
+	select {
+	case <-ready:
+	case err := <-errChan:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnReady")
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	resp, err := client.Get("http://ipxe/snp.efi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, binary.SNP) {
+		t.Fatal("unexpected content served")
+	}
+	client.CloseIdleConnections()
+
+	cancel()
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(sock); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the socket file to be removed on shutdown, got: %v", err)
+	}
+}
+
+func TestUnixSocketTFTP(t *testing.T) {
+	s := &Server{TFTP: ServerSpec{UnixSocket: filepath.Join(t.TempDir(), "tftp.sock")}, HTTP: ServerSpec{Disabled: true}}
+	if err := s.ListenAndServe(context.Background()); err == nil {
+		t.Fatal("expected an error serving TFTP over a Unix domain socket")
+	}
+}
+
+func TestWithHTTPAddrUnix(t *testing.T) {
+	s, err := NewE(WithHTTPAddr("unix:/run/ipxe.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.HTTP.UnixSocket != "/run/ipxe.sock" {
+		t.Fatalf("unexpected socket path: %v", s.HTTP.UnixSocket)
+	}
+	if _, err := NewE(WithHTTPAddr("unix:")); err == nil {
+		t.Fatal("expected an error for an empty socket path")
+	}
+}
@@ -0,0 +1,203 @@
+// Package upstream fetches iPXE binaries from an upstream HTTP(S) server and caches them in memory.
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tinkerbell/ipxedust/clock"
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrUpstream is returned when the upstream server could not be reached or returned an unexpected response.
+var ErrUpstream = errors.New("upstream request failed")
+
+const (
+	// DefaultTTL is how long fetched files are cached when no TTL is given.
+	DefaultTTL = 5 * time.Minute
+	// DefaultNotFoundTTL is how long files that upstream does not have are remembered as missing, when
+	// Cache.NotFoundTTL is not set.
+	DefaultNotFoundTTL = 10 * time.Second
+	// DefaultTimeout is the time a fetch from upstream, including reading the file, is given to complete, when the
+	// client passed to New has no timeout.
+	DefaultTimeout = 30 * time.Second
+	// DefaultMaxSize is the size of the largest file fetched from upstream, when Cache.MaxSize is not set.
+	DefaultMaxSize = 64 << 20
+)
+
+// Cache fetches files from an upstream base URL and caches them in memory for a TTL.
+// Concurrent requests for a file that is not cached result in a single upstream request.
+// A nil *Cache has no files.
+type Cache struct {
+	// Clock tells the time cached files expire. Defaults to the real clock. Set it before the Cache is used.
+	Clock clock.Clock
+	// MaxSize is the size of the largest file fetched, larger files fail with ErrUpstream. Defaults to
+	// DefaultMaxSize. Set it before the Cache is used.
+	MaxSize int64
+	// NotFoundTTL is how long a file that upstream does not have is remembered as missing. Defaults to
+	// DefaultNotFoundTTL, a negative value disables it. Set it before the Cache is used.
+	NotFoundTTL time.Duration
+
+	base   *url.URL
+	ttl    time.Duration
+	client *http.Client
+	group  singleflight.Group
+
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+// entry is a cached file, or a file upstream does not have when missing is set.
+type entry struct {
+	content []byte
+	missing bool
+	expires time.Time
+}
+
+// New returns a Cache for the upstream base URL, for example "https://artifacts.example.com/ipxe/".
+// Files are cached for ttl, or DefaultTTL when ttl is not greater than zero.
+// A nil client means a client with a timeout of DefaultTimeout.
+func New(base string, ttl time.Duration, client *http.Client) (*Cache, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid upstream URL %q: must be an absolute http or https URL", base)
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+	return &Cache{base: u, ttl: ttl, client: client, entries: make(map[string]entry)}, nil
+}
+
+// Get returns the content of filename, from the cache or, when it is not cached or has expired, from upstream.
+// The returned error wraps os.ErrNotExist when upstream does not have the file, or ErrUpstream for any other failure.
+// Files upstream does not have are remembered for NotFoundTTL, the other failures are not cached.
+// The fetch is shared by the concurrent requests of the file, it is not canceled with ctx: ctx being done only stops
+// waiting for it.
+func (c *Cache) Get(ctx context.Context, filename string) ([]byte, error) {
+	if c == nil {
+		return nil, fmt.Errorf("file [%v] unknown: %w", filename, os.ErrNotExist)
+	}
+	if filename == "" || strings.Contains(filename, "/") || filename == "." || filename == ".." {
+		return nil, fmt.Errorf("invalid filename %q: %w", filename, os.ErrNotExist)
+	}
+	if e, ok := c.cached(filename); ok {
+		return e.get(filename)
+	}
+	ch := c.group.DoChan(filename, func() (interface{}, error) {
+		// another request may have filled the cache while this one was waiting.
+		if e, ok := c.cached(filename); ok {
+			return e.get(filename)
+		}
+		content, err := c.fetch(filename)
+		now := clock.Or(c.Clock).Now()
+		switch {
+		case err == nil:
+			c.store(filename, entry{content: content, expires: now.Add(c.ttl)})
+		case errors.Is(err, os.ErrNotExist) && c.notFoundTTL() > 0:
+			c.store(filename, entry{missing: true, expires: now.Add(c.notFoundTTL())})
+		}
+		return content, err
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]byte), nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %v", ErrUpstream, ctx.Err())
+	}
+}
+
+// get returns the content of e, or the error of a file upstream does not have.
+func (e entry) get(filename string) ([]byte, error) {
+	if e.missing {
+		return nil, fmt.Errorf("file [%v] not found upstream: %w", filename, os.ErrNotExist)
+	}
+	return e.content, nil
+}
+
+// cached returns the cache entry of filename, if it has not expired.
+// Expired entries are removed.
+func (c *Cache) cached(filename string) (entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[filename]
+	if !ok {
+		return entry{}, false
+	}
+	if clock.Or(c.Clock).Now().After(e.expires) {
+		delete(c.entries, filename)
+		return entry{}, false
+	}
+	return e, true
+}
+
+// store caches e for filename.
+func (c *Cache) store(filename string, e entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[filename] = e
+}
+
+// notFoundTTL returns NotFoundTTL, or DefaultNotFoundTTL when it is not set.
+func (c *Cache) notFoundTTL() time.Duration {
+	if c.NotFoundTTL == 0 {
+		return DefaultNotFoundTTL
+	}
+	return c.NotFoundTTL
+}
+
+// fetch downloads filename from upstream, within the timeout of the client, or DefaultTimeout when it has none.
+func (c *Cache) fetch(filename string) ([]byte, error) {
+	timeout := c.client.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	u := *c.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + filename
+	u.RawPath = ""
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUpstream, err)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUpstream, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("file [%v] not found upstream: %w", filename, os.ErrNotExist)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%w: %v returned %v", ErrUpstream, u.String(), resp.Status)
+	}
+	max := c.MaxSize
+	if max <= 0 {
+		max = DefaultMaxSize
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading %v: %v", ErrUpstream, u.String(), err)
+	}
+	if int64(len(content)) > max {
+		return nil, fmt.Errorf("%w: %v is larger than %v bytes", ErrUpstream, u.String(), max)
+	}
+	return content, nil
+}
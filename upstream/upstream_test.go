@@ -0,0 +1,234 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/clock"
+)
+
+// fakeUpstream serves "snp.efi" and "large.efi", of 1024 bytes, fails "broken.efi" and counts requests.
+func fakeUpstream(t *testing.T, delay time.Duration) (*httptest.Server, *int32) {
+	t.Helper()
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(delay)
+		switch r.URL.Path {
+		case "/ipxe/snp.efi":
+			_, _ = w.Write([]byte("snp content"))
+		case "/ipxe/broken.efi":
+			http.Error(w, "boom", http.StatusInternalServerError)
+		case "/ipxe/large.efi":
+			_, _ = w.Write(make([]byte, 1024))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s, &requests
+}
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		name         string
+		filename     string
+		gets         int
+		want         string
+		wantErr      error
+		wantRequests int32
+	}{
+		{name: "miss then hit", filename: "snp.efi", gets: 3, want: "snp content", wantRequests: 1},
+		{name: "not found upstream", filename: "missing.efi", gets: 2, wantErr: os.ErrNotExist, wantRequests: 1},
+		{name: "upstream error", filename: "broken.efi", gets: 1, wantErr: ErrUpstream, wantRequests: 1},
+		{name: "invalid filename", filename: "../snp.efi", gets: 1, wantErr: os.ErrNotExist, wantRequests: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, requests := fakeUpstream(t, 0)
+			c, err := New(s.URL+"/ipxe", time.Minute, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.gets; i++ {
+				got, err := c.Get(context.Background(), tt.filename)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error mismatch, got: %v, want: %v", err, tt.wantErr)
+				}
+				if diff := cmp.Diff(string(got), tt.want); diff != "" {
+					t.Fatal(diff)
+				}
+			}
+			if diff := cmp.Diff(atomic.LoadInt32(requests), tt.wantRequests); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestGetUnreachable(t *testing.T) {
+	s, _ := fakeUpstream(t, 0)
+	c, err := New(s.URL, time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if _, err := c.Get(context.Background(), "snp.efi"); !errors.Is(err, ErrUpstream) {
+		t.Fatalf("error mismatch, got: %v, want: %v", err, ErrUpstream)
+	}
+}
+
+func TestGetTTL(t *testing.T) {
+	s, requests := fakeUpstream(t, 0)
+	c, err := New(s.URL+"/ipxe/", time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := clock.NewFake(time.Unix(0, 0))
+	c.Clock = fake
+	// fetched, cached, expired and fetched again.
+	for i, advance := range []time.Duration{0, 59 * time.Second, 2 * time.Second} {
+		fake.Advance(advance)
+		if _, err := c.Get(context.Background(), "snp.efi"); err != nil {
+			t.Fatal(err)
+		}
+		want := map[int]int32{0: 1, 1: 1, 2: 2}[i]
+		if diff := cmp.Diff(atomic.LoadInt32(requests), want); diff != "" {
+			t.Fatalf("request %v after %v: %v", i, advance, diff)
+		}
+	}
+}
+
+func TestGetNotFoundTTL(t *testing.T) {
+	s, requests := fakeUpstream(t, 0)
+	c, err := New(s.URL+"/ipxe/", time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := clock.NewFake(time.Unix(0, 0))
+	c.Clock = fake
+	// fetched, remembered as missing, expired and fetched again.
+	for i, advance := range []time.Duration{0, 9 * time.Second, 2 * time.Second} {
+		fake.Advance(advance)
+		if _, err := c.Get(context.Background(), "missing.efi"); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("error mismatch, got: %v, want: %v", err, os.ErrNotExist)
+		}
+		want := map[int]int32{0: 1, 1: 1, 2: 2}[i]
+		if diff := cmp.Diff(atomic.LoadInt32(requests), want); diff != "" {
+			t.Fatalf("request %v after %v: %v", i, advance, diff)
+		}
+	}
+}
+
+func TestGetMaxSize(t *testing.T) {
+	s, _ := fakeUpstream(t, 0)
+	c, err := New(s.URL+"/ipxe", time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.MaxSize = 1023
+	if _, err := c.Get(context.Background(), "large.efi"); !errors.Is(err, ErrUpstream) {
+		t.Fatalf("error mismatch, got: %v, want: %v", err, ErrUpstream)
+	}
+	c.MaxSize = 1024
+	if got, err := c.Get(context.Background(), "large.efi"); err != nil || len(got) != 1024 {
+		t.Fatalf("expected the whole file, got: %v bytes, %v", len(got), err)
+	}
+}
+
+func TestGetTimeout(t *testing.T) {
+	s, _ := fakeUpstream(t, 200*time.Millisecond)
+	c, err := New(s.URL+"/ipxe", time.Minute, &http.Client{Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(context.Background(), "snp.efi"); !errors.Is(err, ErrUpstream) {
+		t.Fatalf("error mismatch, got: %v, want: %v", err, ErrUpstream)
+	}
+	if c, _ := New(s.URL, time.Minute, nil); c.client.Timeout != DefaultTimeout {
+		t.Fatalf("expected the default client to time out after %v, got: %v", DefaultTimeout, c.client.Timeout)
+	}
+}
+
+func TestGetCanceled(t *testing.T) {
+	s, requests := fakeUpstream(t, 100*time.Millisecond)
+	c, err := New(s.URL+"/ipxe", time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first request is canceled while the file is fetched, the request sharing the fetch still gets the file.
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() {
+		_, err := c.Get(ctx, "snp.efi")
+		canceled <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Get(context.Background(), "snp.efi")
+		done <- err
+	}()
+	cancel()
+	if err := <-canceled; !errors.Is(err, ErrUpstream) {
+		t.Fatalf("error mismatch, got: %v, want: %v", err, ErrUpstream)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(atomic.LoadInt32(requests), int32(1)); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestGetStampede(t *testing.T) {
+	s, requests := fakeUpstream(t, 100*time.Millisecond)
+	c, err := New(s.URL+"/ipxe", time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Get(context.Background(), "snp.efi"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if diff := cmp.Diff(atomic.LoadInt32(requests), int32(1)); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := map[string]bool{
+		"http://127.0.0.1/ipxe":     false,
+		"https://example.com":       false,
+		"ftp://example.com/ipxe":    true,
+		"/ipxe":                     true,
+		"http://[::1]:namedport/ok": true,
+	}
+	for base, wantErr := range tests {
+		if _, err := New(base, 0, nil); (err != nil) != wantErr {
+			t.Errorf("%v: wantErr: %v, got: %v", base, wantErr, err)
+		}
+	}
+}
+
+func TestNilCache(t *testing.T) {
+	var c *Cache
+	if _, err := c.Get(context.Background(), "snp.efi"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("error mismatch, got: %v, want: %v", err, os.ErrNotExist)
+	}
+}